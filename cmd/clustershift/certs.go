@@ -0,0 +1,64 @@
+package clustershift
+
+import (
+	"clustershift/internal/exit"
+	"clustershift/internal/prompt"
+	"clustershift/pkg/linkerd"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	certsOrigin  string
+	certsTarget  string
+	certsOptions = linkerd.DefaultCertOptions()
+
+	certsCmd = &cobra.Command{
+		Use:   "certs",
+		Short: "manage the Linkerd certificates of two clusters",
+	}
+
+	certsRotate = &cobra.Command{
+		Use:   "rotate",
+		Short: "renew the Linkerd identity issuer of both clusters",
+		Run: func(cmd *cobra.Command, args []string) {
+			linkerd.Certs = certsOptions
+			exit.OnErrorWithMessage(linkerd.ValidateCerts(), "Invalid Linkerd certificate options")
+			linkerd.RotateCerts(certsOrigin, certsTarget)
+		},
+	}
+
+	certsCheck = &cobra.Command{
+		Use:   "check",
+		Short: "check the expiry of the Linkerd trust anchors and identity issuers",
+		Run: func(cmd *cobra.Command, args []string) {
+			linkerd.CheckCerts(certsOrigin, certsTarget)
+		},
+	}
+)
+
+// addLinkerdCertFlags registers the flags configuring the Linkerd trust anchor and issuer
+func addLinkerdCertFlags(cmd *cobra.Command, opts *prompt.LinkerdCertOptions) {
+	cmd.Flags().StringVar(&opts.TrustAnchorFile, "linkerd-trust-anchor", "", "PEM file of the Linkerd trust anchor, generated and stored if empty")
+	cmd.Flags().StringVar(&opts.TrustAnchorKeyFile, "linkerd-trust-anchor-key", "", "PEM file of the trust anchor key, used to sign and renew the issuer")
+	cmd.Flags().StringVar(&opts.IssuerCertFile, "linkerd-issuer", "", "PEM file of the Linkerd identity issuer certificate")
+	cmd.Flags().StringVar(&opts.IssuerKeyFile, "linkerd-issuer-key", "", "PEM file of the Linkerd identity issuer key")
+	cmd.Flags().StringVar(&opts.CertManagerIssuer, "linkerd-cert-manager-issuer", "", "cert-manager issuer of the identity issuer: Issuer/<name> in the linkerd namespace or ClusterIssuer/<name>")
+	cmd.Flags().StringVar(&opts.Store, "linkerd-cert-store", opts.Store, "Where generated certificates are stored: secret in the origin cluster, which holds the trust anchor key unencrypted, or the path of a file encrypted with $CLUSTERSHIFT_CERT_PASSPHRASE")
+	cmd.Flags().DurationVar(&opts.IssuerValidity, "linkerd-issuer-validity", opts.IssuerValidity, "Validity of generated and renewed identity issuers")
+}
+
+func init() {
+	certsCmd.PersistentFlags().StringVarP(&certsOrigin, "origin", "o", "", "Specify the path of the kubeconfig for the origin cluster")
+	certsCmd.PersistentFlags().StringVarP(&certsTarget, "target", "t", "", "Specify the path of the kubeconfig for the target cluster")
+
+	// Mark flags as required
+	certsCmd.MarkPersistentFlagRequired("origin")
+	certsCmd.MarkPersistentFlagRequired("target")
+
+	addLinkerdCertFlags(certsRotate, &certsOptions)
+
+	certsCmd.AddCommand(certsRotate)
+	certsCmd.AddCommand(certsCheck)
+	rootCmd.AddCommand(certsCmd)
+}
@@ -1,7 +1,9 @@
 package clustershift
 
 import (
+	"clustershift/internal/exit"
 	"clustershift/pkg/connectivity"
+	"clustershift/pkg/probe"
 
 	"github.com/spf13/cobra"
 )
@@ -9,11 +11,15 @@ import (
 var (
 	cluster1 string
 	cluster2 string
+	probes   []string
 
 	diagnose = &cobra.Command{
 		Use:   "diagnose",
 		Short: "diagnose connectivity between two clusters",
 		Run: func(cmd *cobra.Command, args []string) {
+			applyNodeSelection(cmd)
+			exit.OnErrorWithMessage(connectivity.AddProbes(probes), "Invalid probe")
+			exit.OnErrorWithMessage(probe.ValidateMeasure(connectivity.MeasureConfig.Packets, connectivity.MeasureConfig.Streams), "Invalid measurement")
 			connectivity.DiagnoseConnection(cluster1, cluster2)
 		},
 	}
@@ -23,9 +29,18 @@ func init() {
 	diagnose.Flags().StringVarP(&cluster1, "origin", "o", "", "Specify the path of the kubeconfig for the origin cluster")
 	diagnose.Flags().StringVarP(&cluster2, "target", "t", "", "Specify the path of the kubeconfig for the target cluster")
 
+	diagnose.Flags().BoolVar(&connectivity.MeasureConfig.Enabled, "measure", true, "Measure rtt, jitter, packet loss and throughput between the clusters")
+	diagnose.Flags().BoolVar(&connectivity.MeasureConfig.ThroughTool, "measure-tool", false, "Also measure the path through the installed networking tool, which exports the probe and injects it into the mesh in both clusters")
+	diagnose.Flags().IntVar(&connectivity.MeasureConfig.Packets, "packets", connectivity.MeasureConfig.Packets, "Number of packets used for RTT and packet loss")
+	diagnose.Flags().IntVar(&connectivity.MeasureConfig.Streams, "streams", connectivity.MeasureConfig.Streams, "Number of parallel throughput streams")
+	diagnose.Flags().DurationVar(&connectivity.MeasureConfig.Duration, "duration", connectivity.MeasureConfig.Duration, "Duration of each throughput measurement")
+	diagnose.Flags().StringSliceVar(&probes, "probe", nil, "Additional required probe as protocol:port (tcp, udp, http or tls)")
+
 	// Mark flags as required
 	diagnose.MarkFlagRequired("origin")
 	diagnose.MarkFlagRequired("target")
 
+	addNodeFlags(diagnose, false)
+
 	rootCmd.AddCommand(diagnose)
 }
@@ -1,24 +1,59 @@
 package clustershift
 
 import (
+	"clustershift/internal/kube"
 	"clustershift/internal/logger"
 	"clustershift/internal/prompt"
+	"clustershift/pkg/linkerd"
 	"clustershift/pkg/migration"
+	"clustershift/pkg/redirect"
+	"clustershift/pkg/submariner"
+	"time"
+
 	"github.com/spf13/cobra"
 )
 
 var (
-	kubeconfig1 string
-	kubeconfig2 string
+	kubeconfig1          string
+	kubeconfig2          string
+	generatedLabels      []string
+	generatedAnnotations []string
+	skipPreflight        bool
+	networkOverrides     prompt.NetworkOverrides
+	submarinerProfile    = submariner.DefaultProfile()
+	skupperVersion       int
+	istioMode            string
+	directExposure       string
+	linkerdCerts         = linkerd.DefaultCertOptions()
+	rollout              = linkerd.DefaultRollout()
+	proxyUpstreams       map[string]string
+	proxyDefault         string
+	shift                = redirect.DefaultShift()
+	mirror               prompt.MirrorOptions
 
 	migrateCluster = &cobra.Command{
 		Use:   "migrate",
 		Short: "migrate origin cluster to target cluster",
 		Run: func(cmd *cobra.Command, args []string) {
+			applyNodeSelection(cmd)
 			logger.Info("Starting migration process...")
 			logger.Info("You will be prompted to select a networking tool and rerouting option to establish a secure connection and manage traffic between the clusters.")
 
+			kube.AddGeneratedMarkers(generatedLabels, generatedAnnotations)
+
 			opts := prompt.MigrationPrompt()
+			opts.SkipPreflight = skipPreflight
+			opts.Network = networkOverrides
+			opts.Submariner = submarinerProfile
+			opts.SkupperVersion = skupperVersion
+			opts.IstioMode = istioMode
+			opts.DirectExposure = directExposure
+			opts.LinkerdCerts = linkerdCerts
+			opts.Rollout = rollout
+			opts.ProxyUpstreams = proxyUpstreams
+			opts.ProxyDefault = proxyDefault
+			opts.Shift = shift
+			opts.Mirror = mirror
 			migration.Migrate(kubeconfig1, kubeconfig2, opts)
 			logger.Info("Migration complete")
 		},
@@ -28,5 +63,38 @@ var (
 func init() {
 	migrateCluster.Flags().StringVarP(&kubeconfig1, "origin", "o", "", "Specify the path of the kubeconfig for the origin cluster")
 	migrateCluster.Flags().StringVarP(&kubeconfig2, "target", "t", "", "Specify the path of the kubeconfig for the target cluster")
+	migrateCluster.Flags().StringSliceVar(&generatedLabels, "generated-label", nil, "Label (key or key=value) marking generated objects that should not be copied")
+	migrateCluster.Flags().StringSliceVar(&generatedAnnotations, "generated-annotation", nil, "Annotation (key or key=value) marking generated objects that should not be copied")
+	migrateCluster.Flags().BoolVar(&skipPreflight, "skip-preflight", false, "Skip the preflight checks before starting the migration")
+	migrateCluster.Flags().StringVar(&networkOverrides.OriginPodCIDR, "origin-pod-cidr", "", "Pod CIDR of the origin cluster, discovered if empty")
+	migrateCluster.Flags().StringVar(&networkOverrides.TargetPodCIDR, "target-pod-cidr", "", "Pod CIDR of the target cluster, discovered if empty")
+	migrateCluster.Flags().StringVar(&networkOverrides.OriginServiceCIDR, "origin-service-cidr", "", "Service CIDR of the origin cluster, discovered if empty")
+	migrateCluster.Flags().StringVar(&networkOverrides.TargetServiceCIDR, "target-service-cidr", "", "Service CIDR of the target cluster, discovered if empty")
+	migrateCluster.Flags().StringVar(&networkOverrides.BrokerURL, "broker-url", "", "URL of the Submariner broker, the origin API server if empty")
+	migrateCluster.Flags().StringVar(&submarinerProfile.CableDriver, "cable-driver", submarinerProfile.CableDriver, "Submariner cable driver: libreswan, wireguard or vxlan")
+	migrateCluster.Flags().BoolVar(&submarinerProfile.NATTraversal, "nat-traversal", submarinerProfile.NATTraversal, "Enable Submariner NAT traversal between the gateways")
+	migrateCluster.Flags().StringVar(&submarinerProfile.Globalnet, "globalnet", submarinerProfile.Globalnet, "Submariner globalnet: auto enables it only if the CIDRs overlap, true or false")
+	migrateCluster.Flags().StringVar(&submarinerProfile.Version, "submariner-version", submarinerProfile.Version, "Version of the Submariner charts")
+	migrateCluster.Flags().StringVar(&submarinerProfile.ImageRepository, "submariner-image-repository", submarinerProfile.ImageRepository, "Repository of the Submariner images, e.g. a mirror registry")
+	migrateCluster.Flags().StringVar(&submarinerProfile.Broker, "broker", submarinerProfile.Broker, "Cluster hosting the Submariner broker: origin, target or the kubeconfig path of a third cluster")
+	migrateCluster.Flags().IntVar(&submarinerProfile.Gateways, "submariner-gateways", submarinerProfile.Gateways, "Submariner gateway nodes per cluster, 0 uses --gateway-count")
+	migrateCluster.Flags().IntVar(&skupperVersion, "skupper-version", 0, "Skupper major version (1 or 2) installed if neither cluster runs Skupper, 0 uses the latest")
+	migrateCluster.Flags().StringVar(&istioMode, "istio-mode", prompt.IstioMultiPrimary, "Istio deployment model: multi-primary or primary-remote with the origin cluster as primary")
+	migrateCluster.Flags().StringVar(&directExposure, "direct-exposure", prompt.DirectLoadBalancer, "Service type exposing services with the Direct networking tool: loadbalancer or nodeport")
+	migrateCluster.Flags().IntVar(&rollout.MaxConcurrent, "rollout-concurrency", rollout.MaxConcurrent, "Workloads restarted at once to inject the mesh proxy, 0 restarts all of them at once")
+	migrateCluster.Flags().BoolVar(&rollout.RespectPDBs, "rollout-respect-pdb", rollout.RespectPDBs, "Wait until the PodDisruptionBudgets of a workload allow a disruption before restarting it")
+	migrateCluster.Flags().BoolVar(&rollout.SkipInjected, "rollout-skip-injected", rollout.SkipInjected, "Skip workloads whose pods already run the mesh proxy")
+	migrateCluster.Flags().BoolVar(&rollout.Canary, "rollout-canary", rollout.Canary, "Restart one workload first and continue only once its pods are ready with the proxy")
+	migrateCluster.Flags().StringToStringVar(&proxyUpstreams, "proxy-upstream", nil, "Upstream of a host for the Clustershift rerouting proxy as host=address[:port], other hosts go to the default upstream")
+	migrateCluster.Flags().StringVar(&proxyDefault, "proxy-default-upstream", "", "Upstream of all other hosts for the Clustershift rerouting proxy as address[:port], the LoadBalancer of the Traefik service of the target cluster if empty")
+	migrateCluster.Flags().IntSliceVar(&shift.Steps, "shift-steps", nil, "Percentages of the traffic moved to the target cluster step by step, e.g. 1,10,50,100; empty switches in one step, not supported with Linkerd rerouting")
+	migrateCluster.Flags().DurationVar(&shift.Hold, "shift-hold", shift.Hold, "Time every traffic shifting step is held while the target cluster is checked")
+	migrateCluster.Flags().Float64Var(&shift.MaxErrorRate, "shift-max-error-rate", shift.MaxErrorRate, "Share of server errors of the target cluster above which the traffic is reverted to the origin cluster")
+	migrateCluster.Flags().IntVar(&mirror.Percent, "mirror-percent", 0, "Percentage of the ingress requests mirrored to the target cluster before the cutover, 0 disables mirroring. Only GET and HEAD requests are mirrored unless --mirror-writes is set")
+	migrateCluster.Flags().DurationVar(&mirror.Duration, "mirror-duration", 5*time.Minute, "Time the ingress requests are mirrored to the target cluster")
+	migrateCluster.Flags().BoolVar(&mirror.Writes, "mirror-writes", false, "Mirror requests of all methods, the target cluster applies the mirrored writes to its databases")
+	addLinkerdCertFlags(migrateCluster, &linkerdCerts)
+	addNodeFlags(migrateCluster, true)
+
 	rootCmd.AddCommand(migrateCluster)
 }
@@ -0,0 +1,36 @@
+package clustershift
+
+import (
+	"clustershift/internal/exit"
+	"clustershift/internal/kube"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	probeNodes   string
+	gatewayNodes string
+	gatewayCount int
+)
+
+// addNodeFlags registers the node selection flags, gateway flags are only added to commands installing a networking tool
+func addNodeFlags(cmd *cobra.Command, gateways bool) {
+	cmd.Flags().StringVar(&probeNodes, "nodes", kube.NodeStrategyControlPlane, "Nodes the clusters are reached at: control-plane, external-ip or a label selector")
+	if gateways {
+		cmd.Flags().StringVar(&gatewayNodes, "gateway-nodes", kube.NodeStrategyControlPlane, "Nodes running the networking tool gateways: control-plane, external-ip or a label selector")
+		cmd.Flags().IntVar(&gatewayCount, "gateway-count", 1, "Number of gateway nodes per cluster, more than one makes the gateways highly available, 0 uses all matching nodes")
+	}
+}
+
+// applyNodeSelection parses the node selection flags of the command, commands without gateway flags
+// keep the default gateway selection
+func applyNodeSelection(cmd *cobra.Command) {
+	var err error
+	kube.ProbeNodes, err = kube.ParseNodeSelection(probeNodes, 0)
+	exit.OnErrorWithMessage(err, "Invalid --nodes")
+	if cmd.Flags().Lookup("gateway-nodes") == nil {
+		return
+	}
+	kube.GatewayNodes, err = kube.ParseNodeSelection(gatewayNodes, gatewayCount)
+	exit.OnErrorWithMessage(err, "Invalid --gateway-nodes")
+}
@@ -0,0 +1,34 @@
+package clustershift
+
+import (
+	"clustershift/pkg/preflight"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	preflightOrigin string
+	preflightTarget string
+
+	preflightCmd = &cobra.Command{
+		Use:   "preflight",
+		Short: "check that both clusters meet the requirements for a migration",
+		Run: func(cmd *cobra.Command, args []string) {
+			applyNodeSelection(cmd)
+			preflight.Preflight(preflightOrigin, preflightTarget)
+		},
+	}
+)
+
+func init() {
+	preflightCmd.Flags().StringVarP(&preflightOrigin, "origin", "o", "", "Specify the path of the kubeconfig for the origin cluster")
+	preflightCmd.Flags().StringVarP(&preflightTarget, "target", "t", "", "Specify the path of the kubeconfig for the target cluster")
+
+	// Mark flags as required
+	preflightCmd.MarkFlagRequired("origin")
+	preflightCmd.MarkFlagRequired("target")
+
+	addNodeFlags(preflightCmd, true)
+
+	rootCmd.AddCommand(preflightCmd)
+}
@@ -0,0 +1,75 @@
+package clustershift
+
+import (
+	"clustershift/internal/exit"
+	"clustershift/pkg/probe"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	probeConfig  string
+	probeMeasure probe.MeasureOptions
+	probeHost    string
+	probeIdle    bool
+
+	probeCmd = &cobra.Command{
+		Use:   "probe",
+		Short: "run the connectivity probe inside a cluster (started by diagnose and migrate)",
+		Run: func(cmd *cobra.Command, args []string) {
+			exit.OnErrorWithMessage(probe.Run(probeConfig), "Connectivity probe failed")
+		},
+	}
+
+	probeMeasureCmd = &cobra.Command{
+		Use:   "measure",
+		Short: "measure rtt, jitter, packet loss and throughput to another probe and print the result as JSON",
+		Run: func(cmd *cobra.Command, args []string) {
+			exit.OnErrorWithMessage(probe.ValidateMeasure(probeMeasure.Packets, probeMeasure.Streams), "Invalid measurement")
+			output, err := json.Marshal(probe.Measure(probeMeasure))
+			exit.OnErrorWithMessage(err, "Failed to encode measurement")
+			fmt.Println(string(output))
+		},
+	}
+
+	probeResolveCmd = &cobra.Command{
+		Use:   "resolve",
+		Short: "resolve a host name with the cluster DNS and print the addresses",
+		Run: func(cmd *cobra.Command, args []string) {
+			if probeIdle {
+				// The pod stays up and resolves host names on exec
+				select {}
+			}
+			if probeHost == "" {
+				exit.OnErrorWithMessage(fmt.Errorf("required flag \"host\" not set"), "Invalid resolve")
+			}
+			addresses, err := net.LookupHost(probeHost)
+			exit.OnErrorWithMessage(err, "Failed to resolve "+probeHost)
+			fmt.Println(strings.Join(addresses, " "))
+		},
+	}
+)
+
+func init() {
+	probeCmd.Flags().StringVar(&probeConfig, "config", probe.ConfigFile, "Specify the path of the probe configuration")
+
+	probeMeasureCmd.Flags().StringVar(&probeMeasure.Host, "host", "", "Host of the probe to measure against")
+	probeMeasureCmd.Flags().Int32Var(&probeMeasure.TCPPort, "tcp-port", probe.MeasurePort, "Port of the TCP measure listener")
+	probeMeasureCmd.Flags().Int32Var(&probeMeasure.UDPPort, "udp-port", 0, "Port of the UDP echo listener, 0 measures the RTT over TCP")
+	probeMeasureCmd.Flags().IntVar(&probeMeasure.Packets, "packets", 50, "Number of packets used for RTT and packet loss")
+	probeMeasureCmd.Flags().IntVar(&probeMeasure.Streams, "streams", 4, "Number of parallel throughput streams")
+	probeMeasureCmd.Flags().DurationVar(&probeMeasure.Duration, "duration", 10*time.Second, "Duration of the throughput measurement")
+	probeMeasureCmd.MarkFlagRequired("host")
+
+	probeResolveCmd.Flags().StringVar(&probeHost, "host", "", "Host name to resolve")
+	probeResolveCmd.Flags().BoolVar(&probeIdle, "idle", false, "Keep running without resolving, host names are resolved by exec into the pod")
+
+	probeCmd.AddCommand(probeMeasureCmd)
+	probeCmd.AddCommand(probeResolveCmd)
+	rootCmd.AddCommand(probeCmd)
+}
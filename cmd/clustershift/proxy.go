@@ -0,0 +1,43 @@
+package clustershift
+
+import (
+	"clustershift/internal/exit"
+	"clustershift/internal/kube"
+	"clustershift/pkg/proxy"
+	"clustershift/pkg/redirect"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	proxyConfig string
+	proxyOrigin string
+
+	proxyCmd = &cobra.Command{
+		Use:   "proxy",
+		Short: "run the reverse proxy forwarding requests to the target cluster (started by migrate)",
+		Run: func(cmd *cobra.Command, args []string) {
+			exit.OnErrorWithMessage(proxy.Run(proxyConfig), "Reverse proxy failed")
+		},
+	}
+
+	proxyStatsCmd = &cobra.Command{
+		Use:   "stats",
+		Short: "show the requests and errors of the reverse proxy in the origin cluster",
+		Run: func(cmd *cobra.Command, args []string) {
+			origin, err := kube.InitCluster(proxyOrigin, "origin")
+			exit.OnErrorWithMessage(err, "Failed to initialize origin cluster")
+			exit.OnErrorWithMessage(redirect.ReportProxyStats(origin), "Failed to read proxy stats")
+		},
+	}
+)
+
+func init() {
+	proxyCmd.Flags().StringVar(&proxyConfig, "config", proxy.ConfigFile, "Specify the path of the proxy configuration")
+
+	proxyStatsCmd.Flags().StringVarP(&proxyOrigin, "origin", "o", "", "Specify the path of the kubeconfig for the origin cluster")
+	proxyStatsCmd.MarkFlagRequired("origin")
+
+	proxyCmd.AddCommand(proxyStatsCmd)
+	rootCmd.AddCommand(proxyCmd)
+}
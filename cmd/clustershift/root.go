@@ -1,6 +1,7 @@
 package clustershift
 
 import (
+	"clustershift/internal/constants"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -12,7 +13,7 @@ import (
 var rootCmd = &cobra.Command{
 	Use:     filepath.Base(os.Args[0]),
 	Short:   "Execute, manage, verify and diagnose Clustershift migrations",
-	Version: "0.0.1",
+	Version: constants.Version,
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
@@ -23,3 +24,11 @@ func Execute() {
 		os.Exit(1)
 	}
 }
+
+func init() {
+	if image := os.Getenv(constants.ClustershiftImageEnv); image != "" {
+		constants.ClustershiftImage = image
+	}
+	rootCmd.PersistentFlags().StringVar(&constants.ClustershiftImage, "image", constants.ClustershiftImage,
+		"Image of the in-cluster helpers in the version of the CLI, e.g. in a mirror registry, defaults to $"+constants.ClustershiftImageEnv)
+}
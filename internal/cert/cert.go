@@ -15,9 +15,10 @@ import (
 
 // LinkerdCerts contains all certificates and keys needed for Linkerd installation
 type LinkerdCerts struct {
-	TrustAnchorsPEM []byte // CA certificate
-	IssuerCertPEM   []byte // Issuer certificate
-	IssuerKeyPEM    []byte // Issuer private key
+	TrustAnchorsPEM   []byte // CA certificate
+	TrustAnchorKeyPEM []byte // CA private key, empty if the trust anchor is managed elsewhere
+	IssuerCertPEM     []byte // Issuer certificate
+	IssuerKeyPEM      []byte // Issuer private key
 }
 
 // GenerateLinkerdCerts generates all necessary certificates for Linkerd installation
@@ -36,27 +37,84 @@ func GenerateLinkerdCerts(validity time.Duration) (*LinkerdCerts, error) {
 		return nil, fmt.Errorf("error creating issuer CA: %w", err)
 	}
 
-	// Encode issuer private key in PEM format
-	issuerEcKey, ok := issuerKey.(*ecdsa.PrivateKey)
+	// Encode private keys in PEM format, the root key is kept to renew the issuer
+	issuerKeyPEM, err := encodeECKey(issuerKey)
+	if err != nil {
+		return nil, err
+	}
+	rootKeyPEM, err := encodeECKey(rootKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LinkerdCerts{
+		TrustAnchorsPEM:   rootCert,
+		TrustAnchorKeyPEM: rootKeyPEM,
+		IssuerCertPEM:     issuerCert,
+		IssuerKeyPEM:      issuerKeyPEM,
+	}, nil
+}
+
+// IstioRootCA is the root of trust shared by the clusters of an Istio mesh
+type IstioRootCA struct {
+	CertPEM []byte
+	key     crypto.PrivateKey
+}
+
+// IstioCACerts contains the files of the cacerts secret Istio uses as plugged-in CA
+type IstioCACerts struct {
+	CACertPEM    []byte // Intermediate CA certificate of the cluster
+	CAKeyPEM     []byte // Intermediate CA private key
+	RootCertPEM  []byte // Root CA certificate
+	CertChainPEM []byte // Intermediate and root certificate
+}
+
+// GenerateIstioRootCA generates the root CA of an Istio mesh spanning multiple clusters
+func GenerateIstioRootCA() (*IstioRootCA, error) {
+	rootKey, rootCert, err := createRootCA("root.istio.cluster.local", 87600*time.Hour) // 10 years
+	if err != nil {
+		return nil, fmt.Errorf("error creating root CA: %w", err)
+	}
+	return &IstioRootCA{CertPEM: rootCert, key: rootKey}, nil
+}
+
+// GenerateIntermediate generates the intermediate CA of a cluster signed by the root CA
+// The validity parameter specifies the duration for which the intermediate certificate is valid
+func (r *IstioRootCA) GenerateIntermediate(cluster string, validity time.Duration) (*IstioCACerts, error) {
+	key, cert, err := createIssuerCA("intermediate."+cluster+".istio.cluster.local", r.CertPEM, r.key, validity)
+	if err != nil {
+		return nil, fmt.Errorf("error creating intermediate CA: %w", err)
+	}
+
+	keyPEM, err := encodeECKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &IstioCACerts{
+		CACertPEM:    cert,
+		CAKeyPEM:     keyPEM,
+		RootCertPEM:  r.CertPEM,
+		CertChainPEM: append(append([]byte{}, cert...), r.CertPEM...),
+	}, nil
+}
+
+// encodeECKey encodes an ECDSA private key in PEM format
+func encodeECKey(key crypto.PrivateKey) ([]byte, error) {
+	ecKey, ok := key.(*ecdsa.PrivateKey)
 	if !ok {
-		return nil, fmt.Errorf("issuer key is not an ECDSA private key")
+		return nil, fmt.Errorf("key is not an ECDSA private key")
 	}
 
-	keyBytes, err := x509.MarshalECPrivateKey(issuerEcKey)
+	keyBytes, err := x509.MarshalECPrivateKey(ecKey)
 	if err != nil {
 		return nil, err
 	}
 
-	issuerKeyPEM := pem.EncodeToMemory(&pem.Block{
+	return pem.EncodeToMemory(&pem.Block{
 		Type:  "EC PRIVATE KEY",
 		Bytes: keyBytes,
-	})
-
-	return &LinkerdCerts{
-		TrustAnchorsPEM: rootCert,
-		IssuerCertPEM:   issuerCert,
-		IssuerKeyPEM:    issuerKeyPEM,
-	}, nil
+	}), nil
 }
 
 // createRootCA generates a root CA certificate and private key
@@ -165,3 +223,42 @@ func createIssuerCA(cn string, rootCertPEM []byte, rootKey crypto.PrivateKey, va
 
 	return key, cert, nil
 }
+
+// ParseCertificates parses all certificates of a PEM bundle
+func ParseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certificates []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certificates = append(certificates, certificate)
+	}
+	if len(certificates) == 0 {
+		return nil, fmt.Errorf("no certificate found")
+	}
+	return certificates, nil
+}
+
+// VerifyIssuer checks that the first certificate of issuerPEM is signed by one of the roots in rootsPEM
+func VerifyIssuer(issuerPEM, rootsPEM []byte) (*x509.Certificate, error) {
+	issuers, err := ParseCertificates(issuerPEM)
+	if err != nil {
+		return nil, fmt.Errorf("issuer: %w", err)
+	}
+	roots, err := ParseCertificates(rootsPEM)
+	if err != nil {
+		return nil, fmt.Errorf("trust roots: %w", err)
+	}
+	pool := x509.NewCertPool()
+	for _, root := range roots {
+		pool.AddCert(root)
+	}
+	_, err = issuers[0].Verify(x509.VerifyOptions{Roots: pool, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}})
+	return issuers[0], err
+}
@@ -0,0 +1,202 @@
+package cert
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	saltSize         = 16
+	kdfIterations    = 600000
+	encryptedVersion = 1
+)
+
+// encryptedFile is the format of an encrypted certificate file
+type encryptedFile struct {
+	Version    int    `json:"version"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// LoadLinkerdCertFiles reads a trust anchor and issuer supplied by the user. The key of the trust
+// anchor is optional, without it the issuer cannot be renewed by clustershift.
+func LoadLinkerdCertFiles(trustAnchorFile, trustAnchorKeyFile, issuerCertFile, issuerKeyFile string) (*LinkerdCerts, error) {
+	certs := &LinkerdCerts{}
+	var err error
+	for _, file := range []struct {
+		path   string
+		target *[]byte
+	}{
+		{trustAnchorFile, &certs.TrustAnchorsPEM},
+		{trustAnchorKeyFile, &certs.TrustAnchorKeyPEM},
+		{issuerCertFile, &certs.IssuerCertPEM},
+		{issuerKeyFile, &certs.IssuerKeyPEM},
+	} {
+		if file.path == "" {
+			continue
+		}
+		if *file.target, err = os.ReadFile(file.path); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(certs.TrustAnchorsPEM) == 0 {
+		return nil, fmt.Errorf("a trust anchor is required")
+	}
+	if len(certs.IssuerCertPEM) == 0 || len(certs.IssuerKeyPEM) == 0 {
+		if len(certs.TrustAnchorKeyPEM) == 0 {
+			return nil, fmt.Errorf("an issuer certificate and key or the key of the trust anchor is required")
+		}
+		return certs, certs.RenewIssuer(8760 * time.Hour) // 1 year validity for issuer
+	}
+	if _, err := VerifyIssuer(certs.IssuerCertPEM, certs.TrustAnchorsPEM); err != nil {
+		return nil, fmt.Errorf("issuer is not signed by the trust anchor: %w", err)
+	}
+	return certs, nil
+}
+
+// RenewIssuer replaces the issuer with a new one signed by the trust anchor
+// The validity parameter specifies the duration for which the new issuer certificate is valid
+func (l *LinkerdCerts) RenewIssuer(validity time.Duration) error {
+	if len(l.TrustAnchorKeyPEM) == 0 {
+		return fmt.Errorf("the key of the trust anchor is not available")
+	}
+	block, _ := pem.Decode(l.TrustAnchorKeyPEM)
+	if block == nil {
+		return fmt.Errorf("trust anchor key is not PEM encoded")
+	}
+	rootKey, err := parsePrivateKey(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("error parsing trust anchor key: %w", err)
+	}
+
+	// The first certificate of the bundle is the one the key belongs to
+	roots, err := ParseCertificates(l.TrustAnchorsPEM)
+	if err != nil {
+		return err
+	}
+	signer, ok := rootKey.(crypto.Signer)
+	if !ok {
+		return fmt.Errorf("trust anchor key of type %T cannot sign", rootKey)
+	}
+	if !publicKeysEqual(signer.Public(), roots[0].PublicKey) {
+		return fmt.Errorf("the trust anchor key does not belong to the first trust anchor %s", roots[0].Subject.CommonName)
+	}
+	rootPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: roots[0].Raw})
+
+	issuerKey, issuerCert, err := createIssuerCA("identity.linkerd.cluster.local", rootPEM, rootKey, validity)
+	if err != nil {
+		return fmt.Errorf("error creating issuer CA: %w", err)
+	}
+	issuerKeyPEM, err := encodeECKey(issuerKey)
+	if err != nil {
+		return err
+	}
+	l.IssuerCertPEM = issuerCert
+	l.IssuerKeyPEM = issuerKeyPEM
+	return nil
+}
+
+// IssuerExpiry returns the time the issuer certificate expires
+func (l *LinkerdCerts) IssuerExpiry() (time.Time, error) {
+	issuers, err := ParseCertificates(l.IssuerCertPEM)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return issuers[0].NotAfter, nil
+}
+
+// SaveEncrypted writes the certificates to a file encrypted with AES-GCM, the key is derived
+// from the passphrase with PBKDF2
+func (l *LinkerdCerts) SaveEncrypted(path, passphrase string) error {
+	if passphrase == "" {
+		return fmt.Errorf("a passphrase is required to encrypt the certificates")
+	}
+	plaintext, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+
+	file := encryptedFile{Version: encryptedVersion, Salt: make([]byte, saltSize)}
+	if _, err := rand.Read(file.Salt); err != nil {
+		return err
+	}
+	gcm, err := newGCM(passphrase, file.Salt)
+	if err != nil {
+		return err
+	}
+	file.Nonce = make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(file.Nonce); err != nil {
+		return err
+	}
+	file.Ciphertext = gcm.Seal(nil, file.Nonce, plaintext, nil)
+
+	data, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// LoadEncrypted reads certificates written by SaveEncrypted
+func LoadEncrypted(path, passphrase string) (*LinkerdCerts, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file encryptedFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid certificate file: %w", err)
+	}
+	if file.Version != encryptedVersion {
+		return nil, fmt.Errorf("unsupported certificate file version %d", file.Version)
+	}
+
+	gcm, err := newGCM(passphrase, file.Salt)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := gcm.Open(nil, file.Nonce, file.Ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt certificate file, the passphrase may be wrong")
+	}
+	certs := &LinkerdCerts{}
+	return certs, json.Unmarshal(plaintext, certs)
+}
+
+func newGCM(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := pbkdf2.Key(sha256.New, passphrase, salt, kdfIterations, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// publicKeysEqual compares two public keys, all key types of the crypto packages implement Equal
+func publicKeysEqual(a, b crypto.PublicKey) bool {
+	key, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && key.Equal(b)
+}
+
+// parsePrivateKey parses an EC key in SEC 1 or PKCS #8 form
+func parsePrivateKey(der []byte) (crypto.PrivateKey, error) {
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	return x509.ParsePKCS8PrivateKey(der)
+}
@@ -1,5 +1,13 @@
 package constants
 
+// Version of the clustershift binary, set at build time with
+// -ldflags "-X clustershift/internal/constants.Version=<version>"
+var Version = "0.0.1"
+
+// ClustershiftImage runs the in-cluster helpers in the version of the CLI, so both speak the same protocol.
+// It is overridden by $CLUSTERSHIFT_IMAGE and the --image flag, e.g. for a mirror registry.
+var ClustershiftImage = ClustershiftImageRepository + ":" + Version
+
 const (
 	// Debug flag helm
 	Debug = true
@@ -7,20 +15,26 @@ const (
 	// Kubeconfig temp paths
 	KubeconfigOriginTmp = "tmp/origin_kubeconfig.yaml"
 	KubeconfigTargetTmp = "tmp/target_kubeconfig.yaml"
+	KubeconfigBrokerTmp = "tmp/broker_kubeconfig.yaml"
 
 	// Conectivity probe constants
-	ConnectivityProbeDeploymentURL  = "https://raw.githubusercontent.com/romankudravcev/kube-connectivity-probe/main/infra/Deployment.yml"
-	ConnectivityProbeConfigmapURL   = "https://raw.githubusercontent.com/romankudravcev/kube-connectivity-probe/main/infra/Configmap.yml"
-	ConnectivityProbeDeploymentName = "kube-connectivity-probe"
+	ConnectivityProbeDeploymentName = "clustershift-probe"
 	ConnectivityProbeLabelSelector  = "app=" + ConnectivityProbeDeploymentName
 	ConnectivityProbeConfigmapName  = "connectivity-config"
 	ConnectivityProbeNamespace      = "connectivity-probe"
 	ConnectivityProbePort           = 6443
+	ConnectivityProbeNodePortBase   = 30701
+
+	// Repository of the clustershift image, used for in-cluster helpers like the connectivity probe
+	ClustershiftImageRepository = "ghcr.io/romankudravcev/clustershift"
+	ClustershiftImageEnv        = "CLUSTERSHIFT_IMAGE"
 
 	// Proxy constants
-	HttpProxyDeploymentURL = "https://raw.githubusercontent.com/romankudravcev/reverse-proxy-http/main/Deployment.yml"
-	HttpProxyIngressURL    = "https://raw.githubusercontent.com/romankudravcev/reverse-proxy-http/main/Ingress.yml"
+	HttpProxyName          = "clustershift-proxy"
+	HttpProxyConfigmapName = "clustershift-proxy-config"
 	HttpProxyPort          = 8734
+	HttpProxyTLSPort       = 8735
+	HttpProxyHealthPort    = 8736
 	HttpProxyNamespace     = "clustershift"
 
 	// Submariner constants
@@ -32,6 +46,7 @@ const (
 	SubmarinerOperatorNamespace = "submariner-operator"
 	SubmarinerBrokerClientToken = "submariner-k8s-broker-client-token"
 	SubmarinerVersion           = "0.20.1"
+	SubmarinerImageRepository   = "quay.io/submariner"
 
 	// Linkerd constants
 	LinkerdEdgeRepoName              = "linkerd-edge"
@@ -44,9 +59,38 @@ const (
 	LinkerdMultiClusterLinkChartName = "linkerd/charts"
 	LinkerdNamespace                 = "linkerd"
 	LinkerdMultiClusterNamespace     = "linkerd-multicluster"
+	LinkerdCertsSecret               = "clustershift-linkerd-certs"
+	LinkerdCertsNamespace            = "clustershift"
+	LinkerdCertPassphraseEnv         = "CLUSTERSHIFT_CERT_PASSPHRASE"
 
 	// Skupper constants
-	SkupperSiteControllerURL = "https://raw.githubusercontent.com/skupperproject/skupper/refs/heads/1.8/cmd/site-controller/deploy-watch-all-ns.yaml"
+	SkupperSiteControllerURL       = "https://raw.githubusercontent.com/skupperproject/skupper/refs/heads/1.8/cmd/site-controller/deploy-watch-all-ns.yaml"
+	SkupperControllerURL           = "https://github.com/skupperproject/skupper/releases/download/2.0.1/skupper-cluster-scope.yaml"
+	SkupperNamespace               = "skupper"
+	SkupperSiteControllerNamespace = "skupper-site-controller"
+
+	// Istio constants
+	IstioRepoName             = "istio"
+	IstioRepoURL              = "https://istio-release.storage.googleapis.com/charts"
+	IstioBaseChartName        = "istio/base"
+	IstiodChartName           = "istio/istiod"
+	IstioGatewayChartName     = "istio/gateway"
+	IstioVersion              = "1.26.2"
+	IstioNamespace            = "istio-system"
+	IstioMeshID               = "clustershift-mesh"
+	IstioEastWestGatewayName  = "istio-eastwestgateway"
+	IstioEastWestGatewayLabel = "istio=eastwestgateway"
+
+	// Cilium constants
+	CiliumRepoName           = "cilium"
+	CiliumRepoURL            = "https://helm.cilium.io/"
+	CiliumChartName          = "cilium/cilium"
+	CiliumNamespace          = "kube-system"
+	CiliumClusterMeshAPIName = "clustermesh-apiserver"
+	CiliumClusterMeshPort    = 2379
+
+	// Direct constants
+	DirectExportLabel = "clustershift.io/direct-export"
 
 	// CNPG constants
 	CNPGNamespace     = "cnpg-system"
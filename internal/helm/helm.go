@@ -1,6 +1,7 @@
 package helm
 
 import (
+	"clustershift/internal/kube"
 	"clustershift/internal/logger"
 	"context"
 	"fmt"
@@ -57,6 +58,7 @@ func HelmAddandInstallChart(h helmclient.Client, c ChartOptions) {
 		CreateNamespace: true,
 		ValuesYaml:      c.Values,
 		Version:         c.Version,
+		ReuseValues:     c.ReuseValues,
 		Timeout:         120 * time.Second,
 	}
 
@@ -64,4 +66,6 @@ func HelmAddandInstallChart(h helmclient.Client, c ChartOptions) {
 	if _, err := h.InstallOrUpgradeChart(context.Background(), &chartSpec, nil); err != nil {
 		logger.Debug(fmt.Sprintf("Error installing chart: %v", err))
 	}
+	// Charts may install CRDs
+	kube.InvalidateAPIVersions()
 }
@@ -16,4 +16,5 @@ type ChartOptions struct {
 	Values      string
 	Wait        bool
 	Version     string
+	ReuseValues bool // keep the values of the installed release and merge Values into them
 }
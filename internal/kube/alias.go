@@ -0,0 +1,188 @@
+package kube
+
+import (
+	"clustershift/internal/logger"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// AliasService returns a service with the ports of the given service under another name. Without
+// selector the endpoints of the alias are managed by a networking tool or EndpointSlices.
+func AliasService(service *v1.Service, name string, selector, annotations map[string]string) *v1.Service {
+	ports := make([]v1.ServicePort, 0, len(service.Spec.Ports))
+	for _, port := range service.Spec.Ports {
+		ports = append(ports, v1.ServicePort{
+			Name:        port.Name,
+			Protocol:    port.Protocol,
+			AppProtocol: port.AppProtocol,
+			Port:        port.Port,
+			TargetPort:  port.TargetPort,
+		})
+	}
+	return &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   service.Namespace,
+			Annotations: annotations,
+		},
+		Spec: v1.ServiceSpec{
+			Selector: selector,
+			Ports:    ports,
+		},
+	}
+}
+
+// ApplyEndpointSlice creates the EndpointSlice or replaces the existing one
+func (c Cluster) ApplyEndpointSlice(slice *discoveryv1.EndpointSlice) error {
+	endpointSlices := c.Clientset.DiscoveryV1().EndpointSlices(slice.Namespace)
+	_, err := endpointSlices.Create(context.TODO(), slice, metav1.CreateOptions{})
+	if k8serrors.IsAlreadyExists(err) {
+		_, err = endpointSlices.Update(context.TODO(), slice, metav1.UpdateOptions{})
+	}
+	return err
+}
+
+// headlessMirror is a headless alias created by MirrorHeadlessService
+type headlessMirror struct {
+	from, to Cluster
+	service  *v1.Service
+	alias    string
+	labels   map[string]string
+}
+
+var (
+	headlessMirrorsMu sync.Mutex
+	headlessMirrors   = map[string]headlessMirror{}
+)
+
+// MirrorHeadlessService creates a headless alias of the service without selector in the cluster to.
+// Its EndpointSlice lists the pods of the service in the cluster from with their hostnames, which
+// gives every pod the DNS name <hostname>.<alias>.<namespace>.svc.cluster.local. The addresses are a
+// snapshot of the pods, ResyncHeadlessServices and SyncHeadlessServices update them after pods restarted
+// or changed their readiness.
+func MirrorHeadlessService(from, to Cluster, service *v1.Service, alias string, labels map[string]string) error {
+	headless := AliasService(service, alias, nil, nil)
+	headless.Labels = labels
+	headless.Spec.ClusterIP = v1.ClusterIPNone
+	headless.Spec.PublishNotReadyAddresses = service.Spec.PublishNotReadyAddresses
+	err := to.CreateResource(Service, service.Namespace, headless)
+	if err != nil && !k8serrors.IsAlreadyExists(err) {
+		return err
+	}
+
+	mirror := headlessMirror{from: from, to: to, service: service, alias: alias, labels: labels}
+	if err := mirror.sync(); err != nil {
+		return err
+	}
+	headlessMirrorsMu.Lock()
+	headlessMirrors[to.Name+"/"+service.Namespace+"/"+alias] = mirror
+	headlessMirrorsMu.Unlock()
+	return nil
+}
+
+// ResyncHeadlessServices mirrors the current pods of all headless aliases again. Database migrators call it
+// before they hand the per-pod names to the database, the addresses of restarted pods are stale otherwise.
+func ResyncHeadlessServices() error {
+	headlessMirrorsMu.Lock()
+	mirrors := make([]headlessMirror, 0, len(headlessMirrors))
+	for _, mirror := range headlessMirrors {
+		mirrors = append(mirrors, mirror)
+	}
+	headlessMirrorsMu.Unlock()
+
+	for _, mirror := range mirrors {
+		if err := mirror.sync(); err != nil {
+			return fmt.Errorf("failed to resync alias %s/%s: %w", mirror.service.Namespace, mirror.alias, err)
+		}
+	}
+	return nil
+}
+
+// SyncHeadlessServices resyncs the headless aliases every interval, which follows readiness changes and pod
+// restarts while a database migration runs. The returned function stops the sync.
+func SyncHeadlessServices(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	var once sync.Once
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := ResyncHeadlessServices(); err != nil {
+					logger.Warning("Failed to resync headless aliases", err)
+				}
+			}
+		}
+	}()
+	return func() { once.Do(func() { close(done) }) }
+}
+
+// sync replaces the EndpointSlice of the alias with the current pods of the service
+func (m headlessMirror) sync() error {
+	slices, err := m.from.Clientset.DiscoveryV1().EndpointSlices(m.service.Namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: discoveryv1.LabelServiceName + "=" + m.service.Name,
+	})
+	if err != nil {
+		return err
+	}
+
+	sliceLabels := map[string]string{
+		discoveryv1.LabelServiceName: m.alias,
+		discoveryv1.LabelManagedBy:   "clustershift",
+	}
+	for key, value := range m.labels {
+		sliceLabels[key] = value
+	}
+	mirror := &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      m.alias,
+			Namespace: m.service.Namespace,
+			Labels:    sliceLabels,
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+	}
+	for _, slice := range slices.Items {
+		if slice.AddressType != discoveryv1.AddressTypeIPv4 {
+			continue
+		}
+		mirror.Ports = slice.Ports
+		for _, endpoint := range slice.Endpoints {
+			ready := endpoint.Conditions.Ready == nil || *endpoint.Conditions.Ready
+			if !ready && !m.service.Spec.PublishNotReadyAddresses {
+				continue
+			}
+			mirror.Endpoints = append(mirror.Endpoints, discoveryv1.Endpoint{
+				Addresses:  endpoint.Addresses,
+				Hostname:   endpoint.Hostname,
+				Conditions: discoveryv1.EndpointConditions{Ready: &ready},
+			})
+		}
+	}
+
+	return m.to.ApplyEndpointSlice(mirror)
+}
+
+// DeleteAlias deletes an alias service and the EndpointSlices clustershift created for it
+func DeleteAlias(c Cluster, alias, namespace string) error {
+	headlessMirrorsMu.Lock()
+	delete(headlessMirrors, c.Name+"/"+namespace+"/"+alias)
+	headlessMirrorsMu.Unlock()
+
+	err := c.DeleteResource(Service, alias, namespace)
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return err
+	}
+	return c.Clientset.DiscoveryV1().EndpointSlices(namespace).DeleteCollection(context.TODO(), metav1.DeleteOptions{}, metav1.ListOptions{
+		LabelSelector: discoveryv1.LabelServiceName + "=" + alias + "," + discoveryv1.LabelManagedBy + "=clustershift",
+	})
+}
@@ -0,0 +1,266 @@
+package kube
+
+import (
+	"clustershift/internal/logger"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	k3sDefaultPodCIDR     = "10.42.0.0/16"
+	k3sDefaultServiceCIDR = "10.43.0.0/16"
+	k3sNodeArgsAnnotation = "k3s.io/node-args"
+)
+
+// DiscoveredCIDR is a CIDR of a cluster and the source it was discovered from
+type DiscoveredCIDR struct {
+	CIDR   string
+	Source string
+}
+
+type cidrSource struct {
+	name     string
+	discover func() (string, error)
+}
+
+// DiscoverPodCIDR discovers the pod CIDR of the cluster from the control plane configuration,
+// the CNI configuration or the CIDRs allocated to the nodes
+func (c Cluster) DiscoverPodCIDR() (DiscoveredCIDR, error) {
+	return discoverCIDR(c, "pod", []cidrSource{
+		{"kube-controller-manager --cluster-cidr", func() (string, error) {
+			return c.controlPlaneArg("kube-controller-manager", "--cluster-cidr")
+		}},
+		{"kubeadm-config podSubnet", func() (string, error) { return c.kubeadmNetworking("podSubnet") }},
+		{"k3s --cluster-cidr", func() (string, error) { return c.k3sArg("--cluster-cidr", k3sDefaultPodCIDR) }},
+		{"Calico IPPool", c.calicoIPPool},
+		{"cilium-config", c.ciliumClusterPool},
+		{"node podCIDRs", c.nodePodCIDRs},
+	})
+}
+
+// DiscoverServiceCIDR discovers the service CIDR of the cluster from the control plane
+// configuration or by probing the valid ClusterIP range
+func (c Cluster) DiscoverServiceCIDR() (DiscoveredCIDR, error) {
+	return discoverCIDR(c, "service", []cidrSource{
+		{"kube-apiserver --service-cluster-ip-range", func() (string, error) {
+			return c.controlPlaneArg("kube-apiserver", "--service-cluster-ip-range")
+		}},
+		{"kubeadm-config serviceSubnet", func() (string, error) { return c.kubeadmNetworking("serviceSubnet") }},
+		{"k3s --service-cidr", func() (string, error) { return c.k3sArg("--service-cidr", k3sDefaultServiceCIDR) }},
+		{"ServiceCIDR", c.serviceCIDRObject},
+		{"ClusterIP probing", c.FetchServiceCIDRs},
+	})
+}
+
+// CIDRsOverlap reports whether two CIDRs share addresses
+func CIDRsOverlap(a, b string) bool {
+	_, networkA, errA := net.ParseCIDR(a)
+	_, networkB, errB := net.ParseCIDR(b)
+	if errA != nil || errB != nil {
+		return false
+	}
+	return networkA.Contains(networkB.IP) || networkB.Contains(networkA.IP)
+}
+
+func discoverCIDR(c Cluster, kind string, sources []cidrSource) (DiscoveredCIDR, error) {
+	for _, source := range sources {
+		value, err := source.discover()
+		if err != nil {
+			logger.Debug(fmt.Sprintf("%s CIDR of %s cluster not found in %s: %v", kind, c.Name, source.name, err))
+			continue
+		}
+		if cidr := firstIPv4CIDR(value); cidr != "" {
+			logger.Debug(fmt.Sprintf("Discovered %s CIDR %s of %s cluster from %s", kind, cidr, c.Name, source.name))
+			return DiscoveredCIDR{CIDR: cidr, Source: source.name}, nil
+		}
+	}
+	return DiscoveredCIDR{}, fmt.Errorf("unable to discover the %s CIDR of %s cluster", kind, c.Name)
+}
+
+// firstIPv4CIDR returns the first IPv4 CIDR of a comma or space separated list,
+// dual-stack clusters list both address families
+func firstIPv4CIDR(value string) string {
+	for _, field := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' }) {
+		ip, network, err := net.ParseCIDR(strings.TrimSpace(field))
+		if err == nil && ip.To4() != nil {
+			return network.String()
+		}
+	}
+	return ""
+}
+
+// controlPlaneArg reads a flag of a static control plane pod
+func (c Cluster) controlPlaneArg(component, flag string) (string, error) {
+	pods, err := c.Clientset.CoreV1().Pods("kube-system").List(context.TODO(), metav1.ListOptions{
+		LabelSelector: "component=" + component,
+	})
+	if err != nil {
+		return "", err
+	}
+	for _, pod := range pods.Items {
+		for _, container := range pod.Spec.Containers {
+			if value, ok := flagValue(append(container.Command, container.Args...), flag); ok {
+				return value, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no %s pod with %s", component, flag)
+}
+
+// kubeadmNetworking reads a networking field of the kubeadm ClusterConfiguration
+func (c Cluster) kubeadmNetworking(field string) (string, error) {
+	configMap, err := c.Clientset.CoreV1().ConfigMaps("kube-system").Get(context.TODO(), "kubeadm-config", metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	var config struct {
+		Networking map[string]string `yaml:"networking"`
+	}
+	if err := yaml.Unmarshal([]byte(configMap.Data["ClusterConfiguration"]), &config); err != nil {
+		return "", err
+	}
+	return config.Networking[field], nil
+}
+
+// k3sArg reads a flag from the arguments k3s servers record on their node and falls back
+// to the k3s default if the cluster runs k3s without the flag
+func (c Cluster) k3sArg(flag, defaultValue string) (string, error) {
+	nodes, err := c.Clientset.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return "", err
+	}
+	isK3s := false
+	for _, node := range nodes.Items {
+		if !strings.Contains(node.Status.NodeInfo.KubeletVersion, "k3s") {
+			continue
+		}
+		isK3s = true
+		var args []string
+		if err := json.Unmarshal([]byte(node.Annotations[k3sNodeArgsAnnotation]), &args); err != nil {
+			continue
+		}
+		if value, ok := flagValue(args, flag); ok {
+			return value, nil
+		}
+	}
+	if !isK3s {
+		return "", fmt.Errorf("not a k3s cluster")
+	}
+	return defaultValue, nil
+}
+
+// calicoIPPool returns the CIDR of the first enabled Calico IPv4 pool
+func (c Cluster) calicoIPPool() (string, error) {
+	for _, group := range []string{"crd.projectcalico.org", "projectcalico.org"} {
+		pools, err := c.ListCustomResources(schema.GroupKind{Group: group, Kind: "IPPool"}, "")
+		if err != nil {
+			continue
+		}
+		for _, pool := range pools {
+			if disabled, _, _ := unstructured.NestedBool(pool, "spec", "disabled"); disabled {
+				continue
+			}
+			cidr, _, _ := unstructured.NestedString(pool, "spec", "cidr")
+			if firstIPv4CIDR(cidr) != "" {
+				return cidr, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no Calico IPPool")
+}
+
+// ciliumClusterPool returns the cluster pool of the Cilium IPAM
+func (c Cluster) ciliumClusterPool() (string, error) {
+	configMap, err := c.Clientset.CoreV1().ConfigMaps("kube-system").Get(context.TODO(), "cilium-config", metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	return configMap.Data["cluster-pool-ipv4-cidr"], nil
+}
+
+// serviceCIDRObject reads the default ServiceCIDR of clusters serving networking.k8s.io ServiceCIDRs
+func (c Cluster) serviceCIDRObject() (string, error) {
+	serviceCIDRs, err := c.ListCustomResources(schema.GroupKind{Group: "networking.k8s.io", Kind: "ServiceCIDR"}, "")
+	if err != nil {
+		return "", err
+	}
+	for _, serviceCIDR := range serviceCIDRs {
+		if name, _, _ := unstructured.NestedString(serviceCIDR, "metadata", "name"); name != "kubernetes" {
+			continue
+		}
+		cidrs, _, _ := unstructured.NestedStringSlice(serviceCIDR, "spec", "cidrs")
+		return strings.Join(cidrs, ","), nil
+	}
+	return "", fmt.Errorf("no default ServiceCIDR")
+}
+
+// nodePodCIDRs estimates the cluster CIDR as the smallest network, but at least a /16,
+// containing the pod CIDRs allocated to the nodes
+func (c Cluster) nodePodCIDRs() (string, error) {
+	nodes, err := c.Clientset.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return "", err
+	}
+	var networks []*net.IPNet
+	for _, node := range nodes.Items {
+		for _, cidr := range podCIDRsOfNode(node) {
+			if _, network, err := net.ParseCIDR(cidr); err == nil && network.IP.To4() != nil {
+				networks = append(networks, network)
+			}
+		}
+	}
+	if len(networks) == 0 {
+		return "", fmt.Errorf("no node has a podCIDR")
+	}
+
+	first := networks[0].IP.To4()
+	prefix, _ := networks[0].Mask.Size()
+	for _, network := range networks[1:] {
+		ip := network.IP.To4()
+		common := 0
+		for common < 32 && bitAt(first, common) == bitAt(ip, common) {
+			common++
+		}
+		size, _ := network.Mask.Size()
+		prefix = min(prefix, common, size)
+	}
+	prefix = min(prefix, 16)
+	supernet := net.IPNet{IP: first.Mask(net.CIDRMask(prefix, 32)), Mask: net.CIDRMask(prefix, 32)}
+	return supernet.String(), nil
+}
+
+func podCIDRsOfNode(node v1.Node) []string {
+	if len(node.Spec.PodCIDRs) > 0 {
+		return node.Spec.PodCIDRs
+	}
+	if node.Spec.PodCIDR != "" {
+		return []string{node.Spec.PodCIDR}
+	}
+	return nil
+}
+
+func bitAt(ip net.IP, index int) byte {
+	return (ip[index/8] >> (7 - index%8)) & 1
+}
+
+// flagValue finds a flag given as "--flag=value" or "--flag value"
+func flagValue(args []string, flag string) (string, bool) {
+	for i, arg := range args {
+		if value, ok := strings.CutPrefix(arg, flag+"="); ok {
+			return value, true
+		}
+		if arg == flag && i+1 < len(args) {
+			return args[i+1], true
+		}
+	}
+	return "", false
+}
@@ -15,21 +15,6 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 )
 
-func (c Cluster) FetchMasterNode() *v1.NodeList {
-	nodes, err := c.Clientset.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{
-		LabelSelector: "node-role.kubernetes.io/master",
-	})
-	if err != nil {
-		panic(err.Error())
-	}
-
-	if len(nodes.Items) == 0 {
-		//log.Fatal("No master node found")
-	}
-
-	return nodes
-}
-
 func (c Cluster) AddNodeLabels(node *v1.Node, labels map[string]string) {
 	// Create a patch with the new labels
 	patchLabels := map[string]interface{}{"metadata": map[string]interface{}{"labels": labels}}
@@ -168,26 +153,9 @@ func (c Cluster) FetchServiceCIDRs() (string, error) {
 		return "", fmt.Errorf("error creating service: %v", err)
 	}
 
-	return "", nil // Return an empty string if no error occurs
-}
-
-func (c Cluster) FetchPodCIDRs() (string, error) {
-	nodes, err := c.Clientset.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{
-		LabelSelector: "node-role.kubernetes.io/master",
-	})
-
-	if err != nil {
-		return "", fmt.Errorf("error retrieving nodes: %v", err)
-	}
-
-	for _, node := range nodes.Items {
-		// Return the first podCIDR found on a master node
-		if node.Spec.PodCIDR != "" {
-			return node.Spec.PodCIDR, nil
-		}
-	}
-
-	return "", fmt.Errorf("no podCIDR found for master nodes")
+	// 1.1.1.1 is inside the service range, the probe service has to be removed again
+	_ = c.Clientset.CoreV1().Services("default").Delete(context.TODO(), service.Name, metav1.DeleteOptions{})
+	return "", fmt.Errorf("service range contains the probed IP %s", service.Spec.ClusterIP)
 }
 
 func (c Cluster) FetchKubernetesAPIEndpoint() (string, error) {
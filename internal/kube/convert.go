@@ -0,0 +1,379 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Conversion converts objects of one API version into another one. Convert modifies
+// the object in place and returns the parts that could not be converted automatically.
+// Reject returns why an object cannot be converted at all, or an empty string.
+type Conversion struct {
+	From    schema.GroupVersionKind
+	To      schema.GroupVersionKind
+	Convert func(obj map[string]interface{}) []string
+	Reject  func(obj map[string]interface{}) string
+}
+
+// ConversionIssue describes an object that could not be converted, or only partially
+type ConversionIssue struct {
+	Kind      string
+	Namespace string
+	Name      string
+	Reason    string
+	Skipped   bool
+}
+
+const (
+	traefikLegacyGroup = "traefik.containo.us"
+	traefikGroup       = "traefik.io"
+)
+
+var conversions = buildConversions()
+
+var conversionIssues []ConversionIssue
+
+func buildConversions() []Conversion {
+	var result []Conversion
+
+	// Traefik v2 CRDs moved from traefik.containo.us to traefik.io
+	for _, kind := range []string{"IngressRoute", "IngressRouteTCP", "IngressRouteUDP", "Middleware", "MiddlewareTCP",
+		"TraefikService", "TLSOption", "TLSStore", "ServersTransport", "ServersTransportTCP"} {
+		result = append(result, Conversion{
+			From:    schema.GroupVersionKind{Group: traefikLegacyGroup, Version: "v1alpha1", Kind: kind},
+			To:      schema.GroupVersionKind{Group: traefikGroup, Version: "v1alpha1", Kind: kind},
+			Convert: convertTraefikLegacy,
+		})
+	}
+
+	// Removed Kubernetes API versions
+	for _, from := range []string{"extensions/v1beta1", "networking.k8s.io/v1beta1"} {
+		gv, _ := schema.ParseGroupVersion(from)
+		result = append(result, Conversion{
+			From:    gv.WithKind("Ingress"),
+			To:      schema.GroupVersionKind{Group: "networking.k8s.io", Version: "v1", Kind: "Ingress"},
+			Convert: convertIngressV1beta1,
+		})
+	}
+	result = append(result,
+		Conversion{
+			From:   schema.GroupVersionKind{Group: "policy", Version: "v1beta1", Kind: "PodDisruptionBudget"},
+			To:     schema.GroupVersionKind{Group: "policy", Version: "v1", Kind: "PodDisruptionBudget"},
+			Reject: rejectPDBV1beta1,
+		},
+		Conversion{
+			From: schema.GroupVersionKind{Group: "batch", Version: "v1beta1", Kind: "CronJob"},
+			To:   schema.GroupVersionKind{Group: "batch", Version: "v1", Kind: "CronJob"},
+		},
+		Conversion{
+			From: schema.GroupVersionKind{Group: "autoscaling", Version: "v2beta2", Kind: "HorizontalPodAutoscaler"},
+			To:   schema.GroupVersionKind{Group: "autoscaling", Version: "v2", Kind: "HorizontalPodAutoscaler"},
+		},
+	)
+
+	return result
+}
+
+// ConversionIssues returns all objects reported during conversion
+func ConversionIssues() []ConversionIssue {
+	return conversionIssues
+}
+
+// ConvertObject converts an object into a version served by the target. If no
+// conversion is needed the object is returned unchanged.
+func ConvertObject(obj map[string]interface{}, target APIVersions) ([]string, error) {
+	u := &unstructured.Unstructured{Object: obj}
+	gvk := u.GroupVersionKind()
+
+	var issues []string
+	for !target.Serves(gvk) {
+		conversion, ok := findConversion(gvk, target)
+		if !ok {
+			return issues, fmt.Errorf("no conversion from %s to a version served by the target cluster (served: %s)",
+				gvk.GroupVersion().String(), strings.Join(target[gvk.GroupKind()].Versions, ", "))
+		}
+		if conversion.Reject != nil {
+			if reason := conversion.Reject(obj); reason != "" {
+				return issues, fmt.Errorf("%s", reason)
+			}
+		}
+		if conversion.Convert != nil {
+			issues = append(issues, conversion.Convert(obj)...)
+		}
+		u.SetGroupVersionKind(conversion.To)
+		gvk = conversion.To
+	}
+	return issues, nil
+}
+
+// ConvertibleTo reports whether objects of the given version can be converted into a version served by
+// the target. Single objects may still be rejected by the conversion.
+func ConvertibleTo(gvk schema.GroupVersionKind, target APIVersions) bool {
+	for !target.Serves(gvk) {
+		conversion, ok := findConversion(gvk, target)
+		if !ok {
+			return false
+		}
+		gvk = conversion.To
+	}
+	return true
+}
+
+// findConversion prefers a conversion whose result is served by the target and
+// falls back to any conversion from the given version to allow chaining
+func findConversion(gvk schema.GroupVersionKind, target APIVersions) (Conversion, bool) {
+	var fallback *Conversion
+	for i, conversion := range conversions {
+		if conversion.From != gvk {
+			continue
+		}
+		if target.Serves(conversion.To) {
+			return conversion, true
+		}
+		if fallback == nil {
+			fallback = &conversions[i]
+		}
+	}
+	if fallback != nil {
+		return *fallback, true
+	}
+	return Conversion{}, false
+}
+
+// convertTraefikLegacy handles the differences between the traefik.containo.us
+// and the traefik.io CRDs
+func convertTraefikLegacy(obj map[string]interface{}) []string {
+	var issues []string
+
+	// Routes written for Traefik v2 keep their rule syntax
+	if routes, found, _ := unstructured.NestedSlice(obj, "spec", "routes"); found {
+		for _, route := range routes {
+			if routeMap, ok := route.(map[string]interface{}); ok {
+				if _, set := routeMap["syntax"]; !set {
+					routeMap["syntax"] = "v2"
+				}
+			}
+		}
+		_ = unstructured.SetNestedSlice(obj, routes, "spec", "routes")
+	}
+
+	renameField(obj, []string{"spec", "ipWhiteList"}, "ipAllowList")
+
+	for _, field := range []string{"sslRedirect", "sslTemporaryRedirect", "sslHost", "sslForceHost", "featurePolicy"} {
+		if _, found, _ := unstructured.NestedFieldNoCopy(obj, "spec", "headers", field); found {
+			unstructured.RemoveNestedField(obj, "spec", "headers", field)
+			issues = append(issues, fmt.Sprintf("spec.headers.%s was removed in traefik.io, use a RedirectScheme middleware or permissionsPolicy instead", field))
+		}
+	}
+	return issues
+}
+
+// convertIngressV1beta1 maps the flat v1beta1 backends to the v1 service backend
+func convertIngressV1beta1(obj map[string]interface{}) []string {
+	var issues []string
+
+	if backend, found, _ := unstructured.NestedMap(obj, "spec", "backend"); found {
+		unstructured.RemoveNestedField(obj, "spec", "backend")
+		_ = unstructured.SetNestedMap(obj, convertIngressBackend(backend), "spec", "defaultBackend")
+	}
+
+	rules, found, _ := unstructured.NestedSlice(obj, "spec", "rules")
+	if !found {
+		return issues
+	}
+	for _, rule := range rules {
+		ruleMap, ok := rule.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		paths, _, _ := unstructured.NestedSlice(ruleMap, "http", "paths")
+		for _, path := range paths {
+			pathMap, ok := path.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if _, set := pathMap["pathType"]; !set {
+				pathMap["pathType"] = "ImplementationSpecific"
+			}
+			if backend, ok := pathMap["backend"].(map[string]interface{}); ok {
+				if _, isResource := backend["resource"]; isResource {
+					continue
+				}
+				pathMap["backend"] = convertIngressBackend(backend)
+			}
+		}
+		if len(paths) > 0 {
+			_ = unstructured.SetNestedSlice(ruleMap, paths, "http", "paths")
+		}
+	}
+	_ = unstructured.SetNestedSlice(obj, rules, "spec", "rules")
+	return issues
+}
+
+// rejectPDBV1beta1 rejects budgets with an empty selector, which selects no pods in v1beta1
+// but every pod of the namespace in v1 and would block all evictions there
+func rejectPDBV1beta1(obj map[string]interface{}) string {
+	selector, found, _ := unstructured.NestedMap(obj, "spec", "selector")
+	if !found || len(selector) == 0 {
+		return "the empty selector matches no pods in policy/v1beta1 but all pods of the namespace in policy/v1"
+	}
+	labels, _, _ := unstructured.NestedMap(selector, "matchLabels")
+	expressions, _, _ := unstructured.NestedSlice(selector, "matchExpressions")
+	if len(labels) == 0 && len(expressions) == 0 {
+		return "the empty selector matches no pods in policy/v1beta1 but all pods of the namespace in policy/v1"
+	}
+	return ""
+}
+
+func convertIngressBackend(backend map[string]interface{}) map[string]interface{} {
+	if _, isResource := backend["resource"]; isResource {
+		return backend
+	}
+
+	port := map[string]interface{}{}
+	switch servicePort := backend["servicePort"].(type) {
+	case string:
+		port["name"] = servicePort
+	case int64:
+		port["number"] = servicePort
+	case float64:
+		port["number"] = int64(servicePort)
+	}
+
+	return map[string]interface{}{
+		"service": map[string]interface{}{
+			"name": backend["serviceName"],
+			"port": port,
+		},
+	}
+}
+
+func renameField(obj map[string]interface{}, path []string, newName string) {
+	value, found, _ := unstructured.NestedFieldCopy(obj, path...)
+	if !found {
+		return
+	}
+	unstructured.RemoveNestedField(obj, path...)
+	newPath := append(append([]string{}, path[:len(path)-1]...), newName)
+	_ = unstructured.SetNestedField(obj, value, newPath...)
+}
+
+// convertedKind returns the version copied by the typed clients for resource types that have older
+// versions, and the groups the kind is looked up in when the objects have to be converted
+func convertedKind(resourceType ResourceType) (schema.GroupVersionKind, []schema.GroupKind, bool) {
+	switch resourceType {
+	case Ingress:
+		return schema.GroupVersionKind{Group: "networking.k8s.io", Version: "v1", Kind: "Ingress"},
+			[]schema.GroupKind{{Group: "networking.k8s.io", Kind: "Ingress"}, {Group: "extensions", Kind: "Ingress"}}, true
+	}
+	if traefikType, ok := resourceType.(TraefikResourceType); ok {
+		return schema.GroupVersionKind{Group: traefikGroup, Version: "v1alpha1", Kind: string(traefikType)},
+			[]schema.GroupKind{{Group: traefikGroup, Kind: string(traefikType)}, {Group: traefikLegacyGroup, Kind: string(traefikType)}}, true
+	}
+	return schema.GroupVersionKind{}, nil, false
+}
+
+// requiresConversion reports whether a kind cannot be copied with the typed clients because
+// one of the clusters does not serve the version of the client
+func (c *Clusters) requiresConversion(gvk schema.GroupVersionKind) bool {
+	for _, cluster := range []Cluster{c.Origin, c.Target} {
+		versions, err := cluster.FetchAPIVersions()
+		if err != nil || !versions.Serves(gvk) {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateConvertedResourceDiff copies all objects of the given kinds that exist in the origin
+// but not in the target cluster, converting them to an API version the target serves.
+// The candidates are tried in order and the first one served by the origin is used.
+// Objects that cannot be converted are skipped and reported via ConversionIssues.
+func (c *Clusters) CreateConvertedResourceDiff(candidates ...schema.GroupKind) error {
+	originVersions, err := c.Origin.FetchAPIVersions()
+	if err != nil {
+		return err
+	}
+	targetVersions, err := c.Target.FetchAPIVersions()
+	if err != nil {
+		return err
+	}
+
+	var source schema.GroupVersionKind
+	found := false
+	for _, candidate := range candidates {
+		if source, found = originVersions.Preferred(candidate); found {
+			break
+		}
+	}
+	if !found {
+		// The kind is not used in the origin cluster
+		return nil
+	}
+
+	sourceResource, _ := originVersions.Resource(source)
+	list, err := c.Origin.DynamicClientset.Resource(sourceResource).Namespace("").List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list %s in origin cluster: %w", sourceResource.String(), err)
+	}
+
+	for _, item := range list.Items {
+		meta := metav1.ObjectMeta{Labels: item.GetLabels(), Annotations: item.GetAnnotations(), OwnerReferences: item.GetOwnerReferences()}
+		if isGenerated(&meta) || item.GetNamespace() == "clustershift" {
+			continue
+		}
+
+		obj := cleanUnstructuredForCreation(item)
+		issues, err := ConvertObject(obj.Object, targetVersions)
+		if err != nil {
+			reportConversionIssue(item, err.Error(), true)
+			continue
+		}
+
+		targetGVK := obj.GroupVersionKind()
+		targetResource, _ := targetVersions.Resource(targetGVK)
+		_, err = c.Target.DynamicClientset.Resource(targetResource).Namespace(item.GetNamespace()).Get(context.TODO(), item.GetName(), metav1.GetOptions{})
+		if err == nil {
+			continue
+		}
+		if !k8serrors.IsNotFound(err) {
+			return fmt.Errorf("failed to fetch %s %s/%s of target cluster: %w", targetGVK.Kind, item.GetNamespace(), item.GetName(), err)
+		}
+		for _, issue := range issues {
+			reportConversionIssue(item, issue, false)
+		}
+
+		if err := c.Target.CreateCustomResource(item.GetNamespace(), obj.Object); err != nil {
+			return fmt.Errorf("failed to create %s %s/%s in target cluster: %w", targetGVK.Kind, item.GetNamespace(), item.GetName(), err)
+		}
+	}
+	return nil
+}
+
+func cleanUnstructuredForCreation(item unstructured.Unstructured) *unstructured.Unstructured {
+	obj := item.DeepCopy()
+	for _, field := range []string{"uid", "resourceVersion", "generation", "creationTimestamp", "managedFields", "ownerReferences", "selfLink"} {
+		unstructured.RemoveNestedField(obj.Object, "metadata", field)
+	}
+	annotations := obj.GetAnnotations()
+	delete(annotations, "kubectl.kubernetes.io/last-applied-configuration")
+	obj.SetAnnotations(annotations)
+	unstructured.RemoveNestedField(obj.Object, "status")
+	return obj
+}
+
+func reportConversionIssue(item unstructured.Unstructured, reason string, skipped bool) {
+	conversionIssues = append(conversionIssues, ConversionIssue{
+		Kind:      item.GetKind(),
+		Namespace: item.GetNamespace(),
+		Name:      item.GetName(),
+		Reason:    reason,
+		Skipped:   skipped,
+	})
+}
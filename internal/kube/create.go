@@ -115,6 +115,9 @@ func (c Cluster) CreateConfigmap(name string, namespace string, data map[string]
 }
 
 func (c Cluster) CreateResourcesFromURL(url, namespace string) error {
+	// The manifests may install CRDs
+	defer InvalidateAPIVersions()
+
 	// Register Traefik schemes
 	_ = traefikv1alpha1.AddToScheme(scheme.Scheme)
 
@@ -190,7 +193,7 @@ func (c Cluster) CreateResourcesFromURL(url, namespace string) error {
 			})
 
 		if err != nil {
-			return fmt.Errorf("failed to apply %s %s: %v", gvk.Kind, obj.GetName(), err)
+			return fmt.Errorf("failed to apply %s %s: %w", gvk.Kind, obj.GetName(), err)
 		}
 
 		//fmt.Printf("Successfully created %s/%s in namespace %s\n",
@@ -273,7 +276,7 @@ func (c Cluster) CreateResourcesFromYaml(yamlContent []byte, namespace string) e
 					return fmt.Errorf("failed to create %s %s: %v", gvk.Kind, obj.GetName(), err)
 				}
 			} else {
-				return fmt.Errorf("failed to apply %s %s: %v", gvk.Kind, obj.GetName(), err)
+				return fmt.Errorf("failed to apply %s %s: %w", gvk.Kind, obj.GetName(), err)
 			}
 		}
 	}
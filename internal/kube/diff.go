@@ -2,13 +2,51 @@ package kube
 
 import (
 	"clustershift/internal/exit"
+	"clustershift/internal/logger"
 	"fmt"
 	"reflect"
+	"strings"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+// GeneratedLabelMarkers and GeneratedAnnotationMarkers identify objects that are generated
+// by a controller or operator rather than authored. An empty value matches any value of the key.
+// Objects carrying one of these markers are skipped by CreateResourceDiff.
+var (
+	GeneratedLabelMarkers = map[string]string{
+		"cnpg.io/cluster": "",
+	}
+	GeneratedAnnotationMarkers = map[string]string{
+		"kubernetes.io/service-account.name": "",
+	}
+)
+
+// AddGeneratedMarkers registers additional label and annotation markers.
+// Each marker is given as "key" or "key=value".
+func AddGeneratedMarkers(labels []string, annotations []string) {
+	for _, marker := range labels {
+		key, value := parseMarker(marker)
+		GeneratedLabelMarkers[key] = value
+	}
+	for _, marker := range annotations {
+		key, value := parseMarker(marker)
+		GeneratedAnnotationMarkers[key] = value
+	}
+}
+
+func parseMarker(marker string) (string, string) {
+	key, value, _ := strings.Cut(marker, "=")
+	return strings.TrimSpace(key), strings.TrimSpace(value)
+}
+
 func (c *Clusters) CreateResourceDiff(resourceType ResourceType) {
+	if gvk, candidates, ok := convertedKind(resourceType); ok && c.requiresConversion(gvk) {
+		err := c.CreateConvertedResourceDiff(candidates...)
+		exit.OnErrorWithMessage(err, fmt.Sprintf("Failed to convert %s for target cluster", resourceType))
+		return
+	}
+
 	diffResources, err := c.getResourceDiff(resourceType)
 	if err != nil {
 		//fmt.Printf("Error getting %s diff: %v\n", resourceType, err)
@@ -62,6 +100,10 @@ func (c Clusters) getResourceDiff(resourceType ResourceType) (interface{}, error
 		item := originalItems.Index(i).Interface()
 		meta := reflect.ValueOf(item).FieldByName("ObjectMeta").Interface().(metav1.ObjectMeta)
 		key := fmt.Sprintf("%s/%s", meta.Namespace, meta.Name)
+		if isGenerated(&meta) {
+			logger.Debug(fmt.Sprintf("Skipping generated %s %s", resourceType, key))
+			continue
+		}
 		if !targetResourceMap[key] {
 			diffResources = append(diffResources, item)
 		}
@@ -70,6 +112,25 @@ func (c Clusters) getResourceDiff(resourceType ResourceType) (interface{}, error
 	return diffResources, nil
 }
 
+// isGenerated reports whether an object is managed by a controller or carries a generated marker.
+// Such objects are recreated by their owner in the target cluster and must not be copied.
+func isGenerated(meta *metav1.ObjectMeta) bool {
+	if metav1.GetControllerOfNoCopy(meta) != nil {
+		return true
+	}
+	return matchesMarkers(meta.Labels, GeneratedLabelMarkers) || matchesMarkers(meta.Annotations, GeneratedAnnotationMarkers)
+}
+
+func matchesMarkers(values map[string]string, markers map[string]string) bool {
+	for key, expected := range markers {
+		value, exists := values[key]
+		if exists && (expected == "" || expected == value) {
+			return true
+		}
+	}
+	return false
+}
+
 func CleanResourceForCreation(resource interface{}) interface{} {
 	resourceValue := reflect.ValueOf(resource)
 	if resourceValue.Kind() == reflect.Ptr {
@@ -0,0 +1,146 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+)
+
+// ServedKind describes how a kind is served by the API server
+type ServedKind struct {
+	Resource   string
+	Namespaced bool
+	Versions   []string // ordered by server preference
+}
+
+// APIVersions maps every kind served by a cluster to its resource and versions
+type APIVersions map[schema.GroupKind]ServedKind
+
+// apiVersionsCache holds the discovered API versions per cluster until CRDs are installed
+var apiVersionsCache = struct {
+	sync.Mutex
+	versions map[discovery.DiscoveryInterface]APIVersions
+}{versions: make(map[discovery.DiscoveryInterface]APIVersions)}
+
+// InvalidateAPIVersions drops the discovered API versions of all clusters, it is called after installing
+// resources that may add CRDs
+func InvalidateAPIVersions() {
+	apiVersionsCache.Lock()
+	defer apiVersionsCache.Unlock()
+	apiVersionsCache.versions = make(map[discovery.DiscoveryInterface]APIVersions)
+}
+
+// FetchAPIVersions returns the API versions served by the cluster, discovery runs once until the
+// cache is invalidated. Groups that fail discovery (e.g. an unavailable aggregated API) are skipped.
+func (c Cluster) FetchAPIVersions() (APIVersions, error) {
+	apiVersionsCache.Lock()
+	defer apiVersionsCache.Unlock()
+	if versions, ok := apiVersionsCache.versions[c.DiscoveryClientset]; ok {
+		return versions, nil
+	}
+	versions, err := c.discoverAPIVersions()
+	if err != nil {
+		return nil, err
+	}
+	apiVersionsCache.versions[c.DiscoveryClientset] = versions
+	return versions, nil
+}
+
+func (c Cluster) discoverAPIVersions() (APIVersions, error) {
+	groups, resourceLists, err := c.DiscoveryClientset.ServerGroupsAndResources()
+	if err != nil && !discovery.IsGroupDiscoveryFailedError(err) {
+		return nil, fmt.Errorf("failed to discover API resources of %s cluster: %w", c.Name, err)
+	}
+
+	resourcesByGroupVersion := make(map[string][]string)
+	versions := make(APIVersions)
+	for _, list := range resourceLists {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			continue
+		}
+		for _, resource := range list.APIResources {
+			// Subresources like pods/log are not kinds on their own
+			if strings.Contains(resource.Name, "/") {
+				continue
+			}
+			gk := schema.GroupKind{Group: gv.Group, Kind: resource.Kind}
+			served := versions[gk]
+			served.Resource = resource.Name
+			served.Namespaced = resource.Namespaced
+			versions[gk] = served
+			resourcesByGroupVersion[list.GroupVersion] = append(resourcesByGroupVersion[list.GroupVersion], resource.Kind)
+		}
+	}
+
+	// Add versions in the order of preference reported by the group
+	for _, group := range groups {
+		for _, version := range group.Versions {
+			for _, kind := range resourcesByGroupVersion[version.GroupVersion] {
+				gk := schema.GroupKind{Group: group.Name, Kind: kind}
+				served := versions[gk]
+				served.Versions = append(served.Versions, version.Version)
+				versions[gk] = served
+			}
+		}
+	}
+
+	return versions, nil
+}
+
+// Serves reports whether the given group, version and kind is served
+func (v APIVersions) Serves(gvk schema.GroupVersionKind) bool {
+	for _, version := range v[gvk.GroupKind()].Versions {
+		if version == gvk.Version {
+			return true
+		}
+	}
+	return false
+}
+
+// Preferred returns the preferred version of a kind, or false if the kind is not served
+func (v APIVersions) Preferred(gk schema.GroupKind) (schema.GroupVersionKind, bool) {
+	served, ok := v[gk]
+	if !ok || len(served.Versions) == 0 {
+		return schema.GroupVersionKind{}, false
+	}
+	return gk.WithVersion(served.Versions[0]), true
+}
+
+// Resource returns the GroupVersionResource of a served kind
+func (v APIVersions) Resource(gvk schema.GroupVersionKind) (schema.GroupVersionResource, bool) {
+	served, ok := v[gvk.GroupKind()]
+	if !ok {
+		return schema.GroupVersionResource{}, false
+	}
+	return gvk.GroupVersion().WithResource(served.Resource), true
+}
+
+// ListCustomResources lists a custom resource in its preferred served version.
+// An empty namespace lists the resources of all namespaces.
+func (c Cluster) ListCustomResources(gk schema.GroupKind, namespace string) ([]map[string]interface{}, error) {
+	versions, err := c.FetchAPIVersions()
+	if err != nil {
+		return nil, err
+	}
+	gvk, served := versions.Preferred(gk)
+	if !served {
+		return nil, fmt.Errorf("%s is not served, the CRDs are not installed", gk.String())
+	}
+	gvr, _ := versions.Resource(gvk)
+	list, err := c.DynamicClientset.Resource(gvr).Namespace(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	var items []map[string]interface{}
+	for _, item := range list.Items {
+		items = append(items, item.Object)
+	}
+	return items, nil
+}
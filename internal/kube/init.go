@@ -37,6 +37,15 @@ func InitClients(originKubeconfigPath, targetKubeconfigPath string) (Clusters, e
 	return *clusters, nil
 }
 
+// InitCluster initializes a client for an additional cluster, e.g. a dedicated Submariner broker
+func InitCluster(kubeconfigPath, name string) (Cluster, error) {
+	c, err := newCluster(kubeconfigPath, name, "context-"+name)
+	if err != nil {
+		return Cluster{}, fmt.Errorf("failed to initialize %s cluster: %w", name, err)
+	}
+	return *c, nil
+}
+
 func newCluster(kubeconfigPath, name, context string) (*Cluster, error) {
 	config, err := clientcmd.BuildConfigFromFlags("", kubeconfigPath)
 	if err != nil {
@@ -85,6 +94,17 @@ func GetClusters() *Clusters {
 	return clusters
 }
 
+// Peer returns the other cluster of the migration
+func Peer(c Cluster) (Cluster, error) {
+	if clusters == nil {
+		return Cluster{}, fmt.Errorf("clusters are not initialized")
+	}
+	if c.Name == clusters.Origin.Name {
+		return clusters.Target, nil
+	}
+	return clusters.Origin, nil
+}
+
 func LoadKubeConfig(kubeconfigPath string) (*clientcmdapi.Config, error) {
 	config, err := clientcmd.LoadFromFile(kubeconfigPath)
 	if err != nil {
@@ -0,0 +1,217 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// NodeSelection chooses the nodes a cluster is reached at and the nodes running the gateways
+// of the networking tools
+type NodeSelection struct {
+	Strategy string
+	Selector string // label selector used by NodeStrategySelector
+	Count    int    // maximum number of nodes, 0 selects all matching nodes
+}
+
+const (
+	// NodeStrategyControlPlane selects the control-plane nodes and falls back to nodes with an
+	// external IP on managed clusters that hide their control plane
+	NodeStrategyControlPlane = "control-plane"
+	// NodeStrategyExternalIP selects nodes that have an external IP
+	NodeStrategyExternalIP = "external-ip"
+	// NodeStrategySelector selects nodes matching a user specified label selector
+	NodeStrategySelector = "selector"
+
+	GatewayNodeLabel = "clustershift.io/gateway"
+)
+
+var (
+	ProbeNodes   = NodeSelection{Strategy: NodeStrategyControlPlane}
+	GatewayNodes = NodeSelection{Strategy: NodeStrategyControlPlane, Count: 1}
+
+	controlPlaneLabels = []string{"node-role.kubernetes.io/control-plane", "node-role.kubernetes.io/master"}
+)
+
+// ParseNodeSelection parses "control-plane", "external-ip" or a label selector
+func ParseNodeSelection(value string, count int) (NodeSelection, error) {
+	if count < 0 {
+		return NodeSelection{}, fmt.Errorf("node count must not be negative")
+	}
+	switch value {
+	case "", NodeStrategyControlPlane:
+		return NodeSelection{Strategy: NodeStrategyControlPlane, Count: count}, nil
+	case NodeStrategyExternalIP:
+		return NodeSelection{Strategy: NodeStrategyExternalIP, Count: count}, nil
+	}
+	if _, err := labels.Parse(value); err != nil {
+		return NodeSelection{}, fmt.Errorf("invalid node selector %q: %v", value, err)
+	}
+	return NodeSelection{Strategy: NodeStrategySelector, Selector: value, Count: count}, nil
+}
+
+func (s NodeSelection) String() string {
+	if s.Strategy == NodeStrategySelector {
+		return s.Selector
+	}
+	return s.Strategy
+}
+
+// SelectNodes returns the ready nodes matching the selection sorted by name
+func (c Cluster) SelectNodes(selection NodeSelection) ([]v1.Node, error) {
+	nodeList, err := c.Clientset.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{LabelSelector: selection.Selector})
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving nodes: %v", err)
+	}
+	var ready []v1.Node
+	for _, node := range nodeList.Items {
+		if isNodeReady(node) {
+			ready = append(ready, node)
+		}
+	}
+	sort.Slice(ready, func(i, j int) bool { return ready[i].Name < ready[j].Name })
+
+	var nodes []v1.Node
+	switch selection.Strategy {
+	case NodeStrategyControlPlane:
+		nodes = filterNodes(ready, isControlPlane)
+		if len(nodes) == 0 {
+			nodes = filterNodes(ready, hasExternalIP)
+		}
+		if len(nodes) == 0 {
+			nodes = ready
+		}
+	case NodeStrategyExternalIP:
+		nodes = filterNodes(ready, hasExternalIP)
+	default:
+		nodes = ready
+	}
+
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("no ready node matches %s in %s cluster", selection, c.Name)
+	}
+	if selection.Count > 0 && len(nodes) > selection.Count {
+		nodes = nodes[:selection.Count]
+	}
+	return nodes, nil
+}
+
+// LabelGatewayNodes labels the selected gateway nodes and removes the label from all other nodes
+func (c Cluster) LabelGatewayNodes(selection NodeSelection, extraLabels map[string]string) ([]v1.Node, error) {
+	nodes, err := c.SelectNodes(selection)
+	if err != nil {
+		return nil, err
+	}
+
+	labeled, err := c.Clientset.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{LabelSelector: GatewayNodeLabel})
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving nodes: %v", err)
+	}
+	for _, node := range labeled.Items {
+		if containsNode(nodes, node.Name) {
+			continue
+		}
+		if err := c.RemoveNodeLabel(&node, GatewayNodeLabel); err != nil {
+			return nil, err
+		}
+		for key := range extraLabels {
+			if err := c.RemoveNodeLabel(&node, key); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	nodeLabels := map[string]string{GatewayNodeLabel: "true"}
+	for key, value := range extraLabels {
+		nodeLabels[key] = value
+	}
+	for i := range nodes {
+		c.AddNodeLabels(&nodes[i], nodeLabels)
+	}
+	return nodes, nil
+}
+
+// RemoveNodeLabel removes a label from a node
+func (c Cluster) RemoveNodeLabel(node *v1.Node, key string) error {
+	patch := fmt.Sprintf(`{"metadata":{"labels":{%q:null}}}`, key)
+	_, err := c.Clientset.CoreV1().Nodes().Patch(context.TODO(), node.Name, types.MergePatchType, []byte(patch), metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to remove label %s from node %s: %w", key, node.Name, err)
+	}
+	return nil
+}
+
+// GatewayTolerations tolerates the taints of the control-plane nodes gateways may run on
+func GatewayTolerations() []v1.Toleration {
+	var tolerations []v1.Toleration
+	for _, label := range controlPlaneLabels {
+		tolerations = append(tolerations, v1.Toleration{Key: label, Operator: v1.TolerationOpExists, Effect: v1.TaintEffectNoSchedule})
+	}
+	return tolerations
+}
+
+// NodeAddress returns the external IP of a node, or its internal IP if it has none
+func NodeAddress(node v1.Node) string {
+	for _, addressType := range []v1.NodeAddressType{v1.NodeExternalIP, v1.NodeInternalIP} {
+		for _, address := range node.Status.Addresses {
+			if address.Type == addressType {
+				return address.Address
+			}
+		}
+	}
+	return ""
+}
+
+func isControlPlane(node v1.Node) bool {
+	for _, label := range controlPlaneLabels {
+		if _, exists := node.Labels[label]; exists {
+			return true
+		}
+	}
+	return false
+}
+
+func hasExternalIP(node v1.Node) bool {
+	for _, address := range node.Status.Addresses {
+		if address.Type == v1.NodeExternalIP {
+			return true
+		}
+	}
+	return false
+}
+
+func isNodeReady(node v1.Node) bool {
+	if node.Spec.Unschedulable && !isControlPlane(node) {
+		return false
+	}
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == v1.NodeReady {
+			return condition.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+func filterNodes(nodes []v1.Node, keep func(v1.Node) bool) []v1.Node {
+	var filtered []v1.Node
+	for _, node := range nodes {
+		if keep(node) {
+			filtered = append(filtered, node)
+		}
+	}
+	return filtered
+}
+
+func containsNode(nodes []v1.Node, name string) bool {
+	for _, node := range nodes {
+		if node.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -11,6 +11,7 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/dynamic"
 )
@@ -218,3 +219,16 @@ func ConvertFromCluster(cluster *apiv1.Cluster) (map[string]interface{}, error)
 
 	return data, nil
 }
+
+// WaitForService waits until the service exists in the cluster, imported services are created
+// asynchronously by the networking tool
+func WaitForService(c Cluster, name string, namespace string, timeout time.Duration) error {
+	err := wait.PollUntilContextTimeout(context.TODO(), 2*time.Second, timeout, true, func(ctx context.Context) (bool, error) {
+		_, err := c.Clientset.CoreV1().Services(namespace).Get(ctx, name, metav1.GetOptions{})
+		return err == nil, nil
+	})
+	if err != nil {
+		return fmt.Errorf("service %s/%s does not exist in %s cluster after %s", namespace, name, c.Name, timeout)
+	}
+	return nil
+}
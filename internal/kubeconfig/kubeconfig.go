@@ -42,6 +42,8 @@ func getClusterDetails(clusterType string) (string, string, string) {
 		return "cluster-origin", "user-origin", "context-origin"
 	case "target":
 		return "cluster-target", "user-target", "context-target"
+	case "broker":
+		return "cluster-broker", "user-broker", "context-broker"
 	default:
 		return "", "", ""
 	}
@@ -96,6 +98,8 @@ func getOutputPath(clusterType string) string {
 		return "tmp/origin_kubeconfig.yaml"
 	case "target":
 		return "tmp/target_kubeconfig.yaml"
+	case "broker":
+		return "tmp/broker_kubeconfig.yaml"
 	default:
 		return ""
 	}
@@ -0,0 +1,30 @@
+package migration
+
+import (
+	"clustershift/internal/kube"
+	"time"
+)
+
+// ServiceExporter exports services of one cluster to the peer cluster after the model of the
+// Multi-Cluster Services API: an exported service is imported into the peer, where it is reachable
+// by a DNS name. Callers use it instead of branching on the networking tool.
+type ServiceExporter interface {
+	// PrepareNamespace readies the namespace in both clusters for exporting and importing services
+	PrepareNamespace(c kube.Clusters, namespace string) error
+	// PrepareConsumer readies a namespace whose pods connect to imported services, ingress marks
+	// the namespace of the ingress controller
+	PrepareConsumer(c kube.Cluster, namespace string, ingress bool) error
+	// ExportService exports the service of the cluster to the peer
+	ExportService(c kube.Cluster, namespace string, name string) error
+	// WaitForImport waits until the service exported by the cluster is usable in the peer
+	WaitForImport(c kube.Cluster, namespace, name string, timeout time.Duration) error
+	// ImportedDNSName returns the name the peer reaches the service exported by the cluster at
+	ImportedDNSName(c kube.Cluster, namespace, name string) string
+	// ImportedServiceName returns the service of the peer namespace the import is reachable through,
+	// or an empty string if it is only reachable by its DNS name
+	ImportedServiceName(c kube.Cluster, namespace, name string) string
+	UnexportService(c kube.Cluster, namespace, name string) error
+	// SidecarRouting reports whether a sidecar routes imported services, clients then have to
+	// connect to the service address instead of its endpoints
+	SidecarRouting() bool
+}
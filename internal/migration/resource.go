@@ -3,20 +3,22 @@ package migration
 import (
 	"clustershift/internal/kube"
 	"clustershift/internal/prompt"
+	"clustershift/pkg/cilium"
+	"clustershift/pkg/direct"
+	"clustershift/pkg/istio"
 	"clustershift/pkg/linkerd"
+	"clustershift/pkg/mcs"
 	"clustershift/pkg/skupper"
 	"clustershift/pkg/submariner"
 	"fmt"
+	"time"
 )
 
 type Resources interface {
+	ServiceExporter
 	InstallNetworkingTool(clusters kube.Clusters)
-	GetDNSName(name, namespace string) string
-	GetPostgresDNSName(name, namespace string) string
 	GetHeadlessDNSName(podName, serviceName, namespace, clusterId string) string
-	ExportService(c kube.Cluster, namespace string, name string)
 	GetNetworkingTool() string
-	GetCNPGHostname(clusterName, dbClusterName, namespace string) string
 }
 
 type SubmarinerResources struct {
@@ -27,28 +29,49 @@ func (s *SubmarinerResources) InstallNetworkingTool(clusters kube.Clusters) {
 	submariner.Install(clusters)
 }
 
-func (s *SubmarinerResources) GetDNSName(name, namespace string) string {
-	return fmt.Sprintf("origin.%s-rw.%s.svc.clusterset.local", name, namespace)
+func (s *SubmarinerResources) GetHeadlessDNSName(podName, serviceName, namespace, clusterId string) string {
+	return fmt.Sprintf("%s.%s.%s.%s.svc.clusterset.local", podName, submariner.ClusterID(clusterId), serviceName, namespace)
 }
 
-func (s *SubmarinerResources) GetPostgresDNSName(name, namespace string) string {
-	return fmt.Sprintf("origin.%s.%s.svc.clusterset.local", name, namespace)
+func (s *SubmarinerResources) ExportService(c kube.Cluster, namespace string, name string) error {
+	return submariner.Export(c, namespace, name, "")
 }
 
-func (s *SubmarinerResources) GetHeadlessDNSName(podName, serviceName, namespace, clusterId string) string {
-	return fmt.Sprintf("%s.%s.%s.%s.svc.clusterset.local", podName, clusterId, serviceName, namespace)
+func (s *SubmarinerResources) PrepareNamespace(c kube.Clusters, namespace string) error {
+	return nil
 }
 
-func (s *SubmarinerResources) ExportService(c kube.Cluster, namespace string, name string) {
-	submariner.Export(c, namespace, name, "")
+func (s *SubmarinerResources) PrepareConsumer(c kube.Cluster, namespace string, ingress bool) error {
+	return nil
 }
 
-func (s *SubmarinerResources) GetNetworkingTool() string {
-	return s.networkingTool
+func (s *SubmarinerResources) WaitForImport(c kube.Cluster, namespace, name string, timeout time.Duration) error {
+	peer, err := kube.Peer(c)
+	if err != nil {
+		return err
+	}
+	return submariner.WaitForImport(peer, namespace, name, timeout)
 }
 
-func (s *SubmarinerResources) GetCNPGHostname(clusterName, dbClusterName, namespace string) string {
-	return fmt.Sprintf("%s.%s-rw.%s.svc.clusterset.local", clusterName, dbClusterName, namespace)
+func (s *SubmarinerResources) ImportedDNSName(c kube.Cluster, namespace, name string) string {
+	return fmt.Sprintf("%s.%s.%s.svc.clusterset.local", submariner.ClusterID(c.Name), name, namespace)
+}
+
+// ImportedServiceName is empty, Lighthouse only provides clusterset DNS names
+func (s *SubmarinerResources) ImportedServiceName(c kube.Cluster, namespace, name string) string {
+	return ""
+}
+
+func (s *SubmarinerResources) UnexportService(c kube.Cluster, namespace, name string) error {
+	return submariner.Unexport(c, namespace, name)
+}
+
+func (s *SubmarinerResources) SidecarRouting() bool {
+	return false
+}
+
+func (s *SubmarinerResources) GetNetworkingTool() string {
+	return s.networkingTool
 }
 
 type LinkerdResources struct {
@@ -59,31 +82,61 @@ func (l *LinkerdResources) InstallNetworkingTool(clusters kube.Clusters) {
 	linkerd.Install(clusters)
 }
 
-func (l *LinkerdResources) GetDNSName(name, namespace string) string {
-	return fmt.Sprintf("%s-rw-origin.%s.svc.cluster.local", name, namespace)
+// GetHeadlessDNSName returns the per-pod address of the headless mirror of an exported headless service
+func (l *LinkerdResources) GetHeadlessDNSName(podName, serviceName, namespace, clusterId string) string {
+	return fmt.Sprintf("%s.%s-%s.%s.svc.cluster.local", podName, serviceName, clusterId, namespace)
 }
 
-func (l *LinkerdResources) GetPostgresDNSName(name, namespace string) string {
-	return fmt.Sprintf("%s-origin.%s.svc.cluster.local", name, namespace)
+// ExportService mirrors the service, PrepareNamespace meshes the namespaces of the databases
+func (l *LinkerdResources) ExportService(c kube.Cluster, namespace string, name string) error {
+	return linkerd.MirrorService(c, name, namespace)
 }
 
-// GetHeadlessDNSName TODO - This is a temporary solution, we need to find a way to handle headless services properly
-func (l *LinkerdResources) GetHeadlessDNSName(podName, serviceName, namespace, clusterId string) string {
-	return fmt.Sprintf("%s.%s.%s.%s.svc.clusterset.local", podName, clusterId, serviceName, namespace)
+// PrepareNamespace meshes the namespace in both clusters, the gateway only forwards to meshed pods
+// and only meshed pods reach mirrored services
+func (l *LinkerdResources) PrepareNamespace(c kube.Clusters, namespace string) error {
+	for _, cluster := range []kube.Cluster{c.Origin, c.Target} {
+		cluster.CreateNewNamespace(namespace)
+		if err := linkerd.InjectNamespace(cluster, namespace); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (l *LinkerdResources) ExportService(c kube.Cluster, namespace string, name string) {
-	linkerd.ExportService(c, name, namespace)
+func (l *LinkerdResources) PrepareConsumer(c kube.Cluster, namespace string, ingress bool) error {
+	if ingress {
+		return linkerd.InjectIngress(c, namespace)
+	}
+	return linkerd.InjectNamespace(c, namespace)
 }
 
-func (l *LinkerdResources) GetNetworkingTool() string {
-	return l.networkingTool
+func (l *LinkerdResources) WaitForImport(c kube.Cluster, namespace, name string, timeout time.Duration) error {
+	peer, err := kube.Peer(c)
+	if err != nil {
+		return err
+	}
+	return linkerd.WaitForMirror(peer, name, namespace, c.Name, timeout)
+}
+
+func (l *LinkerdResources) ImportedDNSName(c kube.Cluster, namespace, name string) string {
+	return fmt.Sprintf("%s-%s.%s.svc.cluster.local", name, c.Name, namespace)
+}
+
+func (l *LinkerdResources) ImportedServiceName(c kube.Cluster, namespace, name string) string {
+	return name + "-" + c.Name
+}
+
+func (l *LinkerdResources) UnexportService(c kube.Cluster, namespace, name string) error {
+	return linkerd.UnexportService(c, name, namespace)
 }
 
-func (l *LinkerdResources) GetCNPGHostname(clusterName, dbClusterName, namespace string) string {
-	// For Linkerd, the mirrored service name is constructed as: {original-service-name}-rw-{cluster-name}
-	// This matches how addRWServiceToYaml constructs the service name
-	return fmt.Sprintf("%s-rw-%s.%s.svc.cluster.local", dbClusterName, clusterName, namespace)
+func (l *LinkerdResources) SidecarRouting() bool {
+	return false
+}
+
+func (l *LinkerdResources) GetNetworkingTool() string {
+	return l.networkingTool
 }
 
 type SkupperResources struct {
@@ -94,29 +147,262 @@ func (s *SkupperResources) InstallNetworkingTool(clusters kube.Clusters) {
 	skupper.Install(clusters)
 }
 
-func (s *SkupperResources) GetDNSName(name, namespace string) string {
-	return fmt.Sprintf("%s.%s.svc.cluster.local", name, namespace)
+// GetHeadlessDNSName returns the per-pod address of an exported headless service, Skupper exposes
+// every pod as a service named after the pod and the cluster
+func (s *SkupperResources) GetHeadlessDNSName(podName, serviceName, namespace, clusterId string) string {
+	return fmt.Sprintf("%s-%s.%s.svc.cluster.local", podName, clusterId, namespace)
 }
 
-func (s *SkupperResources) GetPostgresDNSName(name, namespace string) string {
-	return fmt.Sprintf("%s-origin.%s.svc.cluster.local", name, namespace)
+func (s *SkupperResources) ExportService(c kube.Cluster, namespace string, name string) error {
+	return skupper.ExportService(c, namespace, name)
 }
 
-// GetHeadlessDNSName TODO - This is a temporary solution, we need to find a way to handle headless services properly
-func (s *SkupperResources) GetHeadlessDNSName(podName, serviceName, namespace, clusterId string) string {
-	return fmt.Sprintf("%s.%s.%s.%s.svc.clusterset.local", podName, clusterId, serviceName, namespace)
+// PrepareNamespace links the sites of the namespace, Skupper only connects services of linked sites
+func (s *SkupperResources) PrepareNamespace(c kube.Clusters, namespace string) error {
+	skupper.CreateSiteConnection(c, namespace)
+	return nil
+}
+
+func (s *SkupperResources) PrepareConsumer(c kube.Cluster, namespace string, ingress bool) error {
+	return nil
 }
 
-func (s *SkupperResources) ExportService(c kube.Cluster, namespace string, name string) {
-	skupper.ExportService(c, namespace, name)
+func (s *SkupperResources) WaitForImport(c kube.Cluster, namespace, name string, timeout time.Duration) error {
+	return skupper.WaitForImport(c, namespace, name, timeout)
+}
+
+func (s *SkupperResources) ImportedDNSName(c kube.Cluster, namespace, name string) string {
+	return fmt.Sprintf("%s-%s.%s.svc.cluster.local", name, c.Name, namespace)
+}
+
+func (s *SkupperResources) ImportedServiceName(c kube.Cluster, namespace, name string) string {
+	return name + "-" + c.Name
+}
+
+func (s *SkupperResources) UnexportService(c kube.Cluster, namespace, name string) error {
+	return skupper.UnexportService(c, namespace, name)
+}
+
+func (s *SkupperResources) SidecarRouting() bool {
+	return false
 }
 
 func (s *SkupperResources) GetNetworkingTool() string {
 	return s.networkingTool
 }
 
-func (s *SkupperResources) GetCNPGHostname(clusterName, dbClusterName, namespace string) string {
-	return fmt.Sprintf("%s-rw-%s.%s.svc.cluster.local", dbClusterName, clusterName, namespace)
+type IstioResources struct {
+	networkingTool string
+}
+
+func (i *IstioResources) InstallNetworkingTool(clusters kube.Clusters) {
+	istio.Install(clusters)
+}
+
+// GetHeadlessDNSName returns the per-pod address of the alias both clusters get for an exported headless service
+func (i *IstioResources) GetHeadlessDNSName(podName, serviceName, namespace, clusterId string) string {
+	return fmt.Sprintf("%s.%s-%s.%s.svc.cluster.local", podName, serviceName, clusterId, namespace)
+}
+
+func (i *IstioResources) ExportService(c kube.Cluster, namespace string, name string) error {
+	return istio.ExportService(c, namespace, name)
+}
+
+func (i *IstioResources) PrepareNamespace(c kube.Clusters, namespace string) error {
+	return nil
+}
+
+func (i *IstioResources) PrepareConsumer(c kube.Cluster, namespace string, ingress bool) error {
+	// Only pods with a sidecar route the aliases through the east-west gateway
+	return istio.EnableInjection(c, namespace)
+}
+
+func (i *IstioResources) WaitForImport(c kube.Cluster, namespace, name string, timeout time.Duration) error {
+	peer, err := kube.Peer(c)
+	if err != nil {
+		return err
+	}
+	return kube.WaitForService(peer, name+"-"+c.Name, namespace, timeout)
+}
+
+func (i *IstioResources) ImportedDNSName(c kube.Cluster, namespace, name string) string {
+	return fmt.Sprintf("%s-%s.%s.svc.cluster.local", name, c.Name, namespace)
+}
+
+func (i *IstioResources) ImportedServiceName(c kube.Cluster, namespace, name string) string {
+	return name + "-" + c.Name
+}
+
+func (i *IstioResources) UnexportService(c kube.Cluster, namespace, name string) error {
+	return istio.UnexportService(c, namespace, name)
+}
+
+func (i *IstioResources) SidecarRouting() bool {
+	return true
+}
+
+func (i *IstioResources) GetNetworkingTool() string {
+	return i.networkingTool
+}
+
+type CiliumResources struct {
+	networkingTool string
+}
+
+func (c *CiliumResources) InstallNetworkingTool(clusters kube.Clusters) {
+	cilium.Install(clusters)
+}
+
+// GetHeadlessDNSName returns the per-pod address of the alias both clusters get for an exported headless service
+func (c *CiliumResources) GetHeadlessDNSName(podName, serviceName, namespace, clusterId string) string {
+	return fmt.Sprintf("%s.%s-%s.%s.svc.cluster.local", podName, serviceName, clusterId, namespace)
+}
+
+func (c *CiliumResources) ExportService(cluster kube.Cluster, namespace string, name string) error {
+	return cilium.Export(cluster, namespace, name)
+}
+
+func (c *CiliumResources) PrepareNamespace(clusters kube.Clusters, namespace string) error {
+	return nil
+}
+
+func (c *CiliumResources) PrepareConsumer(cluster kube.Cluster, namespace string, ingress bool) error {
+	return nil
+}
+
+func (c *CiliumResources) WaitForImport(cluster kube.Cluster, namespace, name string, timeout time.Duration) error {
+	peer, err := kube.Peer(cluster)
+	if err != nil {
+		return err
+	}
+	return kube.WaitForService(peer, name+"-"+cluster.Name, namespace, timeout)
+}
+
+func (c *CiliumResources) ImportedDNSName(cluster kube.Cluster, namespace, name string) string {
+	return fmt.Sprintf("%s-%s.%s.svc.cluster.local", name, cluster.Name, namespace)
+}
+
+func (c *CiliumResources) ImportedServiceName(cluster kube.Cluster, namespace, name string) string {
+	return name + "-" + cluster.Name
+}
+
+func (c *CiliumResources) UnexportService(cluster kube.Cluster, namespace, name string) error {
+	return cilium.Unexport(cluster, namespace, name)
+}
+
+func (c *CiliumResources) SidecarRouting() bool {
+	return false
+}
+
+func (c *CiliumResources) GetNetworkingTool() string {
+	return c.networkingTool
+}
+
+type DirectResources struct {
+	networkingTool string
+}
+
+func (d *DirectResources) InstallNetworkingTool(clusters kube.Clusters) {
+	direct.Install(clusters)
+}
+
+// GetHeadlessDNSName returns the per-pod address of the alias both clusters get for an exported headless service
+func (d *DirectResources) GetHeadlessDNSName(podName, serviceName, namespace, clusterId string) string {
+	return fmt.Sprintf("%s.%s-%s.%s.svc.cluster.local", podName, serviceName, clusterId, namespace)
+}
+
+func (d *DirectResources) ExportService(cluster kube.Cluster, namespace string, name string) error {
+	return direct.Export(cluster, namespace, name)
+}
+
+func (d *DirectResources) PrepareNamespace(c kube.Clusters, namespace string) error {
+	return nil
+}
+
+func (d *DirectResources) PrepareConsumer(c kube.Cluster, namespace string, ingress bool) error {
+	return nil
+}
+
+func (d *DirectResources) WaitForImport(c kube.Cluster, namespace, name string, timeout time.Duration) error {
+	peer, err := kube.Peer(c)
+	if err != nil {
+		return err
+	}
+	return kube.WaitForService(peer, name+"-"+c.Name, namespace, timeout)
+}
+
+func (d *DirectResources) ImportedDNSName(c kube.Cluster, namespace, name string) string {
+	return fmt.Sprintf("%s-%s.%s.svc.cluster.local", name, c.Name, namespace)
+}
+
+func (d *DirectResources) ImportedServiceName(c kube.Cluster, namespace, name string) string {
+	return name + "-" + c.Name
+}
+
+func (d *DirectResources) UnexportService(c kube.Cluster, namespace, name string) error {
+	return direct.Unexport(c, namespace, name)
+}
+
+func (d *DirectResources) SidecarRouting() bool {
+	return false
+}
+
+func (d *DirectResources) GetNetworkingTool() string {
+	return d.networkingTool
+}
+
+type MCSResources struct {
+	networkingTool string
+}
+
+func (m *MCSResources) InstallNetworkingTool(clusters kube.Clusters) {
+	mcs.Install(clusters)
+}
+
+// GetHeadlessDNSName returns the per-pod clusterset address of the exported alias of a headless service
+func (m *MCSResources) GetHeadlessDNSName(podName, serviceName, namespace, clusterId string) string {
+	return fmt.Sprintf("%s.%s.%s-%s.%s.svc.clusterset.local", podName, mcs.ClusterID(clusterId), serviceName, clusterId, namespace)
+}
+
+func (m *MCSResources) ExportService(c kube.Cluster, namespace string, name string) error {
+	return mcs.Export(c, namespace, name)
+}
+
+func (m *MCSResources) PrepareNamespace(c kube.Clusters, namespace string) error {
+	return nil
+}
+
+func (m *MCSResources) PrepareConsumer(c kube.Cluster, namespace string, ingress bool) error {
+	return nil
+}
+
+func (m *MCSResources) WaitForImport(c kube.Cluster, namespace, name string, timeout time.Duration) error {
+	peer, err := kube.Peer(c)
+	if err != nil {
+		return err
+	}
+	return mcs.WaitForImport(peer, namespace, name, c.Name, timeout)
+}
+
+func (m *MCSResources) ImportedDNSName(c kube.Cluster, namespace, name string) string {
+	return fmt.Sprintf("%s-%s.%s.svc.clusterset.local", name, c.Name, namespace)
+}
+
+// ImportedServiceName is empty, implementations name the services backing imports differently
+func (m *MCSResources) ImportedServiceName(c kube.Cluster, namespace, name string) string {
+	return ""
+}
+
+func (m *MCSResources) UnexportService(c kube.Cluster, namespace, name string) error {
+	return mcs.Unexport(c, namespace, name)
+}
+
+func (m *MCSResources) SidecarRouting() bool {
+	return false
+}
+
+func (m *MCSResources) GetNetworkingTool() string {
+	return m.networkingTool
 }
 
 func GetMigrationResources(tool string) (Resources, error) {
@@ -127,6 +413,14 @@ func GetMigrationResources(tool string) (Resources, error) {
 		return &LinkerdResources{networkingTool: tool}, nil
 	case prompt.NetworkingToolSkupper:
 		return &SkupperResources{networkingTool: tool}, nil
+	case prompt.NetworkingToolIstio:
+		return &IstioResources{networkingTool: tool}, nil
+	case prompt.NetworkingToolCilium:
+		return &CiliumResources{networkingTool: tool}, nil
+	case prompt.NetworkingToolDirect:
+		return &DirectResources{networkingTool: tool}, nil
+	case prompt.NetworkingToolMCS:
+		return &MCSResources{networkingTool: tool}, nil
 	default:
 		return nil, fmt.Errorf("unsupported networking tool: %s", tool)
 	}
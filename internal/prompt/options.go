@@ -1,17 +1,110 @@
 package prompt
 
+import "time"
+
 const (
 	NetworkingToolSubmariner = "Submariner"
 	NetworkingToolLinkerd    = "Linkerd"
 	NetworkingToolSkupper    = "Skupper"
+	NetworkingToolIstio      = "Istio"
+	NetworkingToolCilium     = "Cilium"
+	NetworkingToolDirect     = "Direct"
+	NetworkingToolMCS        = "MCS"
 
 	ReroutingClustershift = "Clustershift"
 	ReroutingSubmariner   = "Submariner"
 	ReroutingLinkerd      = "Linkerd"
 	ReroutingSkupper      = "Skupper"
+	ReroutingIstio        = "Istio"
+
+	GlobalnetAuto     = "auto"
+	GlobalnetEnabled  = "true"
+	GlobalnetDisabled = "false"
+
+	CableDriverLibreswan = "libreswan"
+	CableDriverWireGuard = "wireguard"
+	CableDriverVXLAN     = "vxlan"
+
+	BrokerOrigin = "origin"
+	BrokerTarget = "target"
+
+	IstioMultiPrimary  = "multi-primary"
+	IstioPrimaryRemote = "primary-remote"
+
+	DirectLoadBalancer = "loadbalancer"
+	DirectNodePort     = "nodeport"
+
+	CertStoreSecret = "secret"
 )
 
 type MigrationOptions struct {
 	NetworkingTool string
 	Rerouting      string
+	SkipPreflight  bool
+	Network        NetworkOverrides
+	Submariner     SubmarinerProfile
+	SkupperVersion int    // version installed if neither cluster runs Skupper, 0 uses the latest
+	IstioMode      string // IstioMultiPrimary or IstioPrimaryRemote with the origin cluster as primary
+	DirectExposure string // DirectLoadBalancer or DirectNodePort services exposed by the Direct networking tool
+	LinkerdCerts   LinkerdCertOptions
+	Rollout        RolloutOptions
+	ProxyUpstreams map[string]string // host to address the Clustershift proxy forwards to, other hosts go to ProxyDefault
+	ProxyDefault   string            // upstream of all other hosts, the Traefik LoadBalancer of the target cluster if empty
+	Shift          ShiftOptions
+	Mirror         MirrorOptions
+}
+
+// NetworkOverrides replace the CIDRs and broker URL discovered from the clusters.
+// Empty values are discovered.
+type NetworkOverrides struct {
+	OriginPodCIDR     string
+	TargetPodCIDR     string
+	OriginServiceCIDR string
+	TargetServiceCIDR string
+	BrokerURL         string
+}
+
+// SubmarinerProfile configures the Submariner deployment
+type SubmarinerProfile struct {
+	CableDriver     string
+	NATTraversal    bool
+	Globalnet       string // GlobalnetAuto enables globalnet only if the CIDRs overlap
+	Version         string // chart version
+	ImageRepository string // empty uses the images of the chart
+	Broker          string // BrokerOrigin, BrokerTarget or the kubeconfig path of a third cluster
+	Gateways        int    // gateway nodes per cluster, 0 uses the --gateway-count selection
+}
+
+// LinkerdCertOptions configures the trust anchor and identity issuer of Linkerd. Without
+// certificate files or a cert-manager issuer the certificates are generated and stored.
+type LinkerdCertOptions struct {
+	TrustAnchorFile    string
+	TrustAnchorKeyFile string // optional, allows clustershift to renew the issuer
+	IssuerCertFile     string
+	IssuerKeyFile      string
+	CertManagerIssuer  string        // Issuer/<name> in the linkerd namespace or ClusterIssuer/<name>
+	Store              string        // CertStoreSecret or the path of an encrypted file
+	IssuerValidity     time.Duration // validity of generated and renewed issuers
+}
+
+// RolloutOptions configures the restarts injecting the mesh proxy into running workloads
+type RolloutOptions struct {
+	MaxConcurrent int  // workloads restarted at once, 0 restarts all of them at once
+	RespectPDBs   bool // wait until the PodDisruptionBudgets of a workload allow a disruption
+	SkipInjected  bool // skip workloads whose pods already run the proxy
+	Canary        bool // restart one workload first and check its pods are ready with the proxy
+}
+
+// ShiftOptions configures the gradual cutover of the ingress routes to the target cluster
+type ShiftOptions struct {
+	Steps        []int         // percentages of the traffic sent to the target cluster, empty switches in one step
+	Hold         time.Duration // time every step is held while the target is checked
+	MaxErrorRate float64       // share of 5xx responses above which the cutover is reverted
+}
+
+// MirrorOptions configures the mirroring of ingress traffic to the target cluster before the cutover
+type MirrorOptions struct {
+	Percent  int           // share of the requests copied to the target cluster, 0 disables mirroring
+	Duration time.Duration // time the traffic is mirrored
+	Writes   bool          // mirror all methods instead of GET and HEAD only, the target applies the writes to its data
 }
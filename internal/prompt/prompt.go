@@ -26,8 +26,8 @@ func Select(message string, options []string) string {
 }
 
 func MigrationPrompt() MigrationOptions {
-	networkingTool := Select("Select a networking tool", []string{NetworkingToolSubmariner, NetworkingToolLinkerd, NetworkingToolSkupper})
-	rerouting := Select("Select a rerouting option", []string{ReroutingClustershift, ReroutingSubmariner, ReroutingLinkerd, ReroutingSkupper})
+	networkingTool := Select("Select a networking tool", []string{NetworkingToolSubmariner, NetworkingToolLinkerd, NetworkingToolSkupper, NetworkingToolIstio, NetworkingToolCilium, NetworkingToolDirect, NetworkingToolMCS})
+	rerouting := Select("Select a rerouting option", []string{ReroutingClustershift, ReroutingSubmariner, ReroutingLinkerd, ReroutingSkupper, ReroutingIstio})
 
 	return MigrationOptions{
 		NetworkingTool: networkingTool,
@@ -0,0 +1,215 @@
+package capacity
+
+import (
+	"clustershift/internal/kube"
+	"clustershift/internal/logger"
+	"context"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Totals are the summed up resource requests of a set of workloads
+type Totals struct {
+	CPU     resource.Quantity
+	Memory  resource.Quantity
+	Storage resource.Quantity
+	Pods    int64
+}
+
+// Finding is a capacity or scheduling problem detected during the analysis.
+// Blocking findings mean a workload would stay Pending in the target cluster.
+type Finding struct {
+	Workload string
+	Blocking bool
+	Message  string
+}
+
+// Analysis is the result of comparing the origin workloads with the target cluster
+type Analysis struct {
+	Workloads []Workload
+	Required  Totals
+	Available Totals
+	Findings  []Finding
+}
+
+const defaultStorageClassAnnotation = "storageclass.kubernetes.io/is-default-class"
+
+// Analyze sums up the requests of all workloads selected for migration and checks
+// whether the target cluster can schedule them
+func Analyze(clusters kube.Clusters) (Analysis, error) {
+	var analysis Analysis
+
+	workloads, err := collectWorkloads(clusters.Origin)
+	if err != nil {
+		return analysis, err
+	}
+	analysis.Workloads = workloads
+	analysis.Required = sumWorkloads(workloads)
+
+	nodes, err := fetchNodeStates(clusters.Target)
+	if err != nil {
+		return analysis, err
+	}
+	for _, state := range nodes {
+		if state.node.Spec.Unschedulable {
+			continue
+		}
+		analysis.Available.CPU.Add(state.cpu)
+		analysis.Available.Memory.Add(state.memory)
+		analysis.Available.Pods += state.pods
+	}
+
+	analysis.checkTotals()
+
+	for _, workload := range workloads {
+		unscheduled, reasons := schedule(workload, nodes)
+		if unscheduled > 0 {
+			analysis.addFinding(workload, true, "%d of %d replicas would stay Pending: %s", unscheduled, workload.Replicas, summarizeReasons(reasons))
+		}
+	}
+
+	if err := analysis.checkStorageClasses(clusters.Target); err != nil {
+		return analysis, err
+	}
+	if err := analysis.checkQuotas(clusters.Target); err != nil {
+		return analysis, err
+	}
+
+	logger.Debug(fmt.Sprintf("Capacity analysis finished with %d findings", len(analysis.Findings)))
+	return analysis, nil
+}
+
+// HasBlockingFindings reports whether at least one workload could not be scheduled
+func (a Analysis) HasBlockingFindings() bool {
+	for _, finding := range a.Findings {
+		if finding.Blocking {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *Analysis) addFinding(workload Workload, blocking bool, format string, args ...interface{}) {
+	a.Findings = append(a.Findings, Finding{
+		Workload: workload.String(),
+		Blocking: blocking,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+func sumWorkloads(workloads []Workload) Totals {
+	var totals Totals
+	for _, workload := range workloads {
+		for i := int32(0); i < workload.Replicas; i++ {
+			totals.CPU.Add(workload.CPU)
+			totals.Memory.Add(workload.Memory)
+		}
+		for i := int32(0); i < workload.claimSets(); i++ {
+			for _, claim := range workload.Claims {
+				totals.Storage.Add(claim.Size)
+			}
+		}
+		totals.Pods += int64(workload.Replicas)
+	}
+	return totals
+}
+
+func (a *Analysis) checkTotals() {
+	if a.Required.CPU.Cmp(a.Available.CPU) > 0 {
+		a.Findings = append(a.Findings, Finding{Message: fmt.Sprintf("requested cpu %s exceeds free target cpu %s", a.Required.CPU.String(), a.Available.CPU.String())})
+	}
+	if a.Required.Memory.Cmp(a.Available.Memory) > 0 {
+		a.Findings = append(a.Findings, Finding{Message: fmt.Sprintf("requested memory %s exceeds free target memory %s", a.Required.Memory.String(), a.Available.Memory.String())})
+	}
+	if a.Required.Pods > a.Available.Pods {
+		a.Findings = append(a.Findings, Finding{Message: fmt.Sprintf("%d pods exceed the free target pod capacity of %d", a.Required.Pods, a.Available.Pods)})
+	}
+}
+
+// checkStorageClasses verifies every claim references a storage class present in the target cluster
+func (a *Analysis) checkStorageClasses(c kube.Cluster) error {
+	storageClasses, err := c.Clientset.StorageV1().StorageClasses().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list storage classes: %w", err)
+	}
+
+	available := make(map[string]bool)
+	hasDefault := false
+	for _, storageClass := range storageClasses.Items {
+		available[storageClass.Name] = true
+		if storageClass.Annotations[defaultStorageClassAnnotation] == "true" {
+			hasDefault = true
+		}
+	}
+
+	for _, workload := range a.Workloads {
+		for _, claim := range workload.Claims {
+			if claim.StorageClass == "" && !hasDefault {
+				a.addFinding(workload, true, "claim of %s uses the default storage class but the target cluster has none", claim.Size.String())
+			} else if claim.StorageClass != "" && !available[claim.StorageClass] {
+				a.addFinding(workload, true, "storage class %s does not exist in the target cluster", claim.StorageClass)
+			}
+		}
+	}
+	return nil
+}
+
+// checkQuotas compares the requests per namespace with the resource quotas of
+// namespaces that already exist in the target cluster
+func (a *Analysis) checkQuotas(c kube.Cluster) error {
+	requested := make(map[string]v1.ResourceList)
+	var order []string
+	for _, workload := range a.Workloads {
+		list, ok := requested[workload.Namespace]
+		if !ok {
+			list = v1.ResourceList{}
+			order = append(order, workload.Namespace)
+		}
+		totals := sumWorkloads([]Workload{workload})
+		addQuantity(list, v1.ResourceRequestsCPU, totals.CPU)
+		addQuantity(list, v1.ResourceRequestsMemory, totals.Memory)
+		addQuantity(list, v1.ResourceRequestsStorage, totals.Storage)
+		addQuantity(list, v1.ResourcePods, *resource.NewQuantity(totals.Pods, resource.DecimalSI))
+		addQuantity(list, v1.ResourcePersistentVolumeClaims, *resource.NewQuantity(int64(len(workload.Claims))*int64(workload.claimSets()), resource.DecimalSI))
+		requested[workload.Namespace] = list
+	}
+
+	for _, namespace := range order {
+		quotas, err := c.Clientset.CoreV1().ResourceQuotas(namespace).List(context.TODO(), metav1.ListOptions{})
+		if k8serrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to list resource quotas in namespace %s: %w", namespace, err)
+		}
+
+		for _, quota := range quotas.Items {
+			for name, hard := range quota.Status.Hard {
+				request, ok := requested[namespace][name]
+				if !ok {
+					continue
+				}
+				free := hard.DeepCopy()
+				free.Sub(quota.Status.Used[name])
+				if request.Cmp(free) > 0 {
+					a.Findings = append(a.Findings, Finding{
+						Workload: "namespace " + namespace,
+						Blocking: true,
+						Message:  fmt.Sprintf("resource quota %s allows %s more %s, %s requested", quota.Name, free.String(), name, request.String()),
+					})
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func addQuantity(list v1.ResourceList, name v1.ResourceName, quantity resource.Quantity) {
+	current := list[name]
+	current.Add(quantity)
+	list[name] = current
+}
@@ -0,0 +1,211 @@
+package capacity
+
+import (
+	"clustershift/internal/kube"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+)
+
+// nodeState tracks the remaining capacity of a target node during the simulation
+type nodeState struct {
+	node   v1.Node
+	cpu    resource.Quantity
+	memory resource.Quantity
+	pods   int64
+}
+
+var nodeSelectorOperators = map[v1.NodeSelectorOperator]selection.Operator{
+	v1.NodeSelectorOpIn:           selection.In,
+	v1.NodeSelectorOpNotIn:        selection.NotIn,
+	v1.NodeSelectorOpExists:       selection.Exists,
+	v1.NodeSelectorOpDoesNotExist: selection.DoesNotExist,
+	v1.NodeSelectorOpGt:           selection.GreaterThan,
+	v1.NodeSelectorOpLt:           selection.LessThan,
+}
+
+// fetchNodeStates returns the allocatable resources of all target nodes minus
+// the requests of the pods already running on them
+func fetchNodeStates(c kube.Cluster) ([]*nodeState, error) {
+	nodes, err := c.Clientset.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %w", err)
+	}
+	pods, err := c.Clientset.CoreV1().Pods("").List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods: %w", err)
+	}
+
+	states := make(map[string]*nodeState)
+	var ordered []*nodeState
+	for _, node := range nodes.Items {
+		state := &nodeState{
+			node:   node,
+			cpu:    node.Status.Allocatable.Cpu().DeepCopy(),
+			memory: node.Status.Allocatable.Memory().DeepCopy(),
+			pods:   node.Status.Allocatable.Pods().Value(),
+		}
+		states[node.Name] = state
+		ordered = append(ordered, state)
+	}
+
+	for _, pod := range pods.Items {
+		state, ok := states[pod.Spec.NodeName]
+		if !ok || pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+			continue
+		}
+		cpu, memory := podRequests(pod.Spec)
+		state.cpu.Sub(cpu)
+		state.memory.Sub(memory)
+		state.pods--
+	}
+
+	return ordered, nil
+}
+
+// schedule places the replicas of a workload on the target nodes and reserves
+// their resources. It returns the number of replicas that could not be placed
+// together with the reasons reported by each node.
+func schedule(workload Workload, nodes []*nodeState) (int32, []string) {
+	var unscheduled int32
+	var reasons []string
+	for i := int32(0); i < workload.Replicas; i++ {
+		candidates, nodeReasons := feasibleNodes(workload, nodes)
+		if len(candidates) == 0 {
+			unscheduled = workload.Replicas - i
+			reasons = nodeReasons
+			break
+		}
+
+		// Spread replicas by preferring the node with the most free CPU
+		sort.SliceStable(candidates, func(a, b int) bool {
+			return candidates[a].cpu.Cmp(candidates[b].cpu) > 0
+		})
+		candidates[0].cpu.Sub(workload.CPU)
+		candidates[0].memory.Sub(workload.Memory)
+		candidates[0].pods--
+	}
+	return unscheduled, reasons
+}
+
+func feasibleNodes(workload Workload, nodes []*nodeState) ([]*nodeState, []string) {
+	var candidates []*nodeState
+	var reasons []string
+	for _, state := range nodes {
+		if reason := unfitReason(workload, state); reason != "" {
+			reasons = append(reasons, fmt.Sprintf("%s: %s", state.node.Name, reason))
+			continue
+		}
+		candidates = append(candidates, state)
+	}
+	return candidates, reasons
+}
+
+// unfitReason returns why a replica of the workload cannot run on the node, or an empty string if it fits
+func unfitReason(workload Workload, state *nodeState) string {
+	node := state.node
+	if node.Spec.Unschedulable {
+		return "node is unschedulable"
+	}
+	if taint := untoleratedTaint(workload.PodSpec.Tolerations, node.Spec.Taints); taint != nil {
+		return fmt.Sprintf("untolerated taint %s", taint.ToString())
+	}
+	if !labels.SelectorFromSet(workload.PodSpec.NodeSelector).Matches(labels.Set(node.Labels)) {
+		return "nodeSelector does not match"
+	}
+	if !matchesRequiredAffinity(workload.PodSpec.Affinity, node) {
+		return "required node affinity does not match"
+	}
+	if state.pods < 1 {
+		return "too many pods"
+	}
+	if state.cpu.Cmp(workload.CPU) < 0 {
+		return fmt.Sprintf("insufficient cpu (free %s, requested %s)", state.cpu.String(), workload.CPU.String())
+	}
+	if state.memory.Cmp(workload.Memory) < 0 {
+		return fmt.Sprintf("insufficient memory (free %s, requested %s)", state.memory.String(), workload.Memory.String())
+	}
+	return ""
+}
+
+func untoleratedTaint(tolerations []v1.Toleration, taints []v1.Taint) *v1.Taint {
+	for i := range taints {
+		taint := &taints[i]
+		if taint.Effect == v1.TaintEffectPreferNoSchedule {
+			continue
+		}
+		tolerated := false
+		for j := range tolerations {
+			if tolerations[j].ToleratesTaint(taint) {
+				tolerated = true
+				break
+			}
+		}
+		if !tolerated {
+			return taint
+		}
+	}
+	return nil
+}
+
+// matchesRequiredAffinity evaluates requiredDuringSchedulingIgnoredDuringExecution.
+// The terms are ORed, the expressions within a term are ANDed.
+func matchesRequiredAffinity(affinity *v1.Affinity, node v1.Node) bool {
+	if affinity == nil || affinity.NodeAffinity == nil || affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		return true
+	}
+
+	terms := affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+	for _, term := range terms {
+		if matchesTerm(term, node) {
+			return true
+		}
+	}
+	return len(terms) == 0
+}
+
+func matchesTerm(term v1.NodeSelectorTerm, node v1.Node) bool {
+	if len(term.MatchExpressions) == 0 && len(term.MatchFields) == 0 {
+		return false
+	}
+
+	labelSelector, err := requirementsSelector(term.MatchExpressions)
+	if err != nil || !labelSelector.Matches(labels.Set(node.Labels)) {
+		return false
+	}
+	fieldSelector, err := requirementsSelector(term.MatchFields)
+	if err != nil || !fieldSelector.Matches(labels.Set{"metadata.name": node.Name}) {
+		return false
+	}
+	return true
+}
+
+func requirementsSelector(expressions []v1.NodeSelectorRequirement) (labels.Selector, error) {
+	selector := labels.NewSelector()
+	for _, expression := range expressions {
+		operator, ok := nodeSelectorOperators[expression.Operator]
+		if !ok {
+			return nil, fmt.Errorf("unsupported operator %s", expression.Operator)
+		}
+		requirement, err := labels.NewRequirement(expression.Key, operator, expression.Values)
+		if err != nil {
+			return nil, err
+		}
+		selector = selector.Add(*requirement)
+	}
+	return selector, nil
+}
+
+func summarizeReasons(reasons []string) string {
+	if len(reasons) == 0 {
+		return "no nodes available"
+	}
+	return strings.Join(reasons, "; ")
+}
@@ -0,0 +1,230 @@
+package capacity
+
+import (
+	"clustershift/internal/constants"
+	"clustershift/internal/kube"
+	"clustershift/internal/logger"
+	"context"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Workload is a set of identical pods selected for migration
+type Workload struct {
+	Kind      string
+	Namespace string
+	Name      string
+	Replicas  int32
+	PodSpec   v1.PodSpec
+	CPU       resource.Quantity // requested per replica
+	Memory    resource.Quantity // requested per replica
+	Claims    []Claim
+	// SharedClaims is set if all replicas mount the same claims instead of one set per replica
+	SharedClaims bool
+}
+
+// Claim is a persistent volume claim requested by a workload
+type Claim struct {
+	StorageClass string
+	Size         resource.Quantity
+}
+
+// excludedNamespaces hold system components and tools installed by clustershift, some tools share a namespace
+var excludedNamespaces = namespaceSet(
+	"kube-system",
+	"kube-public",
+	"kube-node-lease",
+	"metallb-system",
+	constants.SkupperSiteControllerNamespace,
+	constants.SkupperNamespace,
+	constants.ConnectivityProbeNamespace,
+	constants.HttpProxyNamespace,
+	constants.LinkerdNamespace,
+	constants.LinkerdMultiClusterNamespace,
+	constants.LinkerdCertsNamespace,
+	constants.SubmarinerOperatorNamespace,
+	constants.SubmarinerBrokerNamespace,
+	constants.IstioNamespace,
+	constants.CiliumNamespace,
+	constants.CNPGNamespace,
+)
+
+func namespaceSet(namespaces ...string) map[string]bool {
+	set := make(map[string]bool, len(namespaces))
+	for _, namespace := range namespaces {
+		set[namespace] = true
+	}
+	return set
+}
+
+func (w Workload) String() string {
+	return fmt.Sprintf("%s %s/%s", w.Kind, w.Namespace, w.Name)
+}
+
+// collectWorkloads gathers Deployments, StatefulSets and CNPG clusters selected for migration
+func collectWorkloads(c kube.Cluster) ([]Workload, error) {
+	var workloads []Workload
+
+	deployments, err := c.Clientset.AppsV1().Deployments("").List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list deployments: %w", err)
+	}
+	for _, deployment := range deployments.Items {
+		if excludedNamespaces[deployment.Namespace] {
+			continue
+		}
+		claims, err := podVolumeClaims(c, deployment.Namespace, deployment.Spec.Template.Spec)
+		if err != nil {
+			return nil, err
+		}
+		workload := newWorkload("Deployment", deployment.ObjectMeta, replicas(deployment.Spec.Replicas), deployment.Spec.Template.Spec, claims)
+		workload.SharedClaims = true
+		workloads = append(workloads, workload)
+	}
+
+	statefulSets, err := c.Clientset.AppsV1().StatefulSets("").List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list statefulsets: %w", err)
+	}
+	for _, statefulSet := range statefulSets.Items {
+		if excludedNamespaces[statefulSet.Namespace] {
+			continue
+		}
+		workloads = append(workloads, newWorkload("StatefulSet", statefulSet.ObjectMeta, replicas(statefulSet.Spec.Replicas), statefulSet.Spec.Template.Spec, volumeClaimTemplates(statefulSet)))
+	}
+
+	cnpgClusters, err := c.FetchCustomResources("postgresql.cnpg.io", "v1", "clusters")
+	if err != nil {
+		// CNPG is not installed in the origin cluster
+		return workloads, nil
+	}
+	for _, resource := range cnpgClusters {
+		cluster, err := kube.ConvertToCluster(resource)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert cnpg cluster: %w", err)
+		}
+		if excludedNamespaces[cluster.Namespace] {
+			continue
+		}
+
+		podSpec := v1.PodSpec{
+			NodeSelector: cluster.Spec.Affinity.NodeSelector,
+			Tolerations:  cluster.Spec.Affinity.Tolerations,
+			Containers:   []v1.Container{{Name: "postgres", Resources: cluster.Spec.Resources}},
+		}
+		if cluster.Spec.Affinity.NodeAffinity != nil {
+			podSpec.Affinity = &v1.Affinity{NodeAffinity: cluster.Spec.Affinity.NodeAffinity}
+		}
+
+		var claims []Claim
+		claims = append(claims, cnpgClaim(cluster.Spec.StorageConfiguration.StorageClass, cluster.Spec.StorageConfiguration.Size))
+		if cluster.Spec.WalStorage != nil {
+			claims = append(claims, cnpgClaim(cluster.Spec.WalStorage.StorageClass, cluster.Spec.WalStorage.Size))
+		}
+
+		workloads = append(workloads, newWorkload("CNPG Cluster", cluster.ObjectMeta, int32(cluster.Spec.Instances), podSpec, claims))
+	}
+
+	return workloads, nil
+}
+
+func newWorkload(kind string, meta metav1.ObjectMeta, replicas int32, podSpec v1.PodSpec, claims []Claim) Workload {
+	cpu, memory := podRequests(podSpec)
+	return Workload{
+		Kind:      kind,
+		Namespace: meta.Namespace,
+		Name:      meta.Name,
+		Replicas:  replicas,
+		PodSpec:   podSpec,
+		CPU:       cpu,
+		Memory:    memory,
+		Claims:    claims,
+	}
+}
+
+// podRequests returns the effective requests of a pod: the sum of all containers
+// or the largest init container, whichever is higher
+func podRequests(podSpec v1.PodSpec) (resource.Quantity, resource.Quantity) {
+	cpu := resource.Quantity{}
+	memory := resource.Quantity{}
+	for _, container := range podSpec.Containers {
+		cpu.Add(*container.Resources.Requests.Cpu())
+		memory.Add(*container.Resources.Requests.Memory())
+	}
+	for _, container := range podSpec.InitContainers {
+		if container.Resources.Requests.Cpu().Cmp(cpu) > 0 {
+			cpu = container.Resources.Requests.Cpu().DeepCopy()
+		}
+		if container.Resources.Requests.Memory().Cmp(memory) > 0 {
+			memory = container.Resources.Requests.Memory().DeepCopy()
+		}
+	}
+	return cpu, memory
+}
+
+func replicas(count *int32) int32 {
+	if count == nil {
+		return 1
+	}
+	return *count
+}
+
+// podVolumeClaims resolves the PVCs mounted by a pod template in the origin cluster, missing claims are skipped
+func podVolumeClaims(c kube.Cluster, namespace string, podSpec v1.PodSpec) ([]Claim, error) {
+	var claims []Claim
+	for _, volume := range podSpec.Volumes {
+		if volume.PersistentVolumeClaim == nil {
+			continue
+		}
+		pvc, err := c.Clientset.CoreV1().PersistentVolumeClaims(namespace).Get(context.TODO(), volume.PersistentVolumeClaim.ClaimName, metav1.GetOptions{})
+		if k8serrors.IsNotFound(err) {
+			logger.Warning(fmt.Sprintf("Skipping persistent volume claim %s/%s in the capacity analysis", namespace, volume.PersistentVolumeClaim.ClaimName), err)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch persistent volume claim %s/%s: %w", namespace, volume.PersistentVolumeClaim.ClaimName, err)
+		}
+		claims = append(claims, newClaim(pvc.Spec))
+	}
+	return claims, nil
+}
+
+func volumeClaimTemplates(statefulSet appsv1.StatefulSet) []Claim {
+	var claims []Claim
+	for _, template := range statefulSet.Spec.VolumeClaimTemplates {
+		claims = append(claims, newClaim(template.Spec))
+	}
+	return claims
+}
+
+func newClaim(spec v1.PersistentVolumeClaimSpec) Claim {
+	claim := Claim{Size: spec.Resources.Requests.Storage().DeepCopy()}
+	if spec.StorageClassName != nil {
+		claim.StorageClass = *spec.StorageClassName
+	}
+	return claim
+}
+
+func cnpgClaim(storageClass *string, size string) Claim {
+	claim := Claim{}
+	if storageClass != nil {
+		claim.StorageClass = *storageClass
+	}
+	if quantity, err := resource.ParseQuantity(size); err == nil {
+		claim.Size = quantity
+	}
+	return claim
+}
+
+// claimSets returns how many sets of claims the workload creates
+func (w Workload) claimSets() int32 {
+	if w.SharedClaims {
+		return 1
+	}
+	return w.Replicas
+}
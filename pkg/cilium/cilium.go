@@ -0,0 +1,259 @@
+package cilium
+
+import (
+	"clustershift/internal/constants"
+	"clustershift/internal/exit"
+	"clustershift/internal/helm"
+	"clustershift/internal/kube"
+	"clustershift/internal/logger"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	releaseAnnotation = "meta.helm.sh/release-name"
+	caSecret          = "cilium-ca"
+	maxClusterID      = 255
+)
+
+// installation is the Cilium installation of a cluster and its ClusterMesh identity
+type installation struct {
+	release string
+	version string
+	name    string
+	id      int
+}
+
+type peer struct {
+	name    string
+	address string
+}
+
+// Install connects the Cilium installations of both clusters to a ClusterMesh. The clusters share
+// the CA of the origin cluster, get unique cluster names and IDs and connect through the
+// clustermesh-apiserver of the peer.
+func Install(c kube.Clusters) {
+	logger.Info("Connecting clusters with Cilium ClusterMesh")
+
+	origin, err := detect(c.Origin)
+	exit.OnErrorWithMessage(err, "Cilium is not installed on origin cluster")
+	target, err := detect(c.Target)
+	exit.OnErrorWithMessage(err, "Cilium is not installed on target cluster")
+	assignIdentities(c, &origin, &target)
+
+	caValues, err := caValues(c.Origin)
+	exit.OnErrorWithMessage(err, "Failed to fetch the Cilium CA of origin cluster")
+
+	enableClusterMesh(c.Origin, origin, caValues, nil)
+	enableClusterMesh(c.Target, target, caValues, nil)
+
+	originAddress, err := apiServerAddress(c.Origin)
+	exit.OnErrorWithMessage(err, "Failed to get the clustermesh-apiserver address of origin cluster")
+	targetAddress, err := apiServerAddress(c.Target)
+	exit.OnErrorWithMessage(err, "Failed to get the clustermesh-apiserver address of target cluster")
+
+	enableClusterMesh(c.Origin, origin, caValues, &peer{name: target.name, address: targetAddress})
+	enableClusterMesh(c.Target, target, caValues, &peer{name: origin.name, address: originAddress})
+}
+
+// Validate checks that both clusters run Cilium installed with Helm and that their pod CIDRs,
+// which are routed between the clusters, do not overlap
+func Validate(c kube.Clusters) error {
+	var errs []error
+	for _, cluster := range []kube.Cluster{c.Origin, c.Target} {
+		if _, err := detect(cluster); err != nil {
+			errs = append(errs, fmt.Errorf("%s cluster: %w", cluster.Name, err))
+		}
+	}
+	if _, err := c.Origin.FetchResource(kube.Secret, caSecret, constants.CiliumNamespace); err != nil {
+		errs = append(errs, fmt.Errorf("origin cluster has no %s secret to share with the target cluster", caSecret))
+	}
+
+	originCIDR, originErr := c.Origin.DiscoverPodCIDR()
+	targetCIDR, targetErr := c.Target.DiscoverPodCIDR()
+	if originErr == nil && targetErr == nil && kube.CIDRsOverlap(originCIDR.CIDR, targetCIDR.CIDR) {
+		errs = append(errs, fmt.Errorf("pod CIDRs %s and %s overlap, ClusterMesh routes pod IPs between the clusters", originCIDR.CIDR, targetCIDR.CIDR))
+	}
+	return errors.Join(errs...)
+}
+
+// detect reads the Helm release, version and ClusterMesh identity of the Cilium installation
+func detect(c kube.Cluster) (installation, error) {
+	daemonSet, err := c.Clientset.AppsV1().DaemonSets(constants.CiliumNamespace).Get(context.TODO(), "cilium", metav1.GetOptions{})
+	if err != nil {
+		return installation{}, fmt.Errorf("cilium daemonset not found: %w", err)
+	}
+	release := daemonSet.Annotations[releaseAnnotation]
+	if release == "" {
+		return installation{}, fmt.Errorf("cilium is not managed by Helm, enable ClusterMesh with the cilium CLI")
+	}
+
+	configInterface, err := c.FetchResource(kube.ConfigMap, "cilium-config", constants.CiliumNamespace)
+	if err != nil {
+		return installation{}, fmt.Errorf("cilium-config not found: %w", err)
+	}
+	config := configInterface.(*v1.ConfigMap).Data
+	id, _ := strconv.Atoi(config["cluster-id"])
+
+	return installation{
+		release: release,
+		version: imageVersion(daemonSet),
+		name:    config["cluster-name"],
+		id:      id,
+	}, nil
+}
+
+// imageVersion returns the version of the cilium agent image, which matches the chart version
+func imageVersion(daemonSet *appsv1.DaemonSet) string {
+	for _, container := range daemonSet.Spec.Template.Spec.Containers {
+		if container.Name != "cilium-agent" {
+			continue
+		}
+		image, _, _ := strings.Cut(container.Image, "@")
+		if index := strings.LastIndex(image, ":"); index != -1 {
+			return strings.TrimPrefix(image[index+1:], "v")
+		}
+	}
+	return ""
+}
+
+// assignIdentities gives the clusters unique names and IDs. ClusterMesh requires both, changing them
+// on a running cluster only applies to pods started afterwards.
+func assignIdentities(c kube.Clusters, origin, target *installation) {
+	for _, pair := range []struct {
+		cluster kube.Cluster
+		install *installation
+		other   *installation
+	}{{c.Origin, origin, target}, {c.Target, target, origin}} {
+		if pair.install.name == "" || pair.install.name == "default" || pair.install.name == pair.other.name {
+			logger.Warning(fmt.Sprintf("Renaming Cilium cluster %q of %s cluster to %q", pair.install.name, pair.cluster.Name, pair.cluster.Name),
+				fmt.Errorf("cluster name is not unique"))
+			pair.install.name = pair.cluster.Name
+		}
+		if pair.install.id == 0 || pair.install.id == pair.other.id {
+			id := 1
+			for id == pair.other.id {
+				id++
+			}
+			logger.Warning(fmt.Sprintf("Setting Cilium cluster ID of %s cluster to %d, restart workloads for it to apply to running pods", pair.cluster.Name, id),
+				fmt.Errorf("cluster ID is not unique"))
+			pair.install.id = id
+		}
+		if pair.install.id > maxClusterID {
+			exit.OnErrorWithMessage(fmt.Errorf("cluster ID %d of %s cluster exceeds %d", pair.install.id, pair.cluster.Name, maxClusterID), "Invalid Cilium cluster ID")
+		}
+	}
+}
+
+// caValues returns the CA of the cluster as chart values
+func caValues(c kube.Cluster) (map[string]interface{}, error) {
+	secretInterface, err := c.FetchResource(kube.Secret, caSecret, constants.CiliumNamespace)
+	if err != nil {
+		return nil, err
+	}
+	data := secretInterface.(*v1.Secret).Data
+	return map[string]interface{}{
+		"cert": base64.StdEncoding.EncodeToString(data["ca.crt"]),
+		"key":  base64.StdEncoding.EncodeToString(data["ca.key"]),
+	}, nil
+}
+
+// enableClusterMesh upgrades the Cilium release with the clustermesh-apiserver and, if a peer is
+// given, the connection to the clustermesh-apiserver of the peer
+func enableClusterMesh(c kube.Cluster, install installation, ca map[string]interface{}, remote *peer) {
+	logger.Info(fmt.Sprintf("Enabling ClusterMesh on %s cluster", c.Name))
+
+	clusterMesh := map[string]interface{}{
+		"useAPIServer": true,
+		"apiserver": map[string]interface{}{
+			"service": map[string]interface{}{"type": "LoadBalancer"},
+			"tls": map[string]interface{}{
+				"auto": map[string]interface{}{"enabled": true, "method": "helm"},
+			},
+		},
+	}
+	if remote != nil {
+		cluster := map[string]interface{}{"name": remote.name, "port": constants.CiliumClusterMeshPort}
+		if net.ParseIP(remote.address) != nil {
+			cluster["ips"] = []string{remote.address}
+		} else {
+			cluster["address"] = remote.address
+		}
+		clusterMesh["config"] = map[string]interface{}{
+			"enabled":  true,
+			"clusters": []interface{}{cluster},
+		}
+	}
+
+	values := map[string]interface{}{
+		"cluster":           map[string]interface{}{"name": install.name, "id": install.id},
+		"rollOutCiliumPods": true,
+		"tls":               map[string]interface{}{"ca": ca},
+		"clustermesh":       clusterMesh,
+	}
+	valuesYaml, err := yaml.Marshal(values)
+	exit.OnErrorWithMessage(err, "Failed to marshal Cilium chart values")
+
+	helmOptions := helm.HelmClientOptions{
+		KubeConfigPath: c.ClusterOptions.KubeconfigPath,
+		Context:        c.ClusterOptions.Context,
+		Namespace:      constants.CiliumNamespace,
+		Debug:          constants.Debug,
+	}
+
+	helmClient := helm.GetHelmClient(helmOptions)
+
+	chartOptions := helm.ChartOptions{
+		RepoName:    constants.CiliumRepoName,
+		RepoURL:     constants.CiliumRepoURL,
+		Namespace:   constants.CiliumNamespace,
+		ReleaseName: install.release,
+		ChartName:   constants.CiliumChartName,
+		Values:      string(valuesYaml),
+		Version:     install.version,
+		ReuseValues: true,
+		Wait:        true,
+	}
+
+	helm.HelmAddandInstallChart(helmClient, chartOptions)
+
+	err = kube.WaitForPodsReadyByLabel(c, "k8s-app="+constants.CiliumClusterMeshAPIName, constants.CiliumNamespace, 3*time.Minute)
+	exit.OnErrorWithMessage(err, "Failed to wait for clustermesh-apiserver to be ready")
+}
+
+// apiServerAddress waits for the load balancer address of the clustermesh-apiserver
+func apiServerAddress(c kube.Cluster) (string, error) {
+	var address string
+	err := wait.PollUntilContextTimeout(context.TODO(), 5*time.Second, 3*time.Minute, true, func(ctx context.Context) (bool, error) {
+		serviceInterface, err := c.FetchResource(kube.Service, constants.CiliumClusterMeshAPIName, constants.CiliumNamespace)
+		if err != nil {
+			return false, nil
+		}
+		for _, ingress := range serviceInterface.(*v1.Service).Status.LoadBalancer.Ingress {
+			address = ingress.IP
+			if address == "" {
+				address = ingress.Hostname
+			}
+			if address != "" {
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("clustermesh-apiserver of %s cluster has no load balancer address: %w", c.Name, err)
+	}
+	return address, nil
+}
@@ -0,0 +1,86 @@
+package cilium
+
+import (
+	"clustershift/internal/kube"
+	"clustershift/internal/logger"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+const (
+	globalAnnotation = "service.cilium.io/global"
+	sharedAnnotation = "service.cilium.io/shared"
+)
+
+// Export makes a service reachable from the peer cluster as <service>-<cluster>. The alias selects the
+// pods of the service and is a shared global service, the peer gets the global alias without selector
+// and shares no backends, so ClusterMesh balances it to the pods of the exporting cluster only.
+// Headless services get an alias listing the pod IPs in both clusters, which ClusterMesh routes directly.
+func Export(c kube.Cluster, namespace, name string) error {
+	logger.Info(fmt.Sprintf("Exporting service %s/%s of %s cluster", namespace, name, c.Name))
+
+	peer, err := kube.Peer(c)
+	if err != nil {
+		return err
+	}
+
+	serviceInterface, err := c.FetchResource(kube.Service, name, namespace)
+	if err != nil {
+		return fmt.Errorf("failed to fetch service %s/%s: %w", namespace, name, err)
+	}
+	service := serviceInterface.(*v1.Service)
+
+	alias := name + "-" + c.Name
+	peer.CreateNewNamespace(namespace)
+
+	if service.Spec.ClusterIP == v1.ClusterIPNone {
+		// The exporting cluster resolves the per-pod names as well, replica sets address their own
+		// members by the names the peer uses
+		for _, cluster := range []kube.Cluster{c, peer} {
+			if err := kube.MirrorHeadlessService(c, cluster, service, alias, nil); err != nil {
+				return fmt.Errorf("failed to mirror endpoints of service %s/%s to %s cluster: %w", namespace, name, cluster.Name, err)
+			}
+		}
+		return nil
+	}
+
+	err = createService(c, kube.AliasService(service, alias, service.Spec.Selector, map[string]string{
+		globalAnnotation: "true",
+		sharedAnnotation: "true",
+	}))
+	if err != nil {
+		return err
+	}
+	return createService(peer, kube.AliasService(service, alias, nil, map[string]string{
+		globalAnnotation: "true",
+		sharedAnnotation: "false",
+	}))
+}
+
+func createService(c kube.Cluster, service *v1.Service) error {
+	err := c.CreateResource(kube.Service, service.Namespace, service)
+	if k8serrors.IsAlreadyExists(err) {
+		logger.Debug(fmt.Sprintf("Service %s/%s already exists in %s cluster", service.Namespace, service.Name, c.Name))
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create service %s/%s: %w", service.Namespace, service.Name, err)
+	}
+	return nil
+}
+
+// Unexport deletes the aliases of the service in both clusters
+func Unexport(c kube.Cluster, namespace, name string) error {
+	peer, err := kube.Peer(c)
+	if err != nil {
+		return err
+	}
+	for _, cluster := range []kube.Cluster{c, peer} {
+		if err := kube.DeleteAlias(cluster, name+"-"+c.Name, namespace); err != nil {
+			return err
+		}
+	}
+	return nil
+}
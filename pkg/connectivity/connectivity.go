@@ -5,230 +5,315 @@ import (
 	"clustershift/internal/exit"
 	"clustershift/internal/kube"
 	"clustershift/internal/logger"
+	"clustershift/pkg/health"
+	"clustershift/pkg/probe"
 	"context"
+	"encoding/json"
 	"fmt"
-	"strings"
 	"time"
 
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/kubernetes"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/wait"
 )
 
+const (
+	nodePortMin    = 30000
+	nodePortMax    = 32767
+	resultsTimeout = 90 * time.Second
+
+	measureTCPNodePort = constants.ConnectivityProbeNodePortBase + 4
+	measureUDPNodePort = constants.ConnectivityProbeNodePortBase + 5
+)
+
+// Probes are run in both directions for every combination of origin and target IPs.
+// Probes on ports in the NodePort range are answered by the probe pod of the other cluster,
+// all other ports are expected to be served by the node itself (e.g. the API server).
+// The TCP listener of the probe pod decides whether a combination is usable, the API server
+// is only served by control-plane nodes, which managed clusters do not expose.
+var Probes = []probe.Probe{
+	{Protocol: probe.TCP, Port: constants.ConnectivityProbeNodePortBase},
+	{Protocol: probe.TCP, Port: constants.ConnectivityProbePort, Optional: true},
+	{Protocol: probe.UDP, Port: constants.ConnectivityProbeNodePortBase + 1, Optional: true},
+	{Protocol: probe.HTTP, Port: constants.ConnectivityProbeNodePortBase + 2, Optional: true},
+	{Protocol: probe.TLS, Port: constants.ConnectivityProbeNodePortBase + 3, Optional: true},
+}
+
+// AddProbes registers additional required probes, each given as "protocol:port"
+func AddProbes(specs []string) error {
+	for _, spec := range specs {
+		newProbe, err := probe.ParseProbe(spec)
+		if err != nil {
+			return err
+		}
+		if newProbe.Port == measureTCPNodePort || newProbe.Port == measureUDPNodePort {
+			return fmt.Errorf("probe %s uses a node port reserved for measurements", newProbe)
+		}
+		for _, existing := range Probes {
+			if existing.Port == newProbe.Port && transport(existing.Protocol) == transport(newProbe.Protocol) && isNodePort(newProbe.Port) {
+				return fmt.Errorf("probe %s conflicts with probe %s on the same node port", newProbe, existing)
+			}
+		}
+		Probes = append(Probes, newProbe)
+	}
+	return nil
+}
+
 func DiagnoseConnection(kubeconfigOrigin string, kubeconfigTarget string) {
 	clusters, err := kube.InitClients(kubeconfigOrigin, kubeconfigTarget)
 	exit.OnErrorWithMessage(err, "Error initializing kubernetes clients")
 
-	RunClusterConnectivityProbe(clusters)
+	matrix := startProbes(clusters)
+	if MeasureConfig.Enabled {
+		report := measurePaths(clusters, matrix)
+		report.Print()
+	}
+	cleanupResources(&clusters, constants.ConnectivityProbeNamespace)
+
+	if tool := health.DetectNetworkingTool(clusters.Target); tool != "" {
+		health.Run(clusters, tool).Print()
+		health.Cleanup(clusters)
+	}
+}
+
+// RunClusterConnectivityProbe deploys the probe in both clusters and returns the resulting
+// IP matrix. It exits if no IP combination passes all required probes.
+func RunClusterConnectivityProbe(clusters kube.Clusters) Matrix {
+	matrix := startProbes(clusters)
+	cleanupResources(&clusters, constants.ConnectivityProbeNamespace)
+	return matrix
 }
 
-func RunClusterConnectivityProbe(clusters kube.Clusters) {
+// startProbes deploys the probes and waits for a usable IP combination. The probes
+// keep running so they can be used for measurements.
+func startProbes(clusters kube.Clusters) Matrix {
 	logger.Info("Checking connectivity between clusters")
 	logger.Debug("Fetching cluster IPs")
 
-	// Get IPs arrays
-	originClusterIPs, err := getClusterIP(clusters.Origin.Clientset)
+	originClusterIPs, err := getClusterIP(clusters.Origin)
 	exit.OnErrorWithMessage(err, "Error getting origin cluster IPs")
-	targetClusterIPs, err := getClusterIP(clusters.Target.Clientset)
+	targetClusterIPs, err := getClusterIP(clusters.Target)
 	exit.OnErrorWithMessage(err, "Error getting target cluster IPs")
 
-	// Try each combination of IPs
-	for _, originIP := range originClusterIPs {
-		for _, targetIP := range targetClusterIPs {
-			cleanupResources(&clusters, constants.ConnectivityProbeNamespace)
-			logger.Debug(fmt.Sprintf("Testing connectivity with Origin IP: %s, Target IP: %s", originIP, targetIP))
-
-			logger.Debug("Deploying probe resources")
-			// Create namespace if it doesn't exist in both clusters
-			clusters.Origin.CreateNewNamespace(constants.ConnectivityProbeNamespace)
-			clusters.Target.CreateNewNamespace(constants.ConnectivityProbeNamespace)
-
-			// Create configmaps with the current IP combination
-			originConfigMap := createConfigMap(constants.ConnectivityProbeConfigmapName,
-				constants.ConnectivityProbeNamespace,
-				targetIP,
-				"6443")
-
-			targetConfigMap := createConfigMap(constants.ConnectivityProbeConfigmapName,
-				constants.ConnectivityProbeNamespace,
-				originIP,
-				"6443")
-
-			err := clusters.Origin.CreateResource(kube.ConfigMap,
-				constants.ConnectivityProbeNamespace,
-				originConfigMap)
-			exit.OnErrorWithMessage(err, "Error creating config map")
-
-			err = clusters.Target.CreateResource(kube.ConfigMap,
-				constants.ConnectivityProbeNamespace,
-				targetConfigMap)
-			exit.OnErrorWithMessage(err, "Error creating config map")
-
-			// Create deployments
-			err = clusters.Origin.CreateResourcesFromURL(constants.ConnectivityProbeDeploymentURL, constants.ConnectivityProbeNamespace)
-			if err != nil {
-				logger.Warning("Failed to create resources", err)
-				cleanupResources(&clusters, constants.ConnectivityProbeNamespace)
-				continue
-			}
+	cleanupResources(&clusters, constants.ConnectivityProbeNamespace)
 
-			err = clusters.Target.CreateResourcesFromURL(constants.ConnectivityProbeDeploymentURL, constants.ConnectivityProbeNamespace)
-			if err != nil {
-				logger.Warning("Failed to create resources", err)
-				cleanupResources(&clusters, constants.ConnectivityProbeNamespace)
-				continue
-			}
-
-			// Check if the pods are running
-			logger.Debug("Waiting for pods to be ready")
-			err = kube.WaitForPodsReadyByLabel(
-				clusters.Origin,
-				constants.ConnectivityProbeLabelSelector,
-				constants.ConnectivityProbeNamespace,
-				90*time.Second,
-			)
-			if err != nil {
-				logger.Warning("Failed waiting for pods", err)
-				cleanupResources(&clusters, constants.ConnectivityProbeNamespace)
-				continue
-			}
-			err = kube.WaitForPodsReadyByLabel(
-				clusters.Target,
-				constants.ConnectivityProbeLabelSelector,
-				constants.ConnectivityProbeNamespace,
-				90*time.Second,
-			)
-			if err != nil {
-				logger.Warning("Failed waiting for pods", err)
-				cleanupResources(&clusters, constants.ConnectivityProbeNamespace)
-				continue
-			}
-			logger.Debug("Pods are ready")
-
-			// Check connectivity
-			logger.Debug("Checking connectivity between clusters")
+	logger.Debug("Deploying probe resources")
+	err = deployProbe(clusters.Origin, targetClusterIPs)
+	exitWithCleanup(&clusters, err, "Failed to deploy connectivity probe in origin cluster")
+	err = deployProbe(clusters.Target, originClusterIPs)
+	exitWithCleanup(&clusters, err, "Failed to deploy connectivity probe in target cluster")
 
-			// Give pods a few seconds to start probing
-			time.Sleep(10 * time.Second)
+	logger.Debug("Waiting for pods to be ready")
+	for _, c := range []kube.Cluster{clusters.Origin, clusters.Target} {
+		err = kube.WaitForPodsReadyByLabel(c, constants.ConnectivityProbeLabelSelector, constants.ConnectivityProbeNamespace, 90*time.Second)
+		exitWithCleanup(&clusters, err, fmt.Sprintf("Connectivity probe in %s cluster did not become ready", c.Name))
+	}
 
-			// Check Origin -> Target connectivity
-			originSuccess, err := checkConnectivityProbeLogs(&clusters.Origin, constants.ConnectivityProbeDeploymentName, constants.ConnectivityProbeNamespace)
-			if err != nil {
-				logger.Warning("Failed to check origin cluster logs", err)
-				cleanupResources(&clusters, constants.ConnectivityProbeNamespace)
-				continue
-			}
+	logger.Debug("Waiting for probe results")
+	matrix, err := waitForResults(clusters, originClusterIPs, targetClusterIPs)
+	exitWithCleanup(&clusters, err, "Failed to read connectivity probe results")
 
-			// Check Target -> Origin connectivity
-			targetSuccess, err := checkConnectivityProbeLogs(&clusters.Target, constants.ConnectivityProbeDeploymentName, constants.ConnectivityProbeNamespace)
-			if err != nil {
-				logger.Warning("Failed to check target cluster logs", err)
-				cleanupResources(&clusters, constants.ConnectivityProbeNamespace)
-				continue
-			}
+	matrix.Print()
+	if !matrix.Usable() {
+		exitWithCleanup(&clusters, fmt.Errorf("all IP combinations failed connectivity check"), "Connectivity check failed")
+	}
+	logger.Debug("Connectivity probe complete")
+	return matrix
+}
 
-			if originSuccess && targetSuccess {
-				logger.Debug(fmt.Sprintf("Connectivity check successful with Origin IP: %s, Target IP: %s - both clusters can reach each other", originIP, targetIP))
-			} else {
-				if !originSuccess {
-					err = fmt.Errorf("origin cluster (%s) cannot reach target cluster (%s)", originIP, targetIP)
-					logger.Warning("Connectivity check failed", err)
-				}
-				if !targetSuccess {
-					err = fmt.Errorf("target cluster (%s) cannot reach origin cluster (%s)", targetIP, originIP)
-					logger.Warning("Connectivity check failed", err)
-				}
-				err = fmt.Errorf("connectivity check failed")
-				cleanupResources(&clusters, constants.ConnectivityProbeNamespace)
-				continue
-			}
+func exitWithCleanup(clusters *kube.Clusters, err error, message string) {
+	if err != nil {
+		cleanupResources(clusters, constants.ConnectivityProbeNamespace)
+		exit.OnErrorWithMessage(err, message)
+	}
+}
 
-			cleanupResources(&clusters, constants.ConnectivityProbeNamespace)
-			logger.Debug("Connectivity probe complete")
-			return // Exit if connectivity check is successful
+// waitForResults polls the results of both probes until a usable combination is found
+// or the timeout expires, and returns the latest matrix
+func waitForResults(clusters kube.Clusters, originIPs, targetIPs []string) (Matrix, error) {
+	var matrix Matrix
+	var lastErr error
+	_ = wait.PollUntilContextTimeout(context.TODO(), 5*time.Second, resultsTimeout, false, func(ctx context.Context) (bool, error) {
+		originResults, err := fetchResults(clusters.Origin)
+		if err != nil {
+			lastErr = err
+			return false, nil
 		}
-	}
-	err = fmt.Errorf("all IP combinations failed connectivity check")
-	exit.OnErrorWithMessage(err, "Connectivity check failed")
+		targetResults, err := fetchResults(clusters.Target)
+		if err != nil {
+			lastErr = err
+			return false, nil
+		}
+		lastErr = nil
+		matrix = buildMatrix(originIPs, targetIPs, originResults, targetResults)
+		return matrix.Usable(), nil
+	})
+	return matrix, lastErr
 }
 
-func getClusterIP(client *kubernetes.Clientset) ([]string, error) {
-	var ips []string
-	// Get the Kubernetes API server endpoint
-	nodes, err := client.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{})
+func fetchResults(c kube.Cluster) (probe.Results, error) {
+	configMap, err := c.Clientset.CoreV1().ConfigMaps(constants.ConnectivityProbeNamespace).Get(context.TODO(), probe.ResultsConfigMap, metav1.GetOptions{})
 	if err != nil {
-		return ips, err
+		return probe.Results{}, fmt.Errorf("no results from %s cluster yet: %w", c.Name, err)
 	}
+	return probe.ReadResults(configMap)
+}
 
-	if len(nodes.Items) == 0 {
-		return ips, fmt.Errorf("no nodes found in the cluster")
+// deployProbe creates the probe pod, its permissions to publish results and the
+// NodePort service the other cluster probes
+func deployProbe(c kube.Cluster, targets []string) error {
+	namespace := constants.ConnectivityProbeNamespace
+	name := constants.ConnectivityProbeDeploymentName
+	labels := map[string]string{"app": name}
+	c.CreateNewNamespace(namespace)
+
+	config, err := json.Marshal(probe.Config{
+		Cluster:   c.Name,
+		Namespace: namespace,
+		Targets:   targets,
+		Probes:    Probes,
+	})
+	if err != nil {
+		return err
 	}
+	c.CreateConfigmap(constants.ConnectivityProbeConfigmapName, namespace, map[string]string{"probe.json": string(config)})
 
-	// Iterate through all nodes and check for master nodes
-	for _, node := range nodes.Items {
-		// Check if the node has the master label
-		if val, exists := node.Labels["node-role.kubernetes.io/master"]; exists && val == "true" {
-			// Get external IP first
-			for _, addr := range node.Status.Addresses {
-				if addr.Type == corev1.NodeExternalIP {
-					ips = append(ips, addr.Address)
-				}
-			}
-
-			// Fallback to internal IP if external IP is not available
-			if len(ips) == 0 {
-				for _, addr := range node.Status.Addresses {
-					if addr.Type == corev1.NodeInternalIP {
-						ips = append(ips, addr.Address)
-					}
-				}
-			}
-		}
+	serviceAccount := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}
+	if err := c.CreateResource(kube.ServiceAccount, namespace, serviceAccount); err != nil {
+		return err
 	}
-
-	if len(ips) == 0 {
-		return ips, fmt.Errorf("no suitable IP address found for master nodes")
+	role := &rbacv1.Role{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Rules: []rbacv1.PolicyRule{{
+			APIGroups: []string{""},
+			Resources: []string{"configmaps"},
+			Verbs:     []string{"get", "create", "update"},
+		}},
+	}
+	if _, err := c.Clientset.RbacV1().Roles(namespace).Create(context.TODO(), role, metav1.CreateOptions{}); err != nil {
+		return err
+	}
+	roleBinding := &rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		RoleRef:    rbacv1.RoleRef{APIGroup: "rbac.authorization.k8s.io", Kind: "Role", Name: name},
+		Subjects:   []rbacv1.Subject{{Kind: "ServiceAccount", Name: name, Namespace: namespace}},
+	}
+	if _, err := c.Clientset.RbacV1().RoleBindings(namespace).Create(context.TODO(), roleBinding, metav1.CreateOptions{}); err != nil {
+		return err
 	}
 
-	return ips, nil
-}
+	var containerPorts []corev1.ContainerPort
+	for protocol, port := range probe.ListenerPorts {
+		containerPorts = append(containerPorts, corev1.ContainerPort{Name: string(protocol), ContainerPort: port, Protocol: transport(protocol)})
+	}
+	containerPorts = append(containerPorts, corev1.ContainerPort{Name: "measure", ContainerPort: probe.MeasurePort, Protocol: corev1.ProtocolTCP})
+	replicas := int32(1)
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					ServiceAccountName: name,
+					Containers: []corev1.Container{{
+						Name:  "probe",
+						Image: constants.ClustershiftImage,
+						Args:  []string{"probe", "--config", probe.ConfigFile},
+						Ports: containerPorts,
+						VolumeMounts: []corev1.VolumeMount{{
+							Name:      "config",
+							MountPath: "/etc/clustershift",
+							ReadOnly:  true,
+						}},
+					}},
+					Volumes: []corev1.Volume{{
+						Name: "config",
+						VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{
+							LocalObjectReference: corev1.LocalObjectReference{Name: constants.ConnectivityProbeConfigmapName},
+						}},
+					}},
+				},
+			},
+		},
+	}
+	if err := c.CreateResource(kube.Deployment, namespace, deployment); err != nil {
+		return err
+	}
 
-func createConfigMap(name string, namespace string, targetIP string, port string) *corev1.ConfigMap {
-	return &corev1.ConfigMap{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      name,
-			Namespace: namespace,
+	// The measure ports are reachable on the node IPs as well as through the networking tools
+	servicePorts := []corev1.ServicePort{
+		{
+			Name:       "measure-tcp",
+			Protocol:   corev1.ProtocolTCP,
+			Port:       probe.MeasurePort,
+			TargetPort: intstr.FromInt32(probe.MeasurePort),
+			NodePort:   measureTCPNodePort,
+		},
+		{
+			Name:       "measure-udp",
+			Protocol:   corev1.ProtocolUDP,
+			Port:       probe.ListenerPorts[probe.UDP],
+			TargetPort: intstr.FromInt32(probe.ListenerPorts[probe.UDP]),
+			NodePort:   measureUDPNodePort,
 		},
-		Data: map[string]string{
-			"target": targetIP,
-			"port":   port,
+	}
+	for _, p := range Probes {
+		if !isNodePort(p.Port) {
+			continue
+		}
+		servicePorts = append(servicePorts, corev1.ServicePort{
+			Name:       fmt.Sprintf("%s-%d", p.Protocol, p.Port),
+			Protocol:   transport(p.Protocol),
+			Port:       p.Port,
+			TargetPort: intstr.FromInt32(probe.ListenerPorts[p.Protocol]),
+			NodePort:   p.Port,
+		})
+	}
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec: corev1.ServiceSpec{
+			Type:     corev1.ServiceTypeNodePort,
+			Selector: labels,
+			Ports:    servicePorts,
 		},
 	}
+	return c.CreateResource(kube.Service, namespace, service)
 }
 
-func checkConnectivityProbeLogs(cluster *kube.Cluster, name string, namespace string) (bool, error) {
-	// Get the pod
-	pods, err := cluster.Clientset.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{
-		LabelSelector: "app=" + name,
-	})
-	if err != nil {
-		return false, fmt.Errorf("failed to list pods: %v", err)
+func transport(protocol probe.Protocol) corev1.Protocol {
+	if protocol == probe.UDP {
+		return corev1.ProtocolUDP
 	}
+	return corev1.ProtocolTCP
+}
 
-	if len(pods.Items) == 0 {
-		return false, fmt.Errorf("no pods found")
-	}
+func isNodePort(port int32) bool {
+	return port >= nodePortMin && port <= nodePortMax
+}
 
-	// Get logs from the pod
-	logs, err := cluster.Clientset.CoreV1().Pods(namespace).GetLogs(pods.Items[0].Name, &corev1.PodLogOptions{}).Do(context.TODO()).Raw()
+// getClusterIP returns the addresses of the nodes selected for probing
+func getClusterIP(c kube.Cluster) ([]string, error) {
+	nodes, err := c.SelectNodes(kube.ProbeNodes)
 	if err != nil {
-		return false, fmt.Errorf("failed to get pod logs: %v", err)
+		return nil, err
 	}
 
-	// Check if logs contain successful connection message
-	// You might want to adjust this based on what your probe outputs
-	return strings.Contains(string(logs), "Successfully connected to"), nil
+	var ips []string
+	for _, node := range nodes {
+		if ip := kube.NodeAddress(node); ip != "" {
+			ips = append(ips, ip)
+		}
+	}
+	if len(ips) == 0 {
+		return ips, fmt.Errorf("no suitable IP address found for nodes matching %s", kube.ProbeNodes)
+	}
+	return ips, nil
 }
 
 func cleanupResources(clusters *kube.Clusters, namespace string) {
@@ -240,13 +325,18 @@ func cleanupResources(clusters *kube.Clusters, namespace string) {
 		PropagationPolicy: &deletePolicy,
 	}
 
-	err := clusters.Origin.Clientset.CoreV1().Namespaces().Delete(context.TODO(), namespace, deleteOptions)
-	if err != nil && !k8serrors.IsNotFound(err) {
-		exit.OnErrorWithMessage(err, "Failed to cleanup origin cluster namespace")
+	for _, c := range []kube.Cluster{clusters.Origin, clusters.Target} {
+		err := c.Clientset.CoreV1().Namespaces().Delete(context.TODO(), namespace, deleteOptions)
+		if err != nil && !k8serrors.IsNotFound(err) {
+			exit.OnErrorWithMessage(err, fmt.Sprintf("Failed to cleanup %s cluster namespace", c.Name))
+		}
 	}
 
-	err = clusters.Target.Clientset.CoreV1().Namespaces().Delete(context.TODO(), namespace, deleteOptions)
-	if err != nil && !k8serrors.IsNotFound(err) {
-		exit.OnErrorWithMessage(err, "Failed to cleanup target cluster namespace")
+	// Wait until the namespaces are gone so the probe can be redeployed
+	for _, c := range []kube.Cluster{clusters.Origin, clusters.Target} {
+		_ = wait.PollUntilContextTimeout(context.TODO(), 2*time.Second, 2*time.Minute, true, func(ctx context.Context) (bool, error) {
+			_, err := c.Clientset.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+			return k8serrors.IsNotFound(err), nil
+		})
 	}
 }
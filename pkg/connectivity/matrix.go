@@ -0,0 +1,133 @@
+package connectivity
+
+import (
+	"clustershift/internal/logger"
+	"clustershift/pkg/probe"
+	"fmt"
+	"strings"
+)
+
+// Check is the outcome of one probe in both directions of an IP combination
+type Check struct {
+	Probe    probe.Probe  `json:"probe"`
+	Outbound probe.Result `json:"outbound"` // origin to target
+	Inbound  probe.Result `json:"inbound"`  // target to origin
+}
+
+// Combination is a pair of origin and target IPs with the checks between them
+type Combination struct {
+	OriginIP string  `json:"originIP"`
+	TargetIP string  `json:"targetIP"`
+	Checks   []Check `json:"checks"`
+}
+
+// Matrix holds the probe results for every combination of origin and target IPs
+type Matrix struct {
+	Combinations []Combination `json:"combinations"`
+}
+
+func buildMatrix(originIPs, targetIPs []string, originResults, targetResults probe.Results) Matrix {
+	outbound := indexResults(originResults)
+	inbound := indexResults(targetResults)
+
+	var matrix Matrix
+	for _, originIP := range originIPs {
+		for _, targetIP := range targetIPs {
+			combination := Combination{OriginIP: originIP, TargetIP: targetIP}
+			for _, p := range Probes {
+				combination.Checks = append(combination.Checks, Check{
+					Probe:    p,
+					Outbound: lookupResult(outbound, targetIP, p),
+					Inbound:  lookupResult(inbound, originIP, p),
+				})
+			}
+			matrix.Combinations = append(matrix.Combinations, combination)
+		}
+	}
+	return matrix
+}
+
+func indexResults(results probe.Results) map[string]probe.Result {
+	index := make(map[string]probe.Result)
+	for _, result := range results.Results {
+		index[resultKey(result.Target, result.Protocol, result.Port)] = result
+	}
+	return index
+}
+
+func lookupResult(index map[string]probe.Result, target string, p probe.Probe) probe.Result {
+	result, ok := index[resultKey(target, p.Protocol, p.Port)]
+	if !ok {
+		return probe.Result{Target: target, Protocol: p.Protocol, Port: p.Port, Error: "no result"}
+	}
+	return result
+}
+
+func resultKey(target string, protocol probe.Protocol, port int32) string {
+	return fmt.Sprintf("%s|%s|%d", target, protocol, port)
+}
+
+// Usable reports whether all required probes succeeded in both directions
+func (c Combination) Usable() bool {
+	for _, check := range c.Checks {
+		if !check.Probe.Optional && !(check.Outbound.Success && check.Inbound.Success) {
+			return false
+		}
+	}
+	return true
+}
+
+// Usable reports whether at least one IP combination can be used
+func (m Matrix) Usable() bool {
+	for _, combination := range m.Combinations {
+		if combination.Usable() {
+			return true
+		}
+	}
+	return false
+}
+
+// UsableCombinations returns all combinations that passed the required probes
+func (m Matrix) UsableCombinations() []Combination {
+	var usable []Combination
+	for _, combination := range m.Combinations {
+		if combination.Usable() {
+			usable = append(usable, combination)
+		}
+	}
+	return usable
+}
+
+// Print logs the matrix with the result of each probe as origin to target / target to origin
+func (m Matrix) Print() {
+	logger.Info("Connectivity matrix (origin -> target / target -> origin):")
+	for _, combination := range m.Combinations {
+		var cells []string
+		for _, check := range combination.Checks {
+			cell := fmt.Sprintf("%s %s/%s", check.Probe, status(check.Outbound), status(check.Inbound))
+			if check.Probe.Optional {
+				cell += " (optional)"
+			}
+			cells = append(cells, cell)
+
+			for _, result := range []probe.Result{check.Outbound, check.Inbound} {
+				if !result.Success {
+					logger.Debug(fmt.Sprintf("Probe %s to %s failed: %s", check.Probe, result.Target, result.Error))
+				}
+			}
+		}
+
+		usable := "unusable"
+		if combination.Usable() {
+			usable = "usable"
+		}
+		logger.Info(fmt.Sprintf("%s <-> %s [%s]: %s", combination.OriginIP, combination.TargetIP, usable, strings.Join(cells, ", ")))
+	}
+}
+
+func status(result probe.Result) string {
+	if result.Success {
+		return "ok"
+	}
+	return "failed"
+}
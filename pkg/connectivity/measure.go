@@ -0,0 +1,265 @@
+package connectivity
+
+import (
+	"bytes"
+	"clustershift/internal/constants"
+	"clustershift/internal/exit"
+	"clustershift/internal/kube"
+	"clustershift/internal/logger"
+	"clustershift/internal/prompt"
+	"clustershift/pkg/cilium"
+	"clustershift/pkg/direct"
+	"clustershift/pkg/health"
+	"clustershift/pkg/istio"
+	"clustershift/pkg/linkerd"
+	"clustershift/pkg/probe"
+	"clustershift/pkg/skupper"
+	"clustershift/pkg/submariner"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// MeasureSettings configure the network measurements of diagnose
+type MeasureSettings struct {
+	Enabled bool
+	// ThroughTool measures the path through the networking tool too, exporting the probe changes both clusters
+	ThroughTool bool
+	Packets     int
+	Streams     int
+	Duration    time.Duration
+}
+
+var MeasureConfig = MeasureSettings{
+	Enabled:  true,
+	Packets:  50,
+	Streams:  4,
+	Duration: 10 * time.Second,
+}
+
+const measureAttempts = 3
+
+// PathMeasurement is the measurement of one network path from origin to target
+type PathMeasurement struct {
+	Path        string
+	Host        string
+	Measurement probe.Measurement
+}
+
+// MeasureReport holds the measured paths and the data that has to be transferred
+type MeasureReport struct {
+	Paths []PathMeasurement
+	Data  map[string]resource.Quantity // size per namespace
+	Total resource.Quantity
+}
+
+// measurePaths measures the raw node IP path and the path through the installed networking tool
+func measurePaths(clusters kube.Clusters, matrix Matrix) MeasureReport {
+	var report MeasureReport
+	usable := matrix.UsableCombinations()
+	if len(usable) > 0 {
+		logger.Info(fmt.Sprintf("Measuring node IP path to %s", usable[0].TargetIP))
+		report.Paths = append(report.Paths, measurePath(clusters.Origin, "node IPs", probe.MeasureOptions{
+			Host:    usable[0].TargetIP,
+			TCPPort: measureTCPNodePort,
+			UDPPort: measureUDPNodePort,
+		}))
+	}
+
+	tool := health.DetectNetworkingTool(clusters.Target)
+	if tool == "" {
+		logger.Info("No networking tool installed, only the node IP path is measured")
+	} else if !MeasureConfig.ThroughTool {
+		logger.Info(fmt.Sprintf("Skipping the path through %s, measuring it exports the probe in both clusters (--measure-tool)", tool))
+	} else {
+		logger.Info(fmt.Sprintf("Measuring path through %s", tool))
+		host, udp := exposeThroughTool(clusters, tool)
+		opts := probe.MeasureOptions{Host: host, TCPPort: probe.MeasurePort}
+		if udp {
+			opts.UDPPort = probe.ListenerPorts[probe.UDP]
+		}
+		report.Paths = append(report.Paths, measurePath(clusters.Origin, tool, opts))
+	}
+
+	var err error
+	report.Data, report.Total, err = fetchDataSizes(clusters.Origin)
+	if err != nil {
+		logger.Warning("Failed to determine the size of the data to migrate", err)
+	}
+	return report
+}
+
+// measurePath runs the measurement from inside the origin probe pod. It is retried as
+// services exported through a networking tool may take a moment to become reachable.
+func measurePath(c kube.Cluster, path string, opts probe.MeasureOptions) PathMeasurement {
+	opts.Packets = MeasureConfig.Packets
+	opts.Streams = MeasureConfig.Streams
+	opts.Duration = MeasureConfig.Duration
+
+	result := PathMeasurement{Path: path, Host: opts.Host}
+	for attempt := 1; attempt <= measureAttempts; attempt++ {
+		measurement, err := execMeasure(c, opts)
+		if err != nil {
+			measurement = probe.Measurement{PacketLoss: -1, Error: err.Error()}
+		}
+		result.Measurement = measurement
+		if measurement.Error == "" {
+			break
+		}
+		logger.Debug(fmt.Sprintf("Measurement of %s failed (attempt %d/%d): %s", path, attempt, measureAttempts, measurement.Error))
+		if attempt < measureAttempts {
+			time.Sleep(20 * time.Second)
+		}
+	}
+	return result
+}
+
+func execMeasure(c kube.Cluster, opts probe.MeasureOptions) (probe.Measurement, error) {
+	var measurement probe.Measurement
+	pods, err := c.Clientset.CoreV1().Pods(constants.ConnectivityProbeNamespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: constants.ConnectivityProbeLabelSelector,
+		FieldSelector: "status.phase=Running",
+	})
+	if err != nil {
+		return measurement, err
+	}
+	if len(pods.Items) == 0 {
+		return measurement, fmt.Errorf("no running probe pod in %s cluster", c.Name)
+	}
+
+	command := []string{"/clustershift", "probe", "measure",
+		"--host", opts.Host,
+		"--tcp-port", strconv.Itoa(int(opts.TCPPort)),
+		"--udp-port", strconv.Itoa(int(opts.UDPPort)),
+		"--packets", strconv.Itoa(opts.Packets),
+		"--streams", strconv.Itoa(opts.Streams),
+		"--duration", opts.Duration.String(),
+	}
+	var stdout, stderr bytes.Buffer
+	if err := c.ExecIntoPod(constants.ConnectivityProbeNamespace, pods.Items[0].Name, "probe", command, &stdout, &stderr); err != nil {
+		return measurement, fmt.Errorf("%v: %s", err, stderr.String())
+	}
+	err = json.Unmarshal(stdout.Bytes(), &measurement)
+	return measurement, err
+}
+
+// exposeThroughTool exports the target probe with the networking tool and returns the
+// host the origin reaches it at and whether UDP is carried by the tool
+func exposeThroughTool(clusters kube.Clusters, tool string) (string, bool) {
+	namespace := constants.ConnectivityProbeNamespace
+	name := constants.ConnectivityProbeDeploymentName
+
+	switch tool {
+	case prompt.NetworkingToolSubmariner:
+		exit.OnErrorWithMessage(submariner.Export(clusters.Target, namespace, name, ""), "Failed to export target probe")
+		return fmt.Sprintf("%s.%s.svc.clusterset.local", name, namespace), true
+	case prompt.NetworkingToolLinkerd:
+		// The origin probe has to be meshed to reach the gateway of the target cluster
+		exit.OnErrorWithMessage(linkerd.InjectNamespace(clusters.Origin, namespace), "Failed to inject origin probe")
+		exit.OnErrorWithMessage(linkerd.ExportService(clusters.Target, name, namespace), "Failed to export target probe")
+		return fmt.Sprintf("%s-%s.%s.svc.cluster.local", name, clusters.Target.Name, namespace), false
+	case prompt.NetworkingToolSkupper:
+		skupper.CreateSiteConnection(clusters, namespace)
+		exit.OnErrorWithMessage(skupper.ExportService(clusters.Target, namespace, name), "Failed to export target probe")
+		return fmt.Sprintf("%s-%s.%s.svc.cluster.local", name, clusters.Target.Name, namespace), false
+	case prompt.NetworkingToolCilium:
+		exit.OnErrorWithMessage(cilium.Export(clusters.Target, namespace, name), "Failed to export target probe")
+		return fmt.Sprintf("%s-%s.%s.svc.cluster.local", name, clusters.Target.Name, namespace), true
+	case prompt.NetworkingToolDirect:
+		exit.OnErrorWithMessage(direct.Export(clusters.Target, namespace, name), "Failed to export target probe")
+		return fmt.Sprintf("%s-%s.%s.svc.cluster.local", name, clusters.Target.Name, namespace), true
+	case prompt.NetworkingToolIstio:
+		// The origin probe needs a sidecar to route through the east-west gateway
+		exit.OnErrorWithMessage(istio.EnableInjection(clusters.Origin, namespace), "Failed to inject origin probe")
+		exit.OnErrorWithMessage(istio.ExportService(clusters.Target, namespace, name), "Failed to export target probe")
+		return fmt.Sprintf("%s-%s.%s.svc.cluster.local", name, clusters.Target.Name, namespace), false
+	}
+	return "", false
+}
+
+// fetchDataSizes sums up the persistent volume claims of the origin cluster per namespace,
+// which covers the databases as well as other stateful workloads
+func fetchDataSizes(c kube.Cluster) (map[string]resource.Quantity, resource.Quantity, error) {
+	sizes := make(map[string]resource.Quantity)
+	total := resource.Quantity{}
+
+	claims, err := c.Clientset.CoreV1().PersistentVolumeClaims("").List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return sizes, total, err
+	}
+	for _, claim := range claims.Items {
+		size := claim.Status.Capacity[corev1.ResourceStorage]
+		if size.IsZero() {
+			size = claim.Spec.Resources.Requests[corev1.ResourceStorage]
+		}
+		namespaceSize := sizes[claim.Namespace]
+		namespaceSize.Add(size)
+		sizes[claim.Namespace] = namespaceSize
+		total.Add(size)
+	}
+	return sizes, total, nil
+}
+
+// Print logs the measured paths and the estimated transfer times
+func (r MeasureReport) Print() {
+	logger.Info("Network measurements (origin -> target):")
+	for _, path := range r.Paths {
+		m := path.Measurement
+		if m.Error != "" && m.RTT == 0 {
+			logger.Warning(fmt.Sprintf("%s (%s): measurement failed", path.Path, path.Host), fmt.Errorf("%s", m.Error))
+			continue
+		}
+		loss := "n/a"
+		if m.PacketLoss >= 0 {
+			loss = fmt.Sprintf("%.1f%%", m.PacketLoss)
+		}
+		logger.Info(fmt.Sprintf("%s (%s): rtt %s, jitter %s, packet loss %s, throughput %s",
+			path.Path, path.Host, m.RTT.Round(time.Microsecond), m.Jitter.Round(time.Microsecond), loss, formatBitrate(m.Throughput)))
+	}
+
+	if r.Total.IsZero() {
+		return
+	}
+
+	namespaces := make([]string, 0, len(r.Data))
+	for namespace := range r.Data {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+	for _, namespace := range namespaces {
+		size := r.Data[namespace]
+		logger.Info(fmt.Sprintf("Data in namespace %s: %s%s", namespace, size.String(), r.estimates(size)))
+	}
+	logger.Info(fmt.Sprintf("Total data to transfer: %s%s", r.Total.String(), r.estimates(r.Total)))
+}
+
+// estimates returns the expected transfer time of the given size for every measured path
+func (r MeasureReport) estimates(size resource.Quantity) string {
+	result := ""
+	for _, path := range r.Paths {
+		if path.Measurement.Throughput <= 0 {
+			continue
+		}
+		seconds := float64(size.Value()) * 8 / path.Measurement.Throughput
+		result += fmt.Sprintf(", ~%s via %s", (time.Duration(seconds) * time.Second).Round(time.Second), path.Path)
+	}
+	return result
+}
+
+func formatBitrate(bitsPerSecond float64) string {
+	switch {
+	case bitsPerSecond >= 1e9:
+		return fmt.Sprintf("%.2f Gbit/s", bitsPerSecond/1e9)
+	case bitsPerSecond >= 1e6:
+		return fmt.Sprintf("%.2f Mbit/s", bitsPerSecond/1e6)
+	default:
+		return fmt.Sprintf("%.2f kbit/s", bitsPerSecond/1e3)
+	}
+}
@@ -6,12 +6,9 @@ import (
 	"clustershift/internal/kube"
 	"clustershift/internal/logger"
 	"clustershift/internal/migration"
-	"clustershift/internal/prompt"
-	"clustershift/pkg/skupper"
 	"encoding/json"
 	"fmt"
 	appv1 "k8s.io/api/apps/v1"
-	v1core "k8s.io/api/core/v1"
 	"strings"
 	"time"
 
@@ -20,7 +17,10 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-func Migrate(clusters kube.Clusters, resources migration.Resources, opts prompt.MigrationOptions) {
+// importTimeout bounds the wait for the exported rw services to be usable in the peer
+const importTimeout = 5 * time.Minute
+
+func Migrate(clusters kube.Clusters, resources migration.Resources) {
 	logger.Info("Scanning for existing cnpg databases")
 	exists := scanExistingDatabases(clusters.Origin)
 
@@ -31,25 +31,37 @@ func Migrate(clusters kube.Clusters, resources migration.Resources, opts prompt.
 
 	logger.Info("Migrate cnpg databases")
 
-	deploymentInterface, err := clusters.Origin.FetchResource(kube.Deployment, "cnpg-controller-manager", "cnpg-system")
+	url, err := OperatorManifestURL(clusters.Origin)
 	exit.OnErrorWithMessage(err, "Failed to fetch cloud native-pg operator deployment")
-	var imageVersion string
-	if deploymentInterface != nil {
-		deployment := deploymentInterface.(*appv1.Deployment)
-		image := deployment.Spec.Template.Spec.Containers[0].Image
-		imageParts := strings.Split(image, ":")
-		imageVersion = imageParts[len(imageParts)-1]
-	}
 
-	url := buildURL(imageVersion)
 	installOperator(clusters.Target, url)
 	err = kube.WaitForPodsReadyByLabel(clusters.Target, constants.CNPGLabelSelector, constants.CNPGNamespace, 90*time.Second)
 	exit.OnErrorWithMessage(err, "Failed to wait for CNPG pods to be ready")
 
 	addClustersetDNS(clusters.Origin, resources)
-	exportRWServices(clusters, clusters.Origin, resources, opts)
+	exportRWServices(clusters, clusters.Origin, resources)
 	createReplicaClusters(clusters, resources)
 }
+
+// OperatorManifestURL returns the upstream release manifest matching the operator version running in the cluster
+func OperatorManifestURL(c kube.Cluster) (string, error) {
+	deploymentInterface, err := c.FetchResource(kube.Deployment, "cnpg-controller-manager", constants.CNPGNamespace)
+	if err != nil {
+		return "", err
+	}
+
+	deployment := deploymentInterface.(*appv1.Deployment)
+	image := deployment.Spec.Template.Spec.Containers[0].Image
+	imageParts := strings.Split(image, ":")
+	imageVersion := imageParts[len(imageParts)-1]
+
+	url := buildURL(imageVersion)
+	if url == "" {
+		return "", fmt.Errorf("unable to derive release from operator image %s", image)
+	}
+	return url, nil
+}
+
 func installOperator(c kube.Cluster, url string) {
 
 	logger.Info("Installing cloud native-pg operator")
@@ -103,11 +115,7 @@ func addRWServiceToYaml(c kube.Cluster, resources []map[string]interface{}, migr
 		name := resource["metadata"].(map[string]interface{})["name"].(string)
 		namespace := resource["metadata"].(map[string]interface{})["namespace"].(string)
 
-		if migrationResources.GetNetworkingTool() == prompt.NetworkingToolSkupper {
-			name = name + "-rw-" + c.Name
-		}
-
-		dns := migrationResources.GetDNSName(name, namespace)
+		dns := migrationResources.ImportedDNSName(c, namespace, name+"-rw")
 
 		spec := resource["spec"].(map[string]interface{})
 
@@ -146,7 +154,7 @@ func addRWServiceToYaml(c kube.Cluster, resources []map[string]interface{}, migr
 }
 
 func addClustersetDNS(c kube.Cluster, migrationResources migration.Resources) {
-	logger.Info("Adding submariner clusterset DNS")
+	logger.Info("Adding imported DNS names of the rw services")
 
 	// Fetch all cnpg clusters
 	logger.Info("fetching cnpg clusters")
@@ -157,7 +165,7 @@ func addClustersetDNS(c kube.Cluster, migrationResources migration.Resources) {
 	)
 	exit.OnErrorWithMessage(err, "Error fetching custom resources")
 
-	// Add clusterset DNS to each cluster
+	// Add the imported DNS name to each cluster
 	logger.Info("Updating cluster resources")
 	err = addRWServiceToYaml(c, resources, migrationResources)
 	exit.OnErrorWithMessage(err, "Error updating cluster resources")
@@ -195,7 +203,7 @@ func createReplicaCluster(c kube.Cluster, originCluster *apiv1.Cluster, migratio
 		{
 			Name: originCluster.Name,
 			ConnectionParameters: map[string]string{
-				"host":    migrationResources.GetCNPGHostname(c.Name, originCluster.Name, originCluster.Namespace),
+				"host":    migrationResources.ImportedDNSName(c, originCluster.Namespace, originCluster.Name+"-rw"),
 				"user":    "streaming_replica",
 				"dbname":  "postgres",
 				"sslmode": "verify-full",
@@ -260,21 +268,17 @@ func createReplicaClusters(c kube.Clusters, migrationResources migration.Resourc
 		// Wait for replica cluster to be ready
 		err = kube.WaitForCNPGClusterReady(c.Target.DynamicClientset, originCluster.Name, originCluster.Namespace, 1*time.Hour)
 		exit.OnErrorWithMessage(err, "Timeout while waiting for replica cluster bootstrap")
-		if migrationResources.GetNetworkingTool() == prompt.NetworkingToolLinkerd {
-			mirrorLabel := map[string]string{
-				"mirror.linkerd.io/exported": "true",
-			}
-			serviceName := fmt.Sprintf("%s-rw", originCluster.Name)
-
-			err = c.Target.AddLabel(kube.Service, serviceName, originCluster.Namespace, mirrorLabel)
-			exit.OnErrorWithMessage(err, "Failed to export service")
-		}
 
+		// The demoted origin cluster replicates from the rw service of the target cluster
+		err = migrationResources.ExportService(c.Target, originCluster.Namespace, fmt.Sprintf("%s-rw", originCluster.Name))
+		exit.OnErrorWithMessage(err, "Failed to export rw service of target cluster")
+		err = migrationResources.WaitForImport(c.Target, originCluster.Namespace, fmt.Sprintf("%s-rw", originCluster.Name), importTimeout)
+		exit.OnErrorWithMessage(err, "Exported rw service is not usable in origin cluster")
 	}
 	logger.Info("Created replica clusters")
 }
 
-func exportRWServices(clusters kube.Clusters, c kube.Cluster, migrationResources migration.Resources, opts prompt.MigrationOptions) {
+func exportRWServices(clusters kube.Clusters, c kube.Cluster, migrationResources migration.Resources) {
 	logger.Info("Exporting cnpg rw services")
 
 	// Fetch all cnpg clusters
@@ -292,29 +296,19 @@ func exportRWServices(clusters kube.Clusters, c kube.Cluster, migrationResources
 		namespace := resource["metadata"].(map[string]interface{})["namespace"].(string)
 		serviceName := fmt.Sprintf("%s-rw", clusterName)
 
-		if migrationResources.GetNetworkingTool() == prompt.NetworkingToolSkupper && opts.Rerouting != prompt.ReroutingSkupper {
-			skupper.CreateSiteConnection(clusters, namespace)
-		}
-
-		if migrationResources.GetNetworkingTool() == prompt.NetworkingToolLinkerd && opts.Rerouting != prompt.ReroutingLinkerd {
-			logger.Info(fmt.Sprintf("Adding linkerd.io/inject=enabled annotation to namespace %s", namespace))
-
-			// Fetch the namespace object first
-			namespaceInterface, err := clusters.Target.FetchResource(kube.Namespace, namespace, "")
-			exit.OnErrorWithMessage(err, "Failed to fetch namespace")
-			namespaceObj := namespaceInterface.(*v1core.Namespace)
-
-			// Add the linkerd injection annotation
-			err = clusters.Target.AddAnnotation(namespaceObj, "linkerd.io/inject", "enabled")
-			exit.OnErrorWithMessage(err, "Failed to add linkerd inject annotation to namespace")
-		}
+		err := migrationResources.PrepareNamespace(clusters, namespace)
+		exit.OnErrorWithMessage(err, fmt.Sprintf("Failed to prepare namespace %s for exported services", namespace))
 
-		migrationResources.ExportService(c, namespace, serviceName)
+		err = migrationResources.ExportService(c, namespace, serviceName)
+		exit.OnErrorWithMessage(err, fmt.Sprintf("Failed to export service %s/%s", namespace, serviceName))
+		err = migrationResources.WaitForImport(c, namespace, serviceName, importTimeout)
+		exit.OnErrorWithMessage(err, fmt.Sprintf("Exported service %s/%s is not usable in the peer cluster", namespace, serviceName))
 	}
 
 }
 
-func DemoteOriginCluster(c kube.Cluster) {
+func DemoteOriginCluster(clusters kube.Clusters, migrationResources migration.Resources) {
+	c := clusters.Origin
 	logger.Info("Demote cnpg clusters")
 
 	// Fetch all cnpg clusters
@@ -336,7 +330,7 @@ func DemoteOriginCluster(c kube.Cluster) {
 			{
 				Name: cluster.Name + "-new",
 				ConnectionParameters: map[string]string{
-					"host":    "target." + cluster.Name + "-rw." + cluster.Namespace + ".svc.clusterset.local",
+					"host":    migrationResources.ImportedDNSName(clusters.Target, cluster.Namespace, cluster.Name+"-rw"),
 					"user":    "streaming_replica",
 					"dbname":  "postgres",
 					"sslmode": "verify-full",
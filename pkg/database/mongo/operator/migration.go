@@ -8,10 +8,7 @@ import (
 	"clustershift/internal/logger"
 	"clustershift/internal/migration"
 	"clustershift/internal/mongo"
-	"clustershift/internal/prompt"
 	"clustershift/pkg/database/mongo/statefulset"
-	"clustershift/pkg/linkerd"
-	"clustershift/pkg/skupper"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -23,7 +20,11 @@ import (
 	"time"
 )
 
-const mongoImage = "mongodb/mongodb-kubernetes-operator"
+const (
+	mongoImage        = "mongodb/mongodb-kubernetes-operator"
+	importTimeout     = 5 * time.Minute
+	aliasSyncInterval = 10 * time.Second
+)
 
 // OperatorInfo holds information about the MongoDB operator deployment
 type OperatorInfo struct {
@@ -33,7 +34,7 @@ type OperatorInfo struct {
 	IsPresent bool
 }
 
-func Migrate(c kube.Clusters, resources migration.Resources, opts prompt.MigrationOptions) {
+func Migrate(c kube.Clusters, resources migration.Resources) {
 	operatorInfo, err := fetchOperatorInfo(c.Origin)
 	if err != nil {
 		exit.OnErrorWithMessage(err, "Failed to fetch MongoDB operator information")
@@ -68,22 +69,17 @@ func Migrate(c kube.Clusters, resources migration.Resources, opts prompt.Migrati
 		service, err := getServiceForStatefulSet(mongoDB, c.Origin)
 		exit.OnErrorWithMessage(err, fmt.Sprintf("Failed to get service for MongoDB cluster %s in origin cluster", mongoDB.Name))
 
-		if resources.GetNetworkingTool() == prompt.NetworkingToolSkupper && opts.Rerouting != prompt.ReroutingSkupper {
-			skupper.CreateSiteConnection(c, mongoDB.Namespace)
-		}
-
-		if resources.GetNetworkingTool() == prompt.NetworkingToolSubmariner {
-			resources.ExportService(c.Origin, service.Namespace, service.Name)
-		}
-		time.Sleep(5 * time.Second)
-		resources.ExportService(c.Target, service.Namespace, service.Name)
+		err = resources.PrepareNamespace(c, mongoDB.Namespace)
+		exit.OnErrorWithMessage(err, fmt.Sprintf("Failed to prepare namespace %s for exported services", mongoDB.Namespace))
+		err = resources.ExportService(c.Target, service.Namespace, service.Name)
+		exit.OnErrorWithMessage(err, fmt.Sprintf("Failed to export service of MongoDB cluster %s", mongoDB.Name))
+		err = resources.WaitForImport(c.Target, service.Namespace, service.Name, importTimeout)
+		exit.OnErrorWithMessage(err, fmt.Sprintf("Service of MongoDB cluster %s is not reachable from origin cluster", mongoDB.Name))
 
-		if resources.GetNetworkingTool() == prompt.NetworkingToolLinkerd {
-			err := linkerd.InjectNamespace(c.Origin, "default")
-			exit.OnErrorWithMessage(err, fmt.Sprintf("Failed to inject Linkerd into namespace %s in origin cluster", service.Namespace))
-		}
+		// The syncer connects from the namespace of the MongoDB clients
+		err = resources.PrepareConsumer(c.Origin, "default", false)
+		exit.OnErrorWithMessage(err, "Failed to prepare namespace default of origin cluster")
 
-		time.Sleep(5 * time.Second)
 		originPrimary, err := mongo.GetPrimaryMongoHost(mongoClientOrigin, service.Name+"."+service.Namespace+".svc.cluster.local")
 		exit.OnErrorWithMessage(err, fmt.Sprintf("Failed to get primary MongoDB host for cluster %s in origin cluster", mongoDB.Name))
 		originPrimaryHost := originPrimary
@@ -99,16 +95,19 @@ func Migrate(c kube.Clusters, resources migration.Resources, opts prompt.Migrati
 		err = mongo.CreateSyncUser(mongoClientTarget, targetPrimaryHost)
 		exit.OnErrorWithMessage(err, fmt.Sprintf("Failed to create sync user for MongoDB cluster %s in target cluster", mongoDB.Name))
 
-		originURI := getMongoURI(c.Origin, mongoDB, service, resources, mongoClientOrigin, originPrimaryHost)
-		targetURI := getMongoURI(c.Target, mongoDB, service, resources, mongoClientTarget, targetPrimaryHost) + "&directConnection=true"
-
-		if resources.GetNetworkingTool() == prompt.NetworkingToolSkupper || resources.GetNetworkingTool() == prompt.NetworkingToolLinkerd {
-			targetURI = fmt.Sprintf("mongodb://clusteradmin:password1@%s-target.%s.svc.cluster.local:27017/?authSource=admin&directConnection=true", service.Name, service.Namespace)
-		}
+		originURI := getMongoURI(mongoDB, mongoClientOrigin, originPrimaryHost)
+		// The target replica set runs a single member until the sync finished, the syncer connects to it directly
+		targetURI := fmt.Sprintf("mongodb://clusteradmin:password1@%s:27017/?authSource=admin&directConnection=true",
+			resources.ImportedDNSName(c.Target, service.Namespace, service.Name))
 
+		// The syncer resolves the per-pod names of the members, which follow restarted members
+		err = kube.ResyncHeadlessServices()
+		exit.OnErrorWithMessage(err, fmt.Sprintf("Failed to resync member aliases of MongoDB cluster %s", mongoDB.Name))
+		stopSync := kube.SyncHeadlessServices(aliasSyncInterval)
 		deployMongoSyncer(c.Origin, originURI, targetURI)
 
 		waitForJobCompletion(c.Origin, "default", "mongosyncer-job")
+		stopSync()
 
 		err = mongo.CreateTestUser(mongoClientTarget, targetPrimaryHost)
 		exit.OnErrorWithMessage(err, fmt.Sprintf("Failed to create test user for MongoDB cluster %s in target cluster", mongoDB.Name))
@@ -125,18 +124,13 @@ func Migrate(c kube.Clusters, resources migration.Resources, opts prompt.Migrati
 
 }
 
-func getMongoURI(c kube.Cluster, mongoDB mongov1.MongoDBCommunity, service corev1.Service, resources migration.Resources, mongoClient *mongo.Client, host string) string {
+func getMongoURI(mongoDB mongov1.MongoDBCommunity, mongoClient *mongo.Client, host string) string {
 	hosts, err := mongo.GetMongoHostsAuthenticated(mongoClient, host)
 	exit.OnErrorWithMessage(err, fmt.Sprintf("Failed to get MongoDB hosts for cluster %s", mongoDB.Name))
 
-	updatedHosts := hosts
-
-	if resources.GetNetworkingTool() == prompt.NetworkingToolSubmariner {
-		updatedHosts = statefulset.UpdateMongoHosts(hosts, resources, service, c)
-	}
 	uri := fmt.Sprintf(
 		"mongodb://clusteradmin:password1@%s/?authSource=admin",
-		strings.Join(updatedHosts, ","),
+		strings.Join(hosts, ","),
 	)
 	logger.Info(uri)
 	return uri
@@ -6,20 +6,20 @@ import (
 	"clustershift/internal/kube"
 	"clustershift/internal/logger"
 	"clustershift/internal/migration"
-	"clustershift/internal/prompt"
-	"clustershift/pkg/skupper"
 	"context"
 	"fmt"
 	appsv1 "k8s.io/api/apps/v1"
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
-	v1core "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"reflect"
 	"strings"
 	"time"
 )
 
+// importTimeout bounds the wait for the exported database services to be usable in the peer
+const importTimeout = 5 * time.Minute
+
 func Migrate(c kube.Clusters, resources migration.Resources) {
 	logger.Info("Migrating PostgreSQL databases")
 
@@ -44,23 +44,12 @@ func Migrate(c kube.Clusters, resources migration.Resources) {
 
 		getCredentialsFromStatefulSet(c.Origin, sts, &db)
 
-		if resources.GetNetworkingTool() == prompt.NetworkingToolSkupper {
-			skupper.CreateSiteConnection(c, db.Namespace)
-		}
-		resources.ExportService(c.Origin, db.Namespace, db.ServiceName)
-
-		if resources.GetNetworkingTool() == prompt.NetworkingToolLinkerd {
-			namespaceInterface, err := c.Target.FetchResource(kube.Namespace, db.Namespace, "")
-			exit.OnErrorWithMessage(err, "Failed to fetch namespace")
-			namespaceObj := namespaceInterface.(*v1core.Namespace)
-
-			// Check if the namespace already has the linkerd.io/inject annotation
-			if namespaceObj.Annotations == nil || namespaceObj.Annotations["linkerd.io/inject"] != "enabled" {
-				// Add the linkerd injection annotation
-				err = c.Target.AddAnnotation(namespaceObj, "linkerd.io/inject", "enabled")
-				exit.OnErrorWithMessage(err, "Failed to add linkerd inject annotation to namespace")
-			}
-		}
+		err = resources.PrepareNamespace(c, db.Namespace)
+		exit.OnErrorWithMessage(err, fmt.Sprintf("Failed to prepare namespace %s for exported services", db.Namespace))
+		err = resources.ExportService(c.Origin, db.Namespace, db.ServiceName)
+		exit.OnErrorWithMessage(err, fmt.Sprintf("Failed to export service %s/%s", db.Namespace, db.ServiceName))
+		err = resources.WaitForImport(c.Origin, db.Namespace, db.ServiceName, importTimeout)
+		exit.OnErrorWithMessage(err, fmt.Sprintf("Exported service %s/%s is not usable in target cluster", db.Namespace, db.ServiceName))
 
 		err = enableReplication(c.Origin, db)
 		exit.OnErrorWithMessage(err, fmt.Sprintf("Failed to enable replication for %s", db.StatefulsetName))
@@ -291,7 +280,7 @@ func copyResources(c kube.Clusters, db DatabaseInstance, resources migration.Res
 			},
 			{
 				Name:  "POSTGRESQL_MASTER_HOST",
-				Value: resources.GetPostgresDNSName(db.ServiceName, db.Namespace),
+				Value: resources.ImportedDNSName(c.Origin, db.Namespace, db.ServiceName),
 			},
 			{
 				Name:  "POSTGRESQL_REPLICATION_USER",
@@ -0,0 +1,122 @@
+package direct
+
+import (
+	"clustershift/internal/constants"
+	"clustershift/internal/exit"
+	"clustershift/internal/kube"
+	"clustershift/internal/logger"
+	"clustershift/internal/prompt"
+	"context"
+	"fmt"
+	"net"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Exposure is the service type exported services are exposed with
+var Exposure = prompt.DirectLoadBalancer
+
+// allowedRanges caches the source ranges of the clusters, keyed by cluster name
+var allowedRanges = make(map[string][]string)
+
+// ValidateExposure checks that the exposure is a supported service type
+func ValidateExposure(exposure string) error {
+	switch exposure {
+	case prompt.DirectLoadBalancer, prompt.DirectNodePort:
+		return nil
+	}
+	return fmt.Errorf("unknown exposure %q, use %s or %s", exposure, prompt.DirectLoadBalancer, prompt.DirectNodePort)
+}
+
+// Install deploys nothing, the clusters reach each other through the exposed services. It
+// resolves the addresses each cluster connects from, which are the only ones allowed to
+// reach the services exposed to it.
+func Install(c kube.Clusters) {
+	logger.Info(fmt.Sprintf("Connecting clusters directly with %s services", Exposure))
+	for _, cluster := range []kube.Cluster{c.Origin, c.Target} {
+		ranges, err := sourceRanges(cluster)
+		exit.OnErrorWithMessage(err, fmt.Sprintf("Failed to determine the addresses of %s cluster", cluster.Name))
+		logger.Debug(fmt.Sprintf("Services exposed to %s cluster allow %v", cluster.Name, ranges))
+	}
+}
+
+// Cleanup deletes the services, network policies and EndpointSlices exposing the services of the
+// cluster, in the cluster itself and in its peer
+func Cleanup(c kube.Cluster) error {
+	peer, err := kube.Peer(c)
+	if err != nil {
+		return err
+	}
+	selector := metav1.ListOptions{LabelSelector: constants.DirectExportLabel + "=" + c.Name}
+	for _, cluster := range []kube.Cluster{c, peer} {
+		services, err := cluster.Clientset.CoreV1().Services("").List(context.TODO(), selector)
+		if err != nil {
+			return err
+		}
+		for _, service := range services.Items {
+			if err := cluster.DeleteResource(kube.Service, service.Name, service.Namespace); err != nil {
+				return err
+			}
+		}
+		slices, err := cluster.Clientset.DiscoveryV1().EndpointSlices("").List(context.TODO(), selector)
+		if err != nil {
+			return err
+		}
+		for _, slice := range slices.Items {
+			err := cluster.Clientset.DiscoveryV1().EndpointSlices(slice.Namespace).Delete(context.TODO(), slice.Name, metav1.DeleteOptions{})
+			if err != nil {
+				return err
+			}
+		}
+		policies, err := cluster.Clientset.NetworkingV1().NetworkPolicies("").List(context.TODO(), selector)
+		if err != nil {
+			return err
+		}
+		for _, policy := range policies.Items {
+			err := cluster.Clientset.NetworkingV1().NetworkPolicies(policy.Namespace).Delete(context.TODO(), policy.Name, metav1.DeleteOptions{})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	logger.Info(fmt.Sprintf("Removed the services exposing %s cluster", c.Name))
+	return nil
+}
+
+// sourceRanges returns the node addresses and the pod CIDR of the cluster, traffic of its pods
+// leaves the cluster either from a node or, without masquerading, from the pod itself
+func sourceRanges(c kube.Cluster) ([]string, error) {
+	if ranges, ok := allowedRanges[c.Name]; ok {
+		return ranges, nil
+	}
+
+	nodes, err := c.Clientset.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var ranges []string
+	for _, node := range nodes.Items {
+		for _, address := range node.Status.Addresses {
+			if address.Type != v1.NodeInternalIP && address.Type != v1.NodeExternalIP {
+				continue
+			}
+			ip := net.ParseIP(address.Address)
+			if ip == nil || ip.To4() == nil || seen[address.Address] {
+				continue
+			}
+			seen[address.Address] = true
+			ranges = append(ranges, address.Address+"/32")
+		}
+	}
+	if podCIDR, err := c.DiscoverPodCIDR(); err == nil {
+		ranges = append(ranges, podCIDR.CIDR)
+	}
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("no node addresses found")
+	}
+
+	allowedRanges[c.Name] = ranges
+	return ranges, nil
+}
@@ -0,0 +1,431 @@
+package direct
+
+import (
+	"clustershift/internal/constants"
+	"clustershift/internal/kube"
+	"clustershift/internal/logger"
+	"clustershift/internal/prompt"
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	podNameLabel  = "statefulset.kubernetes.io/pod-name"
+	managedBy     = "clustershift"
+	exposeTimeout = 3 * time.Minute
+)
+
+// exposure is the address the peer cluster reaches an exposed service at
+type exposure struct {
+	addresses []string
+	ports     []discoveryv1.EndpointPort
+}
+
+// Export makes a service reachable from the peer cluster as <service>-<cluster>. The service is
+// exposed with a LoadBalancer service only the peer may connect to or a NodePort service, the peer gets the
+// alias without selector and an EndpointSlice with the exposed address. Headless services expose
+// every pod on its own, the EndpointSlices keep the pod names as hostnames for per-pod DNS names.
+func Export(c kube.Cluster, namespace, name string) error {
+	logger.Info(fmt.Sprintf("Exporting service %s/%s of %s cluster", namespace, name, c.Name))
+
+	peer, err := kube.Peer(c)
+	if err != nil {
+		return err
+	}
+	ranges, err := sourceRanges(peer)
+	if err != nil {
+		return fmt.Errorf("failed to determine the addresses of %s cluster: %w", peer.Name, err)
+	}
+
+	serviceInterface, err := c.FetchResource(kube.Service, name, namespace)
+	if err != nil {
+		return fmt.Errorf("failed to fetch service %s/%s: %w", namespace, name, err)
+	}
+	service := serviceInterface.(*v1.Service)
+
+	alias := name + "-" + c.Name
+	labels := map[string]string{constants.DirectExportLabel: c.Name}
+	peer.CreateNewNamespace(namespace)
+
+	if service.Spec.ClusterIP != v1.ClusterIPNone {
+		exposed, err := expose(c, service, alias, service.Spec.Selector, ranges)
+		if err != nil {
+			return fmt.Errorf("failed to expose service %s/%s: %w", namespace, name, err)
+		}
+		if err := createService(peer, labelled(kube.AliasService(service, alias, nil, nil), labels)); err != nil {
+			return err
+		}
+		if err := peer.ApplyEndpointSlice(endpointSlice(c, alias, alias, namespace, exposed, nil)); err != nil {
+			return fmt.Errorf("failed to create endpoints of service %s/%s: %w", namespace, alias, err)
+		}
+		return nil
+	}
+
+	headless := labelled(kube.AliasService(service, alias, nil, nil), labels)
+	headless.Spec.ClusterIP = v1.ClusterIPNone
+	if err := createService(peer, headless); err != nil {
+		return err
+	}
+	// The exporting cluster resolves the per-pod names to its own pods, replica sets address their
+	// own members by the names the peer uses
+	if err := kube.MirrorHeadlessService(c, c, service, alias, labels); err != nil {
+		return fmt.Errorf("failed to mirror endpoints of service %s/%s: %w", namespace, name, err)
+	}
+
+	pods, err := podNames(c, service)
+	if err != nil {
+		return fmt.Errorf("failed to list the pods of service %s/%s: %w", namespace, name, err)
+	}
+	for _, pod := range pods {
+		exposed, err := expose(c, service, pod+"-"+c.Name, map[string]string{podNameLabel: pod}, ranges)
+		if err != nil {
+			return fmt.Errorf("failed to expose pod %s/%s: %w", namespace, pod, err)
+		}
+		hostname := pod
+		if err := peer.ApplyEndpointSlice(endpointSlice(c, alias+"-"+pod, alias, namespace, exposed, &hostname)); err != nil {
+			return fmt.Errorf("failed to create endpoints of service %s/%s: %w", namespace, alias, err)
+		}
+	}
+	return nil
+}
+
+// expose creates the service exposing the selected pods to the source ranges and waits for its
+// address. NodePort services keep the client address, so a network policy restricts them instead.
+// Services without ready pods are not exposed with a NodePort service, they have no address.
+func expose(c kube.Cluster, service *v1.Service, name string, selector map[string]string, ranges []string) (exposure, error) {
+	exposed := kube.AliasService(service, name, selector, nil)
+	exposed.Labels = map[string]string{constants.DirectExportLabel: c.Name}
+	if Exposure == prompt.DirectNodePort {
+		// Without ready pods no node accepts connections, the alias stays without endpoints
+		nodes, err := endpointNodes(c, service)
+		if err != nil {
+			return exposure{}, err
+		}
+		if len(nodes) == 0 {
+			logger.Warning(fmt.Sprintf("Not exposing service %s/%s to the peer", service.Namespace, service.Name),
+				fmt.Errorf("the service has no ready pods"))
+			return exposure{}, nil
+		}
+		exposed.Spec.Type = v1.ServiceTypeNodePort
+		exposed.Spec.ExternalTrafficPolicy = v1.ServiceExternalTrafficPolicyLocal
+		if err := allowPeer(c, service, name, selector, ranges); err != nil {
+			return exposure{}, err
+		}
+	} else {
+		exposed.Spec.Type = v1.ServiceTypeLoadBalancer
+		exposed.Spec.LoadBalancerSourceRanges = ranges
+	}
+	if err := createService(c, exposed); err != nil {
+		return exposure{}, err
+	}
+
+	var result exposure
+	err := wait.PollUntilContextTimeout(context.TODO(), 5*time.Second, exposeTimeout, true, func(ctx context.Context) (bool, error) {
+		serviceInterface, err := c.FetchResource(kube.Service, name, service.Namespace)
+		if err != nil {
+			return false, nil
+		}
+		current := serviceInterface.(*v1.Service)
+		if current.Spec.Type == v1.ServiceTypeNodePort {
+			result.addresses, err = endpointNodes(c, current)
+		} else {
+			result.addresses, err = loadBalancerAddresses(current)
+		}
+		if err != nil || len(result.addresses) == 0 {
+			return false, nil
+		}
+		result.ports = exposedPorts(current)
+		return true, nil
+	})
+	if err != nil {
+		return exposure{}, fmt.Errorf("service %s/%s has no address reachable from the peer: %w", service.Namespace, name, err)
+	}
+	return result, nil
+}
+
+// allowPeer restricts the node ports of the selected pods to the peer. Network policies only add to
+// each other: pods existing policies isolate get a policy adding the peer on the exposed ports. Pods
+// accepting all ingress get isolated, the policy keeps the traffic of the local nodes, pods and
+// services on all ports and only blocks other sources.
+func allowPeer(c kube.Cluster, service *v1.Service, name string, selector map[string]string, ranges []string) error {
+	isolated, err := isolated(c, service.Namespace, selector)
+	if err != nil {
+		return err
+	}
+
+	allowed := ranges
+	var ports []networkingv1.NetworkPolicyPort
+	if isolated {
+		for _, port := range service.Spec.Ports {
+			protocol := port.Protocol
+			targetPort := port.TargetPort
+			ports = append(ports, networkingv1.NetworkPolicyPort{Protocol: &protocol, Port: &targetPort})
+		}
+	} else {
+		local, err := sourceRanges(c)
+		if err != nil {
+			return fmt.Errorf("failed to determine the addresses of %s cluster: %w", c.Name, err)
+		}
+		allowed = append(append([]string{}, ranges...), local...)
+		if serviceCIDR, err := c.DiscoverServiceCIDR(); err == nil {
+			allowed = append(allowed, serviceCIDR.CIDR)
+		}
+		logger.Warning(fmt.Sprintf("Isolating the pods of service %s/%s to restrict node port service %s", service.Namespace, service.Name, name),
+			fmt.Errorf("only the nodes, pods and services of %s cluster and the peer may connect to them, other external clients are blocked", c.Name))
+	}
+
+	var peers []networkingv1.NetworkPolicyPeer
+	if !isolated {
+		// Pod addresses are not reliably matched by IP blocks
+		peers = append(peers, networkingv1.NetworkPolicyPeer{NamespaceSelector: &metav1.LabelSelector{}})
+	}
+	for _, cidr := range allowed {
+		peers = append(peers, networkingv1.NetworkPolicyPeer{IPBlock: &networkingv1.IPBlock{CIDR: cidr}})
+	}
+
+	policy := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: service.Namespace,
+			Labels:    map[string]string{constants.DirectExportLabel: c.Name},
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{MatchLabels: selector},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+			Ingress:     []networkingv1.NetworkPolicyIngressRule{{From: peers, Ports: ports}},
+		},
+	}
+	_, err = c.Clientset.NetworkingV1().NetworkPolicies(service.Namespace).Create(context.TODO(), policy, metav1.CreateOptions{})
+	if k8serrors.IsAlreadyExists(err) {
+		return nil
+	}
+	return err
+}
+
+// isolated reports whether an ingress network policy selects one of the pods
+func isolated(c kube.Cluster, namespace string, selector map[string]string) (bool, error) {
+	pods, err := c.Clientset.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(selector).String(),
+	})
+	if err != nil {
+		return false, err
+	}
+	policies, err := c.Clientset.NetworkingV1().NetworkPolicies(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return false, err
+	}
+	for _, policy := range policies.Items {
+		if !restrictsIngress(policy) {
+			continue
+		}
+		policySelector, err := metav1.LabelSelectorAsSelector(&policy.Spec.PodSelector)
+		if err != nil {
+			return false, err
+		}
+		for _, pod := range pods.Items {
+			if policySelector.Matches(labels.Set(pod.Labels)) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// restrictsIngress reports whether the policy applies to ingress, policies without types always do
+func restrictsIngress(policy networkingv1.NetworkPolicy) bool {
+	if len(policy.Spec.PolicyTypes) == 0 {
+		return true
+	}
+	for _, policyType := range policy.Spec.PolicyTypes {
+		if policyType == networkingv1.PolicyTypeIngress {
+			return true
+		}
+	}
+	return false
+}
+
+// loadBalancerAddresses returns the IPv4 addresses of the load balancer, hostnames are resolved
+// because EndpointSlices of cluster services only take IP addresses
+func loadBalancerAddresses(service *v1.Service) ([]string, error) {
+	var addresses []string
+	for _, ingress := range service.Status.LoadBalancer.Ingress {
+		if ingress.IP != "" {
+			addresses = append(addresses, ingress.IP)
+			continue
+		}
+		if ingress.Hostname == "" {
+			continue
+		}
+		ips, err := net.LookupIP(ingress.Hostname)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range ips {
+			if ip.To4() != nil {
+				addresses = append(addresses, ip.String())
+			}
+		}
+	}
+	return addresses, nil
+}
+
+// endpointNodes returns the addresses of the nodes running a ready pod of the service, with the
+// local traffic policy only these nodes accept connections on the node ports
+func endpointNodes(c kube.Cluster, service *v1.Service) ([]string, error) {
+	slices, err := c.Clientset.DiscoveryV1().EndpointSlices(service.Namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: discoveryv1.LabelServiceName + "=" + service.Name,
+	})
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var addresses []string
+	for _, slice := range slices.Items {
+		for _, endpoint := range slice.Endpoints {
+			if endpoint.NodeName == nil || seen[*endpoint.NodeName] || (endpoint.Conditions.Ready != nil && !*endpoint.Conditions.Ready) {
+				continue
+			}
+			node, err := c.Clientset.CoreV1().Nodes().Get(context.TODO(), *endpoint.NodeName, metav1.GetOptions{})
+			if err != nil {
+				return nil, err
+			}
+			seen[*endpoint.NodeName] = true
+			if address := kube.NodeAddress(*node); address != "" {
+				addresses = append(addresses, address)
+			}
+		}
+	}
+	return addresses, nil
+}
+
+// exposedPorts returns the ports the peer connects to, which are the node ports of NodePort services
+func exposedPorts(service *v1.Service) []discoveryv1.EndpointPort {
+	var ports []discoveryv1.EndpointPort
+	for _, port := range service.Spec.Ports {
+		name := port.Name
+		protocol := port.Protocol
+		number := port.Port
+		if service.Spec.Type == v1.ServiceTypeNodePort {
+			number = port.NodePort
+		}
+		ports = append(ports, discoveryv1.EndpointPort{Name: &name, Protocol: &protocol, Port: &number})
+	}
+	return ports
+}
+
+// podNames returns the pods behind a headless service
+func podNames(c kube.Cluster, service *v1.Service) ([]string, error) {
+	slices, err := c.Clientset.DiscoveryV1().EndpointSlices(service.Namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: discoveryv1.LabelServiceName + "=" + service.Name,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var pods []string
+	for _, slice := range slices.Items {
+		for _, endpoint := range slice.Endpoints {
+			if endpoint.TargetRef != nil && endpoint.TargetRef.Kind == "Pod" {
+				pods = append(pods, endpoint.TargetRef.Name)
+			}
+		}
+	}
+	return pods, nil
+}
+
+// endpointSlice lists every exposed address as an endpoint of the alias, consumers only use the
+// first address of an endpoint
+func endpointSlice(c kube.Cluster, name, serviceName, namespace string, exposed exposure, hostname *string) *discoveryv1.EndpointSlice {
+	ready := true
+	endpoints := make([]discoveryv1.Endpoint, 0, len(exposed.addresses))
+	for _, address := range exposed.addresses {
+		endpoints = append(endpoints, discoveryv1.Endpoint{
+			Addresses:  []string{address},
+			Hostname:   hostname,
+			Conditions: discoveryv1.EndpointConditions{Ready: &ready},
+		})
+	}
+	return &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels: map[string]string{
+				discoveryv1.LabelServiceName: serviceName,
+				discoveryv1.LabelManagedBy:   managedBy,
+				constants.DirectExportLabel:  c.Name,
+			},
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+		Endpoints:   endpoints,
+		Ports:       exposed.ports,
+	}
+}
+
+func labelled(service *v1.Service, labels map[string]string) *v1.Service {
+	service.Labels = labels
+	return service
+}
+
+func createService(c kube.Cluster, service *v1.Service) error {
+	err := c.CreateResource(kube.Service, service.Namespace, service)
+	if k8serrors.IsAlreadyExists(err) {
+		logger.Debug(fmt.Sprintf("Service %s/%s already exists in %s cluster", service.Namespace, service.Name, c.Name))
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create service %s/%s: %w", service.Namespace, service.Name, err)
+	}
+	return nil
+}
+
+// Unexport deletes the alias of the service in the peer and the services exposing it
+func Unexport(c kube.Cluster, namespace, name string) error {
+	peer, err := kube.Peer(c)
+	if err != nil {
+		return err
+	}
+	serviceInterface, err := c.FetchResource(kube.Service, name, namespace)
+	if err != nil {
+		return err
+	}
+	service := serviceInterface.(*v1.Service)
+
+	alias := name + "-" + c.Name
+	for _, cluster := range []kube.Cluster{c, peer} {
+		if err := kube.DeleteAlias(cluster, alias, namespace); err != nil {
+			return err
+		}
+	}
+
+	// The exposing services are named after the alias or, for headless services, after the pods
+	exposed := []string{alias}
+	if service.Spec.ClusterIP == v1.ClusterIPNone {
+		pods, err := podNames(c, service)
+		if err != nil {
+			return err
+		}
+		for _, pod := range pods {
+			exposed = append(exposed, pod+"-"+c.Name)
+		}
+	}
+	for _, exposure := range exposed {
+		err := c.DeleteResource(kube.Service, exposure, namespace)
+		if err != nil && !k8serrors.IsNotFound(err) {
+			return err
+		}
+		err = c.Clientset.NetworkingV1().NetworkPolicies(namespace).Delete(context.TODO(), exposure, metav1.DeleteOptions{})
+		if err != nil && !k8serrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
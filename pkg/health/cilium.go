@@ -0,0 +1,77 @@
+package health
+
+import (
+	"bytes"
+	"clustershift/internal/constants"
+	"clustershift/internal/kube"
+	"context"
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ciliumStatus is the part of the agent status describing the ClusterMesh connections
+type ciliumStatus struct {
+	ClusterMesh struct {
+		Clusters []struct {
+			Name  string `json:"name"`
+			Ready bool   `json:"ready"`
+		} `json:"clusters"`
+	} `json:"cluster-mesh"`
+}
+
+func checkCilium(clusters kube.Clusters) []Result {
+	return forEachCluster(clusters, checkClusterMesh)
+}
+
+// checkClusterMesh verifies the clustermesh-apiserver and that the agents are connected to the peer
+func checkClusterMesh(c kube.Cluster) []Result {
+	result := checkDeployment(c, "ClusterMesh API server", constants.CiliumNamespace, constants.CiliumClusterMeshAPIName,
+		"Check the clustermesh-apiserver pods and that its LoadBalancer service received an external IP")
+	if !result.Healthy {
+		return []Result{result}
+	}
+	results := []Result{result}
+
+	pods, err := c.Clientset.CoreV1().Pods(constants.CiliumNamespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: "k8s-app=cilium",
+	})
+	if err != nil || len(pods.Items) == 0 {
+		return append(results, unhealthy("ClusterMesh connection", c.Name, "no cilium agent found", "Check the cilium daemonset"))
+	}
+
+	status, err := agentStatus(c, pods.Items[0])
+	if err != nil {
+		return append(results, unhealthy("ClusterMesh connection", c.Name, err.Error(), "Check the cilium agent logs"))
+	}
+	if len(status.ClusterMesh.Clusters) == 0 {
+		return append(results, unhealthy("ClusterMesh connection", c.Name, "agent is not connected to a remote cluster",
+			"Check that the clustermesh config lists the peer and that its clustermesh-apiserver is reachable on port 2379"))
+	}
+	for _, remote := range status.ClusterMesh.Clusters {
+		if !remote.Ready {
+			results = append(results, unhealthy("ClusterMesh connection", c.Name, fmt.Sprintf("cluster %s is not ready", remote.Name),
+				"Both clusters need the same Cilium CA and unique cluster names and IDs, check the agent logs for TLS errors"))
+			continue
+		}
+		results = append(results, healthy("ClusterMesh connection", c.Name, fmt.Sprintf("connected to %s", remote.Name)))
+	}
+	return results
+}
+
+// agentStatus reads the status of a cilium agent, newer agents ship the cilium-dbg binary
+func agentStatus(c kube.Cluster, pod corev1.Pod) (ciliumStatus, error) {
+	var status ciliumStatus
+	var err error
+	for _, binary := range []string{"cilium-dbg", "cilium"} {
+		var stdout, stderr bytes.Buffer
+		err = c.ExecIntoPod(pod.Namespace, pod.Name, "cilium-agent", []string{binary, "status", "-o", "json"}, &stdout, &stderr)
+		if err != nil {
+			continue
+		}
+		return status, json.Unmarshal(stdout.Bytes(), &status)
+	}
+	return status, fmt.Errorf("failed to read agent status: %w", err)
+}
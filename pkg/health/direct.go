@@ -0,0 +1,43 @@
+package health
+
+import (
+	"clustershift/internal/constants"
+	"clustershift/internal/kube"
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func checkDirect(clusters kube.Clusters) []Result {
+	return forEachCluster(clusters, checkExposedServices)
+}
+
+// checkExposedServices verifies that the load balancers exposing services of the cluster received an address
+func checkExposedServices(c kube.Cluster) []Result {
+	services, err := c.Clientset.CoreV1().Services("").List(context.TODO(), metav1.ListOptions{
+		LabelSelector: constants.DirectExportLabel + "=" + c.Name,
+	})
+	if err != nil {
+		return []Result{unhealthy("Exposed services", c.Name, err.Error(), "")}
+	}
+
+	var results []Result
+	for _, service := range services.Items {
+		if service.Spec.Type != corev1.ServiceTypeLoadBalancer {
+			continue
+		}
+		object := fmt.Sprintf("%s/%s", service.Namespace, service.Name)
+		if len(service.Status.LoadBalancer.Ingress) == 0 {
+			results = append(results, unhealthy("Exposed services", c.Name, object+" has no load balancer address",
+				"The cluster needs a load balancer implementation, or use --direct-exposure nodeport"))
+			continue
+		}
+		results = append(results, healthy("Exposed services", c.Name, object+" is exposed"))
+	}
+	if len(results) == 0 {
+		return []Result{healthy("Exposed services", c.Name, "no services exposed yet")}
+	}
+	return results
+}
@@ -0,0 +1,158 @@
+package health
+
+import (
+	"clustershift/internal/constants"
+	"clustershift/internal/exit"
+	"clustershift/internal/kube"
+	"clustershift/internal/logger"
+	"clustershift/internal/prompt"
+	"fmt"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+// Result is the outcome of a single health check of a networking tool
+type Result struct {
+	Check       string
+	Cluster     string
+	Healthy     bool
+	Message     string
+	Remediation string
+}
+
+// Report holds all health check results of a networking tool
+type Report struct {
+	Tool    string
+	Results []Result
+}
+
+var checks = map[string]func(clusters kube.Clusters) []Result{
+	prompt.NetworkingToolSubmariner: checkSubmariner,
+	prompt.NetworkingToolLinkerd:    checkLinkerd,
+	prompt.NetworkingToolSkupper:    checkSkupper,
+	prompt.NetworkingToolIstio:      checkIstio,
+	prompt.NetworkingToolCilium:     checkCilium,
+	prompt.NetworkingToolDirect:     checkDirect,
+	prompt.NetworkingToolMCS:        checkMCS,
+}
+
+const gateInterval = 10 * time.Second
+
+// DetectNetworkingTool returns the networking tool installed in the cluster, if any
+func DetectNetworkingTool(c kube.Cluster) string {
+	tools := []struct {
+		namespace string
+		tool      string
+	}{
+		{constants.SubmarinerOperatorNamespace, prompt.NetworkingToolSubmariner},
+		{constants.LinkerdMultiClusterNamespace, prompt.NetworkingToolLinkerd},
+		{"skupper-site-controller", prompt.NetworkingToolSkupper},
+		{constants.SkupperNamespace, prompt.NetworkingToolSkupper},
+		{constants.IstioNamespace, prompt.NetworkingToolIstio},
+	}
+	for _, t := range tools {
+		if _, err := c.FetchResource(kube.Namespace, t.namespace, ""); err == nil {
+			return t.tool
+		}
+	}
+	// ClusterMesh runs in the namespace of the Cilium CNI, which exists without it
+	if _, err := c.FetchResource(kube.Deployment, constants.CiliumClusterMeshAPIName, constants.CiliumNamespace); err == nil {
+		return prompt.NetworkingToolCilium
+	}
+	return ""
+}
+
+// Run executes the health checks of the given networking tool on both clusters
+func Run(clusters kube.Clusters, tool string) Report {
+	report := Report{Tool: tool}
+	run, ok := checks[tool]
+	if !ok {
+		report.Results = append(report.Results, unhealthy("Networking tool", "both", "unsupported networking tool "+tool, ""))
+		return report
+	}
+	report.Results = run(clusters)
+	return report
+}
+
+// Gate waits until the networking tool is healthy and exits with remediation hints
+// if it does not become healthy within the timeout
+func Gate(clusters kube.Clusters, tool string, timeout time.Duration) {
+	logger.Info(fmt.Sprintf("Checking health of %s", tool))
+	defer Cleanup(clusters)
+	deadline := time.Now().Add(timeout)
+	for {
+		report := Run(clusters, tool)
+		if report.Healthy() {
+			logger.Debug(fmt.Sprintf("%s is healthy", tool))
+			return
+		}
+		if time.Now().After(deadline) {
+			report.Print()
+			Cleanup(clusters)
+			exit.OnErrorWithMessage(fmt.Errorf("%s did not become healthy within %s", tool, timeout), "Networking tool health check failed")
+		}
+		time.Sleep(gateInterval)
+	}
+}
+
+// Cleanup removes the helper pods kept by the health checks between runs
+func Cleanup(clusters kube.Clusters) {
+	deleteResolvePod(clusters.Origin)
+	deleteResolvePod(clusters.Target)
+}
+
+// Healthy reports whether all checks passed
+func (r Report) Healthy() bool {
+	for _, result := range r.Results {
+		if !result.Healthy {
+			return false
+		}
+	}
+	return true
+}
+
+// Print logs every check and the remediation hints of failed checks
+func (r Report) Print() {
+	logger.Info(fmt.Sprintf("%s health:", r.Tool))
+	for _, result := range r.Results {
+		line := fmt.Sprintf("%-28s %-8s %s", result.Check, result.Cluster, result.Message)
+		if result.Healthy {
+			logger.Info("[OK]   " + line)
+			continue
+		}
+		logger.Error("[FAIL] "+line, fmt.Errorf("unhealthy"))
+		if result.Remediation != "" {
+			logger.Info("       Hint: " + result.Remediation)
+		}
+	}
+}
+
+func healthy(check, cluster, message string) Result {
+	return Result{Check: check, Cluster: cluster, Healthy: true, Message: message}
+}
+
+func unhealthy(check, cluster, message, remediation string) Result {
+	return Result{Check: check, Cluster: cluster, Message: message, Remediation: remediation}
+}
+
+func forEachCluster(clusters kube.Clusters, run func(c kube.Cluster) []Result) []Result {
+	return append(run(clusters.Origin), run(clusters.Target)...)
+}
+
+// checkDeployment verifies all replicas of a deployment are ready
+func checkDeployment(c kube.Cluster, check, namespace, name, remediation string) Result {
+	deploymentInterface, err := c.FetchResource(kube.Deployment, name, namespace)
+	if err != nil {
+		return unhealthy(check, c.Name, fmt.Sprintf("deployment %s/%s not found", namespace, name), remediation)
+	}
+	deployment := deploymentInterface.(*appsv1.Deployment)
+	desired := int32(1)
+	if deployment.Spec.Replicas != nil {
+		desired = *deployment.Spec.Replicas
+	}
+	if deployment.Status.ReadyReplicas < desired {
+		return unhealthy(check, c.Name, fmt.Sprintf("deployment %s/%s has %d/%d ready replicas", namespace, name, deployment.Status.ReadyReplicas, desired), remediation)
+	}
+	return healthy(check, c.Name, fmt.Sprintf("deployment %s/%s ready", namespace, name))
+}
@@ -0,0 +1,97 @@
+package health
+
+import (
+	"bytes"
+	"clustershift/internal/constants"
+	"clustershift/internal/kube"
+	"clustershift/internal/prompt"
+	"clustershift/pkg/istio"
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func checkIstio(clusters kube.Clusters) []Result {
+	results := forEachCluster(clusters, checkEastWestGateway)
+	results = append(results, checkRemoteSecrets(clusters)...)
+	return append(results, checkIstioRootOfTrust(clusters)...)
+}
+
+// checkEastWestGateway verifies the control plane and that the east-west gateway is exposed
+func checkEastWestGateway(c kube.Cluster) []Result {
+	var results []Result
+	if istio.Installed(c) {
+		results = append(results, checkDeployment(c, "Control plane", constants.IstioNamespace, "istiod",
+			"Check the istiod logs, it needs the cacerts secret and access to the remote clusters"))
+	}
+
+	result := checkDeployment(c, "East-west gateway", constants.IstioNamespace, constants.IstioEastWestGatewayName,
+		"Check the east-west gateway pods, they need sidecar injection from the control plane")
+	if !result.Healthy {
+		return append(results, result)
+	}
+	serviceInterface, err := c.FetchResource(kube.Service, constants.IstioEastWestGatewayName, constants.IstioNamespace)
+	if err != nil || len(serviceInterface.(*corev1.Service).Status.LoadBalancer.Ingress) == 0 {
+		return append(results, unhealthy("East-west gateway", c.Name, "service has no load balancer address",
+			"The east-west gateway needs a LoadBalancer service reachable from the other cluster on port 15443"))
+	}
+	return append(results, result)
+}
+
+// checkRemoteSecrets verifies that every primary cluster can discover the endpoints of its peer
+func checkRemoteSecrets(clusters kube.Clusters) []Result {
+	pairs := [][2]kube.Cluster{{clusters.Origin, clusters.Target}, {clusters.Target, clusters.Origin}}
+	if istio.Mode == prompt.IstioPrimaryRemote {
+		pairs = pairs[:1]
+	}
+
+	var results []Result
+	for _, pair := range pairs {
+		primary, peer := pair[0], pair[1]
+		remoteID := istio.ClusterID(peer)
+		secrets, err := primary.Clientset.CoreV1().Secrets(constants.IstioNamespace).List(context.TODO(), metav1.ListOptions{
+			LabelSelector: "istio/multiCluster=true",
+		})
+		if err != nil {
+			results = append(results, unhealthy("Remote secret", primary.Name, err.Error(), ""))
+			continue
+		}
+		found := false
+		for _, secret := range secrets.Items {
+			if _, ok := secret.Data[remoteID]; ok {
+				found = true
+				break
+			}
+		}
+		if !found {
+			results = append(results, unhealthy("Remote secret", primary.Name, fmt.Sprintf("no remote secret for cluster %s", remoteID),
+				"Create the remote secret of the peer cluster, migrate creates it with the istio-reader-service-account token"))
+			continue
+		}
+		results = append(results, healthy("Remote secret", primary.Name, fmt.Sprintf("discovers cluster %s", remoteID)))
+	}
+	return results
+}
+
+// checkIstioRootOfTrust verifies that both clusters issue certificates from the same root CA
+func checkIstioRootOfTrust(clusters kube.Clusters) []Result {
+	var roots [][]byte
+	for _, c := range []kube.Cluster{clusters.Origin, clusters.Target} {
+		// A control plane without plugged-in CA signs with its self-signed CA
+		if secretInterface, err := c.FetchResource(kube.Secret, "cacerts", constants.IstioNamespace); err == nil {
+			roots = append(roots, secretInterface.(*corev1.Secret).Data["root-cert.pem"])
+		} else if secretInterface, err := c.FetchResource(kube.Secret, "istio-ca-secret", constants.IstioNamespace); err == nil {
+			roots = append(roots, secretInterface.(*corev1.Secret).Data["ca-cert.pem"])
+		} else {
+			return []Result{unhealthy("Root of trust", c.Name, "no Istio CA found",
+				"Plug in intermediate CAs of a shared root as cacerts secret and restart istiod")}
+		}
+	}
+	if !bytes.Equal(roots[0], roots[1]) {
+		return []Result{unhealthy("Root of trust", "both", "clusters use different root certificates",
+			"Plug in intermediate CAs of a shared root as cacerts secret and restart istiod")}
+	}
+	return []Result{healthy("Root of trust", "both", "clusters share the root certificate")}
+}
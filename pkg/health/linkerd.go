@@ -0,0 +1,166 @@
+package health
+
+import (
+	"clustershift/internal/cert"
+	"clustershift/internal/constants"
+	"clustershift/internal/kube"
+	"context"
+	"crypto/x509"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	trustRootsConfigMap = "linkerd-identity-trust-roots"
+	trustRootsKey       = "ca-bundle.crt"
+	issuerSecret        = "linkerd-identity-issuer"
+
+	// issuerRenewWithin is the remaining validity below which the issuer is due for rotation
+	issuerRenewWithin = 30 * 24 * time.Hour
+)
+
+func checkLinkerd(clusters kube.Clusters) []Result {
+	results := forEachCluster(clusters, checkLinkerdLinks)
+	results = append(results, forEachCluster(clusters, checkMirroredServices)...)
+	return append(results, checkTrustAnchors(clusters)...)
+}
+
+// checkLinkerdLinks verifies every link has a running service mirror and a reachable remote gateway
+func checkLinkerdLinks(c kube.Cluster) []Result {
+	results := []Result{checkDeployment(c, "Gateway", constants.LinkerdMultiClusterNamespace, "linkerd-gateway",
+		"Check the linkerd-gateway pods and that its LoadBalancer service received an external IP")}
+
+	links, err := c.ListCustomResources(schema.GroupKind{Group: "multicluster.linkerd.io", Kind: "Link"}, constants.LinkerdMultiClusterNamespace)
+	if err != nil {
+		return append(results, unhealthy("Link", c.Name, err.Error(), "Install the linkerd-multicluster extension"))
+	}
+	if len(links) == 0 {
+		return append(results, unhealthy("Link", c.Name, "no link to another cluster",
+			"Link the clusters, the remote kubeconfig secret and Link resource are created by migrate"))
+	}
+
+	for _, link := range links {
+		remote, _, _ := unstructured.NestedString(link, "spec", "targetClusterName")
+		if remote == "" {
+			remote = nestedName(link)
+		}
+		results = append(results, checkDeployment(c, "Service mirror", constants.LinkerdMultiClusterNamespace, "linkerd-service-mirror-"+remote,
+			"Check the service mirror logs, the credentials of the remote cluster may be invalid or its API server unreachable"))
+
+		probeService := "probe-gateway-" + remote
+		if ready, err := HasReadyEndpoints(c, constants.LinkerdMultiClusterNamespace, probeService); err != nil || !ready {
+			results = append(results, unhealthy("Gateway probe", c.Name, fmt.Sprintf("gateway of %s is not reachable through %s", remote, probeService),
+				"Check that the gateway of the remote cluster is reachable on its probe port 4191 and its gateway port 4143"))
+			continue
+		}
+		results = append(results, healthy("Gateway probe", c.Name, fmt.Sprintf("gateway of %s is reachable", remote)))
+	}
+	return results
+}
+
+// checkMirroredServices verifies that every mirrored service has endpoints
+func checkMirroredServices(c kube.Cluster) []Result {
+	services, err := c.Clientset.CoreV1().Services("").List(context.TODO(), metav1.ListOptions{
+		LabelSelector: "mirror.linkerd.io/mirrored-service=true",
+	})
+	if err != nil {
+		return []Result{unhealthy("Mirrored services", c.Name, err.Error(), "")}
+	}
+
+	var missing []string
+	for _, service := range services.Items {
+		if ready, err := HasReadyEndpoints(c, service.Namespace, service.Name); err != nil || !ready {
+			missing = append(missing, service.Namespace+"/"+service.Name)
+		}
+	}
+	if len(missing) > 0 {
+		return []Result{unhealthy("Mirrored services", c.Name, fmt.Sprintf("services without endpoints: %v", missing),
+			"Check that the exported services have ready pods in the remote cluster and that the gateway probe is healthy")}
+	}
+	return []Result{healthy("Mirrored services", c.Name, fmt.Sprintf("%d mirrored services with endpoints", len(services.Items)))}
+}
+
+// checkTrustAnchors verifies both clusters share valid trust anchors and their issuers are signed by them
+func checkTrustAnchors(clusters kube.Clusters) []Result {
+	remediation := "Both clusters must use the same trust anchor, reinstall Linkerd with the shared certificates"
+	bundles := make(map[string]string)
+	var results []Result
+
+	for _, c := range []kube.Cluster{clusters.Origin, clusters.Target} {
+		configMapInterface, err := c.FetchResource(kube.ConfigMap, trustRootsConfigMap, constants.LinkerdNamespace)
+		if err != nil {
+			results = append(results, unhealthy("Trust anchor", c.Name, "trust roots not found", remediation))
+			continue
+		}
+		bundle := configMapInterface.(*corev1.ConfigMap).Data[trustRootsKey]
+		bundles[c.Name] = bundle
+
+		roots, err := cert.ParseCertificates([]byte(bundle))
+		if err != nil {
+			results = append(results, unhealthy("Trust anchor", c.Name, err.Error(), remediation))
+			continue
+		}
+		results = append(results, checkIssuer(c, roots))
+	}
+
+	if len(bundles) == 2 && bundles[clusters.Origin.Name] != bundles[clusters.Target.Name] {
+		return append(results, unhealthy("Trust anchor", "both", "clusters use different trust anchors", remediation))
+	}
+	return append(results, healthy("Trust anchor", "both", "clusters share the trust anchor"))
+}
+
+// checkIssuer verifies the identity issuer is valid and chains up to the trust anchors
+func checkIssuer(c kube.Cluster, roots []*x509.Certificate) Result {
+	remediation := "Issue a new identity issuer certificate signed by the shared trust anchor"
+	for _, root := range roots {
+		if time.Now().After(root.NotAfter) {
+			return unhealthy("Identity issuer", c.Name, fmt.Sprintf("trust anchor expired at %s", root.NotAfter), "Rotate the trust anchor")
+		}
+	}
+
+	secretInterface, err := c.FetchResource(kube.Secret, issuerSecret, constants.LinkerdNamespace)
+	if err != nil {
+		return unhealthy("Identity issuer", c.Name, "issuer secret not found", remediation)
+	}
+	secret := secretInterface.(*corev1.Secret)
+	certificate := secret.Data["crt.pem"]
+	if len(certificate) == 0 {
+		certificate = secret.Data[corev1.TLSCertKey]
+	}
+	issuers, err := cert.ParseCertificates(certificate)
+	if err != nil {
+		return unhealthy("Identity issuer", c.Name, err.Error(), remediation)
+	}
+
+	pool := x509.NewCertPool()
+	for _, root := range roots {
+		pool.AddCert(root)
+	}
+	if _, err := issuers[0].Verify(x509.VerifyOptions{Roots: pool, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}); err != nil {
+		return unhealthy("Identity issuer", c.Name, fmt.Sprintf("issuer is not valid for the trust anchor: %v", err), remediation)
+	}
+	if time.Until(issuers[0].NotAfter) < issuerRenewWithin {
+		return unhealthy("Identity issuer", c.Name, fmt.Sprintf("issuer expires at %s", issuers[0].NotAfter.Format(time.DateOnly)),
+			"Rotate the issuer with clustershift certs rotate")
+	}
+	return healthy("Identity issuer", c.Name, fmt.Sprintf("issuer valid until %s", issuers[0].NotAfter.Format(time.DateOnly)))
+}
+
+// HasReadyEndpoints reports whether the service has at least one ready endpoint
+func HasReadyEndpoints(c kube.Cluster, namespace, name string) (bool, error) {
+	endpoints, err := c.Clientset.CoreV1().Endpoints(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return false, err
+	}
+	for _, subset := range endpoints.Subsets {
+		if len(subset.Addresses) > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
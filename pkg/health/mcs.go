@@ -0,0 +1,44 @@
+package health
+
+import (
+	"clustershift/internal/kube"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func checkMCS(clusters kube.Clusters) []Result {
+	return forEachCluster(clusters, checkServiceExports)
+}
+
+// checkServiceExports verifies that the Multi-Cluster Services API is served and that no export is
+// invalid or conflicts with the export of the same service in another cluster
+func checkServiceExports(c kube.Cluster) []Result {
+	exports, err := c.ListCustomResources(schema.GroupKind{Group: "multicluster.x-k8s.io", Kind: "ServiceExport"}, "")
+	if err != nil {
+		return []Result{unhealthy("Multi-Cluster Services API", c.Name, err.Error(),
+			"Install an implementation of the Multi-Cluster Services API and join the cluster to the clusterset")}
+	}
+	results := []Result{healthy("Multi-Cluster Services API", c.Name, "ServiceExports are served")}
+
+	for _, export := range exports {
+		name, _, _ := unstructured.NestedString(export, "metadata", "name")
+		namespace, _, _ := unstructured.NestedString(export, "metadata", "namespace")
+		conditions, _, _ := unstructured.NestedSlice(export, "status", "conditions")
+		for _, condition := range conditions {
+			conditionMap, ok := condition.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			invalid := conditionMap["type"] == "Valid" && conditionMap["status"] == "False"
+			conflict := conditionMap["type"] == "Conflict" && conditionMap["status"] == "True"
+			if invalid || conflict {
+				results = append(results, unhealthy("Service export", c.Name,
+					fmt.Sprintf("%s/%s: %v", namespace, name, conditionMap["message"]),
+					"Check the ServiceExport conditions, exports of the same name must have compatible ports and types"))
+			}
+		}
+	}
+	return results
+}
@@ -0,0 +1,107 @@
+package health
+
+import (
+	"clustershift/internal/constants"
+	"clustershift/internal/kube"
+	"clustershift/pkg/skupper"
+	"context"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const skupperSiteController = "skupper-site-controller"
+
+func checkSkupper(clusters kube.Clusters) []Result {
+	if skupper.DetectVersion(clusters.Origin, clusters.Target) == skupper.V2 {
+		return forEachCluster(clusters, checkSkupperV2)
+	}
+	return forEachCluster(clusters, checkSkupperSites)
+}
+
+// checkSkupperSites verifies the site controller, every site created in the cluster and its tokens and links
+func checkSkupperSites(c kube.Cluster) []Result {
+	results := []Result{checkDeployment(c, "Site controller", skupperSiteController, skupperSiteController,
+		"Check the site controller logs, it creates the sites from the skupper-site ConfigMaps")}
+
+	configMaps, err := c.Clientset.CoreV1().ConfigMaps("").List(context.TODO(), metav1.ListOptions{
+		FieldSelector: "metadata.name=skupper-site",
+	})
+	if err != nil {
+		return append(results, unhealthy("Site", c.Name, err.Error(), ""))
+	}
+	if len(configMaps.Items) == 0 {
+		return append(results, healthy("Site", c.Name, "no sites created yet"))
+	}
+
+	for _, configMap := range configMaps.Items {
+		namespace := configMap.Namespace
+		remediation := fmt.Sprintf("Check the skupper pods in namespace %s, delete the skupper-site ConfigMap to recreate the site", namespace)
+		results = append(results,
+			checkDeployment(c, "Site router", namespace, "skupper-router", remediation),
+			checkDeployment(c, "Site service controller", namespace, "skupper-service-controller", remediation),
+			checkSkupperTokens(c, namespace),
+		)
+	}
+	return results
+}
+
+// checkSkupperTokens verifies the requested tokens were issued and that the site holds tokens linking it to its peer
+func checkSkupperTokens(c kube.Cluster, namespace string) Result {
+	secrets := c.Clientset.CoreV1().Secrets(namespace)
+	requests, err := secrets.List(context.TODO(), metav1.ListOptions{LabelSelector: "skupper.io/type=connection-token-request"})
+	if err != nil {
+		return unhealthy("Site link", c.Name, err.Error(), "")
+	}
+	for _, request := range requests.Items {
+		if len(request.Data) == 0 {
+			return unhealthy("Site link", c.Name, fmt.Sprintf("token %s/%s was not issued", namespace, request.Name),
+				"Check the service controller logs, the token request secret is populated once the site is ready")
+		}
+	}
+
+	tokens, err := secrets.List(context.TODO(), metav1.ListOptions{LabelSelector: "skupper.io/type=connection-token"})
+	if err != nil {
+		return unhealthy("Site link", c.Name, err.Error(), "")
+	}
+	if len(tokens.Items)+len(requests.Items) < 2 {
+		return unhealthy("Site link", c.Name, fmt.Sprintf("site in namespace %s is not linked to its peer", namespace),
+			"Copy the token issued by the peer site into the namespace to link the sites")
+	}
+	return healthy("Site link", c.Name, fmt.Sprintf("site in namespace %s has %d tokens", namespace, len(tokens.Items)+len(requests.Items)))
+}
+
+// checkSkupperV2 verifies the Skupper 2 controller and that all sites and links report ready
+func checkSkupperV2(c kube.Cluster) []Result {
+	results := []Result{checkDeployment(c, "Controller", constants.SkupperNamespace, "skupper-controller",
+		"Check the skupper-controller logs in the skupper namespace")}
+
+	for _, kind := range []struct {
+		kind        string
+		remediation string
+	}{
+		{"Site", "Check the router pods and events of the site namespace"},
+		{"Link", "Check that the access token was redeemed and that the linked site is reachable on its link port"},
+	} {
+		objects, err := c.ListCustomResources(schema.GroupKind{Group: "skupper.io", Kind: kind.kind}, "")
+		if err != nil {
+			results = append(results, unhealthy(kind.kind, c.Name, err.Error(), "Install the Skupper controller"))
+			continue
+		}
+		var notReady []string
+		for _, object := range objects {
+			if !skupper.IsReady(object) {
+				namespace, _, _ := unstructured.NestedString(object, "metadata", "namespace")
+				notReady = append(notReady, namespace+"/"+nestedName(object))
+			}
+		}
+		if len(notReady) > 0 {
+			results = append(results, unhealthy(kind.kind, c.Name, fmt.Sprintf("not ready: %v", notReady), kind.remediation))
+			continue
+		}
+		results = append(results, healthy(kind.kind, c.Name, fmt.Sprintf("%d ready", len(objects))))
+	}
+	return results
+}
@@ -0,0 +1,181 @@
+package health
+
+import (
+	"bytes"
+	"clustershift/internal/constants"
+	"clustershift/internal/kube"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	lighthouseCoreDNS = "submariner-lighthouse-coredns"
+	resolvePodName    = "clustershift-resolve"
+	resolveNamespace  = "clustershift"
+)
+
+func checkSubmariner(clusters kube.Clusters) []Result {
+	var results []Result
+	drivers := make(map[string]string)
+
+	results = append(results, forEachCluster(clusters, func(c kube.Cluster) []Result {
+		gatewayResults, driver := checkSubmarinerGateways(c)
+		drivers[c.Name] = driver
+		return gatewayResults
+	})...)
+
+	if origin, target := drivers[clusters.Origin.Name], drivers[clusters.Target.Name]; origin != "" && target != "" && origin != target {
+		results = append(results, unhealthy("Cable driver", "both",
+			fmt.Sprintf("origin uses %s, target uses %s", origin, target),
+			"Rejoin both clusters with the same cable driver"))
+	}
+
+	results = append(results, forEachCluster(clusters, checkLighthouse)...)
+	return results
+}
+
+// checkSubmarinerGateways verifies an active gateway exists and all its connections are established.
+// It returns the cable driver used by the active gateway.
+func checkSubmarinerGateways(c kube.Cluster) ([]Result, string) {
+	gateways, err := c.ListCustomResources(schema.GroupKind{Group: "submariner.io", Kind: "Gateway"}, constants.SubmarinerOperatorNamespace)
+	if err != nil {
+		return []Result{unhealthy("Gateway", c.Name, err.Error(), "Check that the Submariner operator is installed and the cluster joined the broker")}, ""
+	}
+
+	var active map[string]interface{}
+	for _, gateway := range gateways {
+		if status, _, _ := unstructured.NestedString(gateway, "status", "haStatus"); status == "active" {
+			active = gateway
+			break
+		}
+	}
+	if active == nil {
+		return []Result{unhealthy("Gateway", c.Name, fmt.Sprintf("no active gateway among %d gateways", len(gateways)),
+			"Label a node with submariner.io/gateway=true and check the submariner-gateway pod logs")}, ""
+	}
+
+	driver, _, _ := unstructured.NestedString(active, "status", "localEndpoint", "backend")
+	results := []Result{healthy("Gateway", c.Name, fmt.Sprintf("active gateway %s with cable driver %s", nestedName(active), driver))}
+
+	connections, _, _ := unstructured.NestedSlice(active, "status", "connections")
+	if len(connections) == 0 {
+		results = append(results, unhealthy("Connection", c.Name, "gateway has no connections to other clusters",
+			"Check that both clusters joined the same broker and that UDP 4500 and 4490 are open between the gateway nodes"))
+	}
+	for _, connection := range connections {
+		connectionMap, ok := connection.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		status, _, _ := unstructured.NestedString(connectionMap, "status")
+		remote, _, _ := unstructured.NestedString(connectionMap, "endpoint", "cluster_id")
+		message, _, _ := unstructured.NestedString(connectionMap, "statusMessage")
+		if status != "connected" {
+			results = append(results, unhealthy("Connection", c.Name, fmt.Sprintf("connection to %s is %s: %s", remote, status, message),
+				"Check that the NAT-T port UDP 4500 is reachable between the gateway nodes and that the cluster CIDRs do not overlap"))
+			continue
+		}
+		results = append(results, healthy("Connection", c.Name, fmt.Sprintf("connected to %s", remote)))
+	}
+	return results, driver
+}
+
+// checkLighthouse verifies the Lighthouse DNS server and resolves an exported service
+func checkLighthouse(c kube.Cluster) []Result {
+	remediation := "Check the submariner-lighthouse-coredns pods and that the cluster DNS forwards clusterset.local to them"
+	results := []Result{checkDeployment(c, "Lighthouse DNS", constants.SubmarinerOperatorNamespace, lighthouseCoreDNS, remediation)}
+
+	configMap, err := c.Clientset.CoreV1().ConfigMaps("kube-system").Get(context.TODO(), "coredns", metav1.GetOptions{})
+	if err == nil && !strings.Contains(configMap.Data["Corefile"], "clusterset.local") {
+		results = append(results, unhealthy("Lighthouse DNS", c.Name, "cluster DNS does not forward clusterset.local",
+			"Add a clusterset.local server block forwarding to the submariner-lighthouse-coredns service to the CoreDNS Corefile"))
+	}
+
+	imports, err := c.ListCustomResources(schema.GroupKind{Group: "multicluster.x-k8s.io", Kind: "ServiceImport"}, "")
+	if err != nil || len(imports) == 0 {
+		return append(results, healthy("Lighthouse resolution", c.Name, "no imported services to resolve yet"))
+	}
+	name, _, _ := unstructured.NestedString(imports[0], "metadata", "name")
+	namespace, _, _ := unstructured.NestedString(imports[0], "metadata", "namespace")
+	host := fmt.Sprintf("%s.%s.svc.clusterset.local", name, namespace)
+
+	if _, err := Resolve(c, host); err != nil {
+		return append(results, unhealthy("Lighthouse resolution", c.Name, fmt.Sprintf("%s does not resolve: %v", host, err), remediation))
+	}
+	return append(results, healthy("Lighthouse resolution", c.Name, host+" resolves"))
+}
+
+// Resolve returns the addresses of a host name resolved with the cluster DNS by exec into the resolve
+// pod, which is created on the first lookup and kept for the following lookups until Cleanup
+func Resolve(c kube.Cluster, host string) ([]string, error) {
+	if err := ensureResolvePod(c); err != nil {
+		return nil, err
+	}
+	var stdout, stderr bytes.Buffer
+	command := []string{"/clustershift", "probe", "resolve", "--host", host}
+	if err := c.ExecIntoPod(resolveNamespace, resolvePodName, "resolve", command, &stdout, &stderr); err != nil {
+		return nil, fmt.Errorf("lookup failed: %s", strings.TrimSpace(stderr.String()))
+	}
+	return strings.Fields(stdout.String()), nil
+}
+
+// ensureResolvePod starts the resolve pod unless it is running already
+func ensureResolvePod(c kube.Cluster) error {
+	pods := c.Clientset.CoreV1().Pods(resolveNamespace)
+	current, err := pods.Get(context.TODO(), resolvePodName, metav1.GetOptions{})
+	if err == nil && current.Status.Phase == corev1.PodRunning {
+		return nil
+	}
+	if err == nil {
+		// A failed or pending leftover is replaced
+		deleteResolvePod(c)
+	} else if !k8serrors.IsNotFound(err) {
+		return err
+	}
+
+	c.CreateNewNamespace(resolveNamespace)
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: resolvePodName, Namespace: resolveNamespace},
+		Spec: corev1.PodSpec{
+			RestartPolicy: corev1.RestartPolicyNever,
+			Containers: []corev1.Container{{
+				Name:  "resolve",
+				Image: constants.ClustershiftImage,
+				Args:  []string{"probe", "resolve", "--idle"},
+			}},
+		},
+	}
+	if _, err := pods.Create(context.TODO(), pod, metav1.CreateOptions{}); err != nil {
+		return err
+	}
+	if err := kube.WaitForPodReadyByName(c, resolvePodName, resolveNamespace, 90*time.Second); err != nil {
+		return fmt.Errorf("resolve pod did not start: %w", err)
+	}
+	return nil
+}
+
+// deleteResolvePod removes the resolve pod and waits until it is gone
+func deleteResolvePod(c kube.Cluster) {
+	pods := c.Clientset.CoreV1().Pods(resolveNamespace)
+	if err := pods.Delete(context.TODO(), resolvePodName, metav1.DeleteOptions{}); k8serrors.IsNotFound(err) {
+		return
+	}
+	_ = wait.PollUntilContextTimeout(context.TODO(), time.Second, 30*time.Second, true, func(ctx context.Context) (bool, error) {
+		_, err := pods.Get(ctx, resolvePodName, metav1.GetOptions{})
+		return k8serrors.IsNotFound(err), nil
+	})
+}
+
+func nestedName(obj map[string]interface{}) string {
+	name, _, _ := unstructured.NestedString(obj, "metadata", "name")
+	return name
+}
@@ -0,0 +1,121 @@
+package istio
+
+import (
+	"clustershift/internal/constants"
+	"clustershift/internal/kube"
+	"clustershift/internal/logger"
+	"clustershift/pkg/linkerd"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+const (
+	exportToAnnotation = "networking.istio.io/exportTo"
+	injectionLabel     = "istio-injection"
+)
+
+// exportedToMesh makes the alias services visible in all namespaces of the mesh
+var exportedToMesh = map[string]string{exportToAnnotation: "*"}
+
+// systemNamespaces never get sidecars, restarting their pods would disrupt the cluster and the mesh itself
+var systemNamespaces = map[string]bool{
+	"kube-system":            true,
+	"kube-public":            true,
+	"kube-node-lease":        true,
+	constants.IstioNamespace: true,
+}
+
+// injectedNamespaces tracks the namespaces already rolled out with sidecars, keyed by cluster/namespace
+var injectedNamespaces = make(map[string]bool)
+
+// ExportService makes a service reachable from the peer cluster as <service>-<cluster>. The alias
+// selects the pods of the service and is exported to the whole mesh. The peer gets the alias without
+// selector, which provides the address while Istio routes to the endpoints in the exporting cluster.
+// Headless services get an alias listing the pod IPs in both clusters, these per-pod addresses are
+// only reachable if the clusters share a network.
+// No ServiceEntry is created: the remote secrets let istiod of both clusters discover the alias endpoints
+// of the exporting cluster, a ServiceEntry is only needed for endpoints outside the service registries.
+func ExportService(c kube.Cluster, namespace, name string) error {
+	logger.Info(fmt.Sprintf("Exporting service %s/%s of %s cluster", namespace, name, c.Name))
+
+	peer, err := kube.Peer(c)
+	if err != nil {
+		return err
+	}
+
+	serviceInterface, err := c.FetchResource(kube.Service, name, namespace)
+	if err != nil {
+		return fmt.Errorf("failed to fetch service %s/%s: %w", namespace, name, err)
+	}
+	service := serviceInterface.(*v1.Service)
+
+	if err := EnableInjection(c, namespace); err != nil {
+		return fmt.Errorf("failed to enable sidecar injection in namespace %s: %w", namespace, err)
+	}
+
+	alias := name + "-" + c.Name
+	peer.CreateNewNamespace(namespace)
+	if service.Spec.ClusterIP == v1.ClusterIPNone {
+		for _, cluster := range []kube.Cluster{c, peer} {
+			if err := kube.MirrorHeadlessService(c, cluster, service, alias, nil); err != nil {
+				return fmt.Errorf("failed to mirror endpoints of service %s/%s to %s cluster: %w", namespace, name, cluster.Name, err)
+			}
+		}
+		return nil
+	}
+	if err := createService(c, kube.AliasService(service, alias, service.Spec.Selector, exportedToMesh)); err != nil {
+		return err
+	}
+	return createService(peer, kube.AliasService(service, alias, nil, exportedToMesh))
+}
+
+// EnableInjection labels the namespace for sidecar injection and restarts its workloads once.
+// System namespaces are skipped.
+func EnableInjection(c kube.Cluster, namespace string) error {
+	if systemNamespaces[namespace] {
+		logger.Warning(fmt.Sprintf("Skipping sidecar injection in namespace %s of %s cluster", namespace, c.Name),
+			fmt.Errorf("system namespaces are not part of the migration"))
+		return nil
+	}
+	key := c.Name + "/" + namespace
+	if injectedNamespaces[key] {
+		return nil
+	}
+	err := c.AddLabel(kube.Namespace, namespace, "", map[string]string{injectionLabel: "enabled"})
+	if err != nil {
+		return err
+	}
+	if err := linkerd.RerollPodsInNamespace(c, namespace); err != nil {
+		return err
+	}
+	injectedNamespaces[key] = true
+	return nil
+}
+
+func createService(c kube.Cluster, service *v1.Service) error {
+	err := c.CreateResource(kube.Service, service.Namespace, service)
+	if k8serrors.IsAlreadyExists(err) {
+		logger.Debug(fmt.Sprintf("Service %s/%s already exists in %s cluster", service.Namespace, service.Name, c.Name))
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create service %s/%s: %w", service.Namespace, service.Name, err)
+	}
+	return nil
+}
+
+// UnexportService deletes the aliases of the service in both clusters
+func UnexportService(c kube.Cluster, namespace, name string) error {
+	peer, err := kube.Peer(c)
+	if err != nil {
+		return err
+	}
+	for _, cluster := range []kube.Cluster{c, peer} {
+		if err := kube.DeleteAlias(cluster, name+"-"+c.Name, namespace); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package istio
+
+import (
+	"clustershift/internal/constants"
+	"clustershift/internal/exit"
+	"clustershift/internal/kube"
+	"clustershift/internal/logger"
+	"context"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	apiVersion   = "networking.istio.io/v1beta1"
+	networkLabel = "topology.istio.io/network"
+	clusterLabel = "topology.istio.io/cluster"
+
+	crossNetworkPort = 15443
+	istiodPort       = 15012
+	webhookPort      = 15017
+)
+
+// installEastWestGateway deploys the gateway carrying the traffic between the networks of the clusters
+// and exposes the services of the cluster through it
+func installEastWestGateway(c kube.Cluster) {
+	logger.Info(fmt.Sprintf("Installing Istio east-west gateway on %s cluster", c.Name))
+
+	network := Network(c)
+	gatewayNodes, err := c.LabelGatewayNodes(kube.GatewayNodes, nil)
+	exit.OnErrorWithMessage(err, "Failed to select gateway nodes")
+
+	values := map[string]interface{}{
+		"name":           constants.IstioEastWestGatewayName,
+		"networkGateway": network,
+		"labels": map[string]string{
+			"istio":      "eastwestgateway",
+			"app":        constants.IstioEastWestGatewayName,
+			networkLabel: network,
+		},
+		"env":          map[string]string{"ISTIO_META_REQUESTED_NETWORK_VIEW": network},
+		"replicaCount": len(gatewayNodes),
+		"autoscaling":  map[string]interface{}{"enabled": false},
+		"nodeSelector": map[string]string{kube.GatewayNodeLabel: "true"},
+		"tolerations":  gatewayTolerations(),
+		"service": map[string]interface{}{
+			"ports": []map[string]interface{}{
+				{"name": "status-port", "port": 15021, "targetPort": 15021},
+				{"name": "tls", "port": crossNetworkPort, "targetPort": crossNetworkPort},
+				{"name": "tls-istiod", "port": istiodPort, "targetPort": istiodPort},
+				{"name": "tls-webhook", "port": webhookPort, "targetPort": webhookPort},
+			},
+		},
+	}
+	deployChart(c, constants.IstioGatewayChartName, constants.IstioEastWestGatewayName, values)
+
+	applyCR(c, constants.IstioNamespace, map[string]interface{}{
+		"apiVersion": apiVersion,
+		"kind":       "Gateway",
+		"metadata":   map[string]interface{}{"name": "cross-network-gateway", "namespace": constants.IstioNamespace},
+		"spec": map[string]interface{}{
+			"selector": map[string]interface{}{"istio": "eastwestgateway"},
+			"servers": []interface{}{
+				map[string]interface{}{
+					"port":  map[string]interface{}{"number": int64(crossNetworkPort), "name": "tls", "protocol": "TLS"},
+					"tls":   map[string]interface{}{"mode": "AUTO_PASSTHROUGH"},
+					"hosts": []interface{}{"*.local"},
+				},
+			},
+		},
+	})
+}
+
+// exposeIstiod lets remote clusters reach the control plane through the east-west gateway
+func exposeIstiod(c kube.Cluster) {
+	logger.Info("Exposing istiod through the east-west gateway")
+
+	istiod := "istiod." + constants.IstioNamespace + ".svc.cluster.local"
+	servers := []interface{}{}
+	routes := []interface{}{}
+	for _, ports := range [][2]int64{{istiodPort, istiodPort}, {webhookPort, 443}} {
+		port, targetPort := ports[0], ports[1]
+		servers = append(servers, map[string]interface{}{
+			"port":  map[string]interface{}{"number": port, "name": fmt.Sprintf("tls-istiod-%d", port), "protocol": "TLS"},
+			"tls":   map[string]interface{}{"mode": "PASSTHROUGH"},
+			"hosts": []interface{}{"*"},
+		})
+		routes = append(routes, map[string]interface{}{
+			"match": []interface{}{map[string]interface{}{"port": port, "sniHosts": []interface{}{"*"}}},
+			"route": []interface{}{map[string]interface{}{
+				"destination": map[string]interface{}{"host": istiod, "port": map[string]interface{}{"number": targetPort}},
+			}},
+		})
+	}
+
+	applyCR(c, constants.IstioNamespace, map[string]interface{}{
+		"apiVersion": apiVersion,
+		"kind":       "Gateway",
+		"metadata":   map[string]interface{}{"name": "istiod-gateway", "namespace": constants.IstioNamespace},
+		"spec": map[string]interface{}{
+			"selector": map[string]interface{}{"istio": "eastwestgateway"},
+			"servers":  servers,
+		},
+	})
+	applyCR(c, constants.IstioNamespace, map[string]interface{}{
+		"apiVersion": apiVersion,
+		"kind":       "VirtualService",
+		"metadata":   map[string]interface{}{"name": "istiod-vs", "namespace": constants.IstioNamespace},
+		"spec": map[string]interface{}{
+			"hosts":    []interface{}{"*"},
+			"gateways": []interface{}{"istiod-gateway"},
+			"tls":      routes,
+		},
+	})
+}
+
+// gatewayAddress waits for the load balancer address of the east-west gateway
+func gatewayAddress(c kube.Cluster) (string, error) {
+	var address string
+	err := wait.PollUntilContextTimeout(context.TODO(), 5*time.Second, 3*time.Minute, true, func(ctx context.Context) (bool, error) {
+		serviceInterface, err := c.FetchResource(kube.Service, constants.IstioEastWestGatewayName, constants.IstioNamespace)
+		if err != nil {
+			return false, nil
+		}
+		for _, ingress := range serviceInterface.(*v1.Service).Status.LoadBalancer.Ingress {
+			address = ingress.IP
+			if address == "" {
+				address = ingress.Hostname
+			}
+			if address != "" {
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("east-west gateway of %s cluster has no load balancer address: %w", c.Name, err)
+	}
+	return address, nil
+}
+
+// gatewayTolerations converts the gateway tolerations to chart values
+func gatewayTolerations() []map[string]string {
+	var tolerations []map[string]string
+	for _, toleration := range kube.GatewayTolerations() {
+		tolerations = append(tolerations, map[string]string{
+			"key":      toleration.Key,
+			"operator": string(toleration.Operator),
+			"effect":   string(toleration.Effect),
+		})
+	}
+	return tolerations
+}
+
+// applyCR creates or updates an Istio CR
+func applyCR(c kube.Cluster, namespace string, resource map[string]interface{}) {
+	err := c.PatchCustomResource(namespace, resource)
+	exit.OnErrorWithMessage(err, fmt.Sprintf("Failed to apply %s", resource["kind"]))
+}
@@ -0,0 +1,157 @@
+package istio
+
+import (
+	"clustershift/internal/constants"
+	"clustershift/internal/exit"
+	"clustershift/internal/helm"
+	"clustershift/internal/kube"
+	"clustershift/internal/logger"
+	"clustershift/internal/prompt"
+	"fmt"
+	"time"
+
+	"gopkg.in/yaml.v2"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+)
+
+// Mode is the Istio deployment model, set from the migration options
+var Mode = prompt.IstioMultiPrimary
+
+// Install connects the clusters to a single Istio mesh. Clusters already running Istio keep their
+// control plane, the other clusters get one installed. In the primary-remote model the origin cluster
+// runs the control plane for both clusters.
+func Install(c kube.Clusters) {
+	logger.Info(fmt.Sprintf("Setting up Istio %s mesh", Mode))
+
+	establishTrust(c)
+
+	installControlPlane(c.Origin, "")
+	installEastWestGateway(c.Origin)
+
+	if Mode == prompt.IstioPrimaryRemote {
+		exposeIstiod(c.Origin)
+		pilotAddress, err := gatewayAddress(c.Origin)
+		exit.OnErrorWithMessage(err, "Failed to get the address of the east-west gateway")
+
+		installControlPlane(c.Target, pilotAddress)
+		CreateRemoteSecret(c.Target, c.Origin)
+		installEastWestGateway(c.Target)
+		return
+	}
+
+	installControlPlane(c.Target, "")
+	installEastWestGateway(c.Target)
+	CreateRemoteSecret(c.Origin, c.Target)
+	CreateRemoteSecret(c.Target, c.Origin)
+}
+
+// ValidateMode checks the deployment model of the migration options
+func ValidateMode(mode string) error {
+	switch mode {
+	case prompt.IstioMultiPrimary, prompt.IstioPrimaryRemote:
+		return nil
+	}
+	return fmt.Errorf("unknown Istio mode %q, use %s or %s", mode, prompt.IstioMultiPrimary, prompt.IstioPrimaryRemote)
+}
+
+// Installed reports whether the cluster runs an Istio control plane
+func Installed(c kube.Cluster) bool {
+	_, err := c.FetchResource(kube.Deployment, "istiod", constants.IstioNamespace)
+	return err == nil
+}
+
+// ClusterID returns the name Istio uses for the cluster, the CLUSTER_ID of an installed control plane
+func ClusterID(c kube.Cluster) string {
+	deploymentInterface, err := c.FetchResource(kube.Deployment, "istiod", constants.IstioNamespace)
+	if err != nil {
+		return c.Name
+	}
+	for _, container := range deploymentInterface.(*appsv1.Deployment).Spec.Template.Spec.Containers {
+		for _, env := range container.Env {
+			if env.Name == "CLUSTER_ID" && env.Value != "" {
+				return env.Value
+			}
+		}
+	}
+	return c.Name
+}
+
+// Network returns the network of the cluster, the network label of the Istio namespace if it is set
+func Network(c kube.Cluster) string {
+	namespaceInterface, err := c.FetchResource(kube.Namespace, constants.IstioNamespace, "")
+	if err == nil {
+		if network := namespaceInterface.(*v1.Namespace).Labels[networkLabel]; network != "" {
+			return network
+		}
+	}
+	return "network-" + c.Name
+}
+
+// installControlPlane installs istiod, or the remote profile pointing to the control plane
+// at remotePilotAddress if it is set
+func installControlPlane(c kube.Cluster, remotePilotAddress string) {
+	network := Network(c)
+	c.CreateNewNamespace(constants.IstioNamespace)
+	err := c.AddLabel(kube.Namespace, constants.IstioNamespace, "", map[string]string{networkLabel: network})
+	exit.OnErrorWithMessage(err, "Failed to label Istio namespace with its network")
+
+	if Installed(c) {
+		logger.Info(fmt.Sprintf("Reusing the Istio control plane of %s cluster", c.Name))
+		return
+	}
+
+	logger.Info(fmt.Sprintf("Installing Istio on %s cluster", c.Name))
+	clusterID := ClusterID(c)
+	global := map[string]interface{}{
+		"meshID":       constants.IstioMeshID,
+		"multiCluster": map[string]interface{}{"clusterName": clusterID},
+		"network":      network,
+	}
+	baseValues := map[string]interface{}{}
+	istiodValues := map[string]interface{}{"global": global}
+
+	if remotePilotAddress != "" {
+		baseValues["profile"] = "remote"
+		istiodValues["profile"] = "remote"
+		istiodValues["istiodRemote"] = map[string]interface{}{
+			"injectionPath": fmt.Sprintf("/inject/cluster/%s/net/%s", clusterID, network),
+		}
+		global["remotePilotAddress"] = remotePilotAddress
+	}
+
+	deployChart(c, constants.IstioBaseChartName, "istio-base", baseValues)
+	deployChart(c, constants.IstiodChartName, "istiod", istiodValues)
+
+	if remotePilotAddress == "" {
+		err = kube.WaitForPodsReadyByLabel(c, "app=istiod", constants.IstioNamespace, 3*time.Minute)
+		exit.OnErrorWithMessage(err, "Failed to wait for istiod to be ready")
+	}
+}
+
+func deployChart(c kube.Cluster, chartName, releaseName string, values map[string]interface{}) {
+	valuesYaml, err := yaml.Marshal(values)
+	exit.OnErrorWithMessage(err, "Failed to marshal Istio chart values")
+
+	helmOptions := helm.HelmClientOptions{
+		KubeConfigPath: c.ClusterOptions.KubeconfigPath,
+		Context:        c.ClusterOptions.Context,
+		Namespace:      constants.IstioNamespace,
+		Debug:          constants.Debug,
+	}
+
+	helmClient := helm.GetHelmClient(helmOptions)
+
+	chartOptions := helm.ChartOptions{
+		RepoName:    constants.IstioRepoName,
+		RepoURL:     constants.IstioRepoURL,
+		Namespace:   constants.IstioNamespace,
+		ReleaseName: releaseName,
+		ChartName:   chartName,
+		Values:      string(valuesYaml),
+		Version:     constants.IstioVersion,
+		Wait:        true,
+	}
+
+	helm.HelmAddandInstallChart(helmClient, chartOptions)
+}
@@ -0,0 +1,94 @@
+package istio
+
+import (
+	"clustershift/internal/constants"
+	"clustershift/internal/exit"
+	"clustershift/internal/kube"
+	"clustershift/internal/logger"
+	"context"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/clientcmd/api"
+)
+
+const (
+	readerServiceAccount = "istio-reader-service-account"
+	readerTokenSecret    = "istio-reader-service-account-token"
+	multiClusterLabel    = "istio/multiCluster"
+	clusterAnnotation    = "networking.istio.io/cluster"
+)
+
+// CreateRemoteSecret gives the control plane of `to` read access to the API server of `from`,
+// which lets it discover the endpoints of `from`
+func CreateRemoteSecret(from, to kube.Cluster) {
+	logger.Info(fmt.Sprintf("Creating remote secret of %s cluster in %s cluster", from.Name, to.Name))
+
+	token, ca, err := readerToken(from)
+	exit.OnErrorWithMessage(err, "Failed to get the token of the Istio reader service account")
+
+	endpoint, err := from.FetchKubernetesAPIEndpoint()
+	exit.OnErrorWithMessage(err, "Error fetching Kubernetes API endpoint")
+
+	clusterID := ClusterID(from)
+	kubeconfig, err := clientcmd.Write(api.Config{
+		Clusters:       map[string]*api.Cluster{clusterID: {Server: "https://" + endpoint, CertificateAuthorityData: ca}},
+		AuthInfos:      map[string]*api.AuthInfo{clusterID: {Token: token}},
+		Contexts:       map[string]*api.Context{clusterID: {Cluster: clusterID, AuthInfo: clusterID}},
+		CurrentContext: clusterID,
+	})
+	exit.OnErrorWithMessage(err, "Error generating kubeconfig")
+
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "istio-remote-secret-" + clusterID,
+			Namespace:   constants.IstioNamespace,
+			Labels:      map[string]string{multiClusterLabel: "true"},
+			Annotations: map[string]string{clusterAnnotation: clusterID},
+		},
+		Data: map[string][]byte{clusterID: kubeconfig},
+	}
+	err = to.CreateResource(kube.Secret, constants.IstioNamespace, secret)
+	if k8serrors.IsAlreadyExists(err) {
+		err = to.UpdateResource(kube.Secret, secret.Name, constants.IstioNamespace, secret)
+	}
+	exit.OnErrorWithMessage(err, "Failed to create Istio remote secret")
+}
+
+// readerToken returns a long-lived token of the Istio reader service account and the CA of the API server
+func readerToken(c kube.Cluster) (string, []byte, error) {
+	if _, err := c.FetchResource(kube.ServiceAccount, readerServiceAccount, constants.IstioNamespace); err != nil {
+		return "", nil, fmt.Errorf("service account %s not found in %s cluster: %w", readerServiceAccount, c.Name, err)
+	}
+
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        readerTokenSecret,
+			Namespace:   constants.IstioNamespace,
+			Annotations: map[string]string{v1.ServiceAccountNameKey: readerServiceAccount},
+		},
+		Type: v1.SecretTypeServiceAccountToken,
+	}
+	err := c.CreateResource(kube.Secret, constants.IstioNamespace, secret)
+	if err != nil && !k8serrors.IsAlreadyExists(err) {
+		return "", nil, err
+	}
+
+	var token string
+	var ca []byte
+	err = wait.PollUntilContextTimeout(context.TODO(), 2*time.Second, time.Minute, true, func(ctx context.Context) (bool, error) {
+		current, err := c.Clientset.CoreV1().Secrets(constants.IstioNamespace).Get(ctx, readerTokenSecret, metav1.GetOptions{})
+		if err != nil {
+			return false, nil
+		}
+		token = string(current.Data[v1.ServiceAccountTokenKey])
+		ca = current.Data[v1.ServiceAccountRootCAKey]
+		return token != "", nil
+	})
+	return token, ca, err
+}
@@ -0,0 +1,74 @@
+package istio
+
+import (
+	"clustershift/internal/kube"
+	"clustershift/internal/prompt"
+	"fmt"
+)
+
+// ShiftTraffic splits the requests to a service between its endpoints in both clusters. The weight
+// is the percentage sent to the target cluster, the rest stays in the origin cluster. The split
+// applies to clients with a sidecar, the subsets select the endpoints by the cluster Istio discovered
+// them in.
+func ShiftTraffic(c kube.Clusters, namespace, name string, targetWeight int) error {
+	if targetWeight < 0 || targetWeight > 100 {
+		return fmt.Errorf("weight %d is not a percentage", targetWeight)
+	}
+
+	host := fmt.Sprintf("%s.%s.svc.cluster.local", name, namespace)
+	ruleName := name + "-clustershift"
+	destinationRule := map[string]interface{}{
+		"apiVersion": apiVersion,
+		"kind":       "DestinationRule",
+		"metadata":   map[string]interface{}{"name": ruleName, "namespace": namespace},
+		"spec": map[string]interface{}{
+			"host": host,
+			"subsets": []interface{}{
+				subset("origin", ClusterID(c.Origin)),
+				subset("target", ClusterID(c.Target)),
+			},
+		},
+	}
+
+	routes := []interface{}{
+		map[string]interface{}{
+			"destination": map[string]interface{}{"host": host, "subset": "origin"},
+			"weight":      int64(100 - targetWeight),
+		},
+		map[string]interface{}{
+			"destination": map[string]interface{}{"host": host, "subset": "target"},
+			"weight":      int64(targetWeight),
+		},
+	}
+	virtualService := map[string]interface{}{
+		"apiVersion": apiVersion,
+		"kind":       "VirtualService",
+		"metadata":   map[string]interface{}{"name": ruleName, "namespace": namespace},
+		"spec": map[string]interface{}{
+			"hosts": []interface{}{host},
+			"http":  []interface{}{map[string]interface{}{"route": routes}},
+			"tcp":   []interface{}{map[string]interface{}{"route": routes}},
+		},
+	}
+
+	// In the primary-remote model the configuration of the mesh lives in the primary only
+	configClusters := []kube.Cluster{c.Origin, c.Target}
+	if Mode == prompt.IstioPrimaryRemote {
+		configClusters = configClusters[:1]
+	}
+	for _, cluster := range configClusters {
+		for _, resource := range []map[string]interface{}{destinationRule, virtualService} {
+			if err := cluster.PatchCustomResource(namespace, resource); err != nil {
+				return fmt.Errorf("failed to apply %s %s in %s cluster: %w", resource["kind"], ruleName, cluster.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func subset(name, clusterID string) map[string]interface{} {
+	return map[string]interface{}{
+		"name":   name,
+		"labels": map[string]interface{}{clusterLabel: clusterID},
+	}
+}
@@ -0,0 +1,94 @@
+package istio
+
+import (
+	"bytes"
+	"clustershift/internal/cert"
+	"clustershift/internal/constants"
+	"clustershift/internal/exit"
+	"clustershift/internal/kube"
+	"clustershift/internal/logger"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	caCertsSecret    = "cacerts"
+	selfSignedSecret = "istio-ca-secret"
+	rootCertKey      = "root-cert.pem"
+)
+
+// establishTrust makes both clusters issue workload certificates from the same root CA, which
+// mTLS between the clusters requires. A cluster running Istio keeps its CA and the CA is copied
+// to the other cluster, otherwise every cluster gets an intermediate CA of a new root.
+func establishTrust(c kube.Clusters) {
+	originCerts, originErr := caCerts(c.Origin)
+	targetCerts, targetErr := caCerts(c.Target)
+
+	switch {
+	case originErr == nil && targetErr == nil:
+		if !bytes.Equal(originCerts[rootCertKey], targetCerts[rootCertKey]) {
+			exit.OnErrorWithMessage(fmt.Errorf("the Istio installations use different root certificates"),
+				"Plug in intermediate CAs of a shared root as cacerts secret in both clusters")
+		}
+		logger.Debug("Istio installations share a root certificate")
+	case originErr == nil:
+		createCACerts(c.Target, originCerts)
+	case targetErr == nil:
+		createCACerts(c.Origin, targetCerts)
+	default:
+		logger.Debug("Create Istio certificates")
+		root, err := cert.GenerateIstioRootCA()
+		exit.OnErrorWithMessage(err, "Failed to generate Istio root CA")
+		for _, cluster := range []kube.Cluster{c.Origin, c.Target} {
+			intermediate, err := root.GenerateIntermediate(ClusterID(cluster), 8760*time.Hour) // 1 year
+			exit.OnErrorWithMessage(err, "Failed to generate Istio intermediate CA")
+			createCACerts(cluster, map[string][]byte{
+				"ca-cert.pem":    intermediate.CACertPEM,
+				"ca-key.pem":     intermediate.CAKeyPEM,
+				rootCertKey:      intermediate.RootCertPEM,
+				"cert-chain.pem": intermediate.CertChainPEM,
+			})
+		}
+	}
+}
+
+// caCerts returns the CA of the cluster. An installed control plane without plugged-in CA signs
+// with its self-signed CA, which is returned as root and intermediate.
+func caCerts(c kube.Cluster) (map[string][]byte, error) {
+	secretInterface, err := c.FetchResource(kube.Secret, caCertsSecret, constants.IstioNamespace)
+	if err == nil {
+		return secretInterface.(*v1.Secret).Data, nil
+	}
+	if !Installed(c) {
+		return nil, fmt.Errorf("no Istio CA in %s cluster", c.Name)
+	}
+
+	secretInterface, err = c.FetchResource(kube.Secret, selfSignedSecret, constants.IstioNamespace)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the Istio CA of %s cluster: %w", c.Name, err)
+	}
+	data := secretInterface.(*v1.Secret).Data
+	return map[string][]byte{
+		"ca-cert.pem":    data["ca-cert.pem"],
+		"ca-key.pem":     data["ca-key.pem"],
+		rootCertKey:      data["ca-cert.pem"],
+		"cert-chain.pem": data["ca-cert.pem"],
+	}, nil
+}
+
+func createCACerts(c kube.Cluster, data map[string][]byte) {
+	logger.Info(fmt.Sprintf("Creating Istio CA of %s cluster", c.Name))
+	c.CreateNewNamespace(constants.IstioNamespace)
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      caCertsSecret,
+			Namespace: constants.IstioNamespace,
+		},
+		Data: data,
+	}
+	err := c.CreateResource(kube.Secret, constants.IstioNamespace, secret)
+	exit.OnErrorWithMessage(err, "Failed to create Istio cacerts secret")
+}
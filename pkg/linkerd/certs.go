@@ -0,0 +1,340 @@
+package linkerd
+
+import (
+	"clustershift/internal/cert"
+	"clustershift/internal/constants"
+	"clustershift/internal/exit"
+	"clustershift/internal/kube"
+	"clustershift/internal/logger"
+	"clustershift/internal/prompt"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// RenewWithin is the remaining validity below which an issuer is due for rotation
+const RenewWithin = 30 * 24 * time.Hour
+
+const certManagerAnnotation = "cert-manager.io/certificate-name"
+
+// Certs configures the Linkerd certificates, set from the migration options
+var Certs = DefaultCertOptions()
+
+// DefaultCertOptions returns the certificate options used when no options are given
+func DefaultCertOptions() prompt.LinkerdCertOptions {
+	return prompt.LinkerdCertOptions{
+		Store:          prompt.CertStoreSecret,
+		IssuerValidity: 8760 * time.Hour, // 1 year validity for issuer
+	}
+}
+
+// ValidateCerts checks that the certificate options can be combined
+func ValidateCerts() error {
+	var errs []error
+	if Certs.CertManagerIssuer != "" {
+		if _, _, err := certManagerIssuer(); err != nil {
+			errs = append(errs, err)
+		}
+		if Certs.IssuerCertFile != "" || Certs.IssuerKeyFile != "" {
+			errs = append(errs, fmt.Errorf("an issuer certificate cannot be combined with a cert-manager issuer"))
+		}
+	} else if Certs.TrustAnchorFile == "" && (Certs.IssuerCertFile != "" || Certs.TrustAnchorKeyFile != "") {
+		errs = append(errs, fmt.Errorf("the issuer or trust anchor key requires the trust anchor certificate"))
+	}
+	if Certs.Store != prompt.CertStoreSecret && os.Getenv(constants.LinkerdCertPassphraseEnv) == "" {
+		errs = append(errs, fmt.Errorf("the certificate file is encrypted with the passphrase in %s, which is not set", constants.LinkerdCertPassphraseEnv))
+	}
+	if Certs.IssuerValidity <= RenewWithin {
+		errs = append(errs, fmt.Errorf("issuer validity must be longer than %s", RenewWithin))
+	}
+	return errors.Join(errs...)
+}
+
+// loadCerts returns the certificates of new control planes. Certificates supplied by the user take
+// precedence, then the stored ones, otherwise new certificates are generated and stored so that
+// reruns share the trust anchor. With cert-manager only the trust anchor is returned, if supplied.
+func loadCerts(c kube.Clusters) (*cert.LinkerdCerts, error) {
+	if Certs.CertManagerIssuer != "" {
+		certs := &cert.LinkerdCerts{}
+		if Certs.TrustAnchorFile != "" {
+			trustAnchors, err := os.ReadFile(Certs.TrustAnchorFile)
+			if err != nil {
+				return nil, err
+			}
+			certs.TrustAnchorsPEM = trustAnchors
+		}
+		return certs, nil
+	}
+
+	if Certs.TrustAnchorFile != "" {
+		certs, err := cert.LoadLinkerdCertFiles(Certs.TrustAnchorFile, Certs.TrustAnchorKeyFile, Certs.IssuerCertFile, Certs.IssuerKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		return certs, storeCerts(c.Origin, certs)
+	}
+
+	certs, err := loadStoredCerts(c.Origin)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the stored certificates: %w", err)
+	}
+	if certs != nil {
+		logger.Info("Using the stored Linkerd certificates")
+		expiry, err := certs.IssuerExpiry()
+		if err != nil || time.Until(expiry) > RenewWithin {
+			return certs, err
+		}
+		logger.Info(fmt.Sprintf("Renewing the stored issuer, it expires at %s", expiry.Format(time.DateOnly)))
+		if err := certs.RenewIssuer(Certs.IssuerValidity); err != nil {
+			return nil, err
+		}
+		return certs, storeCerts(c.Origin, certs)
+	}
+
+	certs, err = cert.GenerateLinkerdCerts(Certs.IssuerValidity)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate Linkerd certificates: %w", err)
+	}
+	return certs, storeCerts(c.Origin, certs)
+}
+
+// storeCerts persists the certificates in a secret of the cluster or an encrypted file. The secret
+// holds the trust anchor key in plain text, it is only protected by the RBAC of the cluster.
+func storeCerts(c kube.Cluster, certs *cert.LinkerdCerts) error {
+	if Certs.Store != prompt.CertStoreSecret {
+		logger.Debug(fmt.Sprintf("Storing the Linkerd certificates in %s", Certs.Store))
+		return certs.SaveEncrypted(Certs.Store, os.Getenv(constants.LinkerdCertPassphraseEnv))
+	}
+
+	logger.Debug(fmt.Sprintf("Storing the Linkerd certificates in secret %s/%s of %s cluster",
+		constants.LinkerdCertsNamespace, constants.LinkerdCertsSecret, c.Name))
+	if len(certs.TrustAnchorKeyPEM) > 0 {
+		logger.Warning(fmt.Sprintf("The trust anchor key is stored unencrypted in secret %s/%s of %s cluster",
+			constants.LinkerdCertsNamespace, constants.LinkerdCertsSecret, c.Name),
+			fmt.Errorf("anyone able to read the secret can issue mesh identities, use --linkerd-cert-store with an encrypted file instead"))
+	}
+	c.CreateNewNamespace(constants.LinkerdCertsNamespace)
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      constants.LinkerdCertsSecret,
+			Namespace: constants.LinkerdCertsNamespace,
+		},
+		Type: v1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			"ca.crt":     certs.TrustAnchorsPEM,
+			"ca.key":     certs.TrustAnchorKeyPEM,
+			"issuer.crt": certs.IssuerCertPEM,
+			"issuer.key": certs.IssuerKeyPEM,
+		},
+	}
+	err := c.CreateResource(kube.Secret, secret.Namespace, secret)
+	if k8serrors.IsAlreadyExists(err) {
+		return c.UpdateResource(kube.Secret, secret.Name, secret.Namespace, secret)
+	}
+	return err
+}
+
+// loadStoredCerts returns the stored certificates, or nil if none are stored
+func loadStoredCerts(c kube.Cluster) (*cert.LinkerdCerts, error) {
+	if Certs.Store != prompt.CertStoreSecret {
+		if _, err := os.Stat(Certs.Store); errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return cert.LoadEncrypted(Certs.Store, os.Getenv(constants.LinkerdCertPassphraseEnv))
+	}
+
+	secretInterface, err := c.FetchResource(kube.Secret, constants.LinkerdCertsSecret, constants.LinkerdCertsNamespace)
+	if k8serrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	data := secretInterface.(*v1.Secret).Data
+	return &cert.LinkerdCerts{
+		TrustAnchorsPEM:   data["ca.crt"],
+		TrustAnchorKeyPEM: data["ca.key"],
+		IssuerCertPEM:     data["issuer.crt"],
+		IssuerKeyPEM:      data["issuer.key"],
+	}, nil
+}
+
+// certManagerIssuer returns the kind and name of the cert-manager issuer
+func certManagerIssuer() (string, string, error) {
+	kind, name, found := strings.Cut(Certs.CertManagerIssuer, "/")
+	if !found || name == "" || (kind != "Issuer" && kind != "ClusterIssuer") {
+		return "", "", fmt.Errorf("cert-manager issuer %q must be Issuer/<name> or ClusterIssuer/<name>", Certs.CertManagerIssuer)
+	}
+	return kind, name, nil
+}
+
+// issueWithCertManager requests the identity issuer of the cluster from cert-manager. The trust
+// anchor is the CA of the cert-manager issuer unless one is supplied.
+func issueWithCertManager(c kube.Cluster, trustAnchors []byte) (*cert.LinkerdCerts, error) {
+	kind, name, err := certManagerIssuer()
+	if err != nil {
+		return nil, err
+	}
+	logger.Info(fmt.Sprintf("Requesting the Linkerd identity issuer from cert-manager %s %s", kind, name))
+
+	c.CreateNewNamespace(constants.LinkerdNamespace)
+	certificate := map[string]interface{}{
+		"apiVersion": "cert-manager.io/v1",
+		"kind":       "Certificate",
+		"metadata":   map[string]interface{}{"name": issuerSecret, "namespace": constants.LinkerdNamespace},
+		"spec": map[string]interface{}{
+			"secretName":  issuerSecret,
+			"duration":    Certs.IssuerValidity.String(),
+			"renewBefore": RenewWithin.String(),
+			"issuerRef":   map[string]interface{}{"name": name, "kind": kind},
+			"commonName":  "identity.linkerd.cluster.local",
+			"dnsNames":    []interface{}{"identity.linkerd.cluster.local"},
+			"isCA":        true,
+			"privateKey":  map[string]interface{}{"algorithm": "ECDSA"},
+			"usages":      []interface{}{"cert sign", "crl sign", "server auth", "client auth"},
+		},
+	}
+	if err := c.CreateCustomResource(constants.LinkerdNamespace, certificate); err != nil && !k8serrors.IsAlreadyExists(err) {
+		return nil, fmt.Errorf("failed to create certificate: %w", err)
+	}
+
+	var data map[string][]byte
+	err = wait.PollUntilContextTimeout(context.TODO(), 5*time.Second, 2*time.Minute, true, func(ctx context.Context) (bool, error) {
+		secretInterface, err := c.FetchResource(kube.Secret, issuerSecret, constants.LinkerdNamespace)
+		if err != nil {
+			return false, nil
+		}
+		data = secretInterface.(*v1.Secret).Data
+		return len(data[v1.TLSCertKey]) > 0, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cert-manager did not issue %s: %w", issuerSecret, err)
+	}
+
+	certs := &cert.LinkerdCerts{TrustAnchorsPEM: trustAnchors, IssuerCertPEM: data[v1.TLSCertKey], IssuerKeyPEM: data[v1.TLSPrivateKeyKey]}
+	if len(certs.TrustAnchorsPEM) == 0 {
+		certs.TrustAnchorsPEM = data["ca.crt"]
+	}
+	if len(certs.TrustAnchorsPEM) == 0 {
+		return nil, fmt.Errorf("the cert-manager issuer does not provide its CA, supply the trust anchor")
+	}
+	if _, err := cert.VerifyIssuer(certs.IssuerCertPEM, certs.TrustAnchorsPEM); err != nil {
+		return nil, fmt.Errorf("issuer is not signed by the trust anchor: %w", err)
+	}
+	return certs, nil
+}
+
+// RotateCerts renews the identity issuer of both clusters. Issuers managed by cert-manager are
+// reissued by cert-manager, the others are replaced by an issuer signed with the stored or supplied
+// trust anchor key. The identity controller picks up the new issuer, proxies renew their
+// certificates with it.
+func RotateCerts(kubeconfigOrigin, kubeconfigTarget string) {
+	clusters, err := kube.InitClients(kubeconfigOrigin, kubeconfigTarget)
+	exit.OnErrorWithMessage(err, "Error initializing kubernetes clients")
+
+	var unmanaged []kube.Cluster
+	for _, c := range []kube.Cluster{clusters.Origin, clusters.Target} {
+		secretInterface, err := c.FetchResource(kube.Secret, issuerSecret, constants.LinkerdNamespace)
+		exit.OnErrorWithMessage(err, fmt.Sprintf("Linkerd identity issuer not found in %s cluster", c.Name))
+		if _, managed := secretInterface.(*v1.Secret).Annotations[certManagerAnnotation]; managed {
+			// cert-manager issues a new certificate once the secret is gone
+			err = c.DeleteResource(kube.Secret, issuerSecret, constants.LinkerdNamespace)
+			exit.OnErrorWithMessage(err, fmt.Sprintf("Failed to trigger the renewal of the issuer of %s cluster", c.Name))
+			logger.Info(fmt.Sprintf("cert-manager reissues the identity issuer of %s cluster", c.Name))
+			continue
+		}
+		unmanaged = append(unmanaged, c)
+	}
+	if len(unmanaged) == 0 {
+		return
+	}
+
+	var certs *cert.LinkerdCerts
+	if Certs.TrustAnchorFile != "" {
+		certs, err = cert.LoadLinkerdCertFiles(Certs.TrustAnchorFile, Certs.TrustAnchorKeyFile, Certs.IssuerCertFile, Certs.IssuerKeyFile)
+	} else {
+		certs, err = loadStoredCerts(clusters.Origin)
+		if err == nil && certs == nil {
+			err = fmt.Errorf("no stored certificates found")
+		}
+	}
+	exit.OnErrorWithMessage(err, "Failed to load the Linkerd trust anchor, supply it with --linkerd-trust-anchor and --linkerd-trust-anchor-key")
+	exit.OnErrorWithMessage(certs.RenewIssuer(Certs.IssuerValidity), "Failed to renew the Linkerd issuer")
+
+	for _, c := range unmanaged {
+		exit.OnErrorWithMessage(updateIssuer(c, certs), fmt.Sprintf("Failed to update the issuer of %s cluster", c.Name))
+	}
+	exit.OnErrorWithMessage(storeCerts(clusters.Origin, certs), "Failed to store the Linkerd certificates")
+
+	expiry, _ := certs.IssuerExpiry()
+	logger.Info(fmt.Sprintf("Rotated the Linkerd identity issuer, valid until %s", expiry.Format(time.DateOnly)))
+}
+
+// updateIssuer replaces the issuer certificate and key in the issuer secret of the cluster
+func updateIssuer(c kube.Cluster, certs *cert.LinkerdCerts) error {
+	secretInterface, err := c.FetchResource(kube.Secret, issuerSecret, constants.LinkerdNamespace)
+	if err != nil {
+		return err
+	}
+	secret := secretInterface.(*v1.Secret)
+	if _, err := cert.VerifyIssuer(certs.IssuerCertPEM, []byte(trustBundle(c))); err != nil {
+		return fmt.Errorf("the cluster does not trust the new issuer: %w", err)
+	}
+	if secret.Type == v1.SecretTypeTLS {
+		secret.Data[v1.TLSCertKey] = certs.IssuerCertPEM
+		secret.Data[v1.TLSPrivateKeyKey] = certs.IssuerKeyPEM
+	} else {
+		secret.Data["crt.pem"] = certs.IssuerCertPEM
+		secret.Data["key.pem"] = certs.IssuerKeyPEM
+	}
+	return c.UpdateResource(kube.Secret, secret.Name, secret.Namespace, secret)
+}
+
+// trustBundle returns the trust anchors of the installed control plane
+func trustBundle(c kube.Cluster) string {
+	install, err := detect(c)
+	if err != nil || install == nil {
+		return ""
+	}
+	return install.trustAnchors
+}
+
+// CheckCerts reports the expiry of the identity issuers and trust anchors of both clusters and
+// exits if one of them expires within RenewWithin
+func CheckCerts(kubeconfigOrigin, kubeconfigTarget string) {
+	clusters, err := kube.InitClients(kubeconfigOrigin, kubeconfigTarget)
+	exit.OnErrorWithMessage(err, "Error initializing kubernetes clients")
+
+	var errs []error
+	for _, c := range []kube.Cluster{clusters.Origin, clusters.Target} {
+		issuer, err := readIssuer(c)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s cluster: %w", c.Name, err))
+			continue
+		}
+		for _, check := range []struct {
+			name string
+			pem  []byte
+		}{{"trust anchor", issuer.TrustAnchorsPEM}, {"identity issuer", issuer.IssuerCertPEM}} {
+			certificates, err := cert.ParseCertificates(check.pem)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s of %s cluster: %w", check.name, c.Name, err))
+				continue
+			}
+			expiry := certificates[0].NotAfter
+			logger.Info(fmt.Sprintf("The %s of %s cluster expires at %s", check.name, c.Name, expiry.Format(time.DateOnly)))
+			if time.Until(expiry) < RenewWithin {
+				errs = append(errs, fmt.Errorf("the %s of %s cluster expires at %s", check.name, c.Name, expiry.Format(time.DateOnly)))
+			}
+		}
+	}
+	exit.OnErrorWithMessage(errors.Join(errs...), "Linkerd certificates are due for rotation, run clustershift certs rotate")
+}
@@ -0,0 +1,177 @@
+package linkerd
+
+import (
+	"clustershift/internal/cert"
+	"clustershift/internal/constants"
+	"clustershift/internal/kube"
+	"clustershift/internal/logger"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+const (
+	trustRootsConfigMap = "linkerd-identity-trust-roots"
+	trustRootsKey       = "ca-bundle.crt"
+	issuerSecret        = "linkerd-identity-issuer"
+)
+
+var versionPattern = regexp.MustCompile(`^(stable|edge|enterprise)-(\d+)\.(\d+)\.(\d+)`)
+
+// minimumStable is the first stable release with the trust roots ConfigMap and headless service mirroring
+var minimumStable = [2]int{2, 12}
+
+// installation is a Linkerd control plane found in a cluster
+type installation struct {
+	version      string
+	trustAnchors string
+	multicluster bool
+}
+
+// detect returns the Linkerd installation of the cluster, or nil if Linkerd is not installed
+func detect(c kube.Cluster) (*installation, error) {
+	configMapInterface, err := c.FetchResource(kube.ConfigMap, "linkerd-config", constants.LinkerdNamespace)
+	if k8serrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	config := getConfigValues(configMapInterface.(*v1.ConfigMap))
+
+	found := &installation{version: config.LinkerdVersion, trustAnchors: config.IdentityTrustAnchorsPEM}
+	if rootsInterface, err := c.FetchResource(kube.ConfigMap, trustRootsConfigMap, constants.LinkerdNamespace); err == nil {
+		found.trustAnchors = rootsInterface.(*v1.ConfigMap).Data[trustRootsKey]
+	}
+	if _, err := c.FetchResource(kube.Deployment, "linkerd-gateway", constants.LinkerdMultiClusterNamespace); err == nil {
+		found.multicluster = true
+	}
+	return found, nil
+}
+
+// Validate checks the existing Linkerd installations. They need a supported version and the clusters
+// have to trust each other, either through a shared trust anchor or because the existing issuer can
+// be shared with the cluster Linkerd is installed on.
+func Validate(c kube.Clusters) error {
+	origin, err := detect(c.Origin)
+	if err != nil {
+		return fmt.Errorf("origin cluster: %w", err)
+	}
+	target, err := detect(c.Target)
+	if err != nil {
+		return fmt.Errorf("target cluster: %w", err)
+	}
+
+	var errs []error
+	for _, pair := range []struct {
+		cluster kube.Cluster
+		install *installation
+	}{{c.Origin, origin}, {c.Target, target}} {
+		if pair.install == nil {
+			continue
+		}
+		if err := validateVersion(pair.install.version); err != nil {
+			errs = append(errs, fmt.Errorf("%s cluster: %w", pair.cluster.Name, err))
+		}
+	}
+
+	switch {
+	case origin != nil && target != nil:
+		if origin.version != target.version {
+			logger.Warning(fmt.Sprintf("Linkerd versions differ, origin runs %s and target %s", origin.version, target.version),
+				fmt.Errorf("version mismatch"))
+		}
+		for _, pair := range [][2]kube.Cluster{{c.Origin, c.Target}, {c.Target, c.Origin}} {
+			peerInstall := origin
+			if pair[1].Name == c.Target.Name {
+				peerInstall = target
+			}
+			issuer, err := readIssuer(pair[0])
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s cluster: %w", pair[0].Name, err))
+				continue
+			}
+			if _, err := cert.VerifyIssuer(issuer.IssuerCertPEM, []byte(peerInstall.trustAnchors)); err != nil {
+				errs = append(errs, fmt.Errorf("the issuer of %s cluster is not trusted by %s cluster, add its trust anchor to identityTrustAnchorsPEM: %w",
+					pair[0].Name, pair[1].Name, err))
+			}
+		}
+	case origin != nil:
+		if _, err := readIssuer(c.Origin); err != nil {
+			errs = append(errs, fmt.Errorf("origin cluster: %w", err))
+		}
+	case target != nil:
+		if _, err := readIssuer(c.Target); err != nil {
+			errs = append(errs, fmt.Errorf("target cluster: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// sharedCerts returns the certificates for the clusters Linkerd is installed on. Installing next to an
+// existing control plane shares its trust anchor and issuer, otherwise the configured ones are used.
+func sharedCerts(c kube.Clusters, origin, target *installation) (*cert.LinkerdCerts, error) {
+	switch {
+	case origin != nil && target != nil:
+		return nil, nil
+	case origin != nil:
+		return readIssuer(c.Origin)
+	case target != nil:
+		return readIssuer(c.Target)
+	}
+	return loadCerts(c)
+}
+
+// readIssuer reads the trust anchors and identity issuer of an existing installation. The issuer
+// secret uses the Linkerd or, if it is managed by cert-manager, the kubernetes.io/tls format.
+func readIssuer(c kube.Cluster) (*cert.LinkerdCerts, error) {
+	install, err := detect(c)
+	if err != nil || install == nil {
+		return nil, fmt.Errorf("linkerd is not installed")
+	}
+
+	secretInterface, err := c.FetchResource(kube.Secret, issuerSecret, constants.LinkerdNamespace)
+	if err != nil {
+		return nil, fmt.Errorf("identity issuer secret not found: %w", err)
+	}
+	data := secretInterface.(*v1.Secret).Data
+	certs := &cert.LinkerdCerts{
+		TrustAnchorsPEM: []byte(install.trustAnchors),
+		IssuerCertPEM:   data["crt.pem"],
+		IssuerKeyPEM:    data["key.pem"],
+	}
+	if len(certs.IssuerCertPEM) == 0 {
+		certs.IssuerCertPEM = data[v1.TLSCertKey]
+		certs.IssuerKeyPEM = data[v1.TLSPrivateKeyKey]
+	}
+	if len(certs.IssuerCertPEM) == 0 || len(certs.IssuerKeyPEM) == 0 {
+		return nil, fmt.Errorf("identity issuer secret has no certificate and key")
+	}
+	if _, err := cert.VerifyIssuer(certs.IssuerCertPEM, certs.TrustAnchorsPEM); err != nil {
+		return nil, fmt.Errorf("identity issuer is not valid for the trust anchors: %w", err)
+	}
+	return certs, nil
+}
+
+// validateVersion checks that the release supports what clustershift relies on. Edge releases are
+// newer than the minimum stable release, versions of unknown channels are accepted.
+func validateVersion(version string) error {
+	match := versionPattern.FindStringSubmatch(version)
+	if match == nil {
+		logger.Debug(fmt.Sprintf("Unknown Linkerd version %q, skipping the version check", version))
+		return nil
+	}
+	if match[1] == "edge" {
+		return nil
+	}
+	major, _ := strconv.Atoi(match[2])
+	minor, _ := strconv.Atoi(match[3])
+	if major < minimumStable[0] || (major == minimumStable[0] && minor < minimumStable[1]) {
+		return fmt.Errorf("linkerd %s is older than %d.%d", version, minimumStable[0], minimumStable[1])
+	}
+	return nil
+}
@@ -1,7 +1,6 @@
 package linkerd
 
 import (
-	"clustershift/internal/exit"
 	"clustershift/internal/kube"
 	"clustershift/internal/logger"
 	"context"
@@ -14,38 +13,21 @@ import (
 	"time"
 )
 
-func ExportService(cluster kube.Cluster, name, namespace string) {
+func ExportService(cluster kube.Cluster, name, namespace string) error {
 	logger.Info(fmt.Sprintf("Exporting service %s in namespace %s", name, namespace))
 
-	// Add linkerd.io/inject=enabled annotation to the namespace
-	logger.Info(fmt.Sprintf("Adding linkerd.io/inject=enabled annotation to namespace %s", namespace))
-
-	// Fetch the namespace object first
-	namespaceInterface, err := cluster.FetchResource(kube.Namespace, namespace, "")
-	exit.OnErrorWithMessage(err, "Failed to fetch namespace")
-	namespaceObj := namespaceInterface.(*v1core.Namespace)
-
-	// Check if the namespace already has the linkerd.io/inject annotation
-	if namespaceObj.Annotations == nil || namespaceObj.Annotations["linkerd.io/inject"] != "enabled" {
-		// Add the linkerd injection annotation
-		err = cluster.AddAnnotation(namespaceObj, "linkerd.io/inject", "enabled")
-		exit.OnErrorWithMessage(err, "Failed to add linkerd inject annotation to namespace")
-
-		// Reroll all pods in the namespace by restarting deployments and statefulsets
-		logger.Info(fmt.Sprintf("Rerolling all pods in namespace %s", namespace))
-		err = RerollPodsInNamespace(cluster, namespace)
+	if err := InjectNamespace(cluster, namespace); err != nil {
+		return fmt.Errorf("failed to inject namespace: %w", err)
 	}
 
-	exit.OnErrorWithMessage(err, "Failed to reroll pods in namespace")
-
-	mirrorLabel := map[string]string{
-		"mirror.linkerd.io/exported": "true",
+	// Consumers connect right after the export, so the mirror has to be usable in the peer
+	if err := MirrorService(cluster, name, namespace); err != nil {
+		return fmt.Errorf("exported service is not mirrored: %w", err)
 	}
-
-	err = cluster.AddLabel(kube.Service, name, namespace, mirrorLabel)
-	exit.OnErrorWithMessage(err, "Failed to export service")
+	return nil
 }
 
+// MirrorService exports the service without meshing its namespace and waits for its mirror in the peer
 func MirrorService(cluster kube.Cluster, name, namespace string) error {
 	logger.Info(fmt.Sprintf("Mirroring service %s in namespace %s", name, namespace))
 
@@ -58,7 +40,39 @@ func MirrorService(cluster kube.Cluster, name, namespace string) error {
 		return fmt.Errorf("failed to mirror service: %v", err)
 	}
 
-	return nil
+	// The service mirror provides the per-pod names of headless services in the peer only, replica
+	// sets address their own members by these names as well
+	serviceInterface, err := cluster.FetchResource(kube.Service, name, namespace)
+	if err != nil {
+		return fmt.Errorf("failed to fetch service: %v", err)
+	}
+	service := serviceInterface.(*v1core.Service)
+	if service.Spec.ClusterIP == v1core.ClusterIPNone {
+		if err := kube.MirrorHeadlessService(cluster, cluster, service, name+"-"+cluster.Name, nil); err != nil {
+			return fmt.Errorf("failed to mirror headless service: %v", err)
+		}
+	}
+
+	peer, err := kube.Peer(cluster)
+	if err != nil {
+		return err
+	}
+	return WaitForMirrorService(peer, name, namespace, cluster.Name, mirrorTimeout)
+}
+
+// UnexportService removes the export label, the service mirror deletes the mirror in the peer
+func UnexportService(cluster kube.Cluster, name, namespace string) error {
+	serviceInterface, err := cluster.FetchResource(kube.Service, name, namespace)
+	if err != nil {
+		return err
+	}
+	service := serviceInterface.(*v1core.Service)
+	delete(service.Labels, "mirror.linkerd.io/exported")
+	if err := cluster.UpdateResource(kube.Service, name, namespace, service); err != nil {
+		return err
+	}
+
+	return kube.DeleteAlias(cluster, name+"-"+cluster.Name, namespace)
 }
 
 func InjectNamespace(cluster kube.Cluster, namespace string) error {
@@ -90,109 +104,54 @@ func InjectNamespace(cluster kube.Cluster, namespace string) error {
 	return nil
 }
 
-// rerollPodsInNamespace restarts all deployments and statefulsets in a namespace
-// This triggers a rolling update which ensures zero downtime
+// RerollPodsInNamespace restarts all deployments, statefulsets and CNPG clusters in a namespace
+// with the configured rollout strategy. Every restart is a rolling update.
 func RerollPodsInNamespace(cluster kube.Cluster, namespace string) error {
-	// Restart all deployments in the namespace
+	var workloads []workload
+
 	deploymentsInterface, err := cluster.FetchResources(kube.Deployment)
 	if err != nil {
 		return fmt.Errorf("failed to fetch deployments: %v", err)
 	}
-
-	deployments := deploymentsInterface.(*v1.DeploymentList)
-	var deploymentNames []string
-	for _, deployment := range deployments.Items {
+	for _, deployment := range deploymentsInterface.(*v1.DeploymentList).Items {
 		if deployment.Namespace == namespace {
-			logger.Info(fmt.Sprintf("Restarting deployment %s in namespace %s", deployment.Name, namespace))
-			err := restartDeployment(cluster, deployment.Name, namespace)
-			if err != nil {
-				return fmt.Errorf("failed to restart deployment %s: %v", deployment.Name, err)
-			}
-			deploymentNames = append(deploymentNames, deployment.Name)
+			workloads = append(workloads, deploymentWorkload(cluster, deployment))
 		}
 	}
 
-	// Restart all statefulsets in the namespace
 	statefulsetsInterface, err := cluster.FetchResources(kube.StatefulSet)
 	if err != nil {
 		return fmt.Errorf("failed to fetch statefulsets: %v", err)
 	}
-
-	statefulsets := statefulsetsInterface.(*v1.StatefulSetList)
-	var statefulsetNames []string
-	for _, statefulset := range statefulsets.Items {
+	for _, statefulset := range statefulsetsInterface.(*v1.StatefulSetList).Items {
 		if statefulset.Namespace == namespace {
-			logger.Info(fmt.Sprintf("Restarting statefulset %s in namespace %s", statefulset.Name, namespace))
-			err := restartStatefulSet(cluster, statefulset.Name, namespace)
-			if err != nil {
-				return fmt.Errorf("failed to restart statefulset %s: %v", statefulset.Name, err)
-			}
-			statefulsetNames = append(statefulsetNames, statefulset.Name)
+			workloads = append(workloads, statefulSetWorkload(cluster, statefulset))
 		}
 	}
 
-	// Restart all CNPG clusters in the namespace
 	cnpgClusters, err := cluster.FetchCustomResources("postgresql.cnpg.io", "v1", "clusters")
 	if err != nil {
 		logger.Info(fmt.Sprintf("No CNPG clusters found or error fetching them: %v", err))
-	} else {
-		var cnpgClusterNames []string
-		for _, cnpgCluster := range cnpgClusters {
-			metadata, ok := cnpgCluster["metadata"].(map[string]interface{})
-			if !ok {
-				continue
-			}
-
-			clusterNamespace, ok := metadata["namespace"].(string)
-			if !ok || clusterNamespace != namespace {
-				continue
-			}
-
-			clusterName, ok := metadata["name"].(string)
-			if !ok {
-				continue
-			}
-
-			logger.Info(fmt.Sprintf("Restarting CNPG cluster %s in namespace %s", clusterName, namespace))
-			err := restartCNPGCluster(cluster, clusterName, namespace)
-			if err != nil {
-				return fmt.Errorf("failed to restart CNPG cluster %s: %v", clusterName, err)
-			}
-			cnpgClusterNames = append(cnpgClusterNames, clusterName)
-		}
-
-		// Wait for all CNPG clusters to be ready
-		logger.Info(fmt.Sprintf("Waiting for CNPG clusters to be ready in namespace %s", namespace))
-		for _, cnpgClusterName := range cnpgClusterNames {
-			err := WaitForCNPGClusterReady(cluster.DynamicClientset, cnpgClusterName, namespace, 15*time.Minute)
-			if err != nil {
-				return fmt.Errorf("failed to wait for CNPG cluster %s to be ready: %v", cnpgClusterName, err)
-			}
-			logger.Info(fmt.Sprintf("CNPG cluster %s is ready", cnpgClusterName))
-		}
 	}
+	for _, cnpgCluster := range cnpgClusters {
+		metadata, ok := cnpgCluster["metadata"].(map[string]interface{})
+		if !ok {
+			continue
+		}
 
-	// Wait for all deployments to be ready
-	logger.Info(fmt.Sprintf("Waiting for deployments to be ready in namespace %s", namespace))
-	for _, deploymentName := range deploymentNames {
-		err := waitForDeploymentReady(cluster, deploymentName, namespace, 10*time.Minute)
-		if err != nil {
-			return fmt.Errorf("failed to wait for deployment %s to be ready: %v", deploymentName, err)
+		clusterNamespace, ok := metadata["namespace"].(string)
+		if !ok || clusterNamespace != namespace {
+			continue
 		}
-		logger.Info(fmt.Sprintf("Deployment %s is ready", deploymentName))
-	}
 
-	// Wait for all statefulsets to be ready
-	logger.Info(fmt.Sprintf("Waiting for statefulsets to be ready in namespace %s", namespace))
-	for _, statefulsetName := range statefulsetNames {
-		err := waitForStatefulSetReady(cluster, statefulsetName, namespace, 10*time.Minute)
-		if err != nil {
-			return fmt.Errorf("failed to wait for statefulset %s to be ready: %v", statefulsetName, err)
+		clusterName, ok := metadata["name"].(string)
+		if !ok {
+			continue
 		}
-		logger.Info(fmt.Sprintf("StatefulSet %s is ready", statefulsetName))
+		workloads = append(workloads, cnpgWorkload(cluster, clusterName, namespace))
 	}
 
-	return nil
+	return rollout(cluster, namespace, workloads)
 }
 
 // restartDeployment performs a rolling restart of a deployment by updating the restart annotation
@@ -352,3 +311,17 @@ func isStatefulSetReady(statefulset *v1.StatefulSet) bool {
 		statefulset.Status.CurrentReplicas == desiredReplicas &&
 		statefulset.Status.ObservedGeneration >= statefulset.Generation
 }
+
+// InjectIngress meshes the ingress controller namespace in ingress mode, the proxy then routes
+// requests by their host header or the l5d-dst-override header
+func InjectIngress(cluster kube.Cluster, namespace string) error {
+	namespaceObj, err := cluster.FetchResource(kube.Namespace, namespace, "")
+	if err != nil {
+		return fmt.Errorf("fetching namespace %s failed: %v", namespace, err)
+	}
+	err = cluster.AddAnnotation(namespaceObj.(*v1core.Namespace), "linkerd.io/inject", "ingress")
+	if err != nil {
+		return fmt.Errorf("failed to add linkerd inject annotation to namespace %s: %v", namespace, err)
+	}
+	return RerollPodsInNamespace(cluster, namespace)
+}
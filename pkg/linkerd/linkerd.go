@@ -11,25 +11,51 @@ import (
 	"fmt"
 	"gopkg.in/yaml.v2"
 	v1 "k8s.io/api/core/v1"
-	"time"
 )
 
+// Install links the clusters with Linkerd. Existing control planes are kept, a cluster without one
+// gets a control plane sharing the trust anchor and issuer of the existing installation.
 func Install(c kube.Clusters) {
-	logger.Debug("Create Linkerd certificates")
+	origin, err := detect(c.Origin)
+	exit.OnErrorWithMessage(err, "Failed to detect Linkerd in origin cluster")
+	target, err := detect(c.Target)
+	exit.OnErrorWithMessage(err, "Failed to detect Linkerd in target cluster")
 
-	certs, err := cert.GenerateLinkerdCerts(8760 * time.Hour) // 1 year validity for issuer
-	if err != nil {
-		panic(fmt.Sprintf("Failed to generate Linkerd certificates: %v", err))
+	logger.Debug("Create Linkerd certificates")
+	certs, err := sharedCerts(c, origin, target)
+	exit.OnErrorWithMessage(err, "Failed to prepare Linkerd certificates")
+
+	for _, pair := range []struct {
+		cluster kube.Cluster
+		install *installation
+	}{{c.Origin, origin}, {c.Target, target}} {
+		if pair.install != nil {
+			logger.Info(fmt.Sprintf("Reusing Linkerd %s in %s cluster", pair.install.version, pair.cluster.Name))
+		} else if Certs.CertManagerIssuer != "" && len(certs.IssuerKeyPEM) == 0 {
+			// cert-manager issues an issuer per cluster, the second one has to be signed by the same CA
+			issued, err := issueWithCertManager(pair.cluster, certs.TrustAnchorsPEM)
+			exit.OnErrorWithMessage(err, fmt.Sprintf("Failed to issue the Linkerd issuer of %s cluster", pair.cluster.Name))
+			certs.TrustAnchorsPEM = issued.TrustAnchorsPEM
+			installControlPlane(pair.cluster, *issued, true)
+		} else {
+			installControlPlane(pair.cluster, *certs, false)
+		}
+		if pair.install == nil || !pair.install.multicluster {
+			installMulticluster(pair.cluster)
+		}
 	}
 
-	installCluster(c.Origin, *certs)
-	installCluster(c.Target, *certs)
-
 	LinkCluster(c.Origin, c.Target, "origin")
 	LinkCluster(c.Target, c.Origin, "target")
+
+	// Services are only mirrored once the service mirrors reach the gateway of their remote cluster
+	exit.OnErrorWithMessage(WaitForLink(c.Target, c.Origin.Name, linkTimeout), "Linkerd link is not healthy")
+	exit.OnErrorWithMessage(WaitForLink(c.Origin, c.Target.Name, linkTimeout), "Linkerd link is not healthy")
 }
 
-func installCluster(c kube.Cluster, certs cert.LinkerdCerts) {
+// installControlPlane installs the control plane with the certificates. An external issuer is
+// managed by cert-manager, the control plane only reads its secret.
+func installControlPlane(c kube.Cluster, certs cert.LinkerdCerts, externalIssuer bool) {
 	logger.Info("Installing Linkerd")
 
 	c.CreateNewNamespace(constants.LinkerdNamespace)
@@ -52,6 +78,11 @@ func installCluster(c kube.Cluster, certs cert.LinkerdCerts) {
 			"nativeSidecar": true,
 		},
 	}
+	if externalIssuer {
+		valuesMap["identity"] = map[string]interface{}{
+			"issuer": map[string]interface{}{"scheme": "kubernetes.io/tls"},
+		}
+	}
 
 	// Convert the map to a YAML string
 	controlPlaneValues, err := yaml.Marshal(valuesMap)
@@ -59,12 +90,22 @@ func installCluster(c kube.Cluster, certs cert.LinkerdCerts) {
 		panic(fmt.Sprintf("Failed to marshal YAML: %v", err))
 	}
 	deployEdgeChart(c.ClusterOptions, constants.LinkerdControlPlaneChartName, "linkerd-control-plane", string(controlPlaneValues))
+}
 
+func installMulticluster(c kube.Cluster) {
 	logger.Debug("Install linkerd-multicluster")
+	gatewayNodes, err := c.LabelGatewayNodes(kube.GatewayNodes, nil)
+	exit.OnErrorWithMessage(err, "Failed to select gateway nodes")
 	multiclusterValuesMap := map[string]interface{}{
 		"controllerDefaults": map[string]interface{}{
 			"enableHeadlessServices": true,
 		},
+		"gateway": map[string]interface{}{
+			"replicas":     len(gatewayNodes),
+			"nodeSelector": map[string]string{kube.GatewayNodeLabel: "true"},
+			"tolerations":  gatewayTolerations(),
+		},
+		"enablePodAntiAffinity": len(gatewayNodes) > 1,
 	}
 
 	// Convert the map to a YAML string
@@ -75,6 +116,19 @@ func installCluster(c kube.Cluster, certs cert.LinkerdCerts) {
 	deployMulticlusterChart(c.ClusterOptions, constants.LinkerdMultiClusterChartName, "linkerd-multicluster", string(multiclusterValues))
 }
 
+// gatewayTolerations converts the gateway tolerations to chart values
+func gatewayTolerations() []map[string]string {
+	var tolerations []map[string]string
+	for _, toleration := range kube.GatewayTolerations() {
+		tolerations = append(tolerations, map[string]string{
+			"key":      toleration.Key,
+			"operator": string(toleration.Operator),
+			"effect":   string(toleration.Effect),
+		})
+	}
+	return tolerations
+}
+
 func linkClusterDep(fromCluster kube.Cluster, toCluster kube.Cluster, fromClusterName string) {
 
 	serviceInterface, err := fromCluster.FetchResource(kube.Service, "linkerd-gateway", "linkerd-multicluster")
@@ -0,0 +1,265 @@
+package linkerd
+
+import (
+	"clustershift/internal/kube"
+	"clustershift/internal/logger"
+	"clustershift/internal/prompt"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/apps/v1"
+	v1core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const pdbTimeout = 10 * time.Minute
+
+// Rollout configures the restarts injecting the proxy, set from the migration options
+var Rollout = DefaultRollout()
+
+// DefaultRollout returns the rollout used when no options are given
+func DefaultRollout() prompt.RolloutOptions {
+	return prompt.RolloutOptions{
+		MaxConcurrent: 3,
+		RespectPDBs:   true,
+		SkipInjected:  true,
+	}
+}
+
+// workload is a restartable owner of pods
+type workload struct {
+	kind     string
+	name     string
+	selector labels.Selector
+	restart  func() error
+	wait     func() error
+}
+
+func (w workload) String() string {
+	return fmt.Sprintf("%s %s", w.kind, w.name)
+}
+
+// rollout restarts the workloads in batches of Rollout.MaxConcurrent. Workloads whose pods already
+// run the proxy are skipped, PodDisruptionBudgets pace the restarts and the canary is restarted
+// and verified on its own first.
+func rollout(c kube.Cluster, namespace string, workloads []workload) error {
+	proxy := expectedProxy(c, namespace)
+	if Rollout.SkipInjected && proxy != "" {
+		workloads = slices.DeleteFunc(workloads, func(w workload) bool {
+			injected, err := podsRunProxy(c, namespace, w.selector, proxy)
+			if err == nil && injected {
+				logger.Info(fmt.Sprintf("Skipping %s, its pods already run %s", w, proxy))
+				return true
+			}
+			return false
+		})
+	}
+	if len(workloads) == 0 {
+		return nil
+	}
+
+	if Rollout.Canary {
+		canary := workloads[0]
+		logger.Info(fmt.Sprintf("Restarting %s as canary", canary))
+		if err := restartBatch(c, namespace, []workload{canary}); err != nil {
+			return err
+		}
+		if proxy != "" {
+			injected, err := podsRunProxy(c, namespace, canary.selector, proxy)
+			if err != nil {
+				return err
+			}
+			if !injected {
+				return fmt.Errorf("canary %s does not run %s after the restart, check the injection of namespace %s", canary, proxy, namespace)
+			}
+		}
+		logger.Info(fmt.Sprintf("Canary %s is healthy", canary))
+		workloads = workloads[1:]
+	}
+
+	batchSize := Rollout.MaxConcurrent
+	if batchSize < 1 {
+		batchSize = len(workloads)
+	}
+	for batch := range slices.Chunk(workloads, batchSize) {
+		if err := restartBatch(c, namespace, batch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restartBatch restarts the workloads together and waits until all of them are ready
+func restartBatch(c kube.Cluster, namespace string, batch []workload) error {
+	for _, w := range batch {
+		if Rollout.RespectPDBs {
+			if err := waitForDisruptionBudget(c, namespace, w); err != nil {
+				return err
+			}
+		}
+		logger.Info(fmt.Sprintf("Restarting %s in namespace %s", w, namespace))
+		if err := w.restart(); err != nil {
+			return fmt.Errorf("failed to restart %s: %v", w, err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(batch))
+	for i, w := range batch {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := w.wait(); err != nil {
+				errs[i] = fmt.Errorf("failed to wait for %s to be ready: %v", w, err)
+				return
+			}
+			logger.Info(fmt.Sprintf("%s is ready", w))
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// waitForDisruptionBudget waits until the PodDisruptionBudgets covering the pods of the workload
+// allow a disruption, a budget without allowed disruptions means the workload is already degraded
+func waitForDisruptionBudget(c kube.Cluster, namespace string, w workload) error {
+	var blocking string
+	err := wait.PollUntilContextTimeout(context.TODO(), waitInterval, pdbTimeout, true, func(ctx context.Context) (bool, error) {
+		budgets, err := c.Clientset.PolicyV1().PodDisruptionBudgets(namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return false, err
+		}
+		podLabels, err := podLabelsOf(c, namespace, w.selector)
+		if err != nil {
+			return false, err
+		}
+		for _, budget := range budgets.Items {
+			selector, err := metav1.LabelSelectorAsSelector(budget.Spec.Selector)
+			if err != nil || !slices.ContainsFunc(podLabels, func(l labels.Set) bool { return selector.Matches(l) }) {
+				continue
+			}
+			if budget.Status.DisruptionsAllowed < 1 {
+				blocking = budget.Name
+				return false, nil
+			}
+		}
+		return true, nil
+	})
+	if err != nil {
+		return fmt.Errorf("PodDisruptionBudget %s of %s allows no disruption: %v", blocking, w, err)
+	}
+	return nil
+}
+
+func podLabelsOf(c kube.Cluster, namespace string, selector labels.Selector) ([]labels.Set, error) {
+	pods, err := c.Clientset.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, err
+	}
+	var sets []labels.Set
+	for _, pod := range pods.Items {
+		sets = append(sets, pod.Labels)
+	}
+	return sets, nil
+}
+
+// expectedProxy returns the proxy container injected into the pods of the namespace, or an empty
+// string if the namespace is not injected
+func expectedProxy(c kube.Cluster, namespace string) string {
+	namespaceInterface, err := c.FetchResource(kube.Namespace, namespace, "")
+	if err != nil {
+		return ""
+	}
+	namespaceObj := namespaceInterface.(*v1core.Namespace)
+	switch {
+	case namespaceObj.Annotations["linkerd.io/inject"] == "enabled" || namespaceObj.Annotations["linkerd.io/inject"] == "ingress":
+		return "linkerd-proxy"
+	case namespaceObj.Labels["istio-injection"] == "enabled":
+		return "istio-proxy"
+	}
+	return ""
+}
+
+// podsRunProxy reports whether every pod of the selector is ready and runs the proxy, as a
+// sidecar or as native sidecar init container
+func podsRunProxy(c kube.Cluster, namespace string, selector labels.Selector, proxy string) (bool, error) {
+	pods, err := c.Clientset.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return false, err
+	}
+	if len(pods.Items) == 0 {
+		return false, nil
+	}
+	isProxy := func(container v1core.Container) bool { return container.Name == proxy }
+	for _, pod := range pods.Items {
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+		if !slices.ContainsFunc(pod.Spec.Containers, isProxy) && !slices.ContainsFunc(pod.Spec.InitContainers, isProxy) {
+			return false, nil
+		}
+		if !podReady(pod) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func podReady(pod v1core.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == v1core.PodReady {
+			return condition.Status == v1core.ConditionTrue
+		}
+	}
+	return false
+}
+
+func deploymentWorkload(cluster kube.Cluster, deployment v1.Deployment) workload {
+	selector, err := metav1.LabelSelectorAsSelector(deployment.Spec.Selector)
+	if err != nil {
+		selector = labels.SelectorFromSet(deployment.Spec.Template.Labels)
+	}
+	return workload{
+		kind:     "deployment",
+		name:     deployment.Name,
+		selector: selector,
+		restart:  func() error { return restartDeployment(cluster, deployment.Name, deployment.Namespace) },
+		wait: func() error {
+			return waitForDeploymentReady(cluster, deployment.Name, deployment.Namespace, 10*time.Minute)
+		},
+	}
+}
+
+func statefulSetWorkload(cluster kube.Cluster, statefulset v1.StatefulSet) workload {
+	selector, err := metav1.LabelSelectorAsSelector(statefulset.Spec.Selector)
+	if err != nil {
+		selector = labels.SelectorFromSet(statefulset.Spec.Template.Labels)
+	}
+	return workload{
+		kind:     "statefulset",
+		name:     statefulset.Name,
+		selector: selector,
+		restart:  func() error { return restartStatefulSet(cluster, statefulset.Name, statefulset.Namespace) },
+		wait: func() error {
+			return waitForStatefulSetReady(cluster, statefulset.Name, statefulset.Namespace, 10*time.Minute)
+		},
+	}
+}
+
+func cnpgWorkload(cluster kube.Cluster, name, namespace string) workload {
+	return workload{
+		kind:     "CNPG cluster",
+		name:     name,
+		selector: labels.SelectorFromSet(labels.Set{"cnpg.io/cluster": name}),
+		restart:  func() error { return restartCNPGCluster(cluster, name, namespace) },
+		wait: func() error {
+			return WaitForCNPGClusterReady(cluster.DynamicClientset, name, namespace, 15*time.Minute)
+		},
+	}
+}
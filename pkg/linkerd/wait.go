@@ -0,0 +1,136 @@
+package linkerd
+
+import (
+	"clustershift/internal/constants"
+	"clustershift/internal/kube"
+	"clustershift/internal/logger"
+	"context"
+	"fmt"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	linkTimeout   = 5 * time.Minute
+	mirrorTimeout = 3 * time.Minute
+	waitInterval  = 5 * time.Second
+)
+
+// WaitForLink waits until the cluster mirrors the services of the remote cluster: the Link exists,
+// its service mirror runs and the service mirror reports the gateway of the remote cluster alive.
+// On timeout the error names the step that is not ready.
+func WaitForLink(c kube.Cluster, remote string, timeout time.Duration) error {
+	logger.Info(fmt.Sprintf("Waiting for the link of %s cluster to %s cluster", c.Name, remote))
+	var problem string
+	err := wait.PollUntilContextTimeout(context.TODO(), waitInterval, timeout, true, func(ctx context.Context) (bool, error) {
+		problem = linkProblem(c, remote)
+		return problem == "", nil
+	})
+	if err != nil {
+		return fmt.Errorf("link of %s cluster to %s cluster not ready after %s: %s", c.Name, remote, timeout, problem)
+	}
+	return nil
+}
+
+// WaitForMirror waits until the mirror <name>-<remote> of an exported service exists in the cluster
+// and has ready endpoints. On timeout the error names the step that is not ready.
+func WaitForMirror(c kube.Cluster, name, namespace, remote string, timeout time.Duration) error {
+	mirror := name + "-" + remote
+	logger.Info(fmt.Sprintf("Waiting for mirror service %s/%s in %s cluster", namespace, mirror, c.Name))
+	var problem string
+	err := wait.PollUntilContextTimeout(context.TODO(), waitInterval, timeout, true, func(ctx context.Context) (bool, error) {
+		problem = mirrorProblem(c, mirror, namespace, remote)
+		return problem == "", nil
+	})
+	if err != nil {
+		if link := linkProblem(c, remote); link != "" {
+			problem = link
+		}
+		return fmt.Errorf("mirror service %s/%s not ready after %s: %s", namespace, mirror, timeout, problem)
+	}
+	return nil
+}
+
+// WaitForMirrorService waits until the mirror <name>-<remote> of an exported service exists in the
+// cluster. Unlike WaitForMirror it does not wait for endpoints, exported services may have no ready pods.
+func WaitForMirrorService(c kube.Cluster, name, namespace, remote string, timeout time.Duration) error {
+	mirror := name + "-" + remote
+	logger.Info(fmt.Sprintf("Waiting for mirror service %s/%s in %s cluster", namespace, mirror, c.Name))
+	err := kube.WaitForService(c, mirror, namespace, timeout)
+	if err != nil {
+		if link := linkProblem(c, remote); link != "" {
+			return fmt.Errorf("%v: %s", err, link)
+		}
+		return fmt.Errorf("%v: check that the service is exported in %s cluster", err, remote)
+	}
+	return nil
+}
+
+// linkProblem returns why the link to the remote cluster is not ready, or an empty string
+func linkProblem(c kube.Cluster, remote string) string {
+	links, err := c.ListCustomResources(schema.GroupKind{Group: "multicluster.linkerd.io", Kind: "Link"}, constants.LinkerdMultiClusterNamespace)
+	if err != nil {
+		return fmt.Sprintf("links are not readable (%v), check that linkerd-multicluster is installed", err)
+	}
+	found := false
+	for _, link := range links {
+		name, _, _ := unstructured.NestedString(link, "metadata", "name")
+		target, _, _ := unstructured.NestedString(link, "spec", "targetClusterName")
+		found = found || name == remote || target == remote
+	}
+	if !found {
+		return fmt.Sprintf("the Link to %s does not exist in namespace %s", remote, constants.LinkerdMultiClusterNamespace)
+	}
+
+	mirror := "linkerd-service-mirror-" + remote
+	deploymentInterface, err := c.FetchResource(kube.Deployment, mirror, constants.LinkerdMultiClusterNamespace)
+	if err != nil {
+		return fmt.Sprintf("the service mirror %s does not exist", mirror)
+	}
+	if deploymentInterface.(*appsv1.Deployment).Status.AvailableReplicas < 1 {
+		return fmt.Sprintf("the service mirror %s is not available, check its logs, the credentials of the remote API server may be invalid", mirror)
+	}
+
+	probe := "probe-gateway-" + remote
+	ready, err := readyEndpoints(c, probe, constants.LinkerdMultiClusterNamespace)
+	if err != nil || !ready {
+		return fmt.Sprintf("the service mirror reports the gateway of %s unreachable through %s, check that the gateway address is reachable on ports 4143 and 4191", remote, probe)
+	}
+	return ""
+}
+
+// mirrorProblem returns why the mirror service is not ready, or an empty string
+func mirrorProblem(c kube.Cluster, mirror, namespace, remote string) string {
+	if _, err := c.FetchResource(kube.Service, mirror, namespace); err != nil {
+		return fmt.Sprintf("the service mirror did not create %s, check that the service is exported in %s cluster", mirror, remote)
+	}
+	ready, err := readyEndpoints(c, mirror, namespace)
+	if err != nil || !ready {
+		return fmt.Sprintf("%s has no ready endpoints, check that the exported service has ready pods in %s cluster", mirror, remote)
+	}
+	return ""
+}
+
+// readyEndpoints reports whether the service has at least one ready endpoint
+func readyEndpoints(c kube.Cluster, name, namespace string) (bool, error) {
+	slices, err := c.Clientset.DiscoveryV1().EndpointSlices(namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: discoveryv1.LabelServiceName + "=" + name,
+	})
+	if err != nil {
+		return false, err
+	}
+	for _, slice := range slices.Items {
+		for _, endpoint := range slice.Endpoints {
+			if endpoint.Conditions.Ready == nil || *endpoint.Conditions.Ready {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
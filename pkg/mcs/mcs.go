@@ -0,0 +1,131 @@
+package mcs
+
+import (
+	"clustershift/internal/exit"
+	"clustershift/internal/kube"
+	"clustershift/internal/logger"
+	"context"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// clusterProperty names the ClusterProperty holding the ID of the cluster in the clusterset
+const clusterProperty = "cluster.clusterset.k8s.io"
+
+var (
+	serviceExportResource   = schema.GroupVersionResource{Group: "multicluster.x-k8s.io", Version: "v1alpha1", Resource: "serviceexports"}
+	serviceImportResource   = schema.GroupVersionResource{Group: "multicluster.x-k8s.io", Version: "v1alpha1", Resource: "serviceimports"}
+	clusterPropertyResource = schema.GroupVersionResource{Group: "about.k8s.io", Version: "v1alpha1", Resource: "clusterproperties"}
+)
+
+// clusterIDs are the clusterset IDs of the clusters, keyed by cluster name
+var clusterIDs = make(map[string]string)
+
+// Install checks that both clusters run an implementation of the Multi-Cluster Services API, such as
+// GKE multi-cluster services or a stand-in controller. The implementation joins the clusters to a
+// clusterset, clustershift only exports services.
+func Install(c kube.Clusters) {
+	for _, cluster := range []kube.Cluster{c.Origin, c.Target} {
+		_, err := cluster.DynamicClientset.Resource(serviceExportResource).Namespace(v1.NamespaceDefault).List(context.TODO(), metav1.ListOptions{})
+		exit.OnErrorWithMessage(err, fmt.Sprintf("The Multi-Cluster Services API is not available in %s cluster, install an implementation first", cluster.Name))
+
+		clusterIDs[cluster.Name] = readClusterID(cluster)
+		logger.Info(fmt.Sprintf("%s cluster is member of the clusterset as %s", cluster.Name, ClusterID(cluster.Name)))
+	}
+}
+
+// ClusterID returns the clusterset ID of the cluster, which names it in per-pod DNS names
+func ClusterID(name string) string {
+	if id, ok := clusterIDs[name]; ok {
+		return id
+	}
+	return name
+}
+
+// readClusterID returns the ID of the cluster property, or the cluster name if the implementation does not set it
+func readClusterID(c kube.Cluster) string {
+	property, err := c.DynamicClientset.Resource(clusterPropertyResource).Get(context.TODO(), clusterProperty, metav1.GetOptions{})
+	if err != nil {
+		return c.Name
+	}
+	id, _, _ := unstructured.NestedString(property.Object, "spec", "value")
+	if id == "" {
+		return c.Name
+	}
+	return id
+}
+
+// Export exports the service as <service>-<cluster>, the suffix keeps the services of both clusters
+// apart as the clusterset merges exports of the same name. Headless services get an alias listing
+// the pods with their hostnames for per-pod DNS names.
+func Export(c kube.Cluster, namespace, name string) error {
+	logger.Info(fmt.Sprintf("Exporting service %s/%s of %s cluster", namespace, name, c.Name))
+
+	serviceInterface, err := c.FetchResource(kube.Service, name, namespace)
+	if err != nil {
+		return fmt.Errorf("failed to fetch service %s/%s: %w", namespace, name, err)
+	}
+	service := serviceInterface.(*v1.Service)
+
+	alias := name + "-" + c.Name
+	if service.Spec.ClusterIP == v1.ClusterIPNone {
+		if err := kube.MirrorHeadlessService(c, c, service, alias, nil); err != nil {
+			return fmt.Errorf("failed to mirror endpoints of service %s/%s: %w", namespace, name, err)
+		}
+	} else {
+		err = c.CreateResource(kube.Service, namespace, kube.AliasService(service, alias, service.Spec.Selector, nil))
+		if err != nil && !k8serrors.IsAlreadyExists(err) {
+			return fmt.Errorf("failed to create service %s/%s: %w", namespace, alias, err)
+		}
+	}
+
+	err = c.CreateCustomResource(namespace, map[string]interface{}{
+		"apiVersion": serviceExportResource.GroupVersion().String(),
+		"kind":       "ServiceExport",
+		"metadata": map[string]interface{}{
+			"name":      alias,
+			"namespace": namespace,
+		},
+	})
+	if err != nil && !k8serrors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to export service %s/%s: %w", namespace, alias, err)
+	}
+	return nil
+}
+
+// Unexport removes the export and the alias of the service
+func Unexport(c kube.Cluster, namespace, name string) error {
+	alias := name + "-" + c.Name
+	err := c.DynamicClientset.Resource(serviceExportResource).Namespace(namespace).Delete(context.TODO(), alias, metav1.DeleteOptions{})
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return err
+	}
+	return kube.DeleteAlias(c, alias, namespace)
+}
+
+// WaitForImport waits until the cluster imports the service exported by the remote cluster. The
+// ServiceImport of a ClusterSetIP service is usable once the implementation assigned its IPs.
+func WaitForImport(c kube.Cluster, namespace, name, remote string, timeout time.Duration) error {
+	alias := name + "-" + remote
+	logger.Info(fmt.Sprintf("Waiting for ServiceImport %s/%s in %s cluster", namespace, alias, c.Name))
+	err := wait.PollUntilContextTimeout(context.TODO(), 5*time.Second, timeout, true, func(ctx context.Context) (bool, error) {
+		serviceImport, err := c.DynamicClientset.Resource(serviceImportResource).Namespace(namespace).Get(ctx, alias, metav1.GetOptions{})
+		if err != nil {
+			return false, nil
+		}
+		importType, _, _ := unstructured.NestedString(serviceImport.Object, "spec", "type")
+		ips, _, _ := unstructured.NestedStringSlice(serviceImport.Object, "spec", "ips")
+		return importType == "Headless" || len(ips) > 0, nil
+	})
+	if err != nil {
+		return fmt.Errorf("ServiceImport %s/%s not ready in %s cluster after %s, check the ServiceExport conditions in %s cluster", namespace, alias, c.Name, timeout, remote)
+	}
+	return nil
+}
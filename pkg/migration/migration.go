@@ -8,18 +8,28 @@ import (
 	"clustershift/internal/logger"
 	migration2 "clustershift/internal/migration"
 	"clustershift/internal/prompt"
+	"clustershift/pkg/cilium"
 	"clustershift/pkg/connectivity"
 	"clustershift/pkg/database/cnpg"
 	mongooperator "clustershift/pkg/database/mongo/operator"
 	mongostateful "clustershift/pkg/database/mongo/statefulset"
 	"clustershift/pkg/database/postgres"
+	"clustershift/pkg/direct"
+	"clustershift/pkg/health"
+	"clustershift/pkg/istio"
 	"clustershift/pkg/linkerd"
+	"clustershift/pkg/preflight"
 	"clustershift/pkg/redirect"
 	"clustershift/pkg/skupper"
+	"clustershift/pkg/submariner"
 	"fmt"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"time"
 )
 
+const networkingHealthTimeout = 5 * time.Minute
+
 var clusters kube.Clusters
 var resources migration2.Resources
 
@@ -28,6 +38,7 @@ func Migrate(kubeconfigOrigin string, kubeconfigTarget string, opts prompt.Migra
 
 	logger.Info("Establishing secure connection between clusters")
 	resources.InstallNetworkingTool(clusters)
+	health.Gate(clusters, opts.NetworkingTool, networkingHealthTimeout)
 	migrateConfigurationResources()
 
 	if opts.Rerouting == prompt.ReroutingSkupper {
@@ -38,11 +49,20 @@ func Migrate(kubeconfigOrigin string, kubeconfigTarget string, opts prompt.Migra
 		handleLinkerdRerouting()
 	}
 
+	if opts.Rerouting == prompt.ReroutingIstio {
+		handleIstioRerouting()
+	}
+
 	migrateDatabases(resources, opts)
 	migrateKubernetesResources()
-	cnpg.DemoteOriginCluster(clusters.Origin)
+	cnpg.DemoteOriginCluster(clusters, resources)
 	cnpg.DisableReplication(clusters.Target)
+	if opts.NetworkingTool == prompt.NetworkingToolDirect {
+		// The target cluster no longer replicates from the origin, only its own services stay exposed
+		exit.OnErrorWithMessage(direct.Cleanup(clusters.Origin), "Failed to remove the services exposing the origin cluster")
+	}
 	redirect.EnableRequestForwarding(clusters, opts, resources)
+	reportMirroring(opts.Mirror)
 }
 
 func handleLinkerdRerouting() {
@@ -70,6 +90,24 @@ func handleLinkerdRerouting() {
 	}
 }
 
+func handleIstioRerouting() {
+	namespaces, err := clusters.Target.FetchResources(kube.Namespace)
+	exit.OnErrorWithMessage(err, "Failed to fetch namespaces from target cluster")
+	namespaceList, ok := namespaces.(*v1.NamespaceList)
+	if !ok {
+		exit.OnErrorWithMessage(fmt.Errorf("failed to convert to NamespaceList"), "Type assertion failed")
+	}
+
+	targetNamespaces := []string{"postgres", "mongodb", "benchmark"}
+	validNamespaces := filterSpecificNamespaces(namespaceList.Items, targetNamespaces)
+	logger.Info(fmt.Sprintf("Number of valid namespaces found: %d", len(validNamespaces)))
+
+	for _, namespace := range validNamespaces {
+		err = istio.EnableInjection(clusters.Target, namespace.Name)
+		exit.OnErrorWithMessage(err, "Failed to enable sidecar injection in namespace "+namespace.Name)
+	}
+}
+
 func handleSkupperRerouting() {
 	logger.Info("Entering Skupper rerouting section")
 
@@ -100,21 +138,75 @@ func handleSkupperRerouting() {
 func prepareMigration(kubeconfigOrigin string, kubeconfigTarget string, opts prompt.MigrationOptions) {
 	initClusters(kubeconfigOrigin, kubeconfigTarget)
 
+	if !opts.SkipPreflight {
+		report := preflight.RunChecks(clusters)
+		report.Print()
+		if report.HasFailures() {
+			exit.OnErrorWithMessage(fmt.Errorf("preflight checks failed"), "Resolve the failed checks or rerun with --skip-preflight")
+		}
+	}
+
 	var err error
+	submariner.Overrides = opts.Network
+	submariner.Profile = opts.Submariner
+	if opts.NetworkingTool == prompt.NetworkingToolSubmariner {
+		exit.OnErrorWithMessage(submariner.ValidateProfile(clusters), "Submariner profile does not fit the clusters")
+	}
+	if opts.Rollout.MaxConcurrent < 0 {
+		exit.OnErrorWithMessage(fmt.Errorf("rollout concurrency must not be negative"), "Invalid --rollout-concurrency")
+	}
+	linkerd.Rollout = opts.Rollout
+	if opts.NetworkingTool == prompt.NetworkingToolLinkerd {
+		linkerd.Certs = opts.LinkerdCerts
+		exit.OnErrorWithMessage(linkerd.ValidateCerts(), "Invalid Linkerd certificate options")
+		exit.OnErrorWithMessage(linkerd.Validate(clusters), "Existing Linkerd installations cannot be linked")
+	}
+	if opts.NetworkingTool == prompt.NetworkingToolCilium {
+		exit.OnErrorWithMessage(cilium.Validate(clusters), "Cilium ClusterMesh is not possible between the clusters")
+	}
+	switch opts.SkupperVersion {
+	case 0:
+	case skupper.V1, skupper.V2:
+		skupper.Preferred = opts.SkupperVersion
+	default:
+		exit.OnErrorWithMessage(fmt.Errorf("unknown Skupper version %d", opts.SkupperVersion), "Invalid --skupper-version, use 1 or 2")
+	}
+	if opts.NetworkingTool == prompt.NetworkingToolSkupper {
+		exit.OnErrorWithMessage(skupper.Validate(clusters), "Existing Skupper installations cannot be linked")
+	}
+	if opts.IstioMode != "" {
+		exit.OnErrorWithMessage(istio.ValidateMode(opts.IstioMode), "Invalid --istio-mode")
+		istio.Mode = opts.IstioMode
+	}
+	if opts.DirectExposure != "" {
+		exit.OnErrorWithMessage(direct.ValidateExposure(opts.DirectExposure), "Invalid --direct-exposure")
+		direct.Exposure = opts.DirectExposure
+	}
+	exit.OnErrorWithMessage(redirect.ValidateShift(opts.Shift), "Invalid --shift-steps or --shift-max-error-rate")
+	if len(opts.Shift.Steps) > 0 && opts.Rerouting == prompt.ReroutingLinkerd {
+		// The rerouting middleware overrides the destination of every request, the weights would be ignored
+		exit.OnErrorWithMessage(fmt.Errorf("rerouting with Linkerd switches all requests at once"), "Unsupported --shift-steps")
+	}
+	exit.OnErrorWithMessage(redirect.ValidateMirror(opts.Mirror), "Invalid --mirror-percent")
+	if opts.Rerouting == prompt.ReroutingIstio && opts.NetworkingTool != prompt.NetworkingToolIstio {
+		exit.OnErrorWithMessage(fmt.Errorf("rerouting with Istio requires Istio as networking tool"), "Unsupported rerouting option")
+	}
 	resources, err = migration2.GetMigrationResources(opts.NetworkingTool)
 	exit.OnErrorWithMessage(err, "Unsupported networking tool")
 	clusters.Origin.CreateNewNamespace("clustershift")
 	clusters.Target.CreateNewNamespace("clustershift")
 	connectivity.RunClusterConnectivityProbe(clusters)
 	if opts.Rerouting == prompt.ReroutingClustershift {
+		redirect.Upstreams = opts.ProxyUpstreams
+		redirect.DefaultUpstream = opts.ProxyDefault
 		redirect.InitializeRequestForwarding(clusters)
 	}
 }
 
 func migrateDatabases(resources migration2.Resources, opts prompt.MigrationOptions) {
-	cnpg.Migrate(clusters, resources, opts)
+	cnpg.Migrate(clusters, resources)
 	mongostateful.Migrate(clusters, resources)
-	mongooperator.Migrate(clusters, resources, opts)
+	mongooperator.Migrate(clusters, resources)
 	postgres.Migrate(clusters, resources)
 }
 
@@ -128,6 +220,50 @@ func migrateKubernetesResources() {
 	clusters.CreateResourceDiff(kube.IngressRouteUDP)
 	clusters.CreateResourceDiff(kube.Middleware)
 	clusters.CreateResourceDiff(kube.TraefikService)
+	for _, kind := range convertedKinds {
+		err := clusters.CreateConvertedResourceDiff(kind)
+		exit.OnErrorWithMessage(err, fmt.Sprintf("Failed to migrate %s", kind.Kind))
+	}
+	reportConversionIssues()
+}
+
+// convertedKinds are copied in a version served by the target cluster, converting removed versions
+var convertedKinds = []schema.GroupKind{
+	{Group: "policy", Kind: "PodDisruptionBudget"},
+	{Group: "batch", Kind: "CronJob"},
+	{Group: "autoscaling", Kind: "HorizontalPodAutoscaler"},
+}
+
+func reportConversionIssues() {
+	for _, issue := range kube.ConversionIssues() {
+		object := fmt.Sprintf("%s %s/%s", issue.Kind, issue.Namespace, issue.Name)
+		if issue.Skipped {
+			logger.Warning("Skipped "+object+", it has to be migrated manually", fmt.Errorf("%s", issue.Reason))
+		} else {
+			logger.Warning("Converted "+object+" with changes", fmt.Errorf("%s", issue.Reason))
+		}
+	}
+}
+
+// reportMirroring logs how the target cluster handled the requests mirrored before the cutover
+func reportMirroring(opts prompt.MirrorOptions) {
+	results := redirect.MirrorResults()
+	if len(results) == 0 {
+		return
+	}
+	if opts.Writes {
+		logger.Info("Mirrored traffic handled by target cluster, writes among the requests were applied to its databases:")
+	} else {
+		logger.Info("Mirrored GET and HEAD requests handled by target cluster:")
+	}
+	for _, result := range results {
+		line := fmt.Sprintf("%s/%s: %d requests, %d server errors, mean latency %s", result.Namespace, result.Service, result.Requests, result.Errors, result.MeanLatency.Round(time.Millisecond))
+		if result.Errors > 0 {
+			logger.Warning(line, fmt.Errorf("target cluster answered mirrored requests with server errors"))
+		} else {
+			logger.Info(line)
+		}
+	}
 }
 
 func migrateConfigurationResources() {
@@ -166,6 +302,7 @@ func filterValidNamespaces(namespaces []v1.Namespace) []v1.Namespace {
 		"connectivity-probe":      true,
 		"linkerd":                 true,
 		"linkerd-multicluster":    true,
+		"istio-system":            true,
 		"traefik":                 true,
 	}
 
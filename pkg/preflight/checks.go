@@ -0,0 +1,406 @@
+package preflight
+
+import (
+	"clustershift/internal/constants"
+	"clustershift/internal/kube"
+	"clustershift/pkg/capacity"
+	"clustershift/pkg/database/cnpg"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// requiredAccess lists the API access clustershift needs on both clusters
+var requiredAccess = []authorizationv1.ResourceAttributes{
+	{Verb: "create", Resource: "namespaces"},
+	{Verb: "list", Resource: "namespaces"},
+	{Verb: "patch", Resource: "namespaces"},
+	{Verb: "list", Resource: "nodes"},
+	{Verb: "patch", Resource: "nodes"},
+	{Verb: "create", Resource: "configmaps"},
+	{Verb: "list", Resource: "configmaps"},
+	{Verb: "create", Resource: "secrets"},
+	{Verb: "list", Resource: "secrets"},
+	{Verb: "update", Resource: "secrets"},
+	{Verb: "create", Resource: "services"},
+	{Verb: "list", Resource: "services"},
+	{Verb: "patch", Resource: "services"},
+	{Verb: "create", Resource: "serviceaccounts"},
+	{Verb: "list", Resource: "serviceaccounts"},
+	{Verb: "create", Resource: "pods"},
+	{Verb: "list", Resource: "pods"},
+	{Verb: "create", Resource: "pods", Subresource: "exec"},
+	{Verb: "get", Resource: "pods", Subresource: "log"},
+	{Verb: "create", Group: "apps", Resource: "deployments"},
+	{Verb: "update", Group: "apps", Resource: "deployments"},
+	{Verb: "create", Group: "apps", Resource: "statefulsets"},
+	{Verb: "update", Group: "apps", Resource: "statefulsets"},
+	{Verb: "get", Group: "batch", Resource: "jobs"},
+	{Verb: "create", Group: "rbac.authorization.k8s.io", Resource: "clusterroles"},
+	{Verb: "create", Group: "rbac.authorization.k8s.io", Resource: "clusterrolebindings"},
+	{Verb: "create", Group: "apiextensions.k8s.io", Resource: "customresourcedefinitions"},
+	{Verb: "create", Group: "networking.k8s.io", Resource: "ingresses"},
+}
+
+// migratedKinds lists the kinds copied by the migration, each with the groups it may be served as
+var migratedKinds = [][]schema.GroupKind{
+	{{Group: "networking.k8s.io", Kind: "Ingress"}, {Group: "extensions", Kind: "Ingress"}},
+	{{Group: "traefik.io", Kind: "IngressRoute"}, {Group: "traefik.containo.us", Kind: "IngressRoute"}},
+	{{Group: "traefik.io", Kind: "IngressRouteTCP"}, {Group: "traefik.containo.us", Kind: "IngressRouteTCP"}},
+	{{Group: "traefik.io", Kind: "IngressRouteUDP"}, {Group: "traefik.containo.us", Kind: "IngressRouteUDP"}},
+	{{Group: "traefik.io", Kind: "Middleware"}, {Group: "traefik.containo.us", Kind: "Middleware"}},
+	{{Group: "traefik.io", Kind: "TraefikService"}, {Group: "traefik.containo.us", Kind: "TraefikService"}},
+}
+
+// conflictingNamespaces are namespaces of components clustershift installs itself
+var conflictingNamespaces = []string{
+	constants.LinkerdNamespace,
+	constants.LinkerdMultiClusterNamespace,
+	constants.SubmarinerOperatorNamespace,
+	constants.SubmarinerBrokerNamespace,
+	constants.SkupperSiteControllerNamespace,
+	constants.ConnectivityProbeNamespace,
+}
+
+func forEachCluster(clusters kube.Clusters, run func(c kube.Cluster) []Result) []Result {
+	var results []Result
+	results = append(results, run(clusters.Origin)...)
+	results = append(results, run(clusters.Target)...)
+	return results
+}
+
+func checkPermissions(clusters kube.Clusters) []Result {
+	return forEachCluster(clusters, func(c kube.Cluster) []Result {
+		var denied []string
+		for _, attributes := range requiredAccess {
+			review := &authorizationv1.SelfSubjectAccessReview{
+				Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+					ResourceAttributes: &attributes,
+				},
+			}
+			response, err := c.Clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(context.TODO(), review, metav1.CreateOptions{})
+			if err != nil {
+				return []Result{fail(c.Name, "unable to review access: %v", err)}
+			}
+			if !response.Status.Allowed {
+				denied = append(denied, describeAccess(attributes))
+			}
+		}
+
+		if len(denied) > 0 {
+			return []Result{fail(c.Name, "missing permissions: %s", joinOrNone(denied))}
+		}
+		return []Result{pass(c.Name, "all %d required permissions granted", len(requiredAccess))}
+	})
+}
+
+func describeAccess(attributes authorizationv1.ResourceAttributes) string {
+	resourceName := attributes.Resource
+	if attributes.Subresource != "" {
+		resourceName += "/" + attributes.Subresource
+	}
+	if attributes.Group != "" {
+		resourceName += "." + attributes.Group
+	}
+	return attributes.Verb + " " + resourceName
+}
+
+func checkLoadBalancer(clusters kube.Clusters) []Result {
+	return forEachCluster(clusters, func(c kube.Cluster) []Result {
+		services, err := c.Clientset.CoreV1().Services("").List(context.TODO(), metav1.ListOptions{})
+		if err != nil {
+			return []Result{fail(c.Name, "unable to list services: %v", err)}
+		}
+
+		for _, service := range services.Items {
+			if service.Spec.Type != v1.ServiceTypeLoadBalancer {
+				continue
+			}
+			for _, ingress := range service.Status.LoadBalancer.Ingress {
+				if ingress.IP != "" || ingress.Hostname != "" {
+					return []Result{pass(c.Name, "LoadBalancer address available on %s/%s", service.Namespace, service.Name)}
+				}
+			}
+		}
+		return []Result{warn(c.Name, "no LoadBalancer service with an external address found; Linkerd gateways and Clustershift rerouting need one")}
+	})
+}
+
+func checkNodeRoles(clusters kube.Clusters) []Result {
+	return forEachCluster(clusters, func(c kube.Cluster) []Result {
+		var results []Result
+		for _, selection := range []struct {
+			role      string
+			selection kube.NodeSelection
+		}{
+			{"probe", kube.ProbeNodes},
+			{"gateway", kube.GatewayNodes},
+		} {
+			nodes, err := c.SelectNodes(selection.selection)
+			if err != nil {
+				results = append(results, fail(c.Name, "no %s nodes: %v", selection.role, err))
+				continue
+			}
+			var names []string
+			for _, node := range nodes {
+				names = append(names, fmt.Sprintf("%s (%s)", node.Name, kube.NodeAddress(node)))
+			}
+			results = append(results, pass(c.Name, "%s nodes (%s): %s", selection.role, selection.selection, joinOrNone(names)))
+		}
+		return results
+	})
+}
+
+func checkKubernetesVersions(clusters kube.Clusters) []Result {
+	originVersion, err := clusters.Origin.DiscoveryClientset.ServerVersion()
+	if err != nil {
+		return []Result{fail(clusters.Origin.Name, "unable to fetch server version: %v", err)}
+	}
+	targetVersion, err := clusters.Target.DiscoveryClientset.ServerVersion()
+	if err != nil {
+		return []Result{fail(clusters.Target.Name, "unable to fetch server version: %v", err)}
+	}
+
+	originMinor := parseMinor(originVersion.Minor)
+	targetMinor := parseMinor(targetVersion.Minor)
+	versions := fmt.Sprintf("origin %s, target %s", originVersion.GitVersion, targetVersion.GitVersion)
+
+	switch {
+	case originVersion.Major != targetVersion.Major:
+		return []Result{fail("both", "major versions differ: %s", versions)}
+	case targetMinor < originMinor:
+		return []Result{warn("both", "target is older than origin, newer API versions may be rejected: %s", versions)}
+	case targetMinor-originMinor > 2:
+		return []Result{warn("both", "version skew larger than two minor versions, deprecated APIs may be removed: %s", versions)}
+	default:
+		return []Result{pass("both", "%s", versions)}
+	}
+}
+
+func parseMinor(minor string) int {
+	value, err := strconv.Atoi(strings.TrimSuffix(minor, "+"))
+	if err != nil {
+		return 0
+	}
+	return value
+}
+
+func checkRequiredPorts(clusters kube.Clusters) []Result {
+	return forEachCluster(clusters, func(c kube.Cluster) []Result {
+		endpoint, err := c.FetchKubernetesAPIEndpoint()
+		if err != nil {
+			return []Result{fail(c.Name, "unable to determine API server endpoint: %v", err)}
+		}
+
+		conn, err := net.DialTimeout("tcp", endpoint, 5*time.Second)
+		if err != nil {
+			return []Result{warn(c.Name, "API server endpoint %s used by the broker and cluster links is not reachable from this host: %v", endpoint, err)}
+		}
+		conn.Close()
+		return []Result{pass(c.Name, "API server endpoint %s reachable", endpoint)}
+	})
+}
+
+func checkCIDROverlap(clusters kube.Clusters) []Result {
+	var results []Result
+	var cidrs [][]string
+	for _, c := range []kube.Cluster{clusters.Origin, clusters.Target} {
+		podCIDR, err := c.DiscoverPodCIDR()
+		if err != nil {
+			results = append(results, warn(c.Name, "%v, pass it with --%s-pod-cidr", err, c.Name))
+			continue
+		}
+		serviceCIDR, err := c.DiscoverServiceCIDR()
+		if err != nil {
+			results = append(results, warn(c.Name, "%v, pass it with --%s-service-cidr", err, c.Name))
+			continue
+		}
+		cidrs = append(cidrs, []string{podCIDR.CIDR, serviceCIDR.CIDR})
+		results = append(results, pass(c.Name, "pod CIDR %s (%s), service CIDR %s (%s)", podCIDR.CIDR, podCIDR.Source, serviceCIDR.CIDR, serviceCIDR.Source))
+	}
+
+	if len(cidrs) == 2 {
+		for _, origin := range cidrs[0] {
+			for _, target := range cidrs[1] {
+				if kube.CIDRsOverlap(origin, target) {
+					results = append(results, warn("both", "CIDRs %s and %s overlap; Submariner will enable globalnet", origin, target))
+				}
+			}
+		}
+	}
+	return results
+}
+
+func checkExistingInstallations(clusters kube.Clusters) []Result {
+	return forEachCluster(clusters, func(c kube.Cluster) []Result {
+		var existing []string
+		for _, namespace := range conflictingNamespaces {
+			_, err := c.Clientset.CoreV1().Namespaces().Get(context.TODO(), namespace, metav1.GetOptions{})
+			if err == nil {
+				existing = append(existing, namespace)
+			} else if !k8serrors.IsNotFound(err) {
+				return []Result{fail(c.Name, "unable to check namespace %s: %v", namespace, err)}
+			}
+		}
+
+		if len(existing) > 0 {
+			return []Result{warn(c.Name, "existing installations found in namespaces: %s", joinOrNone(existing))}
+		}
+		return []Result{pass(c.Name, "no conflicting installations found")}
+	})
+}
+
+// checkAPIVersions verifies that migrated kinds served by the origin can be converted
+// to a version served by the target cluster
+func checkAPIVersions(clusters kube.Clusters) []Result {
+	originVersions, err := clusters.Origin.FetchAPIVersions()
+	if err != nil {
+		return []Result{fail(clusters.Origin.Name, "%v", err)}
+	}
+	targetVersions, err := clusters.Target.FetchAPIVersions()
+	if err != nil {
+		return []Result{fail(clusters.Target.Name, "%v", err)}
+	}
+
+	var converted []string
+	var results []Result
+	for _, candidates := range migratedKinds {
+		for _, candidate := range candidates {
+			gvk, served := originVersions.Preferred(candidate)
+			if !served {
+				continue
+			}
+			if !kube.ConvertibleTo(gvk, targetVersions) {
+				results = append(results, warn(clusters.Target.Name, "%s is not served and cannot be converted automatically", gvk.String()))
+			} else if !targetVersions.Serves(gvk) {
+				converted = append(converted, gvk.GroupVersion().String()+"/"+gvk.Kind)
+			}
+			break
+		}
+	}
+	if len(results) > 0 {
+		return results
+	}
+	return []Result{pass("both", "conversions required: %s", joinOrNone(converted))}
+}
+
+func checkCNPGVersion(clusters kube.Clusters) []Result {
+	c := clusters.Origin
+	url, err := cnpg.OperatorManifestURL(c)
+	if k8serrors.IsNotFound(err) {
+		return []Result{pass(c.Name, "CloudNativePG operator not installed")}
+	}
+	if err != nil {
+		return []Result{fail(c.Name, "unable to determine CloudNativePG release: %v", err)}
+	}
+
+	client := http.Client{Timeout: 10 * time.Second}
+	response, err := client.Head(url)
+	if err != nil {
+		return []Result{warn(c.Name, "unable to reach %s: %v", url, err)}
+	}
+	response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return []Result{fail(c.Name, "CloudNativePG release manifest %s not available upstream (HTTP %d)", url, response.StatusCode)}
+	}
+	return []Result{pass(c.Name, "CloudNativePG release manifest available")}
+}
+
+func checkCapacity(clusters kube.Clusters) []Result {
+	analysis, err := capacity.Analyze(clusters)
+	if err != nil {
+		return []Result{fail(clusters.Target.Name, "unable to analyze capacity: %v", err)}
+	}
+
+	var results []Result
+	for _, finding := range analysis.Findings {
+		message := finding.Message
+		if finding.Workload != "" {
+			message = fmt.Sprintf("%s: %s", finding.Workload, finding.Message)
+		}
+		if finding.Blocking {
+			results = append(results, fail(clusters.Target.Name, "%s", message))
+		} else {
+			results = append(results, warn(clusters.Target.Name, "%s", message))
+		}
+	}
+
+	if len(results) > 0 {
+		return results
+	}
+
+	required := analysis.Required
+	return []Result{pass(clusters.Target.Name, "%d workloads requesting cpu %s, memory %s, storage %s and %d pods fit on the target nodes",
+		len(analysis.Workloads), required.CPU.String(), required.Memory.String(), required.Storage.String(), required.Pods)}
+}
+
+// imagePullReasons are the waiting reasons of a container whose image cannot be pulled
+var imagePullReasons = map[string]bool{"ErrImagePull": true, "ImagePullBackOff": true, "InvalidImageName": true}
+
+// checkImage starts a pod running the clustershift image in each cluster, the probe, resolve pod and
+// proxy depend on it
+func checkImage(clusters kube.Clusters) []Result {
+	return forEachCluster(clusters, func(c kube.Cluster) []Result {
+		namespace := constants.HttpProxyNamespace
+		name := "clustershift-image-check"
+		c.CreateNewNamespace(namespace)
+		pods := c.Clientset.CoreV1().Pods(namespace)
+		_ = pods.Delete(context.TODO(), name, metav1.DeleteOptions{})
+		defer pods.Delete(context.TODO(), name, metav1.DeleteOptions{})
+
+		pod := &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec: v1.PodSpec{
+				RestartPolicy: v1.RestartPolicyNever,
+				Containers: []v1.Container{{
+					Name:    "check",
+					Image:   constants.ClustershiftImage,
+					Command: []string{"/clustershift", "--version"},
+				}},
+			},
+		}
+		var err error
+		for attempt := 0; attempt < 10; attempt++ {
+			// The pod of an earlier check may still be terminating
+			if _, err = pods.Create(context.TODO(), pod, metav1.CreateOptions{}); !k8serrors.IsAlreadyExists(err) {
+				break
+			}
+			time.Sleep(2 * time.Second)
+		}
+		if err != nil {
+			return []Result{warn(c.Name, "unable to start a pod with image %s: %v", constants.ClustershiftImage, err)}
+		}
+
+		deadline := time.Now().Add(2 * time.Minute)
+		for time.Now().Before(deadline) {
+			current, err := pods.Get(context.TODO(), name, metav1.GetOptions{})
+			if err != nil {
+				return []Result{warn(c.Name, "unable to check the pod with image %s: %v", constants.ClustershiftImage, err)}
+			}
+			if current.Status.Phase == v1.PodSucceeded || current.Status.Phase == v1.PodFailed {
+				return []Result{pass(c.Name, "image %s can be pulled", constants.ClustershiftImage)}
+			}
+			for _, status := range current.Status.ContainerStatuses {
+				if status.State.Running != nil {
+					return []Result{pass(c.Name, "image %s can be pulled", constants.ClustershiftImage)}
+				}
+				if waiting := status.State.Waiting; waiting != nil && imagePullReasons[waiting.Reason] {
+					return []Result{fail(c.Name, "image %s cannot be pulled (%s), publish it or set --image to a mirror", constants.ClustershiftImage, waiting.Message)}
+				}
+			}
+			time.Sleep(3 * time.Second)
+		}
+		return []Result{warn(c.Name, "pod with image %s did not start within 2 minutes", constants.ClustershiftImage)}
+	})
+}
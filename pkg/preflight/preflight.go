@@ -0,0 +1,137 @@
+package preflight
+
+import (
+	"clustershift/internal/exit"
+	"clustershift/internal/kube"
+	"clustershift/internal/logger"
+	"fmt"
+	"strings"
+)
+
+// Status is the outcome of a single preflight check
+type Status string
+
+const (
+	StatusPass Status = "PASS"
+	StatusWarn Status = "WARN"
+	StatusFail Status = "FAIL"
+)
+
+// Result describes the outcome of a check against one cluster
+type Result struct {
+	Check   string
+	Cluster string
+	Status  Status
+	Message string
+}
+
+// Check is a single preflight check. Run receives both clusters so checks
+// can compare them, and returns one or more results.
+type Check struct {
+	Name string
+	Run  func(clusters kube.Clusters) []Result
+}
+
+// Report is the collection of all check results
+type Report struct {
+	Results []Result
+}
+
+var registeredChecks = []Check{
+	{Name: "RBAC", Run: checkPermissions},
+	{Name: "LoadBalancer", Run: checkLoadBalancer},
+	{Name: "Node roles", Run: checkNodeRoles},
+	{Name: "Kubernetes version", Run: checkKubernetesVersions},
+	{Name: "Required ports", Run: checkRequiredPorts},
+	{Name: "CIDR overlap", Run: checkCIDROverlap},
+	{Name: "Existing installations", Run: checkExistingInstallations},
+	{Name: "API versions", Run: checkAPIVersions},
+	{Name: "CNPG version", Run: checkCNPGVersion},
+	{Name: "Capacity", Run: checkCapacity},
+	{Name: "Clustershift image", Run: checkImage},
+}
+
+// Register adds a check to the set of checks executed by RunChecks
+func Register(check Check) {
+	registeredChecks = append(registeredChecks, check)
+}
+
+// Preflight runs all checks against the clusters of the given kubeconfigs and prints the report
+func Preflight(kubeconfigOrigin string, kubeconfigTarget string) {
+	clusters, err := kube.InitClients(kubeconfigOrigin, kubeconfigTarget)
+	exit.OnErrorWithMessage(err, "Error initializing kubernetes clients")
+
+	report := RunChecks(clusters)
+	report.Print()
+	if report.HasFailures() {
+		exit.OnErrorWithMessage(fmt.Errorf("%d checks failed", report.count(StatusFail)), "Preflight checks failed")
+	}
+}
+
+// RunChecks executes all registered checks against both clusters
+func RunChecks(clusters kube.Clusters) Report {
+	logger.Info("Running preflight checks")
+
+	var report Report
+	for _, check := range registeredChecks {
+		logger.Debug(fmt.Sprintf("Running preflight check %s", check.Name))
+		for _, result := range check.Run(clusters) {
+			if result.Check == "" {
+				result.Check = check.Name
+			}
+			report.Results = append(report.Results, result)
+		}
+	}
+	return report
+}
+
+// HasFailures reports whether at least one check failed
+func (r Report) HasFailures() bool {
+	return r.count(StatusFail) > 0
+}
+
+// Print writes the report to the console and the log file
+func (r Report) Print() {
+	for _, result := range r.Results {
+		line := fmt.Sprintf("%-6s %-24s %-8s %s", "["+string(result.Status)+"]", result.Check, result.Cluster, result.Message)
+		switch result.Status {
+		case StatusFail:
+			logger.Error(line, fmt.Errorf("check failed"))
+		case StatusWarn:
+			logger.Warning(line, fmt.Errorf("check needs attention"))
+		default:
+			logger.Info(line)
+		}
+	}
+	logger.Info(fmt.Sprintf("Preflight summary: %d passed, %d warnings, %d failed",
+		r.count(StatusPass), r.count(StatusWarn), r.count(StatusFail)))
+}
+
+func (r Report) count(status Status) int {
+	count := 0
+	for _, result := range r.Results {
+		if result.Status == status {
+			count++
+		}
+	}
+	return count
+}
+
+func pass(cluster, format string, args ...interface{}) Result {
+	return Result{Cluster: cluster, Status: StatusPass, Message: fmt.Sprintf(format, args...)}
+}
+
+func warn(cluster, format string, args ...interface{}) Result {
+	return Result{Cluster: cluster, Status: StatusWarn, Message: fmt.Sprintf(format, args...)}
+}
+
+func fail(cluster, format string, args ...interface{}) Result {
+	return Result{Cluster: cluster, Status: StatusFail, Message: fmt.Sprintf(format, args...)}
+}
+
+func joinOrNone(values []string) string {
+	if len(values) == 0 {
+		return "none"
+	}
+	return strings.Join(values, ", ")
+}
@@ -0,0 +1,86 @@
+package probe
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// check runs a single probe against a target and measures the round trip
+func check(target string, probe Probe) Result {
+	result := Result{Target: target, Protocol: probe.Protocol, Port: probe.Port}
+	address := net.JoinHostPort(target, strconv.Itoa(int(probe.Port)))
+
+	start := time.Now()
+	var err error
+	switch probe.Protocol {
+	case TCP:
+		err = checkTCP(address)
+	case UDP:
+		err = checkUDP(address)
+	case HTTP:
+		err = checkHTTP(address)
+	case TLS:
+		err = checkTLS(address)
+	default:
+		err = fmt.Errorf("unsupported protocol %s", probe.Protocol)
+	}
+
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Success = true
+	result.Latency = time.Since(start).Round(time.Microsecond).String()
+	return result
+}
+
+func checkTCP(address string) error {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// checkUDP expects an answer from the probe listener, as UDP has no handshake
+func checkUDP(address string) error {
+	conn, err := net.DialTimeout("udp", address, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		return err
+	}
+	buffer := make([]byte, 16)
+	if _, err := conn.Read(buffer); err != nil {
+		return fmt.Errorf("no answer: %w", err)
+	}
+	return nil
+}
+
+// checkHTTP succeeds on any HTTP response, so existing services can be probed as well
+func checkHTTP(address string) error {
+	client := http.Client{Timeout: timeout}
+	response, err := client.Get("http://" + address + "/healthz")
+	if err != nil {
+		return err
+	}
+	return response.Body.Close()
+}
+
+// checkTLS only verifies the handshake, the certificate of the peer is not trusted
+func checkTLS(address string) error {
+	dialer := &net.Dialer{Timeout: timeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", address, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
@@ -0,0 +1,121 @@
+package probe
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// startListeners serves the TCP, UDP, HTTP and TLS endpoints the other cluster probes
+func startListeners(latest *atomic.Pointer[Results]) error {
+	tcpListener, err := net.Listen("tcp", fmt.Sprintf(":%d", ListenerPorts[TCP]))
+	if err != nil {
+		return fmt.Errorf("failed to listen on tcp port: %w", err)
+	}
+	go serveBanner(tcpListener)
+
+	udpConn, err := net.ListenPacket("udp", fmt.Sprintf(":%d", ListenerPorts[UDP]))
+	if err != nil {
+		return fmt.Errorf("failed to listen on udp port: %w", err)
+	}
+	go serveUDP(udpConn)
+
+	certificate, err := selfSignedCertificate()
+	if err != nil {
+		return fmt.Errorf("failed to generate tls certificate: %w", err)
+	}
+	tlsListener, err := tls.Listen("tcp", fmt.Sprintf(":%d", ListenerPorts[TLS]), &tls.Config{Certificates: []tls.Certificate{certificate}})
+	if err != nil {
+		return fmt.Errorf("failed to listen on tls port: %w", err)
+	}
+	go serveBanner(tlsListener)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, banner)
+	})
+	mux.HandleFunc("/results", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(latest.Load())
+	})
+	httpListener, err := net.Listen("tcp", fmt.Sprintf(":%d", ListenerPorts[HTTP]))
+	if err != nil {
+		return fmt.Errorf("failed to listen on http port: %w", err)
+	}
+	go func() {
+		_ = http.Serve(httpListener, mux)
+	}()
+
+	measureListener, err := net.Listen("tcp", fmt.Sprintf(":%d", MeasurePort))
+	if err != nil {
+		return fmt.Errorf("failed to listen on measure port: %w", err)
+	}
+	go serveMeasure(measureListener)
+
+	return nil
+}
+
+// serveBanner writes the probe banner to every connection and closes it
+func serveBanner(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			continue
+		}
+		go func() {
+			defer conn.Close()
+			_ = conn.SetDeadline(time.Now().Add(timeout))
+			_, _ = conn.Write([]byte(banner + "\n"))
+		}()
+	}
+}
+
+// serveUDP echoes every datagram, which is used for probing as well as RTT and packet loss measurements
+func serveUDP(conn net.PacketConn) {
+	buffer := make([]byte, 1024)
+	for {
+		n, addr, err := conn.ReadFrom(buffer)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			continue
+		}
+		_, _ = conn.WriteTo(buffer[:n], addr)
+	}
+}
+
+func selfSignedCertificate() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: banner},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
@@ -0,0 +1,280 @@
+package probe
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	modeEcho = 'e'
+	modeSink = 's'
+
+	frameSize     = 16
+	chunkSize     = 128 * 1024
+	packetTimeout = 500 * time.Millisecond
+	packetGap     = 20 * time.Millisecond
+)
+
+// MeasureOptions configure a measurement against the probe of the other cluster
+type MeasureOptions struct {
+	Host     string
+	TCPPort  int32
+	UDPPort  int32 // 0 measures the RTT over TCP and skips packet loss
+	Packets  int
+	Streams  int
+	Duration time.Duration
+}
+
+// Measurement holds the network characteristics of one path
+type Measurement struct {
+	RTT        time.Duration `json:"rtt"`
+	Jitter     time.Duration `json:"jitter"`
+	PacketLoss float64       `json:"packetLoss"` // percent, negative if not measured
+	Throughput float64       `json:"throughput"` // bits per second
+	Error      string        `json:"error,omitempty"`
+}
+
+// ValidateMeasure checks a measurement sends at least one packet over at least one stream
+func ValidateMeasure(packets, streams int) error {
+	if packets < 1 {
+		return fmt.Errorf("number of packets %d has to be at least 1", packets)
+	}
+	if streams < 1 {
+		return fmt.Errorf("number of streams %d has to be at least 1", streams)
+	}
+	return nil
+}
+
+// Measure determines RTT, jitter, packet loss and throughput to the given host
+func Measure(opts MeasureOptions) Measurement {
+	measurement := Measurement{PacketLoss: -1}
+	tcpAddress := net.JoinHostPort(opts.Host, strconv.Itoa(int(opts.TCPPort)))
+
+	var rtts []time.Duration
+	var err error
+	if opts.UDPPort != 0 {
+		var lost int
+		rtts, lost, err = measureLatencyUDP(net.JoinHostPort(opts.Host, strconv.Itoa(int(opts.UDPPort))), opts.Packets)
+		if err == nil {
+			measurement.PacketLoss = float64(lost) * 100 / float64(opts.Packets)
+		}
+	} else {
+		rtts, err = measureLatencyTCP(tcpAddress, opts.Packets)
+	}
+	if err != nil {
+		measurement.Error = err.Error()
+		return measurement
+	}
+	measurement.RTT, measurement.Jitter = summarizeRTT(rtts)
+
+	measurement.Throughput, err = measureThroughput(tcpAddress, opts.Streams, opts.Duration)
+	if err != nil {
+		measurement.Error = err.Error()
+	}
+	return measurement
+}
+
+// measureLatencyUDP sends numbered datagrams to the UDP echo listener one after another
+func measureLatencyUDP(address string, packets int) ([]time.Duration, int, error) {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer conn.Close()
+
+	var rtts []time.Duration
+	lost := 0
+	packet := make([]byte, frameSize)
+	reply := make([]byte, frameSize)
+	for seq := uint64(0); seq < uint64(packets); seq++ {
+		binary.BigEndian.PutUint64(packet, seq)
+		start := time.Now()
+		if _, err := conn.Write(packet); err != nil {
+			return nil, 0, err
+		}
+
+		received := false
+		_ = conn.SetReadDeadline(start.Add(packetTimeout))
+		for {
+			n, err := conn.Read(reply)
+			if err != nil {
+				break
+			}
+			// Late replies of earlier packets are ignored
+			if n >= 8 && binary.BigEndian.Uint64(reply) == seq {
+				received = true
+				break
+			}
+		}
+
+		if received {
+			rtts = append(rtts, time.Since(start))
+		} else {
+			lost++
+		}
+		time.Sleep(packetGap)
+	}
+
+	if len(rtts) == 0 {
+		return nil, lost, fmt.Errorf("no udp echo received from %s", address)
+	}
+	return rtts, lost, nil
+}
+
+// measureLatencyTCP measures the round trip of small frames over an established connection,
+// which also works through proxies that terminate TCP like Linkerd or Skupper
+func measureLatencyTCP(address string, packets int) ([]time.Duration, error) {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte{modeEcho}); err != nil {
+		return nil, err
+	}
+
+	var rtts []time.Duration
+	frame := make([]byte, frameSize)
+	reply := make([]byte, frameSize)
+	for i := 0; i < packets; i++ {
+		_ = conn.SetDeadline(time.Now().Add(timeout))
+		start := time.Now()
+		if _, err := conn.Write(frame); err != nil {
+			return nil, err
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return nil, err
+		}
+		rtts = append(rtts, time.Since(start))
+		time.Sleep(packetGap)
+	}
+	return rtts, nil
+}
+
+// measureThroughput sends data over parallel streams and uses the byte count confirmed by the receiver
+func measureThroughput(address string, streams int, duration time.Duration) (float64, error) {
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	var total uint64
+	var firstErr error
+
+	start := time.Now()
+	for i := 0; i < streams; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			received, err := sendStream(address, duration)
+			mutex.Lock()
+			defer mutex.Unlock()
+			total += received
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}()
+	}
+	wg.Wait()
+
+	if total == 0 && firstErr != nil {
+		return 0, firstErr
+	}
+	return float64(total) * 8 / time.Since(start).Seconds(), nil
+}
+
+func sendStream(address string, duration time.Duration) (uint64, error) {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte{modeSink}); err != nil {
+		return 0, err
+	}
+	chunk := make([]byte, chunkSize)
+	deadline := time.Now().Add(duration)
+	for time.Now().Before(deadline) {
+		_ = conn.SetWriteDeadline(deadline.Add(timeout))
+		if _, err := conn.Write(chunk); err != nil {
+			return 0, err
+		}
+	}
+
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		_ = tcpConn.CloseWrite()
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(30 * time.Second))
+	count := make([]byte, 8)
+	if _, err := io.ReadFull(conn, count); err != nil {
+		return 0, fmt.Errorf("no byte count received: %w", err)
+	}
+	return binary.BigEndian.Uint64(count), nil
+}
+
+// serveMeasure answers echo and throughput connections, selected by the first byte
+func serveMeasure(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			continue
+		}
+		go func() {
+			defer conn.Close()
+			reader := bufio.NewReader(conn)
+			mode, err := reader.ReadByte()
+			if err != nil {
+				return
+			}
+			switch mode {
+			case modeEcho:
+				frame := make([]byte, frameSize)
+				for {
+					if _, err := io.ReadFull(reader, frame); err != nil {
+						return
+					}
+					if _, err := conn.Write(frame); err != nil {
+						return
+					}
+				}
+			case modeSink:
+				received, _ := io.Copy(io.Discard, reader)
+				count := make([]byte, 8)
+				binary.BigEndian.PutUint64(count, uint64(received))
+				_, _ = conn.Write(count)
+			}
+		}()
+	}
+}
+
+// summarizeRTT returns the mean RTT and the mean deviation between consecutive samples
+func summarizeRTT(rtts []time.Duration) (time.Duration, time.Duration) {
+	if len(rtts) == 0 {
+		return 0, 0
+	}
+	var sum, deviation time.Duration
+	for i, rtt := range rtts {
+		sum += rtt
+		if i > 0 {
+			difference := rtt - rtts[i-1]
+			if difference < 0 {
+				difference = -difference
+			}
+			deviation += difference
+		}
+	}
+	mean := sum / time.Duration(len(rtts))
+	if len(rtts) < 2 {
+		return mean, 0
+	}
+	return mean, deviation / time.Duration(len(rtts)-1)
+}
@@ -0,0 +1,195 @@
+package probe
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// Protocol is the protocol a probe uses to reach its target
+type Protocol string
+
+const (
+	TCP  Protocol = "tcp"
+	UDP  Protocol = "udp"
+	HTTP Protocol = "http"
+	TLS  Protocol = "tls"
+)
+
+// Listener ports inside the probe pod, exposed to the other cluster through a NodePort service
+var ListenerPorts = map[Protocol]int32{
+	TCP:  7001,
+	UDP:  7002,
+	HTTP: 7003,
+	TLS:  7004,
+}
+
+// MeasurePort serves the TCP echo and throughput streams used by Measure
+const MeasurePort int32 = 7005
+
+const (
+	// ConfigFile is the path the probe configuration is mounted at inside the probe pod
+	ConfigFile = "/etc/clustershift/probe.json"
+	// ResultsConfigMap stores the results published by the probe pod
+	ResultsConfigMap = "connectivity-results"
+	ResultsKey       = "results.json"
+
+	banner   = "clustershift-probe"
+	interval = 5 * time.Second
+	timeout  = 3 * time.Second
+)
+
+// Probe is a single protocol and port to check against every target
+type Probe struct {
+	Protocol Protocol `json:"protocol"`
+	Port     int32    `json:"port"`
+	// Optional probes are reported but do not decide whether a connection is usable
+	Optional bool `json:"optional,omitempty"`
+}
+
+// Config is passed to the probe pod through a ConfigMap
+type Config struct {
+	Cluster   string   `json:"cluster"`
+	Namespace string   `json:"namespace"`
+	Targets   []string `json:"targets"`
+	Probes    []Probe  `json:"probes"`
+}
+
+// Result is the outcome of a probe against one target
+type Result struct {
+	Target   string   `json:"target"`
+	Protocol Protocol `json:"protocol"`
+	Port     int32    `json:"port"`
+	Success  bool     `json:"success"`
+	Latency  string   `json:"latency,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// Results are published by the probe pod after every round of probes
+type Results struct {
+	Cluster string    `json:"cluster"`
+	Round   int       `json:"round"`
+	Updated time.Time `json:"updated"`
+	Results []Result  `json:"results"`
+}
+
+func (p Probe) String() string {
+	return fmt.Sprintf("%s/%d", p.Protocol, p.Port)
+}
+
+// ParseProbe parses a probe given as "protocol:port"
+func ParseProbe(spec string) (Probe, error) {
+	protocol, port, found := strings.Cut(strings.TrimSpace(spec), ":")
+	if !found {
+		return Probe{}, fmt.Errorf("invalid probe %q, expected protocol:port", spec)
+	}
+	number, err := strconv.ParseInt(port, 10, 32)
+	if err != nil || number < 1 || number > 65535 {
+		return Probe{}, fmt.Errorf("invalid port in probe %q", spec)
+	}
+
+	probe := Probe{Protocol: Protocol(strings.ToLower(protocol)), Port: int32(number)}
+	if _, ok := ListenerPorts[probe.Protocol]; !ok {
+		return Probe{}, fmt.Errorf("unsupported protocol in probe %q, use tcp, udp, http or tls", spec)
+	}
+	return probe, nil
+}
+
+// Run starts the listeners and probes the configured targets until the process is stopped.
+// It is the entrypoint of the probe pod.
+func Run(configPath string) error {
+	content, err := os.ReadFile(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to read probe config: %w", err)
+	}
+	var config Config
+	if err := json.Unmarshal(content, &config); err != nil {
+		return fmt.Errorf("failed to parse probe config: %w", err)
+	}
+
+	restConfig, err := rest.InClusterConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load in-cluster config: %w", err)
+	}
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return fmt.Errorf("failed to initialize clientset: %w", err)
+	}
+
+	var latest atomic.Pointer[Results]
+	latest.Store(&Results{Cluster: config.Cluster})
+	if err := startListeners(&latest); err != nil {
+		return err
+	}
+
+	for round := 1; ; round++ {
+		results := &Results{
+			Cluster: config.Cluster,
+			Round:   round,
+			Results: runProbes(config),
+			Updated: time.Now(),
+		}
+		latest.Store(results)
+		if err := publish(clientset, config.Namespace, results); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to publish results: %v\n", err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+func runProbes(config Config) []Result {
+	var results []Result
+	for _, target := range config.Targets {
+		for _, probe := range config.Probes {
+			results = append(results, check(target, probe))
+		}
+	}
+	return results
+}
+
+// publish writes the results to the results ConfigMap in the probe namespace
+func publish(clientset kubernetes.Interface, namespace string, results *Results) error {
+	content, err := json.Marshal(results)
+	if err != nil {
+		return err
+	}
+
+	configMaps := clientset.CoreV1().ConfigMaps(namespace)
+	configMap, err := configMaps.Get(context.TODO(), ResultsConfigMap, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		_, err = configMaps.Create(context.TODO(), &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: ResultsConfigMap, Namespace: namespace},
+			Data:       map[string]string{ResultsKey: string(content)},
+		}, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	configMap.Data = map[string]string{ResultsKey: string(content)}
+	_, err = configMaps.Update(context.TODO(), configMap, metav1.UpdateOptions{})
+	return err
+}
+
+// ReadResults parses the results published by a probe pod
+func ReadResults(configMap *corev1.ConfigMap) (Results, error) {
+	var results Results
+	content, ok := configMap.Data[ResultsKey]
+	if !ok {
+		return results, fmt.Errorf("configmap %s has no %s", configMap.Name, ResultsKey)
+	}
+	err := json.Unmarshal([]byte(content), &results)
+	return results, err
+}
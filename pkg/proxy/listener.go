@@ -0,0 +1,194 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errHelloRead aborts the handshake once the ClientHello of a passed through connection is read
+var errHelloRead = errors.New("client hello read")
+
+// startListeners serves the forwarded HTTP requests, the passed through TLS connections and the health endpoint
+func (p *proxy) startListeners() error {
+	httpListener, err := net.Listen("tcp", fmt.Sprintf(":%d", p.config.HTTPPort))
+	if err != nil {
+		return fmt.Errorf("failed to listen on http port: %w", err)
+	}
+	// Cleartext HTTP/2 is accepted for gRPC, Traefik sends it with the h2c scheme
+	var protocols http.Protocols
+	protocols.SetHTTP1(true)
+	protocols.SetUnencryptedHTTP2(true)
+	server := &http.Server{Handler: p.httpHandler(), Protocols: &protocols}
+	go func() {
+		_ = server.Serve(httpListener)
+	}()
+
+	tlsListener, err := net.Listen("tcp", fmt.Sprintf(":%d", p.config.TLSPort))
+	if err != nil {
+		return fmt.Errorf("failed to listen on tls port: %w", err)
+	}
+	go p.serveTLS(tlsListener)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	})
+	mux.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(p.stats.snapshot(p.config.Cluster))
+	})
+	healthListener, err := net.Listen("tcp", fmt.Sprintf(":%d", p.config.HealthPort))
+	if err != nil {
+		return fmt.Errorf("failed to listen on health port: %w", err)
+	}
+	go func() {
+		_ = http.Serve(healthListener, mux)
+	}()
+	return nil
+}
+
+// httpHandler forwards requests with their Host header to the upstream of the host. Websocket
+// upgrades are handled by the reverse proxy, gRPC is forwarded as cleartext HTTP/2.
+func (p *proxy) httpHandler() http.Handler {
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	http1 := &http.Transport{DialContext: dialer.DialContext, MaxIdleConnsPerHost: 100, IdleConnTimeout: 90 * time.Second}
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+	h2c := &http.Transport{DialContext: dialer.DialContext, Protocols: &protocols}
+
+	reverseProxy := &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(&url.URL{Scheme: "http", Host: p.upstream(r.In.Host, "80")})
+			r.Out.Host = r.In.Host
+			r.SetXForwarded()
+		},
+		Transport: roundTripper(func(r *http.Request) (*http.Response, error) {
+			if r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+				return h2c.RoundTrip(r)
+			}
+			return http1.RoundTrip(r)
+		}),
+		// Streams such as gRPC and server-sent events are flushed immediately
+		FlushInterval: -1,
+		ModifyResponse: func(r *http.Response) error {
+			p.stats.request(r.Request.Host, r.StatusCode >= http.StatusInternalServerError)
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			p.stats.request(r.Host, true)
+			fmt.Fprintf(os.Stderr, "failed to forward request for %s: %v\n", r.Host, err)
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}
+	return reverseProxy
+}
+
+type roundTripper func(*http.Request) (*http.Response, error)
+
+func (f roundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// serveTLS passes TLS connections through to the upstream of their server name, the
+// connection is not terminated so the client negotiates SNI and ALPN with the upstream
+func (p *proxy) serveTLS(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go p.passthrough(conn)
+	}
+}
+
+func (p *proxy) passthrough(conn net.Conn) {
+	defer conn.Close()
+
+	_ = conn.SetReadDeadline(time.Now().Add(dialTimeout))
+	serverName, hello, err := readServerName(conn)
+	if err != nil {
+		p.stats.connection("", true)
+		return
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+
+	upstream, err := net.DialTimeout("tcp", p.upstream(serverName, "443"), dialTimeout)
+	if err != nil {
+		p.stats.connection(serverName, true)
+		fmt.Fprintf(os.Stderr, "failed to connect upstream for %s: %v\n", serverName, err)
+		return
+	}
+	defer upstream.Close()
+	p.stats.connection(serverName, false)
+
+	if _, err := upstream.Write(hello); err != nil {
+		return
+	}
+	pipe(conn, upstream)
+}
+
+// pipe copies between both connections until one side is closed
+func pipe(client, upstream net.Conn) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	copyAndClose := func(dst, src net.Conn) {
+		defer wg.Done()
+		_, _ = io.Copy(dst, src)
+		if tcp, ok := dst.(*net.TCPConn); ok {
+			_ = tcp.CloseWrite()
+		} else {
+			_ = dst.Close()
+		}
+	}
+	go copyAndClose(upstream, client)
+	go copyAndClose(client, upstream)
+	wg.Wait()
+}
+
+// readServerName reads the ClientHello of the connection and returns its server name with the
+// bytes read, which are replayed to the upstream
+func readServerName(conn net.Conn) (string, []byte, error) {
+	recording := &recordingConn{Conn: conn}
+	var serverName string
+	read := false
+	err := tls.Server(recording, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverName = hello.ServerName
+			read = true
+			return nil, errHelloRead
+		},
+	}).Handshake()
+	if !read {
+		return "", nil, fmt.Errorf("failed to read client hello: %w", err)
+	}
+	return serverName, recording.buffer.Bytes(), nil
+}
+
+// recordingConn records everything read from the connection and discards writes, the
+// handshake reading the ClientHello must not answer the client
+type recordingConn struct {
+	net.Conn
+	buffer bytes.Buffer
+}
+
+func (c *recordingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.buffer.Write(b[:n])
+	return n, err
+}
+
+func (c *recordingConn) Write(b []byte) (int, error) {
+	return len(b), nil
+}
@@ -0,0 +1,255 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	// ConfigFile is the path the proxy configuration is mounted at inside the proxy pod
+	ConfigFile = "/etc/clustershift/proxy.json"
+	// StatsConfigMap stores the request counts published by the proxy pods, one key per pod
+	StatsConfigMap = "clustershift-proxy-stats"
+	statsSuffix    = ".json"
+
+	publishInterval = 10 * time.Second
+	dialTimeout     = 10 * time.Second
+)
+
+// Config is passed to the proxy pod through a ConfigMap
+type Config struct {
+	Cluster   string `json:"cluster"`
+	Namespace string `json:"namespace"`
+	// Default receives the requests of hosts without an upstream, as host or host:port
+	Default string `json:"default"`
+	// Upstreams maps host names to the address their requests are forwarded to
+	Upstreams  map[string]string `json:"upstreams,omitempty"`
+	HTTPPort   int32             `json:"httpPort"`
+	TLSPort    int32             `json:"tlsPort"`
+	HealthPort int32             `json:"healthPort"`
+}
+
+// HostStats counts the traffic forwarded for one host
+type HostStats struct {
+	Requests    int64 `json:"requests"`
+	Errors      int64 `json:"errors"`
+	Connections int64 `json:"connections"` // TLS connections passed through
+}
+
+// Stats are published by the proxy pod, hosts without SNI or Host header are counted as "*"
+type Stats struct {
+	Cluster string               `json:"cluster"`
+	Started time.Time            `json:"started"`
+	Updated time.Time            `json:"updated"`
+	Hosts   map[string]HostStats `json:"hosts"`
+}
+
+// counters collects the stats of the running proxy
+type counters struct {
+	mu      sync.Mutex
+	started time.Time
+	hosts   map[string]*HostStats
+}
+
+func (c *counters) host(name string) *HostStats {
+	if name == "" {
+		name = "*"
+	}
+	stats, ok := c.hosts[name]
+	if !ok {
+		stats = &HostStats{}
+		c.hosts[name] = stats
+	}
+	return stats
+}
+
+func (c *counters) request(host string, failed bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.host(host)
+	stats.Requests++
+	if failed {
+		stats.Errors++
+	}
+}
+
+func (c *counters) connection(host string, failed bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.host(host)
+	stats.Connections++
+	if failed {
+		stats.Errors++
+	}
+}
+
+func (c *counters) snapshot(cluster string) *Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := &Stats{Cluster: cluster, Started: c.started, Updated: time.Now(), Hosts: make(map[string]HostStats, len(c.hosts))}
+	for host, hostStats := range c.hosts {
+		stats.Hosts[host] = *hostStats
+	}
+	return stats
+}
+
+// proxy forwards the traffic of the origin cluster to the upstreams
+type proxy struct {
+	config Config
+	stats  *counters
+}
+
+// upstream returns the address requests for the host are forwarded to, defaultPort is added
+// if the configured address has none
+func (p *proxy) upstream(host, defaultPort string) string {
+	address, ok := p.config.Upstreams[hostname(host)]
+	if !ok {
+		address = p.config.Default
+	}
+	if _, _, err := net.SplitHostPort(address); err == nil {
+		return address
+	}
+	return net.JoinHostPort(address, defaultPort)
+}
+
+// hostname strips the port and trailing dot of a Host header or server name
+func hostname(host string) string {
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		host = name
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// Run serves the HTTP, TLS passthrough and health listeners and publishes the stats until the
+// process is stopped. It is the entrypoint of the proxy pod.
+func Run(configPath string) error {
+	content, err := os.ReadFile(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to read proxy config: %w", err)
+	}
+	var config Config
+	if err := json.Unmarshal(content, &config); err != nil {
+		return fmt.Errorf("failed to parse proxy config: %w", err)
+	}
+	if config.Default == "" && len(config.Upstreams) == 0 {
+		return fmt.Errorf("proxy config has no upstreams")
+	}
+	upstreams := make(map[string]string, len(config.Upstreams))
+	for host, address := range config.Upstreams {
+		upstreams[hostname(host)] = address
+	}
+	config.Upstreams = upstreams
+
+	restConfig, err := rest.InClusterConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load in-cluster config: %w", err)
+	}
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return fmt.Errorf("failed to initialize clientset: %w", err)
+	}
+
+	// The hostname of a pod is its name
+	pod, err := os.Hostname()
+	if err != nil {
+		return fmt.Errorf("failed to determine the pod name: %w", err)
+	}
+
+	p := &proxy{config: config, stats: &counters{started: time.Now(), hosts: make(map[string]*HostStats)}}
+	if err := p.startListeners(); err != nil {
+		return err
+	}
+
+	for {
+		time.Sleep(publishInterval)
+		if err := publish(clientset, config.Namespace, pod, p.stats.snapshot(config.Cluster)); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to publish stats: %v\n", err)
+		}
+	}
+}
+
+// publish writes the stats of the pod to its key of the stats ConfigMap, conflicting updates of
+// other pods are retried with the next publish
+func publish(clientset kubernetes.Interface, namespace, pod string, stats *Stats) error {
+	content, err := json.Marshal(stats)
+	if err != nil {
+		return err
+	}
+
+	configMaps := clientset.CoreV1().ConfigMaps(namespace)
+	configMap, err := configMaps.Get(context.TODO(), StatsConfigMap, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		_, err = configMaps.Create(context.TODO(), &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: StatsConfigMap, Namespace: namespace},
+			Data:       map[string]string{pod + statsSuffix: string(content)},
+		}, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if configMap.Data == nil {
+		configMap.Data = make(map[string]string)
+	}
+	configMap.Data[pod+statsSuffix] = string(content)
+	_, err = configMaps.Update(context.TODO(), configMap, metav1.UpdateOptions{})
+	return err
+}
+
+// ReadStats sums the stats published by the proxy pods
+func ReadStats(configMap *corev1.ConfigMap) (Stats, error) {
+	stats := Stats{Hosts: make(map[string]HostStats)}
+	found := false
+	for key, content := range configMap.Data {
+		if !strings.HasSuffix(key, statsSuffix) {
+			continue
+		}
+		var pod Stats
+		if err := json.Unmarshal([]byte(content), &pod); err != nil {
+			return stats, fmt.Errorf("invalid stats %s: %w", key, err)
+		}
+		stats.Cluster = pod.Cluster
+		if !found || pod.Started.Before(stats.Started) {
+			stats.Started = pod.Started
+		}
+		if pod.Updated.After(stats.Updated) {
+			stats.Updated = pod.Updated
+		}
+		for host, podHost := range pod.Hosts {
+			hostStats := stats.Hosts[host]
+			hostStats.Requests += podHost.Requests
+			hostStats.Errors += podHost.Errors
+			hostStats.Connections += podHost.Connections
+			stats.Hosts[host] = hostStats
+		}
+		found = true
+	}
+	if !found {
+		return stats, fmt.Errorf("configmap %s has no stats of a proxy pod", configMap.Name)
+	}
+	return stats, nil
+}
+
+// SortedHosts returns the hosts of the stats in alphabetical order
+func (s Stats) SortedHosts() []string {
+	hosts := make([]string, 0, len(s.Hosts))
+	for host := range s.Hosts {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	return hosts
+}
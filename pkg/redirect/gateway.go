@@ -0,0 +1,274 @@
+package redirect
+
+import (
+	"clustershift/internal/kube"
+	"clustershift/internal/logger"
+	"clustershift/internal/migration"
+	"clustershift/internal/prompt"
+	"context"
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// The routes are handled as unstructured objects in their preferred served version, the gateway-api
+// version pinned in go.mod predates GRPCRoute and the v1 API.
+const gatewayGroup = "gateway.networking.k8s.io"
+
+// gatewayRouteKinds are the Gateway API routes whose backendRefs are rerouted
+var gatewayRouteKinds = []string{"HTTPRoute", "GRPCRoute", "TLSRoute", "TCPRoute"}
+
+// maxGatewayWeight keeps the scaled weights of a gradual shift below the limit of 1000000 of the Gateway API
+const maxGatewayWeight = 10000
+
+// gatewayRoute is a Gateway API route of origin whose backendRefs are shifted gradually
+type gatewayRoute struct {
+	resource  schema.GroupVersionResource
+	namespace string
+	name      string
+	backends  []shiftedBackend
+}
+
+// updateGatewayRoutes rewrites the Service backendRefs of the Gateway API routes of origin to the exported
+// services. A gradual cutover adds a weighted backendRef of the exported service next to every backendRef
+// instead, the returned routes are shifted. Backends in another namespace than the route get a ReferenceGrant,
+// services that were not exported stay in origin.
+func updateGatewayRoutes(clusters kube.Clusters, migrationResource migration.Resources, exported serviceSet, opts prompt.MigrationOptions) ([]shiftedBackend, []gatewayRoute, error) {
+	// Istio rerouting keeps the services and shifts their traffic
+	if opts.Rerouting == prompt.ReroutingIstio {
+		return nil, nil, nil
+	}
+
+	c := clusters.Origin
+	versions, err := c.FetchAPIVersions()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var backends []shiftedBackend
+	var routes []gatewayRoute
+	for _, kind := range gatewayRouteKinds {
+		gvk, served := versions.Preferred(schema.GroupKind{Group: gatewayGroup, Kind: kind})
+		if !served {
+			logger.Debug(fmt.Sprintf("%s is not served by origin cluster", kind))
+			continue
+		}
+		gvr, _ := versions.Resource(gvk)
+		list, err := c.DynamicClientset.Resource(gvr).Namespace("").List(context.TODO(), metav1.ListOptions{})
+		if err != nil {
+			return nil, nil, fmt.Errorf("fetching %s for update failed: %v", kind, err)
+		}
+
+		for _, item := range list.Items {
+			route := gatewayRoute{resource: gvr, namespace: item.GetNamespace(), name: item.GetName()}
+			rules, _, _ := unstructured.NestedSlice(item.Object, "spec", "rules")
+			changed := false
+			for i, rule := range rules {
+				ruleMap, ok := rule.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				refs, _, _ := unstructured.NestedSlice(ruleMap, "backendRefs")
+				var rewritten []interface{}
+				ruleChanged := false
+				for _, ref := range refs {
+					refMap, ok := ref.(map[string]interface{})
+					if !ok || !isServiceRef(refMap) {
+						rewritten = append(rewritten, ref)
+						continue
+					}
+					name, _ := refMap["name"].(string)
+					refNamespace, _ := refMap["namespace"].(string)
+					namespace := serviceNamespace(refNamespace, route.namespace)
+					if !exported.has(namespace, name) {
+						rewritten = append(rewritten, ref)
+						continue
+					}
+					remoteServiceName := remoteService(clusters, migrationResource, namespace, name)
+					if err := checkGatewayBackend(c, namespace, remoteServiceName); err != nil {
+						return nil, nil, err
+					}
+					backend := shiftedBackend{namespace: namespace, service: name, routed: remoteServiceName}
+
+					if namespace != route.namespace {
+						if err := createReferenceGrant(c, versions, kind, route.namespace, namespace, remoteServiceName); err != nil {
+							return nil, nil, err
+						}
+					}
+
+					remoteRef := make(map[string]interface{}, len(refMap))
+					for key, value := range refMap {
+						remoteRef[key] = value
+					}
+					remoteRef["name"] = remoteServiceName
+					if gradualShift(opts) && refWeight(refMap) > 0 {
+						logger.Info(fmt.Sprintf("Adding weighted backend %s to %s %s/%s", remoteServiceName, kind, route.namespace, route.name))
+						scale := min(refWeight(refMap), maxGatewayWeight)
+						refMap["weight"] = scale * 100
+						remoteRef["weight"] = int64(0)
+						rewritten = append(rewritten, refMap, remoteRef)
+						if !containsBackend(route.backends, backend) {
+							route.backends = append(route.backends, backend)
+						}
+					} else {
+						logger.Info(fmt.Sprintf("Updating backend in %s %s/%s from %s to %s", kind, route.namespace, route.name, name, remoteServiceName))
+						rewritten = append(rewritten, remoteRef)
+					}
+					if !containsBackend(backends, backend) {
+						backends = append(backends, backend)
+					}
+					ruleChanged = true
+				}
+				if ruleChanged {
+					changed = true
+					ruleMap["backendRefs"] = rewritten
+					rules[i] = ruleMap
+				}
+			}
+			if !changed {
+				continue
+			}
+
+			if err := unstructured.SetNestedSlice(item.Object, rules, "spec", "rules"); err != nil {
+				return nil, nil, err
+			}
+			_, err = c.DynamicClientset.Resource(gvr).Namespace(route.namespace).Update(context.TODO(), &item, metav1.UpdateOptions{})
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to update %s %s/%s: %v", kind, route.namespace, route.name, err)
+			}
+			if len(route.backends) > 0 {
+				routes = append(routes, route)
+			}
+		}
+	}
+	return backends, routes, nil
+}
+
+// setGatewayWeights sends the percentage of the traffic of the shifted backendRefs to the target cluster,
+// keeping the weight of every backendRef pair relative to the other backendRefs of the rule
+func setGatewayWeights(c kube.Cluster, routes []gatewayRoute, percent int) error {
+	for _, route := range routes {
+		item, err := c.DynamicClientset.Resource(route.resource).Namespace(route.namespace).Get(context.TODO(), route.name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to fetch %s %s/%s: %w", route.resource.Resource, route.namespace, route.name, err)
+		}
+
+		rules, _, _ := unstructured.NestedSlice(item.Object, "spec", "rules")
+		for i, rule := range rules {
+			ruleMap, ok := rule.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			refs, _, _ := unstructured.NestedSlice(ruleMap, "backendRefs")
+			for _, backend := range route.backends {
+				origin := findServiceRef(refs, route.namespace, backend.namespace, backend.service)
+				remote := findServiceRef(refs, route.namespace, backend.namespace, backend.routed)
+				if origin == nil || remote == nil {
+					continue
+				}
+				scale := max((refWeight(origin)+refWeight(remote))/100, 1)
+				origin["weight"] = scale * int64(100-percent)
+				remote["weight"] = scale * int64(percent)
+			}
+			ruleMap["backendRefs"] = refs
+			rules[i] = ruleMap
+		}
+
+		if err := unstructured.SetNestedSlice(item.Object, rules, "spec", "rules"); err != nil {
+			return err
+		}
+		_, err = c.DynamicClientset.Resource(route.resource).Namespace(route.namespace).Update(context.TODO(), item, metav1.UpdateOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to update %s %s/%s: %w", route.resource.Resource, route.namespace, route.name, err)
+		}
+	}
+	return nil
+}
+
+// createReferenceGrant allows the routes of a namespace to reference the exported service in another namespace
+func createReferenceGrant(c kube.Cluster, versions kube.APIVersions, kind, routeNamespace, namespace, service string) error {
+	gvk, served := versions.Preferred(schema.GroupKind{Group: gatewayGroup, Kind: "ReferenceGrant"})
+	if !served {
+		return fmt.Errorf("%s %s references service %s/%s, which requires a ReferenceGrant but the CRD is not installed", kind, routeNamespace, namespace, service)
+	}
+
+	name := fmt.Sprintf("%s-%s-%s", service, strings.ToLower(kind), routeNamespace)
+	logger.Info(fmt.Sprintf("Granting %s of namespace %s access to service %s/%s", kind, routeNamespace, namespace, service))
+	err := c.CreateCustomResource(namespace, map[string]interface{}{
+		"apiVersion": gvk.GroupVersion().String(),
+		"kind":       "ReferenceGrant",
+		"metadata": map[string]interface{}{
+			"name":      name,
+			"namespace": namespace,
+		},
+		"spec": map[string]interface{}{
+			"from": []interface{}{map[string]interface{}{
+				"group":     gatewayGroup,
+				"kind":      kind,
+				"namespace": routeNamespace,
+			}},
+			"to": []interface{}{map[string]interface{}{
+				"group": "",
+				"kind":  "Service",
+				"name":  service,
+			}},
+		},
+	})
+	if err != nil && !k8serrors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create ReferenceGrant %s/%s: %w", namespace, name, err)
+	}
+	return nil
+}
+
+// isServiceRef reports whether a backendRef references a Kubernetes service, the default kind
+func isServiceRef(ref map[string]interface{}) bool {
+	group, _ := ref["group"].(string)
+	kind, _ := ref["kind"].(string)
+	return group == "" && (kind == "" || kind == "Service")
+}
+
+// findServiceRef returns the backendRef of the service, nil if the rule does not reference it
+func findServiceRef(refs []interface{}, routeNamespace, namespace, name string) map[string]interface{} {
+	for _, ref := range refs {
+		refMap, ok := ref.(map[string]interface{})
+		if !ok || !isServiceRef(refMap) {
+			continue
+		}
+		refName, _ := refMap["name"].(string)
+		refNamespace, _ := refMap["namespace"].(string)
+		if refName == name && serviceNamespace(refNamespace, routeNamespace) == namespace {
+			return refMap
+		}
+	}
+	return nil
+}
+
+// refWeight returns the weight of a backendRef, which defaults to 1
+func refWeight(ref map[string]interface{}) int64 {
+	switch weight := ref["weight"].(type) {
+	case int64:
+		return weight
+	case float64:
+		return int64(weight)
+	}
+	return 1
+}
+
+// checkGatewayBackend fails for missing services and for ExternalName services, which most Gateway API
+// implementations reject as backends with ResolvedRefs=False. Such a service is left over from an earlier
+// clustershift version.
+func checkGatewayBackend(c kube.Cluster, namespace, name string) error {
+	serviceInterface, err := c.FetchResource(kube.Service, name, namespace)
+	if err != nil {
+		return fmt.Errorf("failed to fetch backend service %s/%s: %v", namespace, name, err)
+	}
+	if serviceInterface.(*v1.Service).Spec.Type == v1.ServiceTypeExternalName {
+		return fmt.Errorf("backend service %s/%s is an ExternalName service, which Gateway API routes cannot reference; delete it and rerun the migration", namespace, name)
+	}
+	return nil
+}
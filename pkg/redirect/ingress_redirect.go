@@ -6,76 +6,134 @@ import (
 	"clustershift/internal/logger"
 	"clustershift/internal/migration"
 	"clustershift/internal/prompt"
-	"clustershift/pkg/linkerd"
+	"clustershift/pkg/health"
+	"clustershift/pkg/istio"
+	"context"
 	"fmt"
 	traefikv1dynamic "github.com/traefik/traefik/v3/pkg/config/dynamic"
 	traefikv1 "github.com/traefik/traefik/v3/pkg/provider/kubernetes/crd/traefikio/v1alpha1"
 	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	// importTimeout bounds the wait for the import of a routed service
+	importTimeout = 5 * time.Minute
+	// resolveTimeout bounds the wait for the DNS name of an import to resolve in origin
+	resolveTimeout = time.Minute
 )
 
 func Redirect(c kube.Clusters, migrationResource migration.Resources, opts prompt.MigrationOptions) {
-	err := exportAllServices(c, migrationResource)
-	exit.OnErrorWithMessage(err, "Failed to export all services")
-	err = updateIngressRoutes(c.Origin, migrationResource, opts)
+	services, err := exportRoutedServices(c, migrationResource)
+	exit.OnErrorWithMessage(err, "Failed to export the routed services")
+	exported := newServiceSet(services)
+	err = mirrorTraffic(c, migrationResource, exported, opts)
+	exit.OnErrorWithMessage(err, "Failed to mirror traffic to target cluster")
+	backends, err := updateIngressRoutes(c, migrationResource, exported, opts)
 	exit.OnErrorWithMessage(err, "Failed to update ingress routes")
+	gatewayBackends, gatewayRoutes, err := updateGatewayRoutes(c, migrationResource, exported, opts)
+	exit.OnErrorWithMessage(err, "Failed to update Gateway API routes")
+	for _, backend := range gatewayBackends {
+		if !containsBackend(backends, backend) {
+			backends = append(backends, backend)
+		}
+	}
+	// Istio rerouting keeps the services of all routes and shifts their traffic
+	if opts.Rerouting == prompt.ReroutingIstio {
+		for _, service := range services {
+			backend := shiftedBackend{namespace: service.namespace, service: service.name, routed: service.name}
+			if !containsBackend(backends, backend) {
+				backends = append(backends, backend)
+			}
+		}
+	}
+	err = shiftTraffic(c, opts, backends, gatewayRoutes)
+	exit.OnErrorWithMessage(err, "Failed to shift traffic to target cluster")
+	err = updateStreamRoutes(c, migrationResource, exported, opts)
+	exit.OnErrorWithMessage(err, "Failed to update tcp, udp and ingress routes")
 }
 
-// exportAllServices gets all services of target cluster and exports them
-func exportAllServices(c kube.Clusters, migrationResource migration.Resources) error {
-	services, err := c.Target.FetchResources(kube.Service)
-	if err != nil {
-		return fmt.Errorf("fetching services for export failed: %v", err)
+// shiftServices sends the given percentage of the traffic of the backends to their target endpoints
+func shiftServices(c kube.Clusters, backends []shiftedBackend, percent int) error {
+	for _, backend := range backends {
+		logger.Debug(fmt.Sprintf("Shifting %d%% of the traffic of service %s/%s to target cluster", percent, backend.namespace, backend.service))
+		if err := istio.ShiftTraffic(c, backend.namespace, backend.service, percent); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	serviceList, ok := services.(*v1.ServiceList)
-	if !ok {
-		return fmt.Errorf("failed to cast resources to *v1.ServiceList")
+// exportRoutedServices exports the services of target cluster the routes of origin reference and returns them.
+// Services missing in target cluster are skipped, their routes keep sending the traffic to origin.
+func exportRoutedServices(c kube.Clusters, migrationResource migration.Resources) ([]routedService, error) {
+	routed, err := routedServices(c.Origin)
+	if err != nil {
+		return nil, err
 	}
 
-	for _, service := range serviceList.Items {
-		if migrationResource.GetNetworkingTool() == prompt.NetworkingToolLinkerd {
-			err := linkerd.MirrorService(c.Target, service.Name, service.Namespace)
-			exit.OnErrorWithMessage(err, fmt.Sprintf("Failed to mirror service %s in namespace %s", service.Name, service.Namespace))
-		} else {
-			migrationResource.ExportService(c.Target, service.Namespace, service.Name)
+	var exported []routedService
+	for _, routedService := range routed {
+		serviceInterface, err := c.Target.FetchResource(kube.Service, routedService.name, routedService.namespace)
+		if k8serrors.IsNotFound(err) {
+			logger.Warning(fmt.Sprintf("Skipping export of service %s/%s", routedService.namespace, routedService.name),
+				fmt.Errorf("the service is routed to but does not exist in target cluster"))
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("fetching service %s/%s for export failed: %v", routedService.namespace, routedService.name, err)
+		}
+		service := serviceInterface.(*v1.Service)
+
+		if err := migrationResource.ExportService(c.Target, service.Namespace, service.Name); err != nil {
+			return nil, fmt.Errorf("failed to export service %s/%s: %v", service.Namespace, service.Name, err)
+		}
+		// Routes are only pointed at imports that exist in origin
+		if err := migrationResource.WaitForImport(c.Target, service.Namespace, service.Name, importTimeout); err != nil {
+			return nil, fmt.Errorf("service %s/%s is not imported into origin cluster: %v", service.Namespace, service.Name, err)
 		}
 
-		if migrationResource.GetNetworkingTool() == prompt.NetworkingToolSubmariner {
-			err = createRemoteService(c.Origin, migrationResource, service)
+		// Routes reference services, imports only reachable by their DNS name get a service of their own
+		if migrationResource.ImportedServiceName(c.Target, service.Namespace, service.Name) == "" {
+			err = createRemoteService(c, migrationResource, *service)
 			if err != nil {
-				return fmt.Errorf("failed to create remote service: %v", err)
+				return nil, fmt.Errorf("failed to create remote service: %v", err)
 			}
 		}
+		exported = append(exported, routedService)
 	}
 
-	return nil
+	return exported, nil
 }
 
-// updateIngressRoutes gets all IngressRoutes of origin and changes the service name to the exported service name
-func updateIngressRoutes(c kube.Cluster, migrationResource migration.Resources, opts prompt.MigrationOptions) error {
-	if migrationResource.GetNetworkingTool() == prompt.NetworkingToolLinkerd {
-		namespaceObj, err := c.FetchResource(kube.Namespace, "traefik", "")
-		if err != nil {
-			return fmt.Errorf("fetching traefik namespace failed: %v", err)
-		}
-		namespace := namespaceObj.(*v1.Namespace)
-		err = c.AddAnnotation(namespace, "linkerd.io/inject", "ingress")
-		exit.OnErrorWithMessage(err, "Failed to add linkerd inject annotation to namespace")
-		err = linkerd.RerollPodsInNamespace(c, namespace.Name)
-		exit.OnErrorWithMessage(err, "Failed to reroll pods in namespace "+namespace.Name)
-	}
+// updateIngressRoutes gets all IngressRoutes of origin and changes the service name to the exported service name.
+// A gradual cutover routes to a weighted TraefikService of both services instead, the returned backends are shifted.
+// Services that were not exported stay in origin.
+func updateIngressRoutes(clusters kube.Clusters, migrationResource migration.Resources, exported serviceSet, opts prompt.MigrationOptions) ([]shiftedBackend, error) {
+	c := clusters.Origin
+	err := migrationResource.PrepareConsumer(c, "traefik", true)
+	exit.OnErrorWithMessage(err, "Failed to prepare namespace traefik for imported services")
 
 	ingressRoutes, err := c.FetchResources(kube.IngressRoute)
 	if err != nil {
-		return fmt.Errorf("fetching ingress routes for update failed: %v", err)
+		return nil, fmt.Errorf("fetching ingress routes for update failed: %v", err)
 	}
 
 	ingressRouteList, ok := ingressRoutes.(*traefikv1.IngressRouteList)
 	if !ok {
-		return fmt.Errorf("failed to cast resources to *v1.IngressRouteList")
+		return nil, fmt.Errorf("failed to cast resources to *v1.IngressRouteList")
 	}
 
+	var backends []shiftedBackend
+	shifted := make(map[string]bool)
+
 	for _, ingressRoute := range ingressRouteList.Items {
 		if ingressRoute.Name == "traefik-dashboard" {
 			logger.Debug(fmt.Sprintf("Ignoring IngressRoute %s as it is the Traefik dashboard", ingressRoute.Name))
@@ -85,84 +143,210 @@ func updateIngressRoutes(c kube.Cluster, migrationResource migration.Resources,
 		// replace the service name with the exported service name
 		for i, route := range ingressRoute.Spec.Routes {
 			for j, service := range route.Services {
-				if migrationResource.GetNetworkingTool() == prompt.NetworkingToolSubmariner {
-					// For Submariner, we need to use the remote service name
-					remoteServiceName := service.Name + "-remote"
-					ingressRoute.Spec.Routes[i].Services[j].Name = remoteServiceName
-				} else if migrationResource.GetNetworkingTool() == prompt.NetworkingToolSkupper {
-					remoteServiceName := service.Name + "-target"
-					ingressRoute.Spec.Routes[i].Services[j].Name = remoteServiceName
-				} else if migrationResource.GetNetworkingTool() == prompt.NetworkingToolLinkerd {
-					remoteServiceName := service.Name + "-target"
+				if service.Kind != "" && service.Kind != "Service" {
+					continue
+				}
+				namespace := serviceNamespace(service.Namespace, ingressRoute.Namespace)
+				if !exported.has(namespace, service.Name) {
+					continue
+				}
+				remoteServiceName := remoteService(clusters, migrationResource, namespace, service.Name)
+
+				// Traefik has to send the requests to the service address for the sidecar to route them
+				if migrationResource.SidecarRouting() {
+					nativeLB := true
+					ingressRoute.Spec.Routes[i].Services[j].NativeLB = &nativeLB
+				}
+
+				backend := shiftedBackend{namespace: namespace, service: service.Name, routed: service.Name}
+				switch {
+				case opts.Rerouting == prompt.ReroutingIstio:
+					// Istio rerouting keeps the service and shifts its traffic
+				case gradualShift(opts):
+					logger.Info(fmt.Sprintf("Routing service %s in IngressRoute %s through a weighted service", service.Name, ingressRoute.Name))
+					backend.routed = remoteServiceName
+					backend.traefikService, err = createWeightedService(c, namespace, ingressRoute.Spec.Routes[i].Services[j], remoteServiceName)
+					if err != nil {
+						return nil, err
+					}
+					ingressRoute.Spec.Routes[i].Services[j] = traefikv1.Service{LoadBalancerSpec: traefikv1.LoadBalancerSpec{
+						Name:      backend.traefikService,
+						Namespace: namespace,
+						Kind:      "TraefikService",
+					}}
+				default:
+					// the exported service of the target cluster is used
 					logger.Info(fmt.Sprintf("Updating service name in IngressRoute %s from %s to %s", ingressRoute.Name, service.Name, remoteServiceName))
 					ingressRoute.Spec.Routes[i].Services[j].Name = remoteServiceName
+				}
+				if key := backend.namespace + "/" + backend.service; !shifted[key] {
+					shifted[key] = true
+					backends = append(backends, backend)
+				}
 
-					if opts.Rerouting == prompt.ReroutingLinkerd {
-						reroutingMiddleware := &traefikv1.Middleware{
-							ObjectMeta: metav1.ObjectMeta{
-								Name:      remoteServiceName + "-rerouting-middleware",
-								Namespace: ingressRoute.Namespace,
-							},
-							Spec: traefikv1.MiddlewareSpec{
-								Headers: &traefikv1dynamic.Headers{
-									CustomRequestHeaders: map[string]string{
-										"l5d-dst-override": fmt.Sprintf("%s.%s.svc.cluster.local:80", remoteServiceName, ingressRoute.Namespace),
-									},
+				if opts.Rerouting == prompt.ReroutingLinkerd {
+					reroutingMiddleware := &traefikv1.Middleware{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      remoteServiceName + "-rerouting-middleware",
+							Namespace: ingressRoute.Namespace,
+						},
+						Spec: traefikv1.MiddlewareSpec{
+							Headers: &traefikv1dynamic.Headers{
+								CustomRequestHeaders: map[string]string{
+									"l5d-dst-override": fmt.Sprintf("%s.%s.svc.cluster.local:%s", remoteServiceName, namespace, servicePort(c, namespace, service.Name, service.Port)),
 								},
 							},
-						}
+						},
+					}
 
-						err = c.CreateResource(kube.Middleware, reroutingMiddleware.Namespace, reroutingMiddleware)
-						exit.OnErrorWithMessage(err, fmt.Sprintf("Failed to create middleware %s", reroutingMiddleware.Name))
+					err = c.CreateResource(kube.Middleware, reroutingMiddleware.Namespace, reroutingMiddleware)
+					exit.OnErrorWithMessage(err, fmt.Sprintf("Failed to create middleware %s", reroutingMiddleware.Name))
 
-						var nativeLB bool
-						nativeLB = true
-						ingressRoute.Spec.Routes[i].Services[j].NativeLB = &nativeLB
-						ingressRoute.Spec.Routes[i].Middlewares = append(ingressRoute.Spec.Routes[i].Middlewares, traefikv1.MiddlewareRef{
-							Name: remoteServiceName + "-rerouting-middleware",
-						})
-					}
-				} else {
-					// For other networking tools, we can keep the original service name
-					ingressRoute.Spec.Routes[i].Services[j].Name = fmt.Sprintf("target.%s.%s.svc.clusterset.local", service.Name, ingressRoute.Namespace)
+					nativeLB := true
+					ingressRoute.Spec.Routes[i].Services[j].NativeLB = &nativeLB
+					ingressRoute.Spec.Routes[i].Middlewares = append(ingressRoute.Spec.Routes[i].Middlewares, traefikv1.MiddlewareRef{
+						Name: remoteServiceName + "-rerouting-middleware",
+					})
 				}
 			}
 		}
 		// Update the ingress route with the modified service names
 		err = c.UpdateResource(kube.IngressRoute, ingressRoute.Name, ingressRoute.Namespace, &ingressRoute)
 		if err != nil {
-			return fmt.Errorf("failed to update ingress route %s: %v", ingressRoute.Name, err)
+			return nil, fmt.Errorf("failed to update ingress route %s: %v", ingressRoute.Name, err)
 		}
 	}
 
-	return nil
+	return backends, nil
 }
 
-func createRemoteService(c kube.Cluster, migrationResource migration.Resources, service v1.Service) error {
-	// Create a new service in the origin cluster that points to the target cluster's service
+// createRemoteService creates the service of origin for an import only reachable by its DNS name. Traefik
+// ignores ExternalName backends of Ingresses and Gateway API implementations reject them, so the service
+// has no selector and an EndpointSlice with the addresses the name resolves to in origin.
+func createRemoteService(c kube.Clusters, migrationResource migration.Resources, service v1.Service) error {
 	remoteService := &v1.Service{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      service.Name + "-remote",
 			Namespace: service.Namespace,
 		},
 		Spec: v1.ServiceSpec{
-			Type:         v1.ServiceTypeExternalName,
-			ExternalName: fmt.Sprintf("target.%s.%s.svc.clusterset.local", service.Name, service.Namespace),
-			Ports: []v1.ServicePort{
-				{
-					Name:     "http",
-					Port:     80,
-					Protocol: v1.ProtocolTCP,
-				},
-			},
-			Selector: map[string]string{"app": service.Name},
+			Ports: remotePorts(service),
 		},
 	}
 
-	err := c.CreateResource(kube.Service, remoteService.Namespace, remoteService)
-	if err != nil {
+	err := c.Origin.CreateResource(kube.Service, remoteService.Namespace, remoteService)
+	if err != nil && !k8serrors.IsAlreadyExists(err) {
 		return fmt.Errorf("failed to create remote service %s: %v", service.Name, err)
 	}
 
-	return nil
+	// Without endpoints the remote service would drop the traffic of every route pointed at it
+	addresses, err := importedAddresses(c, migrationResource, service)
+	if err != nil {
+		return fmt.Errorf("remote service %s/%s has no endpoints: %v", remoteService.Namespace, remoteService.Name, err)
+	}
+	return c.Origin.ApplyEndpointSlice(remoteEndpointSlice(remoteService, addresses))
+}
+
+// importedAddresses resolves the DNS name of the imported service in origin
+func importedAddresses(c kube.Clusters, migrationResource migration.Resources, service v1.Service) ([]string, error) {
+	host := migrationResource.ImportedDNSName(c.Target, service.Namespace, service.Name)
+	var addresses []string
+	err := wait.PollUntilContextTimeout(context.TODO(), 5*time.Second, resolveTimeout, true, func(ctx context.Context) (bool, error) {
+		resolved, err := health.Resolve(c.Origin, host)
+		if err != nil {
+			return false, nil
+		}
+		addresses = addresses[:0]
+		for _, address := range resolved {
+			if ip := net.ParseIP(address); ip != nil && ip.To4() != nil {
+				addresses = append(addresses, address)
+			}
+		}
+		return len(addresses) > 0, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s does not resolve in %s cluster after %s", host, c.Origin.Name, resolveTimeout)
+	}
+	return addresses, nil
+}
+
+// remoteEndpointSlice lists the addresses of the import as endpoints of the remote service, the ports
+// of the import are the ports of the service
+func remoteEndpointSlice(service *v1.Service, addresses []string) *discoveryv1.EndpointSlice {
+	ready := true
+	endpoints := make([]discoveryv1.Endpoint, 0, len(addresses))
+	for _, address := range addresses {
+		endpoints = append(endpoints, discoveryv1.Endpoint{
+			Addresses:  []string{address},
+			Conditions: discoveryv1.EndpointConditions{Ready: &ready},
+		})
+	}
+	ports := make([]discoveryv1.EndpointPort, 0, len(service.Spec.Ports))
+	for _, port := range service.Spec.Ports {
+		name := port.Name
+		protocol := port.Protocol
+		number := port.Port
+		ports = append(ports, discoveryv1.EndpointPort{Name: &name, Protocol: &protocol, Port: &number, AppProtocol: port.AppProtocol})
+	}
+	return &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      service.Name,
+			Namespace: service.Namespace,
+			Labels: map[string]string{
+				discoveryv1.LabelServiceName: service.Name,
+				discoveryv1.LabelManagedBy:   "clustershift",
+			},
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+		Endpoints:   endpoints,
+		Ports:       ports,
+	}
+}
+
+// remotePorts copies the ports of the service, routes reference them by number or name
+func remotePorts(service v1.Service) []v1.ServicePort {
+	ports := make([]v1.ServicePort, 0, len(service.Spec.Ports))
+	for _, port := range service.Spec.Ports {
+		ports = append(ports, v1.ServicePort{
+			Name:        port.Name,
+			Protocol:    port.Protocol,
+			AppProtocol: port.AppProtocol,
+			Port:        port.Port,
+		})
+	}
+	return ports
+}
+
+// remoteService returns the service of the origin cluster the service of the target cluster is reachable through
+func remoteService(clusters kube.Clusters, migrationResource migration.Resources, namespace, name string) string {
+	if imported := migrationResource.ImportedServiceName(clusters.Target, namespace, name); imported != "" {
+		return imported
+	}
+	return name + "-remote"
+}
+
+// serviceNamespace returns the namespace of a service referenced by a route, which defaults to the namespace of the route
+func serviceNamespace(namespace, routeNamespace string) string {
+	if namespace == "" {
+		return routeNamespace
+	}
+	return namespace
+}
+
+// servicePort returns the port number of a route service. Named ports are looked up in the service,
+// routes without a port use the first port of the service.
+func servicePort(c kube.Cluster, namespace, name string, port intstr.IntOrString) string {
+	if port.Type == intstr.Int && port.IntVal != 0 {
+		return port.String()
+	}
+	serviceInterface, err := c.FetchResource(kube.Service, name, namespace)
+	if err != nil {
+		return "80"
+	}
+	for _, servicePort := range serviceInterface.(*v1.Service).Spec.Ports {
+		if port.Type == intstr.Int || servicePort.Name == port.StrVal {
+			return strconv.Itoa(int(servicePort.Port))
+		}
+	}
+	return "80"
 }
@@ -0,0 +1,170 @@
+package redirect
+
+import (
+	"bufio"
+	"bytes"
+	"clustershift/internal/kube"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	traefikLabelSelector = "app.kubernetes.io/name=traefik"
+	// traefikMetricsPort is the metrics entrypoint of the Traefik Helm chart
+	traefikMetricsPort  = "9100"
+	requestsMetric      = "traefik_service_requests_total"
+	durationSumMetric   = "traefik_service_request_duration_seconds_sum"
+	durationCountMetric = "traefik_service_request_duration_seconds_count"
+)
+
+// requestCount counts the requests of a Traefik service, the ones answered with a server error and their duration
+type requestCount struct {
+	total         float64
+	errors        float64
+	durationSum   float64 // seconds
+	durationCount float64
+}
+
+func (r requestCount) minus(other requestCount) requestCount {
+	return requestCount{
+		total:         r.total - other.total,
+		errors:        r.errors - other.errors,
+		durationSum:   r.durationSum - other.durationSum,
+		durationCount: r.durationCount - other.durationCount,
+	}
+}
+
+func (r requestCount) add(other requestCount) requestCount {
+	return requestCount{
+		total:         r.total + other.total,
+		errors:        r.errors + other.errors,
+		durationSum:   r.durationSum + other.durationSum,
+		durationCount: r.durationCount + other.durationCount,
+	}
+}
+
+// meanLatency returns the mean duration of the requests, 0 without requests
+func (r requestCount) meanLatency() time.Duration {
+	if r.durationCount <= 0 {
+		return 0
+	}
+	return time.Duration(r.durationSum / r.durationCount * float64(time.Second))
+}
+
+// traefikRequests are the request counts of the Traefik services, keyed by the service label of the metrics
+type traefikRequests map[string]requestCount
+
+// service sums the counts of a Kubernetes service, which Traefik names <namespace>-<name>-<port>@kubernetescrd
+// with the port as the route references it, by number or name, or <namespace>-<name>@kubernetescrd without port
+func (t traefikRequests) service(namespace, name string, ports []string) requestCount {
+	names := map[string]bool{namespace + "-" + name + "@kubernetescrd": true}
+	for _, port := range ports {
+		names[namespace+"-"+name+"-"+port+"@kubernetescrd"] = true
+	}
+	var sum requestCount
+	for service, count := range t {
+		if names[service] {
+			sum = sum.add(count)
+		}
+	}
+	return sum
+}
+
+// servicePorts returns the numbers and names of the ports of a service, routes reference them by either
+func servicePorts(c kube.Cluster, namespace, name string) []string {
+	serviceInterface, err := c.FetchResource(kube.Service, name, namespace)
+	if err != nil {
+		return nil
+	}
+	var ports []string
+	for _, port := range serviceInterface.(*corev1.Service).Spec.Ports {
+		ports = append(ports, strconv.Itoa(int(port.Port)))
+		if port.Name != "" {
+			ports = append(ports, port.Name)
+		}
+	}
+	return ports
+}
+
+// scrapeRequests reads the request counts from the metrics of all Traefik pods of the cluster
+func scrapeRequests(c kube.Cluster) (traefikRequests, error) {
+	pods, err := c.Clientset.CoreV1().Pods("").List(context.TODO(), metav1.ListOptions{LabelSelector: traefikLabelSelector})
+	if err != nil {
+		return nil, err
+	}
+
+	requests := make(traefikRequests)
+	scraped := 0
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+		content, err := c.Clientset.CoreV1().Pods(pod.Namespace).ProxyGet("http", pod.Name, traefikMetricsPort, "/metrics", nil).DoRaw(context.TODO())
+		if err != nil {
+			return nil, fmt.Errorf("failed to scrape Traefik pod %s/%s: %w", pod.Namespace, pod.Name, err)
+		}
+		parseRequests(content, requests)
+		scraped++
+	}
+	if scraped == 0 {
+		return nil, fmt.Errorf("no running Traefik pods found")
+	}
+	return requests, nil
+}
+
+// parseRequests adds the request counters and durations of Prometheus metrics to the requests
+func parseRequests(content []byte, requests traefikRequests) {
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+		metric, _, found := strings.Cut(line, "{")
+		if !found || (metric != requestsMetric && metric != durationSumMetric && metric != durationCountMetric) {
+			continue
+		}
+		end := strings.LastIndex(line, "}")
+		if end < 0 {
+			continue
+		}
+		fields := strings.Fields(line[end+1:])
+		if len(fields) == 0 {
+			continue
+		}
+		value, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			continue
+		}
+
+		labels := parseLabels(line[len(metric)+1 : end])
+		count := requests[labels["service"]]
+		switch metric {
+		case requestsMetric:
+			count.total += value
+			if strings.HasPrefix(labels["code"], "5") {
+				count.errors += value
+			}
+		case durationSumMetric:
+			count.durationSum += value
+		case durationCountMetric:
+			count.durationCount += value
+		}
+		requests[labels["service"]] = count
+	}
+}
+
+// parseLabels parses the label pairs of a metric, label values of Traefik metrics contain no quotes
+func parseLabels(content string) map[string]string {
+	labels := make(map[string]string)
+	for _, pair := range strings.Split(content, ",") {
+		key, value, found := strings.Cut(pair, "=")
+		if found {
+			labels[strings.TrimSpace(key)] = strings.Trim(value, `"`)
+		}
+	}
+	return labels
+}
@@ -0,0 +1,234 @@
+package redirect
+
+import (
+	"clustershift/internal/kube"
+	"clustershift/internal/logger"
+	"clustershift/internal/migration"
+	"clustershift/internal/prompt"
+	"fmt"
+	"time"
+
+	traefikv1 "github.com/traefik/traefik/v3/pkg/provider/kubernetes/crd/traefikio/v1alpha1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// MirrorResult are the statistics of the requests mirrored to a service of the target cluster
+type MirrorResult struct {
+	Namespace   string
+	Service     string // service of the origin cluster the requests were mirrored from
+	Requests    int64
+	Errors      int64 // responses with a server error
+	MeanLatency time.Duration
+}
+
+// mirrorResults are collected by mirrorTraffic for the migration report
+var mirrorResults []MirrorResult
+
+// MirrorResults returns the statistics of the traffic mirrored before the cutover
+func MirrorResults() []MirrorResult {
+	return mirrorResults
+}
+
+// ValidateMirror checks the mirrored share is a percentage
+func ValidateMirror(opts prompt.MirrorOptions) error {
+	if opts.Percent < 0 || opts.Percent > 100 {
+		return fmt.Errorf("mirrored share %d is not a percentage", opts.Percent)
+	}
+	return nil
+}
+
+// mirrorTraffic copies a share of the requests of every exported IngressRoute service to its service of the
+// target cluster for the configured duration, the responses of the target are discarded. Only GET and HEAD
+// requests are mirrored: every route gets a copy of higher priority for these methods, which sends them
+// through the mirroring service. With opts.Mirror.Writes the routes themselves mirror all methods, the
+// target then applies the writes to its databases. The IngressRoutes are restored on every return and the
+// statistics of the target kept for the migration report.
+func mirrorTraffic(clusters kube.Clusters, migrationResource migration.Resources, exported serviceSet, opts prompt.MigrationOptions) (err error) {
+	if opts.Mirror.Percent == 0 {
+		return nil
+	}
+	if opts.Rerouting == prompt.ReroutingIstio || opts.Rerouting == prompt.ReroutingLinkerd {
+		logger.Warning("Skipping traffic mirroring", fmt.Errorf("%s rerouting routes the requests through the mesh, which Traefik cannot mirror to", opts.Rerouting))
+		return nil
+	}
+	c := clusters.Origin
+
+	ingressRoutes, err := c.FetchResources(kube.IngressRoute)
+	if err != nil {
+		return fmt.Errorf("fetching ingress routes for mirroring failed: %v", err)
+	}
+	ingressRouteList, ok := ingressRoutes.(*traefikv1.IngressRouteList)
+	if !ok {
+		return fmt.Errorf("failed to cast resources to *v1.IngressRouteList")
+	}
+
+	var mirrored []shiftedBackend
+	originalRoutes := make(map[types.NamespacedName][]traefikv1.Route)
+	defer func() {
+		if len(mirrored) == 0 && len(originalRoutes) == 0 {
+			return
+		}
+		if stopErr := stopMirroring(c, originalRoutes, mirrored); err == nil {
+			err = stopErr
+		}
+	}()
+	for _, ingressRoute := range ingressRouteList.Items {
+		if ingressRoute.Name == "traefik-dashboard" {
+			continue
+		}
+		original := ingressRoute.DeepCopy()
+		changed := false
+		routes := make([]traefikv1.Route, 0, len(ingressRoute.Spec.Routes))
+		for _, route := range ingressRoute.Spec.Routes {
+			mirrorRoute := route.DeepCopy()
+			routeMirrored := false
+			for j, service := range route.Services {
+				if service.Kind != "" && service.Kind != "Service" {
+					continue
+				}
+				namespace := serviceNamespace(service.Namespace, ingressRoute.Namespace)
+				// A TraefikService mirroring to a missing service fails as a whole, with it the route
+				if !exported.has(namespace, service.Name) {
+					continue
+				}
+				remoteServiceName := remoteService(clusters, migrationResource, namespace, service.Name)
+
+				backend := shiftedBackend{namespace: namespace, service: service.Name, routed: remoteServiceName}
+				backend.traefikService, err = createMirroringService(c, namespace, service, remoteServiceName, opts.Mirror.Percent)
+				if err != nil {
+					return err
+				}
+				mirrorRoute.Services[j] = traefikv1.Service{LoadBalancerSpec: traefikv1.LoadBalancerSpec{
+					Name:      backend.traefikService,
+					Namespace: namespace,
+					Kind:      "TraefikService",
+				}}
+				if !containsBackend(mirrored, backend) {
+					mirrored = append(mirrored, backend)
+				}
+				routeMirrored = true
+			}
+			if !routeMirrored {
+				routes = append(routes, route)
+				continue
+			}
+			changed = true
+			if opts.Mirror.Writes {
+				routes = append(routes, *mirrorRoute)
+				continue
+			}
+			routes = append(routes, route, readOnlyRoute(*mirrorRoute))
+		}
+		if !changed {
+			continue
+		}
+		ingressRoute.Spec.Routes = routes
+		originalRoutes[types.NamespacedName{Namespace: ingressRoute.Namespace, Name: ingressRoute.Name}] = original.Spec.Routes
+		logger.Info(fmt.Sprintf("Mirroring %d%% of the requests of IngressRoute %s/%s to target cluster", opts.Mirror.Percent, ingressRoute.Namespace, ingressRoute.Name))
+		err = c.UpdateResource(kube.IngressRoute, ingressRoute.Name, ingressRoute.Namespace, &ingressRoute)
+		if err != nil {
+			return fmt.Errorf("failed to update ingress route %s: %v", ingressRoute.Name, err)
+		}
+	}
+	if len(mirrored) == 0 {
+		return nil
+	}
+
+	if opts.Mirror.Writes {
+		logger.Warning("Mirrored requests include writes", fmt.Errorf("POST, PUT and DELETE requests are mirrored too, the target cluster applies them to its databases"))
+	}
+	baseline, err := scrapeRequests(c)
+	if err != nil {
+		logger.Warning("Traefik metrics are unavailable, no statistics of the mirrored requests are collected", err)
+	}
+	logger.Info(fmt.Sprintf("Mirroring traffic for %s", opts.Mirror.Duration))
+	time.Sleep(opts.Mirror.Duration)
+	if baseline != nil {
+		current, err := scrapeRequests(c)
+		if err != nil {
+			logger.Warning("Failed to read Traefik metrics, no statistics of the mirrored requests are collected", err)
+		} else {
+			for _, backend := range mirrored {
+				ports := servicePorts(c, backend.namespace, backend.routed)
+				requests := current.service(backend.namespace, backend.routed, ports).minus(baseline.service(backend.namespace, backend.routed, ports))
+				mirrorResults = append(mirrorResults, MirrorResult{
+					Namespace:   backend.namespace,
+					Service:     backend.service,
+					Requests:    int64(requests.total),
+					Errors:      int64(requests.errors),
+					MeanLatency: requests.meanLatency(),
+				})
+			}
+		}
+	}
+
+	return nil
+}
+
+// readOnlyRoute restricts the route to GET and HEAD requests and ranks it above the route it was copied from.
+// Traefik ranks routes without priority by the length of their rule.
+func readOnlyRoute(route traefikv1.Route) traefikv1.Route {
+	priority := route.Priority
+	if priority == 0 {
+		priority = len(route.Match)
+	}
+	route.Match = fmt.Sprintf("(%s) && (Method(`GET`) || Method(`HEAD`))", route.Match)
+	route.Priority = priority + 1
+	return route
+}
+
+// createMirroringService creates a TraefikService sending the requests to the route service and a
+// copy of the percentage of them to the service of the target cluster
+func createMirroringService(c kube.Cluster, namespace string, service traefikv1.Service, remoteServiceName string, percent int) (string, error) {
+	name := service.Name + "-clustershift-mirror"
+	mirror := service.LoadBalancerSpec
+	mirror.Name = remoteServiceName
+
+	traefikService := &traefikv1.TraefikService{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec: traefikv1.TraefikServiceSpec{
+			Mirroring: &traefikv1.Mirroring{
+				LoadBalancerSpec: service.LoadBalancerSpec,
+				Mirrors:          []traefikv1.MirrorService{{LoadBalancerSpec: mirror, Percent: percent}},
+			},
+		},
+	}
+	if err := ignoreExists(c.CreateResource(kube.TraefikService, namespace, traefikService)); err != nil {
+		return "", fmt.Errorf("failed to create TraefikService %s/%s: %w", namespace, name, err)
+	}
+	return name, nil
+}
+
+// stopMirroring restores the routes of the IngressRoutes and deletes the mirroring services
+func stopMirroring(c kube.Cluster, originalRoutes map[types.NamespacedName][]traefikv1.Route, mirrored []shiftedBackend) error {
+	logger.Info("Stopping traffic mirroring")
+	for key, routes := range originalRoutes {
+		resource, err := c.FetchResource(kube.IngressRoute, key.Name, key.Namespace)
+		if err != nil {
+			return fmt.Errorf("failed to fetch ingress route %s: %v", key, err)
+		}
+		ingressRoute := resource.(*traefikv1.IngressRoute)
+		ingressRoute.Spec.Routes = routes
+		if err := c.UpdateResource(kube.IngressRoute, key.Name, key.Namespace, ingressRoute); err != nil {
+			return fmt.Errorf("failed to restore ingress route %s: %v", key, err)
+		}
+	}
+	for _, backend := range mirrored {
+		err := c.DeleteResource(kube.TraefikService, backend.traefikService, backend.namespace)
+		if err != nil && !k8serrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete TraefikService %s/%s: %v", backend.namespace, backend.traefikService, err)
+		}
+	}
+	return nil
+}
+
+func containsBackend(backends []shiftedBackend, backend shiftedBackend) bool {
+	for _, b := range backends {
+		if b.namespace == backend.namespace && b.service == backend.service {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,233 @@
+package redirect
+
+import (
+	"clustershift/internal/constants"
+	"clustershift/internal/kube"
+	"clustershift/internal/logger"
+	"clustershift/pkg/proxy"
+	"context"
+	"encoding/json"
+	"fmt"
+
+	traefikv1 "github.com/traefik/traefik/v3/pkg/provider/kubernetes/crd/traefikio/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// proxyReplicas keeps requests flowing while a proxy pod restarts or its node drains
+const proxyReplicas = 2
+
+// proxyRoutePriority places the proxy routes above the existing routes, whose priority is the length of their rule
+const proxyRoutePriority = 100000
+
+// deployProxy creates the reverse proxy pods running clustershift proxy, spread across nodes, their
+// permissions to publish stats and the service the ingress routes forward to
+func deployProxy(c kube.Cluster, defaultUpstream string) error {
+	namespace := constants.HttpProxyNamespace
+	name := constants.HttpProxyName
+	labels := map[string]string{"app": name}
+	c.CreateNewNamespace(namespace)
+
+	config, err := json.Marshal(proxy.Config{
+		Cluster:    c.Name,
+		Namespace:  namespace,
+		Default:    defaultUpstream,
+		Upstreams:  Upstreams,
+		HTTPPort:   constants.HttpProxyPort,
+		TLSPort:    constants.HttpProxyTLSPort,
+		HealthPort: constants.HttpProxyHealthPort,
+	})
+	if err != nil {
+		return err
+	}
+	c.CreateConfigmap(constants.HttpProxyConfigmapName, namespace, map[string]string{"proxy.json": string(config)})
+
+	serviceAccount := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}
+	if err := ignoreExists(c.CreateResource(kube.ServiceAccount, namespace, serviceAccount)); err != nil {
+		return err
+	}
+	role := &rbacv1.Role{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Rules: []rbacv1.PolicyRule{{
+			APIGroups: []string{""},
+			Resources: []string{"configmaps"},
+			Verbs:     []string{"get", "create", "update"},
+		}},
+	}
+	if _, err := c.Clientset.RbacV1().Roles(namespace).Create(context.TODO(), role, metav1.CreateOptions{}); ignoreExists(err) != nil {
+		return err
+	}
+	roleBinding := &rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		RoleRef:    rbacv1.RoleRef{APIGroup: "rbac.authorization.k8s.io", Kind: "Role", Name: name},
+		Subjects:   []rbacv1.Subject{{Kind: "ServiceAccount", Name: name, Namespace: namespace}},
+	}
+	if _, err := c.Clientset.RbacV1().RoleBindings(namespace).Create(context.TODO(), roleBinding, metav1.CreateOptions{}); ignoreExists(err) != nil {
+		return err
+	}
+
+	replicas := int32(proxyReplicas)
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					ServiceAccountName: name,
+					Affinity: &corev1.Affinity{PodAntiAffinity: &corev1.PodAntiAffinity{
+						PreferredDuringSchedulingIgnoredDuringExecution: []corev1.WeightedPodAffinityTerm{{
+							Weight: 100,
+							PodAffinityTerm: corev1.PodAffinityTerm{
+								LabelSelector: &metav1.LabelSelector{MatchLabels: labels},
+								TopologyKey:   corev1.LabelHostname,
+							},
+						}},
+					}},
+					Containers: []corev1.Container{{
+						Name:  "proxy",
+						Image: constants.ClustershiftImage,
+						Args:  []string{"proxy", "--config", proxy.ConfigFile},
+						Ports: []corev1.ContainerPort{
+							{Name: "http", ContainerPort: constants.HttpProxyPort, Protocol: corev1.ProtocolTCP},
+							{Name: "tls", ContainerPort: constants.HttpProxyTLSPort, Protocol: corev1.ProtocolTCP},
+							{Name: "health", ContainerPort: constants.HttpProxyHealthPort, Protocol: corev1.ProtocolTCP},
+						},
+						ReadinessProbe: &corev1.Probe{
+							ProbeHandler: corev1.ProbeHandler{HTTPGet: &corev1.HTTPGetAction{
+								Path: "/healthz",
+								Port: intstr.FromString("health"),
+							}},
+							PeriodSeconds: 5,
+						},
+						LivenessProbe: &corev1.Probe{
+							ProbeHandler: corev1.ProbeHandler{HTTPGet: &corev1.HTTPGetAction{
+								Path: "/healthz",
+								Port: intstr.FromString("health"),
+							}},
+							PeriodSeconds: 10,
+						},
+						VolumeMounts: []corev1.VolumeMount{{
+							Name:      "config",
+							MountPath: "/etc/clustershift",
+							ReadOnly:  true,
+						}},
+					}},
+					Volumes: []corev1.Volume{{
+						Name: "config",
+						VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{
+							LocalObjectReference: corev1.LocalObjectReference{Name: constants.HttpProxyConfigmapName},
+						}},
+					}},
+				},
+			},
+		},
+	}
+	if err := ignoreExists(c.CreateResource(kube.Deployment, namespace, deployment)); err != nil {
+		return err
+	}
+
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec: corev1.ServiceSpec{
+			Selector: labels,
+			Ports: []corev1.ServicePort{
+				{Name: "http", Protocol: corev1.ProtocolTCP, Port: constants.HttpProxyPort, TargetPort: intstr.FromString("http")},
+				{Name: "tls", Protocol: corev1.ProtocolTCP, Port: constants.HttpProxyTLSPort, TargetPort: intstr.FromString("tls")},
+			},
+		},
+	}
+	return ignoreExists(c.CreateResource(kube.Service, namespace, service))
+}
+
+// createProxyRoutes sends all requests of the Traefik entrypoints to the proxy. Plain HTTP is
+// forwarded by the proxy, gRPC over cleartext HTTP/2 and TLS connections are passed through.
+func createProxyRoutes(c kube.Cluster) error {
+	namespace := constants.HttpProxyNamespace
+	name := constants.HttpProxyName
+
+	ingressRoute := &traefikv1.IngressRoute{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec: traefikv1.IngressRouteSpec{
+			EntryPoints: []string{"web"},
+			Routes: []traefikv1.Route{
+				{
+					Match:    "HeaderRegexp(`Content-Type`, `^application/grpc`)",
+					Kind:     "Rule",
+					Priority: proxyRoutePriority + 1,
+					Services: []traefikv1.Service{{LoadBalancerSpec: traefikv1.LoadBalancerSpec{
+						Name:   name,
+						Port:   intstr.FromInt32(constants.HttpProxyPort),
+						Scheme: "h2c",
+					}}},
+				},
+				{
+					Match:    "PathPrefix(`/`)",
+					Kind:     "Rule",
+					Priority: proxyRoutePriority,
+					Services: []traefikv1.Service{{LoadBalancerSpec: traefikv1.LoadBalancerSpec{
+						Name: name,
+						Port: intstr.FromInt32(constants.HttpProxyPort),
+					}}},
+				},
+			},
+		},
+	}
+	if err := ignoreExists(c.CreateResource(kube.IngressRoute, namespace, ingressRoute)); err != nil {
+		return fmt.Errorf("failed to create IngressRoute %s: %w", name, err)
+	}
+
+	ingressRouteTCP := &traefikv1.IngressRouteTCP{
+		ObjectMeta: metav1.ObjectMeta{Name: name + "-tls", Namespace: namespace},
+		Spec: traefikv1.IngressRouteTCPSpec{
+			EntryPoints: []string{"websecure"},
+			Routes: []traefikv1.RouteTCP{{
+				Match:    "HostSNI(`*`)",
+				Priority: proxyRoutePriority,
+				Services: []traefikv1.ServiceTCP{{
+					Name: name,
+					Port: intstr.FromInt32(constants.HttpProxyTLSPort),
+				}},
+			}},
+			TLS: &traefikv1.TLSTCP{Passthrough: true},
+		},
+	}
+	if err := ignoreExists(c.CreateResource(kube.IngressRouteTCP, namespace, ingressRouteTCP)); err != nil {
+		return fmt.Errorf("failed to create IngressRouteTCP %s: %w", ingressRouteTCP.Name, err)
+	}
+	return nil
+}
+
+// ReportProxyStats logs the requests the proxy of the cluster forwarded per host
+func ReportProxyStats(c kube.Cluster) error {
+	configMap, err := c.Clientset.CoreV1().ConfigMaps(constants.HttpProxyNamespace).Get(context.TODO(), proxy.StatsConfigMap, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("no stats from the proxy in %s cluster yet: %w", c.Name, err)
+	}
+	stats, err := proxy.ReadStats(configMap)
+	if err != nil {
+		return err
+	}
+
+	logger.Info(fmt.Sprintf("Proxy in %s cluster running since %s, updated %s", stats.Cluster, stats.Started.Format("2006-01-02 15:04:05"), stats.Updated.Format("15:04:05")))
+	if len(stats.Hosts) == 0 {
+		logger.Info("No requests forwarded yet")
+	}
+	for _, host := range stats.SortedHosts() {
+		hostStats := stats.Hosts[host]
+		logger.Info(fmt.Sprintf("%s: %d requests, %d TLS connections, %d errors", host, hostStats.Requests, hostStats.Connections, hostStats.Errors))
+	}
+	return nil
+}
+
+func ignoreExists(err error) error {
+	if k8serrors.IsAlreadyExists(err) {
+		return nil
+	}
+	return err
+}
@@ -7,66 +7,82 @@ import (
 	"clustershift/internal/logger"
 	"clustershift/internal/migration"
 	"clustershift/internal/prompt"
+	"context"
 	"fmt"
-	"strconv"
+	"time"
 
 	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+// Upstreams maps hosts to the address the Clustershift proxy forwards their requests to,
+// all other hosts are forwarded to DefaultUpstream
+var Upstreams map[string]string
+
+// DefaultUpstream receives the requests of all other hosts, the LoadBalancer of the Traefik
+// service of the target cluster if empty
+var DefaultUpstream string
+
 func InitializeRequestForwarding(c kube.Clusters) {
 	logger.Info("Deploy reverse proxy for request forwarding")
 
-	// Get the Loadbalancer IP of the target cluster
-	logger.Debug("Fetching loadbalancer IP")
-	ip, err := getLoadbalancerIP(c.Target)
-	exit.OnErrorWithMessage(err, "Failed to get loadbalancer ip")
-	logger.Debug(fmt.Sprintf("Fetched loadbalancer IP: %s", ip))
+	upstream := DefaultUpstream
+	if upstream == "" {
+		address, err := ingressControllerAddress(c.Target)
+		exit.OnErrorWithMessage(err, "Failed to determine the default upstream of the reverse proxy, set it with --proxy-default-upstream")
+		upstream = address
+	}
+	logger.Debug(fmt.Sprintf("Forwarding requests of other hosts to %s", upstream))
 
 	// Create HTTP proxy resources in the origin cluster
 	logger.Debug("Deploying proxy")
-	createHttpProxyDeployment(c.Origin, ip)
+	err := deployProxy(c.Origin, upstream)
+	exit.OnErrorWithMessage(err, "Failed to deploy the reverse proxy")
+	err = kube.WaitForPodsReadyByLabel(c.Origin, "app="+constants.HttpProxyName, constants.HttpProxyNamespace, 90*time.Second)
+	exit.OnErrorWithMessage(err, "Reverse proxy did not become ready")
 }
 
 func EnableRequestForwarding(c kube.Clusters, opts prompt.MigrationOptions, resources migration.Resources) {
 	logger.Info("Enable request forwarding from origin")
 	if opts.Rerouting == prompt.ReroutingClustershift {
-		err := c.Origin.CreateResourcesFromURL(constants.HttpProxyIngressURL, "clustershift")
-		exit.OnErrorWithMessage(err, "Failed to create resources from URL")
+		err := createProxyRoutes(c.Origin)
+		exit.OnErrorWithMessage(err, "Failed to route requests to the reverse proxy")
+		logger.Info("Requests are forwarded to the target cluster, run clustershift proxy stats to follow them")
 	} else {
 		Redirect(c, resources, opts)
 	}
 }
 
-func getLoadbalancerIP(c kube.Cluster) (string, error) {
-	services, err := c.FetchResources(kube.Service)
+// ingressControllerAddress returns the LoadBalancer address of the Traefik service of the cluster
+func ingressControllerAddress(c kube.Cluster) (string, error) {
+	services, err := c.Clientset.CoreV1().Services("").List(context.TODO(), metav1.ListOptions{LabelSelector: traefikLabelSelector})
 	if err != nil {
-		return "", fmt.Errorf("fetching services for loadbalancer ip failed: %v", err)
-	}
-
-	serviceList, ok := services.(*v1.ServiceList)
-	if !ok {
-		return "", fmt.Errorf("failed to cast resources to *v1.ServiceList")
+		return "", fmt.Errorf("fetching the Traefik services of %s cluster failed: %v", c.Name, err)
 	}
 
-	for _, service := range serviceList.Items {
-		if service.Status.LoadBalancer.Ingress != nil && len(service.Status.LoadBalancer.Ingress) > 0 {
-			ip := service.Status.LoadBalancer.Ingress[0].IP
-			logger.Debug(fmt.Sprintf("Service: %s IP: %s", service.ObjectMeta.Name, ip))
-			return ip, nil
+	var addresses []string
+	for _, service := range services.Items {
+		if service.Spec.Type != v1.ServiceTypeLoadBalancer {
+			continue
+		}
+		for _, ingress := range service.Status.LoadBalancer.Ingress {
+			address := ingress.IP
+			if address == "" {
+				address = ingress.Hostname
+			}
+			if address != "" {
+				logger.Debug(fmt.Sprintf("Traefik service %s/%s has address %s", service.Namespace, service.Name, address))
+				addresses = append(addresses, address)
+				break
+			}
 		}
 	}
-	return "", fmt.Errorf("no LoadBalancer IP found")
-}
-
-func createHttpProxyDeployment(c kube.Cluster, lbIpTarget string) {
-	// Create configmap
-	data := map[string]string{
-		"TARGET_URL": lbIpTarget,
-		"PORT":       strconv.Itoa(constants.HttpProxyPort),
+	switch len(addresses) {
+	case 0:
+		return "", fmt.Errorf("no LoadBalancer service with label %s has an address in %s cluster", traefikLabelSelector, c.Name)
+	case 1:
+		return addresses[0], nil
+	default:
+		return "", fmt.Errorf("%d LoadBalancer services with label %s in %s cluster", len(addresses), traefikLabelSelector, c.Name)
 	}
-	c.CreateConfigmap("http-proxy-config", constants.HttpProxyNamespace, data)
-
-	// Create resources from yaml
-	err := c.CreateResourcesFromURL(constants.HttpProxyDeploymentURL, "clustershift")
-	exit.OnErrorWithMessage(err, "Failed to create resources from URL")
 }
@@ -0,0 +1,167 @@
+package redirect
+
+import (
+	"clustershift/internal/kube"
+	"clustershift/internal/logger"
+	"clustershift/internal/migration"
+	"clustershift/internal/prompt"
+	"fmt"
+
+	traefikv1 "github.com/traefik/traefik/v3/pkg/provider/kubernetes/crd/traefikio/v1alpha1"
+	networkingv1 "k8s.io/api/networking/v1"
+)
+
+// updateStreamRoutes points the TCP, UDP and Ingress routes of origin to the services of the target cluster.
+// They switch in one step once the HTTP traffic moved, routes to services that were not exported stay in origin.
+func updateStreamRoutes(clusters kube.Clusters, migrationResource migration.Resources, exported serviceSet, opts prompt.MigrationOptions) error {
+	if err := updateIngressRouteTCPs(clusters, migrationResource, exported, opts); err != nil {
+		return err
+	}
+	if err := updateIngressRouteUDPs(clusters, migrationResource, exported, opts); err != nil {
+		return err
+	}
+	return updateIngresses(clusters, migrationResource, exported, opts)
+}
+
+// updateIngressRouteTCPs changes the service names of the IngressRouteTCPs of origin to the exported service names.
+// TLS passthrough routes keep their TLS configuration, the service of the target cluster terminates the connection.
+func updateIngressRouteTCPs(clusters kube.Clusters, migrationResource migration.Resources, exported serviceSet, opts prompt.MigrationOptions) error {
+	c := clusters.Origin
+	ingressRoutes, err := c.FetchResources(kube.IngressRouteTCP)
+	if err != nil {
+		return fmt.Errorf("fetching tcp ingress routes for update failed: %v", err)
+	}
+
+	ingressRouteList, ok := ingressRoutes.(*traefikv1.IngressRouteTCPList)
+	if !ok {
+		return fmt.Errorf("failed to cast resources to *v1.IngressRouteTCPList")
+	}
+
+	for _, ingressRoute := range ingressRouteList.Items {
+		for i, route := range ingressRoute.Spec.Routes {
+			for j, service := range route.Services {
+				namespace := serviceNamespace(service.Namespace, ingressRoute.Namespace)
+				if !exported.has(namespace, service.Name) {
+					continue
+				}
+
+				// Istio rerouting keeps the service and shifts its traffic
+				if opts.Rerouting != prompt.ReroutingIstio {
+					remoteServiceName := remoteService(clusters, migrationResource, namespace, service.Name)
+					logger.Info(fmt.Sprintf("Updating service name in IngressRouteTCP %s from %s to %s", ingressRoute.Name, service.Name, remoteServiceName))
+					ingressRoute.Spec.Routes[i].Services[j].Name = remoteServiceName
+				}
+
+				// The mesh proxy of Traefik routes connections by the service address
+				if migrationResource.SidecarRouting() || opts.Rerouting == prompt.ReroutingLinkerd {
+					nativeLB := true
+					ingressRoute.Spec.Routes[i].Services[j].NativeLB = &nativeLB
+				}
+			}
+		}
+
+		err = c.UpdateResource(kube.IngressRouteTCP, ingressRoute.Name, ingressRoute.Namespace, &ingressRoute)
+		if err != nil {
+			return fmt.Errorf("failed to update tcp ingress route %s: %v", ingressRoute.Name, err)
+		}
+	}
+	return nil
+}
+
+// updateIngressRouteUDPs changes the service names of the IngressRouteUDPs of origin to the exported service names.
+// The meshes do not carry UDP, with mesh rerouting the routes stay in the origin cluster.
+func updateIngressRouteUDPs(clusters kube.Clusters, migrationResource migration.Resources, exported serviceSet, opts prompt.MigrationOptions) error {
+	c := clusters.Origin
+	ingressRoutes, err := c.FetchResources(kube.IngressRouteUDP)
+	if err != nil {
+		return fmt.Errorf("fetching udp ingress routes for update failed: %v", err)
+	}
+
+	ingressRouteList, ok := ingressRoutes.(*traefikv1.IngressRouteUDPList)
+	if !ok {
+		return fmt.Errorf("failed to cast resources to *v1.IngressRouteUDPList")
+	}
+
+	for _, ingressRoute := range ingressRouteList.Items {
+		if opts.Rerouting == prompt.ReroutingIstio || opts.Rerouting == prompt.ReroutingLinkerd {
+			logger.Warning(fmt.Sprintf("IngressRouteUDP %s/%s stays in origin cluster, it has to be moved manually", ingressRoute.Namespace, ingressRoute.Name),
+				fmt.Errorf("%s does not carry UDP", opts.Rerouting))
+			continue
+		}
+
+		for i, route := range ingressRoute.Spec.Routes {
+			for j, service := range route.Services {
+				namespace := serviceNamespace(service.Namespace, ingressRoute.Namespace)
+				if !exported.has(namespace, service.Name) {
+					continue
+				}
+				remoteServiceName := remoteService(clusters, migrationResource, namespace, service.Name)
+				logger.Info(fmt.Sprintf("Updating service name in IngressRouteUDP %s from %s to %s", ingressRoute.Name, service.Name, remoteServiceName))
+				ingressRoute.Spec.Routes[i].Services[j].Name = remoteServiceName
+			}
+		}
+
+		err = c.UpdateResource(kube.IngressRouteUDP, ingressRoute.Name, ingressRoute.Namespace, &ingressRoute)
+		if err != nil {
+			return fmt.Errorf("failed to update udp ingress route %s: %v", ingressRoute.Name, err)
+		}
+	}
+	return nil
+}
+
+// updateIngresses changes the backend services of the Ingresses of origin to the exported service names.
+// Backends keep their port, named ports resolve as the remote services copy the port names.
+func updateIngresses(clusters kube.Clusters, migrationResource migration.Resources, exported serviceSet, opts prompt.MigrationOptions) error {
+	// Istio rerouting keeps the services and shifts their traffic
+	if opts.Rerouting == prompt.ReroutingIstio {
+		return nil
+	}
+
+	c := clusters.Origin
+	ingresses, err := c.FetchResources(kube.Ingress)
+	if err != nil {
+		return fmt.Errorf("fetching ingresses for update failed: %v", err)
+	}
+
+	ingressList, ok := ingresses.(*networkingv1.IngressList)
+	if !ok {
+		return fmt.Errorf("failed to cast resources to *networkingv1.IngressList")
+	}
+
+	for _, ingress := range ingressList.Items {
+		var backends []*networkingv1.IngressBackend
+		if ingress.Spec.DefaultBackend != nil {
+			backends = append(backends, ingress.Spec.DefaultBackend)
+		}
+		for i := range ingress.Spec.Rules {
+			if ingress.Spec.Rules[i].HTTP == nil {
+				continue
+			}
+			for j := range ingress.Spec.Rules[i].HTTP.Paths {
+				backends = append(backends, &ingress.Spec.Rules[i].HTTP.Paths[j].Backend)
+			}
+		}
+
+		changed := false
+		for _, backend := range backends {
+			// Resource backends reference objects of the ingress controller instead of services, services
+			// that were not exported stay in origin
+			if backend.Service == nil || !exported.has(ingress.Namespace, backend.Service.Name) {
+				continue
+			}
+			remoteServiceName := remoteService(clusters, migrationResource, ingress.Namespace, backend.Service.Name)
+			logger.Info(fmt.Sprintf("Updating service name in Ingress %s from %s to %s", ingress.Name, backend.Service.Name, remoteServiceName))
+			backend.Service.Name = remoteServiceName
+			changed = true
+		}
+		if !changed {
+			continue
+		}
+
+		err = c.UpdateResource(kube.Ingress, ingress.Name, ingress.Namespace, &ingress)
+		if err != nil {
+			return fmt.Errorf("failed to update ingress %s: %v", ingress.Name, err)
+		}
+	}
+	return nil
+}
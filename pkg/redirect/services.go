@@ -0,0 +1,150 @@
+package redirect
+
+import (
+	"clustershift/internal/kube"
+	"clustershift/internal/logger"
+	"context"
+	"fmt"
+
+	traefikv1 "github.com/traefik/traefik/v3/pkg/provider/kubernetes/crd/traefikio/v1alpha1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// routedService is a service of origin a route sends traffic to, only these services are exported
+type routedService struct {
+	namespace string
+	name      string
+}
+
+// serviceSet holds the routed services exported to origin, routes to other services are left untouched
+type serviceSet map[routedService]bool
+
+func newServiceSet(services []routedService) serviceSet {
+	set := make(serviceSet, len(services))
+	for _, service := range services {
+		set[service] = true
+	}
+	return set
+}
+
+// has reports whether the service of the namespace is in the set
+func (s serviceSet) has(namespace, name string) bool {
+	return s[routedService{namespace: namespace, name: name}]
+}
+
+// routedServices returns the services referenced by the IngressRoutes, IngressRouteTCPs, IngressRouteUDPs,
+// Ingresses and Gateway API routes of the cluster
+func routedServices(c kube.Cluster) ([]routedService, error) {
+	var services []routedService
+	add := func(namespace, name string) {
+		service := routedService{namespace: namespace, name: name}
+		for _, existing := range services {
+			if existing == service {
+				return
+			}
+		}
+		services = append(services, service)
+	}
+
+	ingressRoutes, err := c.FetchResources(kube.IngressRoute)
+	if err != nil {
+		return nil, fmt.Errorf("fetching ingress routes failed: %v", err)
+	}
+	for _, ingressRoute := range ingressRoutes.(*traefikv1.IngressRouteList).Items {
+		if ingressRoute.Name == "traefik-dashboard" {
+			continue
+		}
+		for _, route := range ingressRoute.Spec.Routes {
+			for _, service := range route.Services {
+				if service.Kind == "" || service.Kind == "Service" {
+					add(serviceNamespace(service.Namespace, ingressRoute.Namespace), service.Name)
+				}
+			}
+		}
+	}
+
+	tcpRoutes, err := c.FetchResources(kube.IngressRouteTCP)
+	if err != nil {
+		return nil, fmt.Errorf("fetching tcp ingress routes failed: %v", err)
+	}
+	for _, ingressRoute := range tcpRoutes.(*traefikv1.IngressRouteTCPList).Items {
+		for _, route := range ingressRoute.Spec.Routes {
+			for _, service := range route.Services {
+				add(serviceNamespace(service.Namespace, ingressRoute.Namespace), service.Name)
+			}
+		}
+	}
+
+	udpRoutes, err := c.FetchResources(kube.IngressRouteUDP)
+	if err != nil {
+		return nil, fmt.Errorf("fetching udp ingress routes failed: %v", err)
+	}
+	for _, ingressRoute := range udpRoutes.(*traefikv1.IngressRouteUDPList).Items {
+		for _, route := range ingressRoute.Spec.Routes {
+			for _, service := range route.Services {
+				add(serviceNamespace(service.Namespace, ingressRoute.Namespace), service.Name)
+			}
+		}
+	}
+
+	ingresses, err := c.FetchResources(kube.Ingress)
+	if err != nil {
+		return nil, fmt.Errorf("fetching ingresses failed: %v", err)
+	}
+	for _, ingress := range ingresses.(*networkingv1.IngressList).Items {
+		if backend := ingress.Spec.DefaultBackend; backend != nil && backend.Service != nil {
+			add(ingress.Namespace, backend.Service.Name)
+		}
+		for _, rule := range ingress.Spec.Rules {
+			if rule.HTTP == nil {
+				continue
+			}
+			for _, path := range rule.HTTP.Paths {
+				if path.Backend.Service != nil {
+					add(ingress.Namespace, path.Backend.Service.Name)
+				}
+			}
+		}
+	}
+
+	versions, err := c.FetchAPIVersions()
+	if err != nil {
+		return nil, err
+	}
+	for _, kind := range gatewayRouteKinds {
+		gvk, served := versions.Preferred(schema.GroupKind{Group: gatewayGroup, Kind: kind})
+		if !served {
+			continue
+		}
+		gvr, _ := versions.Resource(gvk)
+		list, err := c.DynamicClientset.Resource(gvr).Namespace("").List(context.TODO(), metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("fetching %s failed: %v", kind, err)
+		}
+		for _, item := range list.Items {
+			rules, _, _ := unstructured.NestedSlice(item.Object, "spec", "rules")
+			for _, rule := range rules {
+				ruleMap, ok := rule.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				refs, _, _ := unstructured.NestedSlice(ruleMap, "backendRefs")
+				for _, ref := range refs {
+					refMap, ok := ref.(map[string]interface{})
+					if !ok || !isServiceRef(refMap) {
+						continue
+					}
+					name, _ := refMap["name"].(string)
+					namespace, _ := refMap["namespace"].(string)
+					add(serviceNamespace(namespace, item.GetNamespace()), name)
+				}
+			}
+		}
+	}
+
+	logger.Debug(fmt.Sprintf("Routes of %s cluster reference %d services", c.Name, len(services)))
+	return services, nil
+}
@@ -0,0 +1,192 @@
+package redirect
+
+import (
+	"clustershift/internal/kube"
+	"clustershift/internal/logger"
+	"clustershift/internal/prompt"
+	"clustershift/pkg/health"
+	"fmt"
+	"time"
+
+	traefikv1 "github.com/traefik/traefik/v3/pkg/provider/kubernetes/crd/traefikio/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	shiftCheckInterval = 10 * time.Second
+	// shiftMinRequests is the number of requests of a step below which its error rate is not judged
+	shiftMinRequests = 20
+)
+
+// shiftedBackend is a service of the ingress routes whose traffic moves to the target cluster
+type shiftedBackend struct {
+	namespace string
+	service   string // service of the target cluster
+	routed    string // service the routes send the target share of the requests to
+	// traefikService is the weighted TraefikService of the backend, empty if Istio shifts the traffic
+	// or the backend is weighted in Gateway API routes
+	traefikService string
+}
+
+// DefaultShift returns the cutover used when no options are given, which switches in one step
+func DefaultShift() prompt.ShiftOptions {
+	return prompt.ShiftOptions{
+		Hold:         2 * time.Minute,
+		MaxErrorRate: 0.05,
+	}
+}
+
+// ValidateShift checks the steps increase up to 100 percent
+func ValidateShift(opts prompt.ShiftOptions) error {
+	previous := 0
+	for _, step := range opts.Steps {
+		if step <= previous || step > 100 {
+			return fmt.Errorf("steps %v have to increase from 1 to 100 percent", opts.Steps)
+		}
+		previous = step
+	}
+	if len(opts.Steps) > 0 && previous != 100 {
+		return fmt.Errorf("the last step has to send 100 percent of the traffic to the target cluster")
+	}
+	if opts.MaxErrorRate < 0 || opts.MaxErrorRate > 1 {
+		return fmt.Errorf("error rate %v is not between 0 and 1", opts.MaxErrorRate)
+	}
+	return nil
+}
+
+// gradualShift reports whether the traffic moves to the target cluster in steps
+func gradualShift(opts prompt.MigrationOptions) bool {
+	return len(opts.Shift.Steps) > 0
+}
+
+// createWeightedService creates a TraefikService splitting the requests of the route service between
+// the origin service and the service of the target cluster, all requests stay in the origin at first
+func createWeightedService(c kube.Cluster, namespace string, service traefikv1.Service, remoteServiceName string) (string, error) {
+	name := service.Name + "-clustershift"
+	origin := service
+	origin.Weight = weight(100)
+	remote := service
+	remote.Name = remoteServiceName
+	remote.Weight = weight(0)
+
+	traefikService := &traefikv1.TraefikService{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec: traefikv1.TraefikServiceSpec{
+			Weighted: &traefikv1.WeightedRoundRobin{Services: []traefikv1.Service{origin, remote}},
+		},
+	}
+	if err := ignoreExists(c.CreateResource(kube.TraefikService, namespace, traefikService)); err != nil {
+		return "", fmt.Errorf("failed to create TraefikService %s/%s: %w", namespace, name, err)
+	}
+	return name, nil
+}
+
+// shiftTraffic moves the traffic of the backends to the target cluster. Every step is held while the
+// target is checked, the traffic returns to the origin cluster if a check fails.
+func shiftTraffic(c kube.Clusters, opts prompt.MigrationOptions, backends []shiftedBackend, gatewayRoutes []gatewayRoute) error {
+	if !gradualShift(opts) {
+		if opts.Rerouting == prompt.ReroutingIstio {
+			return shiftServices(c, backends, 100)
+		}
+		return nil
+	}
+	defer health.Cleanup(c)
+
+	for _, percent := range opts.Shift.Steps {
+		logger.Info(fmt.Sprintf("Shifting %d%% of the traffic to target cluster", percent))
+		if err := setWeights(c, opts, backends, gatewayRoutes, percent); err != nil {
+			return err
+		}
+
+		if err := holdStep(c, opts, backends); err != nil {
+			logger.Warning(fmt.Sprintf("Cutover step %d%% failed, reverting the traffic to origin cluster", percent), err)
+			if revertErr := setWeights(c, opts, backends, gatewayRoutes, 0); revertErr != nil {
+				return fmt.Errorf("failed to revert the traffic to origin cluster: %v", revertErr)
+			}
+			return fmt.Errorf("traffic reverted to origin cluster at %d%%: %w", percent, err)
+		}
+	}
+	return nil
+}
+
+// setWeights sends the percentage of the traffic of every backend to the target cluster
+func setWeights(c kube.Clusters, opts prompt.MigrationOptions, backends []shiftedBackend, gatewayRoutes []gatewayRoute, percent int) error {
+	if opts.Rerouting == prompt.ReroutingIstio {
+		return shiftServices(c, backends, percent)
+	}
+
+	for _, backend := range backends {
+		// Gateway API backends are weighted in their routes
+		if backend.traefikService == "" {
+			continue
+		}
+		resource, err := c.Origin.FetchResource(kube.TraefikService, backend.traefikService, backend.namespace)
+		if err != nil {
+			return fmt.Errorf("failed to fetch TraefikService %s/%s: %w", backend.namespace, backend.traefikService, err)
+		}
+		traefikService := resource.(*traefikv1.TraefikService)
+		for i, service := range traefikService.Spec.Weighted.Services {
+			if service.Name == backend.routed {
+				traefikService.Spec.Weighted.Services[i].Weight = weight(percent)
+			} else {
+				traefikService.Spec.Weighted.Services[i].Weight = weight(100 - percent)
+			}
+		}
+		err = c.Origin.UpdateResource(kube.TraefikService, traefikService.Name, traefikService.Namespace, traefikService)
+		if err != nil {
+			return fmt.Errorf("failed to update TraefikService %s/%s: %w", backend.namespace, backend.traefikService, err)
+		}
+	}
+	return setGatewayWeights(c.Origin, gatewayRoutes, percent)
+}
+
+// holdStep checks the networking tool, the target services and the error rate of the routed
+// services until the hold time of the step passed
+func holdStep(c kube.Clusters, opts prompt.MigrationOptions, backends []shiftedBackend) error {
+	baseline, err := scrapeRequests(c.Origin)
+	if err != nil {
+		logger.Warning("Traefik metrics are unavailable, the error rate is not checked", err)
+	}
+	ports := make(map[shiftedBackend][]string, len(backends))
+	for _, backend := range backends {
+		ports[backend] = servicePorts(c.Origin, backend.namespace, backend.routed)
+	}
+
+	deadline := time.Now().Add(opts.Shift.Hold)
+	for {
+		if report := health.Run(c, opts.NetworkingTool); !report.Healthy() {
+			report.Print()
+			return fmt.Errorf("%s is unhealthy", opts.NetworkingTool)
+		}
+		for _, backend := range backends {
+			if ready, err := health.HasReadyEndpoints(c.Target, backend.namespace, backend.service); err != nil || !ready {
+				return fmt.Errorf("service %s/%s has no ready endpoints in target cluster", backend.namespace, backend.service)
+			}
+		}
+
+		if baseline != nil {
+			current, err := scrapeRequests(c.Origin)
+			if err != nil {
+				return fmt.Errorf("failed to read Traefik metrics: %w", err)
+			}
+			for _, backend := range backends {
+				requests := current.service(backend.namespace, backend.routed, ports[backend]).minus(baseline.service(backend.namespace, backend.routed, ports[backend]))
+				if requests.total < shiftMinRequests {
+					continue
+				}
+				if rate := requests.errors / requests.total; rate > opts.Shift.MaxErrorRate {
+					return fmt.Errorf("service %s/%s answered %.1f%% of %d requests with server errors", backend.namespace, backend.routed, rate*100, int(requests.total))
+				}
+			}
+		}
+
+		if time.Now().After(deadline) {
+			return nil
+		}
+		time.Sleep(shiftCheckInterval)
+	}
+}
+
+func weight(percent int) *int {
+	return &percent
+}
@@ -0,0 +1,35 @@
+package skupper
+
+import (
+	"clustershift/internal/kube"
+	"context"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Validate checks that the Skupper installations of the clusters are able to link, sites of
+// different major versions cannot be linked with each other
+func Validate(c kube.Clusters) error {
+	origin := installedVersion(c.Origin)
+	target := installedVersion(c.Target)
+	if origin != 0 && target != 0 && origin != target {
+		return fmt.Errorf("origin cluster runs Skupper v%d and target cluster v%d", origin, target)
+	}
+	return nil
+}
+
+// controllerRunning reports whether a deployment with the label runs in any namespace of the cluster
+func controllerRunning(c kube.Cluster, selector string) bool {
+	deployments, err := c.Clientset.AppsV1().Deployments("").List(context.TODO(), metav1.ListOptions{LabelSelector: selector})
+	return err == nil && len(deployments.Items) > 0
+}
+
+// existingSite returns the name of the Skupper v2 site in the namespace, a namespace has at most one site
+func existingSite(c kube.Cluster, namespace string) string {
+	sites, err := c.DynamicClientset.Resource(siteResource).Namespace(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil || len(sites.Items) == 0 {
+		return ""
+	}
+	return sites.Items[0].GetName()
+}
@@ -1,17 +1,167 @@
 package skupper
 
 import (
-	"clustershift/internal/exit"
 	"clustershift/internal/kube"
 	"clustershift/internal/logger"
+	"context"
+	"fmt"
+	"time"
+
 	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 )
 
-func ExportService(c kube.Cluster, namespace string, name string) {
+const podNameLabel = "statefulset.kubernetes.io/pod-name"
+
+func ExportService(c kube.Cluster, namespace string, name string) error {
 	logger.Info("Export service")
 	serviceInterface, err := c.FetchResource(kube.Service, name, namespace)
-	exit.OnErrorWithMessage(err, "Could not fetch service")
+	if err != nil {
+		return fmt.Errorf("could not fetch service: %w", err)
+	}
+	service := serviceInterface.(*v1.Service)
+
+	if service.Spec.ClusterIP == v1.ClusterIPNone {
+		return exportPods(c, service)
+	}
+	if DetectVersion(c) == V2 {
+		peer, err := kube.Peer(c)
+		if err != nil {
+			return err
+		}
+		return exportServiceV2(c, service, service.Name+"-"+c.Name, peer)
+	}
+	if err := c.AddAnnotation(service, "skupper.io/proxy", "tcp"); err != nil {
+		return fmt.Errorf("failed to annotate service: %w", err)
+	}
+	if err := c.AddAnnotation(service, "skupper.io/address", name+"-"+c.Name); err != nil {
+		return fmt.Errorf("failed to annotate service: %w", err)
+	}
+	return nil
+}
+
+// exportPods exposes every pod of a headless service as <pod>-<cluster> in both clusters. The names
+// tell pods of both clusters apart even if their StatefulSets have the same name, and resolve in the
+// exporting cluster as well, where replica sets address their own members by them.
+func exportPods(c kube.Cluster, service *v1.Service) error {
+	pods, err := c.Clientset.CoreV1().Pods(service.Namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(service.Spec.Selector).String(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list the pods of service %s/%s: %w", service.Namespace, service.Name, err)
+	}
+
+	for _, pod := range pods.Items {
+		podService := kube.AliasService(service, pod.Name+"-"+c.Name, map[string]string{podNameLabel: pod.Name}, nil)
+		podService.Spec.PublishNotReadyAddresses = true
+
+		if DetectVersion(c) == V2 {
+			peer, err := kube.Peer(c)
+			if err != nil {
+				return err
+			}
+			if err := exportServiceV2(c, podService, podService.Name, c, peer); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Skupper 1.x provides the address of an annotated service in every site of the network
+		podService.Annotations = map[string]string{"skupper.io/proxy": "tcp"}
+		err := c.CreateResource(kube.Service, podService.Namespace, podService)
+		if err != nil && !k8serrors.IsAlreadyExists(err) {
+			return fmt.Errorf("failed to create service %s/%s: %w", podService.Namespace, podService.Name, err)
+		}
+	}
+	return nil
+}
+
+// UnexportService removes the export of the service, the exposed addresses disappear in both sites
+func UnexportService(c kube.Cluster, namespace string, name string) error {
+	serviceInterface, err := c.FetchResource(kube.Service, name, namespace)
+	if err != nil {
+		return err
+	}
 	service := serviceInterface.(*v1.Service)
-	exit.OnErrorWithMessage(c.AddAnnotation(service, "skupper.io/proxy", "tcp"), "Failed to annotate service")
-	exit.OnErrorWithMessage(c.AddAnnotation(service, "skupper.io/address", name+"-"+c.Name), "Failed to annotate service")
+	peer, err := kube.Peer(c)
+	if err != nil {
+		return err
+	}
+
+	hosts := []string{name + "-" + c.Name}
+	if service.Spec.ClusterIP == v1.ClusterIPNone {
+		if hosts, err = podHosts(c, service); err != nil {
+			return err
+		}
+	}
+
+	if DetectVersion(c) == V2 {
+		for _, host := range hosts {
+			for _, routingKey := range routingKeys(service, host) {
+				deleteCR(c, connectorResource, namespace, routingKey)
+				deleteCR(c, listenerResource, namespace, routingKey)
+				deleteCR(peer, listenerResource, namespace, routingKey)
+			}
+		}
+	} else if service.Spec.ClusterIP != v1.ClusterIPNone {
+		delete(service.Annotations, "skupper.io/proxy")
+		delete(service.Annotations, "skupper.io/address")
+		if err := c.UpdateResource(kube.Service, name, namespace, service); err != nil {
+			return err
+		}
+	}
+
+	// Pod services are created by clustershift for both versions
+	if service.Spec.ClusterIP == v1.ClusterIPNone {
+		for _, host := range hosts {
+			err := c.DeleteResource(kube.Service, host, namespace)
+			if err != nil && !k8serrors.IsNotFound(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// WaitForImport waits until the addresses of the exported service exist in the peer site
+func WaitForImport(c kube.Cluster, namespace string, name string, timeout time.Duration) error {
+	serviceInterface, err := c.FetchResource(kube.Service, name, namespace)
+	if err != nil {
+		return err
+	}
+	service := serviceInterface.(*v1.Service)
+	peer, err := kube.Peer(c)
+	if err != nil {
+		return err
+	}
+
+	hosts := []string{name + "-" + c.Name}
+	if service.Spec.ClusterIP == v1.ClusterIPNone {
+		if hosts, err = podHosts(c, service); err != nil {
+			return err
+		}
+	}
+	for _, host := range hosts {
+		if err := kube.WaitForService(peer, host, namespace, timeout); err != nil {
+			return fmt.Errorf("%v, check the link between the sites of namespace %s", err, namespace)
+		}
+	}
+	return nil
+}
+
+// podHosts returns the addresses exportPods exposes the pods of a headless service at
+func podHosts(c kube.Cluster, service *v1.Service) ([]string, error) {
+	pods, err := c.Clientset.CoreV1().Pods(service.Namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(service.Spec.Selector).String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var hosts []string
+	for _, pod := range pods.Items {
+		hosts = append(hosts, pod.Name+"-"+c.Name)
+	}
+	return hosts, nil
 }
@@ -9,6 +9,7 @@ import (
 	v1 "k8s.io/api/core/v1"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -18,10 +19,19 @@ var (
 	skupperCLIPath string
 	downloadOnce   sync.Once
 	downloadError  error
+
+	// connectedNamespaces tracks the namespaces whose sites are linked already
+	connectedNamespaces = make(map[string]bool)
 )
 
 func Install(c kube.Clusters) {
-	logger.Info("Installing Skupper")
+	logger.Info(fmt.Sprintf("Installing Skupper v%d", DetectVersion(c.Origin, c.Target)))
+
+	if Version == V2 {
+		CreateController(c.Origin)
+		CreateController(c.Target)
+		return
+	}
 
 	// Deploy Site Controller
 	CreateSiteController(c.Origin)
@@ -29,8 +39,17 @@ func Install(c kube.Clusters) {
 }
 
 func CreateSiteConnection(c kube.Clusters, siteNamespace string) {
+	if connectedNamespaces[siteNamespace] {
+		return
+	}
+	defer func() { connectedNamespaces[siteNamespace] = true }()
 	logger.Info("Creating Site Connection on Namespace: " + siteNamespace)
 
+	if DetectVersion(c.Origin, c.Target) == V2 {
+		createSiteConnectionV2(c, siteNamespace)
+		return
+	}
+
 	// Create Site
 	CreateSite(c.Origin, c.Origin.Name+"-"+siteNamespace, siteNamespace)
 	CreateSite(c.Target, c.Target.Name+"-"+siteNamespace, siteNamespace)
@@ -45,6 +64,10 @@ func CreateSiteConnection(c kube.Clusters, siteNamespace string) {
 }
 
 func CreateSiteController(c kube.Cluster) {
+	if controllerRunning(c, "application=skupper-site-controller") {
+		logger.Info(fmt.Sprintf("Reusing the Skupper site controller of %s cluster", c.Name))
+		return
+	}
 	logger.Info("Deploying Site Controller")
 
 	c.CreateNewNamespace("skupper-site-controller")
@@ -63,10 +86,20 @@ func CreateSiteController(c kube.Cluster) {
 }
 
 func CreateSite(c kube.Cluster, name, namespace string) {
+	if _, err := c.FetchResource(kube.ConfigMap, "skupper-site", namespace); err == nil {
+		logger.Info(fmt.Sprintf("Reusing the Skupper site in namespace %s of %s cluster", namespace, c.Name))
+		return
+	}
 	logger.Info("Creating Site")
 
 	data := map[string]string{
-		"name": name,
+		"name":    name,
+		"routers": strconv.Itoa(routerCount()),
+	}
+	// Routers only follow explicitly selected gateway nodes, the site controller does not
+	// tolerate the taints of control-plane nodes
+	if kube.GatewayNodes.Strategy == kube.NodeStrategySelector {
+		data["router-node-selector"] = kube.GatewayNodes.Selector
 	}
 	c.CreateConfigmap("skupper-site", namespace, data)
 
@@ -77,6 +110,14 @@ func CreateSite(c kube.Cluster, name, namespace string) {
 	exit.OnErrorWithMessage(err, "Failed to wait for Skupper pods to be ready")
 }
 
+// routerCount returns the number of routers per site, more than one router makes the site highly available
+func routerCount() int {
+	if kube.GatewayNodes.Count < 1 {
+		return 1
+	}
+	return kube.GatewayNodes.Count
+}
+
 func CreateConnectionToken(c kube.Cluster, name, namespace string) {
 	logger.Info("Creating Connection Token")
 
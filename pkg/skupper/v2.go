@@ -0,0 +1,259 @@
+package skupper
+
+import (
+	"clustershift/internal/constants"
+	"clustershift/internal/exit"
+	"clustershift/internal/kube"
+	"clustershift/internal/logger"
+	"context"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const apiVersion = "skupper.io/v2alpha1"
+
+var (
+	siteResource        = schema.GroupVersionResource{Group: "skupper.io", Version: "v2alpha1", Resource: "sites"}
+	accessGrantResource = schema.GroupVersionResource{Group: "skupper.io", Version: "v2alpha1", Resource: "accessgrants"}
+	accessTokenResource = schema.GroupVersionResource{Group: "skupper.io", Version: "v2alpha1", Resource: "accesstokens"}
+	connectorResource   = schema.GroupVersionResource{Group: "skupper.io", Version: "v2alpha1", Resource: "connectors"}
+	listenerResource    = schema.GroupVersionResource{Group: "skupper.io", Version: "v2alpha1", Resource: "listeners"}
+)
+
+// CreateController deploys the cluster scoped Skupper v2 controller
+func CreateController(c kube.Cluster) {
+	if controllerRunning(c, "application=skupper-controller") {
+		logger.Info(fmt.Sprintf("Reusing the Skupper controller of %s cluster", c.Name))
+		return
+	}
+	logger.Info("Deploying Skupper controller")
+
+	c.CreateNewNamespace(constants.SkupperNamespace)
+	err := c.CreateResourcesFromURL(constants.SkupperControllerURL, constants.SkupperNamespace)
+	if err != nil && !k8serrors.IsAlreadyExists(err) {
+		exit.OnErrorWithMessage(err, "Failed to create resources from URL")
+	}
+
+	err = kube.WaitForPodsReadyByLabel(c, "application=skupper-controller", constants.SkupperNamespace, 90*time.Second)
+	exit.OnErrorWithMessage(err, "Failed to wait for Skupper controller pods to be ready")
+}
+
+// createSiteConnectionV2 creates a site in the namespace of both clusters and links the target site to the origin site.
+// Links carry traffic in both directions, so a single link is sufficient.
+func createSiteConnectionV2(c kube.Clusters, siteNamespace string) {
+	CreateSiteCR(c.Origin, c.Origin.Name+"-"+siteNamespace, siteNamespace)
+	CreateSiteCR(c.Target, c.Target.Name+"-"+siteNamespace, siteNamespace)
+	LinkSites(c.Target, c.Origin, siteNamespace)
+}
+
+// CreateSiteCR creates a Site accepting links and waits until it is ready. An existing site of the
+// namespace is reused, a namespace only has a single site.
+func CreateSiteCR(c kube.Cluster, name, namespace string) {
+	if existing := existingSite(c, namespace); existing != "" {
+		logger.Info(fmt.Sprintf("Reusing Skupper site %s in namespace %s of %s cluster", existing, namespace, c.Name))
+		_, err := waitForReady(c, siteResource, namespace, existing, 120*time.Second)
+		exit.OnErrorWithMessage(err, "Failed to wait for Skupper site to be ready")
+		return
+	}
+	logger.Info("Creating Site")
+
+	site := map[string]interface{}{
+		"apiVersion": apiVersion,
+		"kind":       "Site",
+		"metadata":   map[string]interface{}{"name": name, "namespace": namespace},
+		"spec": map[string]interface{}{
+			"linkAccess": "default",
+			"ha":         routerCount() > 1,
+		},
+	}
+	exit.OnErrorWithMessage(createCR(c, namespace, site), "Failed to create Skupper site")
+
+	_, err := waitForReady(c, siteResource, namespace, name, 120*time.Second)
+	exit.OnErrorWithMessage(err, "Failed to wait for Skupper site to be ready")
+}
+
+// LinkSites grants access to the site of `to` and redeems the grant in `from`, which creates the link
+func LinkSites(from, to kube.Cluster, namespace string) {
+	logger.Info(fmt.Sprintf("Linking site of %s cluster to %s cluster", from.Name, to.Name))
+
+	name := "clustershift-" + from.Name + "-" + namespace
+	grant := map[string]interface{}{
+		"apiVersion": apiVersion,
+		"kind":       "AccessGrant",
+		"metadata":   map[string]interface{}{"name": name, "namespace": namespace},
+		"spec": map[string]interface{}{
+			"redemptionsAllowed": int64(1),
+			"expirationWindow":   "1h",
+		},
+	}
+	exit.OnErrorWithMessage(createCR(to, namespace, grant), "Failed to create Skupper grant")
+
+	issued, err := waitForReady(to, accessGrantResource, namespace, name, 120*time.Second)
+	exit.OnErrorWithMessage(err, "Access grant was not issued within timeout period")
+
+	tokenSpec := make(map[string]interface{})
+	for _, field := range []string{"url", "code", "ca"} {
+		value, _, _ := unstructured.NestedString(issued, "status", field)
+		tokenSpec[field] = value
+	}
+	token := map[string]interface{}{
+		"apiVersion": apiVersion,
+		"kind":       "AccessToken",
+		"metadata":   map[string]interface{}{"name": name, "namespace": namespace},
+		"spec":       tokenSpec,
+	}
+	exit.OnErrorWithMessage(createCR(from, namespace, token), "Failed to create Skupper token")
+
+	_, err = waitForReady(from, accessTokenResource, namespace, name, 120*time.Second)
+	exit.OnErrorWithMessage(err, "Access token was not redeemed within timeout period")
+}
+
+// exportServiceV2 creates a Connector for every port of the service and the matching Listeners, which
+// provide the service under the given host in the listed clusters
+func exportServiceV2(c kube.Cluster, service *v1.Service, host string, listeners ...kube.Cluster) error {
+	selector := labels.SelectorFromSet(service.Spec.Selector).String()
+	keys := routingKeys(service, host)
+
+	for i, port := range service.Spec.Ports {
+		routingKey := keys[i]
+		targetPort, err := containerPort(c, service, port)
+		if err != nil {
+			logger.Warning(fmt.Sprintf("Skipping port %d of service %s/%s", port.Port, service.Namespace, service.Name), err)
+			continue
+		}
+
+		connector := map[string]interface{}{
+			"apiVersion": apiVersion,
+			"kind":       "Connector",
+			"metadata":   map[string]interface{}{"name": routingKey, "namespace": service.Namespace},
+			"spec": map[string]interface{}{
+				"routingKey":          routingKey,
+				"selector":            selector,
+				"port":                int64(targetPort),
+				"includeNotReadyPods": service.Spec.PublishNotReadyAddresses,
+			},
+		}
+		if err := createCR(c, service.Namespace, connector); err != nil {
+			return err
+		}
+
+		listener := map[string]interface{}{
+			"apiVersion": apiVersion,
+			"kind":       "Listener",
+			"metadata":   map[string]interface{}{"name": routingKey, "namespace": service.Namespace},
+			"spec": map[string]interface{}{
+				"routingKey": routingKey,
+				"host":       host,
+				"port":       int64(port.Port),
+			},
+		}
+		for _, cluster := range listeners {
+			if err := createCR(cluster, service.Namespace, listener); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// containerPort returns the port of the selected pods the service port targets, named target ports
+// are resolved from the container ports of the pods
+func containerPort(c kube.Cluster, service *v1.Service, port v1.ServicePort) (int32, error) {
+	switch {
+	case port.TargetPort.Type == intstr.Int && port.TargetPort.IntVal != 0:
+		return port.TargetPort.IntVal, nil
+	case port.TargetPort.Type == intstr.Int:
+		return port.Port, nil
+	}
+
+	pods, err := c.Clientset.CoreV1().Pods(service.Namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(service.Spec.Selector).String(),
+	})
+	if err != nil {
+		return 0, err
+	}
+	protocol := port.Protocol
+	if protocol == "" {
+		protocol = v1.ProtocolTCP
+	}
+	for _, pod := range pods.Items {
+		for _, container := range pod.Spec.Containers {
+			for _, containerPort := range container.Ports {
+				containerProtocol := containerPort.Protocol
+				if containerProtocol == "" {
+					containerProtocol = v1.ProtocolTCP
+				}
+				if containerPort.Name == port.TargetPort.StrVal && containerProtocol == protocol {
+					return containerPort.ContainerPort, nil
+				}
+			}
+		}
+	}
+	return 0, fmt.Errorf("no selected pod has a container port named %s", port.TargetPort.StrVal)
+}
+
+// routingKeys returns the routing key of every port of the service, one per port
+func routingKeys(service *v1.Service, host string) []string {
+	keys := make([]string, 0, len(service.Spec.Ports))
+	for _, port := range service.Spec.Ports {
+		if len(service.Spec.Ports) > 1 {
+			keys = append(keys, fmt.Sprintf("%s-%d", host, port.Port))
+		} else {
+			keys = append(keys, host)
+		}
+	}
+	return keys
+}
+
+func createCR(c kube.Cluster, namespace string, resource map[string]interface{}) error {
+	err := c.CreateCustomResource(namespace, resource)
+	if err != nil && !k8serrors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create %s: %w", resource["kind"], err)
+	}
+	return nil
+}
+
+func deleteCR(c kube.Cluster, resource schema.GroupVersionResource, namespace, name string) {
+	err := c.DynamicClientset.Resource(resource).Namespace(namespace).Delete(context.TODO(), name, metav1.DeleteOptions{})
+	if err != nil && !k8serrors.IsNotFound(err) {
+		logger.Warning(fmt.Sprintf("Failed to delete %s %s/%s", resource.Resource, namespace, name), err)
+	}
+}
+
+// waitForReady waits until a Skupper CR reports that it is ready and returns it
+func waitForReady(c kube.Cluster, resource schema.GroupVersionResource, namespace, name string, timeout time.Duration) (map[string]interface{}, error) {
+	var object map[string]interface{}
+	err := wait.PollUntilContextTimeout(context.TODO(), 5*time.Second, timeout, true, func(ctx context.Context) (bool, error) {
+		current, err := c.DynamicClientset.Resource(resource).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, nil
+		}
+		object = current.Object
+		return IsReady(object), nil
+	})
+	return object, err
+}
+
+// IsReady reports whether a Skupper v2 CR has the Ready condition or status
+func IsReady(object map[string]interface{}) bool {
+	if status, _, _ := unstructured.NestedString(object, "status", "status"); status == "Ready" {
+		return true
+	}
+	conditions, _, _ := unstructured.NestedSlice(object, "status", "conditions")
+	for _, condition := range conditions {
+		conditionMap, ok := condition.(map[string]interface{})
+		if ok && conditionMap["type"] == "Ready" && conditionMap["status"] == "True" {
+			return true
+		}
+	}
+	return false
+}
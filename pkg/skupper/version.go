@@ -0,0 +1,53 @@
+package skupper
+
+import (
+	"clustershift/internal/kube"
+	"clustershift/internal/logger"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// V1 is the site controller model configured through ConfigMaps and secrets
+	V1 = 1
+	// V2 is the controller configured through skupper.io CRs
+	V2 = 2
+)
+
+var (
+	// Version is the Skupper version in use, 0 until it is detected
+	Version int
+	// Preferred is the version installed if neither cluster runs Skupper yet
+	Preferred = V2
+)
+
+// DetectVersion returns the Skupper version installed in the clusters, or the preferred
+// version if none is installed. The result is cached in Version.
+func DetectVersion(clusters ...kube.Cluster) int {
+	if Version != 0 {
+		return Version
+	}
+	Version = Preferred
+	for _, c := range clusters {
+		if installed := installedVersion(c); installed != 0 {
+			Version = installed
+			break
+		}
+	}
+	logger.Debug(fmt.Sprintf("Using Skupper v%d", Version))
+	return Version
+}
+
+func installedVersion(c kube.Cluster) int {
+	versions, err := c.FetchAPIVersions()
+	if err == nil {
+		if _, served := versions.Preferred(schema.GroupKind{Group: "skupper.io", Kind: "Site"}); served {
+			return V2
+		}
+	}
+	if _, err := c.FetchResource(kube.Namespace, "skupper-site-controller", ""); err == nil {
+		return V1
+	}
+	return 0
+}
@@ -23,7 +23,7 @@ func DeployBroker(c cluster.ClusterOptions) {
 		Namespace:   constants.SubmarinerBrokerNamespace,
 		ChartName:   constants.SubmarinerBrokerChartName,
 		Wait:        true,
-		Version:     constants.SubmarinerVersion,
+		Version:     Profile.Version,
 	}
 
 	helm.HelmAddandInstallChart(helmClient, chartOptions)
@@ -0,0 +1,233 @@
+package submariner
+
+import (
+	"clustershift/internal/constants"
+	"clustershift/internal/kube"
+	"clustershift/internal/logger"
+	"context"
+	"encoding/base64"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var submarinerResource = schema.GroupVersionResource{Group: "submariner.io", Version: "v1alpha1", Resource: "submariners"}
+
+// clusterIDs are the Submariner cluster IDs, keyed by cluster name. Clusters joined by clustershift
+// use their name, clusters that were already joined keep the ID they joined with.
+var clusterIDs = make(map[string]string)
+
+// installation is the configuration of a cluster that is already joined to a broker
+type installation struct {
+	brokerURL   string
+	token       string
+	ca          string // base64 encoded
+	psk         string
+	cableDriver string
+	clusterID   string
+	globalCIDR  string
+	natEnabled  bool
+	version     string
+	repository  string
+}
+
+// ClusterID returns the Submariner cluster ID of the cluster, which names it in clusterset DNS names
+func ClusterID(name string) string {
+	if id, ok := clusterIDs[name]; ok {
+		return id
+	}
+	return name
+}
+
+// detect returns the configuration the cluster joined the broker with, or nil if it is not joined.
+// The broker credentials and the PSK are either part of the Submariner resource or referenced secrets.
+func detect(c kube.Cluster) (*installation, error) {
+	object, err := c.DynamicClientset.Resource(submarinerResource).Namespace(constants.SubmarinerOperatorNamespace).
+		Get(context.TODO(), "submariner", metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	spec := func(field string) string {
+		value, _, _ := unstructured.NestedString(object.Object, "spec", field)
+		return value
+	}
+	natEnabled, _, _ := unstructured.NestedBool(object.Object, "spec", "natEnabled")
+	found := &installation{
+		brokerURL:   spec("brokerK8sApiServer"),
+		token:       spec("brokerK8sApiServerToken"),
+		ca:          spec("brokerK8sCA"),
+		psk:         spec("ceIPSecPSK"),
+		cableDriver: spec("cableDriver"),
+		clusterID:   spec("clusterID"),
+		globalCIDR:  spec("globalCIDR"),
+		natEnabled:  natEnabled,
+		version:     spec("version"),
+		repository:  spec("repository"),
+	}
+
+	if name := spec("brokerK8sSecret"); name != "" {
+		data, err := secretData(c, name)
+		if err != nil {
+			return nil, fmt.Errorf("broker secret %s: %w", name, err)
+		}
+		found.token = string(data["token"])
+		found.ca = base64.StdEncoding.EncodeToString(data["ca.crt"])
+	}
+	if name := spec("ceIPSecPSKSecret"); name != "" {
+		data, err := secretData(c, name)
+		if err != nil {
+			return nil, fmt.Errorf("PSK secret %s: %w", name, err)
+		}
+		found.psk = string(data["psk"])
+	}
+	if found.clusterID == "" {
+		found.clusterID = c.Name
+	}
+	return found, nil
+}
+
+func secretData(c kube.Cluster, name string) (map[string][]byte, error) {
+	secretInterface, err := c.FetchResource(kube.Secret, name, constants.SubmarinerOperatorNamespace)
+	if err != nil {
+		return nil, err
+	}
+	return secretInterface.(*v1.Secret).Data, nil
+}
+
+// validateExisting checks that clusters already joined to a broker can be connected. Both joined
+// clusters need the same broker and PSK, a cluster joining the broker needs an unused cluster ID.
+func validateExisting(c kube.Clusters) []error {
+	origin, err := detect(c.Origin)
+	if err != nil {
+		return []error{fmt.Errorf("origin cluster: %w", err)}
+	}
+	target, err := detect(c.Target)
+	if err != nil {
+		return []error{fmt.Errorf("target cluster: %w", err)}
+	}
+
+	var errs []error
+	switch {
+	case origin != nil && target != nil:
+		if origin.brokerURL != target.brokerURL {
+			errs = append(errs, fmt.Errorf("the clusters are joined to different brokers, %s and %s", origin.brokerURL, target.brokerURL))
+		}
+		if origin.psk != target.psk {
+			errs = append(errs, fmt.Errorf("the clusters are joined with different IPsec PSKs"))
+		}
+		if origin.clusterID == target.clusterID {
+			errs = append(errs, fmt.Errorf("both clusters are joined with cluster ID %s", origin.clusterID))
+		}
+		if origin.cableDriver != target.cableDriver {
+			errs = append(errs, fmt.Errorf("the clusters use different cable drivers, %s and %s", origin.cableDriver, target.cableDriver))
+		}
+	case origin != nil:
+		errs = append(errs, validateJoining(origin, c.Target)...)
+	case target != nil:
+		errs = append(errs, validateJoining(target, c.Origin)...)
+	}
+	return errs
+}
+
+func validateJoining(existing *installation, joining kube.Cluster) []error {
+	var errs []error
+	if existing.brokerURL == "" || existing.token == "" || existing.psk == "" {
+		errs = append(errs, fmt.Errorf("the broker credentials or the PSK of the joined cluster are not readable"))
+	}
+	if existing.clusterID == joining.Name {
+		errs = append(errs, fmt.Errorf("cluster ID %s is already used by the joined cluster", joining.Name))
+	}
+	if existing.cableDriver != "" && existing.cableDriver != Profile.CableDriver {
+		logger.Warning(fmt.Sprintf("Joining %s cluster with the cable driver %s of the existing installation", joining.Name, existing.cableDriver),
+			fmt.Errorf("cable driver %s ignored", Profile.CableDriver))
+	}
+	return errs
+}
+
+// joinExisting joins the cluster to the broker the other cluster is already joined to, with the
+// credentials, PSK and cable driver of the existing installation
+func joinExisting(existing *installation, c kube.Cluster, other kube.Cluster) {
+	logger.Info(fmt.Sprintf("Joining %s cluster to the existing broker %s", c.Name, existing.brokerURL))
+
+	cidrs := &CIDRs{
+		podCIDROrigin:     discoverOrOverride(clusterOverride(c, Overrides.OriginPodCIDR, Overrides.TargetPodCIDR), c.DiscoverPodCIDR, c.Name, "pod"),
+		serviceCIDROrigin: discoverOrOverride(clusterOverride(c, Overrides.OriginServiceCIDR, Overrides.TargetServiceCIDR), c.DiscoverServiceCIDR, c.Name, "service"),
+		podCIDRTarget:     discoverOrOverride(clusterOverride(other, Overrides.OriginPodCIDR, Overrides.TargetPodCIDR), other.DiscoverPodCIDR, other.Name, "pod"),
+		serviceCIDRTarget: discoverOrOverride(clusterOverride(other, Overrides.OriginServiceCIDR, Overrides.TargetServiceCIDR), other.DiscoverServiceCIDR, other.Name, "service"),
+	}
+	globalCIDR := ""
+	if existing.globalCIDR != "" {
+		globalCIDR = cidrs.freeGlobalCIDR(existing.globalCIDR)
+	}
+
+	if versionPattern.MatchString(existing.version) {
+		Profile.Version = existing.version
+	}
+	if existing.repository != "" {
+		Profile.ImageRepository = existing.repository
+	}
+	cableDriver := existing.cableDriver
+	if cableDriver == "" {
+		cableDriver = Profile.CableDriver
+	}
+
+	LabelGatewayNode(c)
+	JoinCluster(*c.ClusterOptions, SubmarinerJoinOptions{
+		Psk:             existing.psk,
+		BrokerURL:       existing.brokerURL,
+		Token:           existing.token,
+		CA:              existing.ca,
+		ClusterId:       c.Name,
+		PodCIDR:         cidrs.podCIDROrigin,
+		ServiceCIDR:     cidrs.serviceCIDROrigin,
+		GlobalCIDR:      globalCIDR,
+		CableDriver:     cableDriver,
+		NATEnabled:      existing.natEnabled,
+		ImageRepository: Profile.ImageRepository,
+	})
+	clusterIDs[c.Name] = c.Name
+	clusterIDs[other.Name] = existing.clusterID
+}
+
+// clusterOverride returns the override for the cluster
+func clusterOverride(c kube.Cluster, origin, target string) string {
+	if c.Name == "origin" {
+		return origin
+	}
+	return target
+}
+
+// freeGlobalCIDR picks a global CIDR overlapping neither the cluster CIDRs nor the used global CIDR
+func (c *CIDRs) freeGlobalCIDR(used string) string {
+	for _, candidate := range globalCIDRCandidates {
+		if kube.CIDRsOverlap(candidate, used) {
+			continue
+		}
+		overlapping := false
+		for _, cidr := range []string{c.podCIDROrigin, c.podCIDRTarget, c.serviceCIDROrigin, c.serviceCIDRTarget} {
+			if kube.CIDRsOverlap(candidate, cidr) {
+				overlapping = true
+				break
+			}
+		}
+		if !overlapping {
+			return candidate
+		}
+	}
+	logger.Warning("All global CIDR candidates overlap, the cluster joins without a global CIDR", fmt.Errorf("no free global CIDR"))
+	return ""
+}
+
+// brokerDeployed reports whether the broker already runs in the cluster
+func brokerDeployed(broker kube.Cluster) bool {
+	_, err := broker.FetchResource(kube.Secret, constants.SubmarinerBrokerClientToken, constants.SubmarinerBrokerNamespace)
+	return err == nil
+}
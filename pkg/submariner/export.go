@@ -1,24 +1,31 @@
 package submariner
 
 import (
-	"clustershift/internal/exit"
 	"clustershift/internal/kube"
 	"clustershift/internal/logger"
 	"context"
 	"encoding/json"
 	"fmt"
 	"strconv"
+	"time"
 
 	lhconstants "github.com/submariner-io/lighthouse/pkg/constants"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
 	mcsv1a1 "sigs.k8s.io/mcs-api/pkg/apis/v1alpha1"
 )
 
-func Export(c kube.Cluster, namespace string, name string, useClustersetIP string) {
+var serviceExportResource = schema.GroupVersionResource{Group: mcsv1a1.GroupVersion.Group, Version: mcsv1a1.GroupVersion.Version, Resource: "serviceexports"}
+
+func Export(c kube.Cluster, namespace string, name string, useClustersetIP string) error {
 	logger.Info("Checking for namespace")
 	_, err := c.Clientset.CoreV1().Services(namespace).Get(context.TODO(), name, metav1.GetOptions{})
-	exit.OnErrorWithMessage(err, fmt.Sprintf("Unable to find the Service %q in namespace %q", name, namespace))
+	if err != nil {
+		return fmt.Errorf("unable to find the Service %q in namespace %q: %w", name, namespace, err)
+	}
 
 	logger.Info("Namespace exists")
 
@@ -38,24 +45,68 @@ func Export(c kube.Cluster, namespace string, name string, useClustersetIP strin
 	// If user specified the use-clusterset-ip flag
 	if useClustersetIP != "" {
 		result, err := strconv.ParseBool(useClustersetIP)
-		exit.OnErrorWithMessage(err, "use-clusterset-ip must be set to true/false: ")
+		if err != nil {
+			return fmt.Errorf("use-clusterset-ip must be set to true/false: %w", err)
+		}
 
 		mcsServiceExport.SetAnnotations(map[string]string{lhconstants.UseClustersetIP: strconv.FormatBool(result)})
 	}
 
 	resourceServiceExport, err := convertToUnstructured(mcsServiceExport)
-	exit.OnErrorWithMessage(err, "Failed to convert to Unstructured")
+	if err != nil {
+		return fmt.Errorf("failed to convert to Unstructured: %w", err)
+	}
 
 	logger.Debug(fmt.Sprintf("%v", resourceServiceExport))
 
 	err = c.CreateCustomResource(namespace, resourceServiceExport)
 	if k8serrors.IsAlreadyExists(err) {
 		logger.Info("Service already exported")
-		return
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to export service: %w", err)
 	}
-	exit.OnErrorWithMessage(err, "Failed to export service")
 
 	logger.Info("Service exported successfully")
+	return nil
+}
+
+// Unexport removes the ServiceExport of the service
+func Unexport(c kube.Cluster, namespace, name string) error {
+	err := c.DynamicClientset.Resource(serviceExportResource).Namespace(namespace).
+		Delete(context.TODO(), name, metav1.DeleteOptions{})
+	if k8serrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// WaitForImport waits until Lighthouse imported the service into the cluster. Lighthouse keeps the
+// ServiceImport in the namespace of the service, older versions in the operator namespace with labels
+// naming the source service.
+func WaitForImport(c kube.Cluster, namespace, name string, timeout time.Duration) error {
+	logger.Info(fmt.Sprintf("Waiting for ServiceImport of %s/%s in %s cluster", namespace, name, c.Name))
+	err := wait.PollUntilContextTimeout(context.TODO(), 5*time.Second, timeout, true, func(ctx context.Context) (bool, error) {
+		imports, err := c.ListCustomResources(schema.GroupKind{Group: mcsv1a1.GroupVersion.Group, Kind: "ServiceImport"}, "")
+		if err != nil {
+			return false, nil
+		}
+		for _, serviceImport := range imports {
+			importName, _, _ := unstructured.NestedString(serviceImport, "metadata", "name")
+			importNamespace, _, _ := unstructured.NestedString(serviceImport, "metadata", "namespace")
+			labels, _, _ := unstructured.NestedStringMap(serviceImport, "metadata", "labels")
+			if (importName == name && importNamespace == namespace) ||
+				(labels[lhconstants.LabelSourceName] == name && labels[lhconstants.LabelSourceNamespace] == namespace) {
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		return fmt.Errorf("service %s/%s is not imported into %s cluster after %s, check the ServiceExport status and the Lighthouse agent", namespace, name, c.Name, timeout)
+	}
+	return nil
 }
 
 func convertToUnstructured(serviceExport *mcsv1a1.ServiceExport) (map[string]interface{}, error) {
@@ -30,7 +30,7 @@ func JoinCluster(c cluster.ClusterOptions, s SubmarinerJoinOptions) {
 		ChartName:   constants.SubmarinerOperatorChartName,
 		Values:      values,
 		Wait:        true,
-		Version:     constants.SubmarinerVersion,
+		Version:     Profile.Version,
 	}
 
 	helm.HelmAddandInstallChart(helmClient, chartOptions)
@@ -1,14 +1,17 @@
 package submariner
 
 type SubmarinerJoinOptions struct {
-	Psk         string
-	BrokerURL   string
-	Token       string
-	CA          string
-	ClusterId   string
-	PodCIDR     string
-	ServiceCIDR string
-	GlobalCIDR  string
+	Psk             string
+	BrokerURL       string
+	Token           string
+	CA              string
+	ClusterId       string
+	PodCIDR         string
+	ServiceCIDR     string
+	GlobalCIDR      string
+	CableDriver     string
+	NATEnabled      bool
+	ImageRepository string
 }
 
 type CIDRs struct {
@@ -16,5 +19,8 @@ type CIDRs struct {
 	podCIDRTarget     string
 	serviceCIDROrigin string
 	serviceCIDRTarget string
+	globalCIDROrigin  string // empty if globalnet is disabled
+	globalCIDRTarget  string
+	globalnet         bool
 	brokerURL         string
 }
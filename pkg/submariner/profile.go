@@ -0,0 +1,160 @@
+package submariner
+
+import (
+	"clustershift/internal/constants"
+	"clustershift/internal/exit"
+	"clustershift/internal/kube"
+	"clustershift/internal/kubeconfig"
+	"clustershift/internal/logger"
+	"clustershift/internal/prompt"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// Profile configures the Submariner deployment, set from the migration options
+var Profile = DefaultProfile()
+
+var (
+	versionPattern = regexp.MustCompile(`^v?\d+\.\d+\.\d+(-[0-9A-Za-z.-]+)?$`)
+	kernelPattern  = regexp.MustCompile(`^(\d+)\.(\d+)`)
+)
+
+// wireGuardKernel is the first kernel release shipping the WireGuard module
+var wireGuardKernel = [2]int{5, 6}
+
+// DefaultProfile returns the profile used when no options are given
+func DefaultProfile() prompt.SubmarinerProfile {
+	return prompt.SubmarinerProfile{
+		CableDriver:     prompt.CableDriverLibreswan,
+		NATTraversal:    true,
+		Globalnet:       prompt.GlobalnetAuto,
+		Version:         constants.SubmarinerVersion,
+		Broker:          prompt.BrokerOrigin,
+		ImageRepository: constants.SubmarinerImageRepository,
+	}
+}
+
+// ValidateProfile checks the profile and whether the clusters are able to run it
+func ValidateProfile(c kube.Clusters) error {
+	var errs []error
+	switch Profile.CableDriver {
+	case prompt.CableDriverLibreswan, prompt.CableDriverWireGuard:
+	case prompt.CableDriverVXLAN:
+		logger.Warning("The vxlan cable driver does not encrypt the traffic between the clusters", fmt.Errorf("unencrypted cable driver"))
+	default:
+		errs = append(errs, fmt.Errorf("unknown cable driver %q, use libreswan, wireguard or vxlan", Profile.CableDriver))
+	}
+
+	switch Profile.Globalnet {
+	case prompt.GlobalnetAuto, prompt.GlobalnetEnabled, prompt.GlobalnetDisabled:
+	default:
+		errs = append(errs, fmt.Errorf("unknown globalnet mode %q, use auto, true or false", Profile.Globalnet))
+	}
+
+	if !versionPattern.MatchString(Profile.Version) {
+		errs = append(errs, fmt.Errorf("invalid chart version %q", Profile.Version))
+	}
+
+	switch Profile.Broker {
+	case prompt.BrokerOrigin, prompt.BrokerTarget:
+	default:
+		if _, err := os.Stat(Profile.Broker); err != nil {
+			errs = append(errs, fmt.Errorf("broker must be origin, target or a kubeconfig path: %w", err))
+		}
+	}
+
+	if Profile.Gateways < 0 {
+		errs = append(errs, fmt.Errorf("gateway count must not be negative"))
+	}
+	for _, cluster := range []kube.Cluster{c.Origin, c.Target} {
+		errs = append(errs, validateGatewayNodes(cluster)...)
+	}
+	errs = append(errs, validateExisting(c)...)
+	return errors.Join(errs...)
+}
+
+// validateGatewayNodes checks that enough gateway nodes exist and that they support the cable driver
+func validateGatewayNodes(c kube.Cluster) []error {
+	selection := gatewaySelection()
+	nodes, err := c.SelectNodes(kube.NodeSelection{Strategy: selection.Strategy, Selector: selection.Selector})
+	if err != nil {
+		return []error{err}
+	}
+
+	var errs []error
+	if selection.Count > len(nodes) {
+		errs = append(errs, fmt.Errorf("%s cluster has %d gateway nodes matching %s, %d requested", c.Name, len(nodes), selection, selection.Count))
+	}
+	if selection.Count > 0 && len(nodes) > selection.Count {
+		nodes = nodes[:selection.Count]
+	}
+
+	for _, node := range nodes {
+		if Profile.CableDriver == prompt.CableDriverWireGuard && !supportsWireGuard(node.Status.NodeInfo.KernelVersion) {
+			errs = append(errs, fmt.Errorf("gateway node %s of %s cluster runs kernel %s, wireguard requires %d.%d or newer",
+				node.Name, c.Name, node.Status.NodeInfo.KernelVersion, wireGuardKernel[0], wireGuardKernel[1]))
+		}
+		if !Profile.NATTraversal && behindNAT(node) {
+			errs = append(errs, fmt.Errorf("gateway node %s of %s cluster is behind NAT, enable NAT traversal", node.Name, c.Name))
+		}
+	}
+	return errs
+}
+
+// gatewaySelection returns the gateway node selection with the gateway count of the profile
+func gatewaySelection() kube.NodeSelection {
+	selection := kube.GatewayNodes
+	if Profile.Gateways > 0 {
+		selection.Count = Profile.Gateways
+	}
+	return selection
+}
+
+// brokerCluster returns the cluster the broker is deployed to
+func brokerCluster(c kube.Clusters) kube.Cluster {
+	switch Profile.Broker {
+	case prompt.BrokerOrigin:
+		return c.Origin
+	case prompt.BrokerTarget:
+		return c.Target
+	}
+	exit.OnErrorWithMessage(kubeconfig.ProcessKubeconfig(Profile.Broker, "broker"), "Processing broker kubeconfig failed")
+	broker, err := kube.InitCluster(constants.KubeconfigBrokerTmp, "broker")
+	exit.OnErrorWithMessage(err, "Failed to initialize broker cluster")
+	return broker
+}
+
+func supportsWireGuard(kernelVersion string) bool {
+	match := kernelPattern.FindStringSubmatch(kernelVersion)
+	if match == nil {
+		return false
+	}
+	major, _ := strconv.Atoi(match[1])
+	minor, _ := strconv.Atoi(match[2])
+	return major > wireGuardKernel[0] || (major == wireGuardKernel[0] && minor >= wireGuardKernel[1])
+}
+
+// behindNAT reports whether a node has an external IP that is not assigned to the node itself
+func behindNAT(node v1.Node) bool {
+	var internal, external []string
+	for _, address := range node.Status.Addresses {
+		switch address.Type {
+		case v1.NodeInternalIP:
+			internal = append(internal, address.Address)
+		case v1.NodeExternalIP:
+			external = append(external, address.Address)
+		}
+	}
+	for _, address := range external {
+		if !slices.Contains(internal, address) {
+			return true
+		}
+	}
+	return false
+}
@@ -3,6 +3,7 @@ package submariner
 import (
 	"clustershift/internal/constants"
 	"clustershift/internal/decoder"
+	"clustershift/internal/exit"
 	"clustershift/internal/kube"
 	"clustershift/internal/logger"
 	"encoding/base64"
@@ -11,26 +12,53 @@ import (
 	v1 "k8s.io/api/core/v1"
 )
 
+// Install connects the clusters with Submariner. Clusters already joined to a broker are kept,
+// a single joined cluster has the other one join its broker.
 func Install(c kube.Clusters) {
 	logger.Info("Installing Submariner")
 	defer logger.Info("Submariner installed")
 
+	origin, err := detect(c.Origin)
+	exit.OnErrorWithMessage(err, "Failed to detect Submariner in origin cluster")
+	target, err := detect(c.Target)
+	exit.OnErrorWithMessage(err, "Failed to detect Submariner in target cluster")
+	switch {
+	case origin != nil && target != nil:
+		logger.Info(fmt.Sprintf("Reusing the Submariner installations joined to %s", origin.brokerURL))
+		clusterIDs[c.Origin.Name] = origin.clusterID
+		clusterIDs[c.Target.Name] = target.clusterID
+		return
+	case origin != nil:
+		joinExisting(origin, c.Target, c.Origin)
+		return
+	case target != nil:
+		joinExisting(target, c.Origin, c.Target)
+		return
+	}
+
+	broker := brokerCluster(c)
+	logger.Info(fmt.Sprintf("Using cable driver %s with broker in %s cluster", Profile.CableDriver, broker.Name))
+
 	// Gather necessary information
-	cidrs := BuildCIDRs(c)
+	cidrs := BuildCIDRs(c, broker)
 
 	logger.Info("Labeling gateway nodes")
-	// Label one master node in each cluster as a gateway node
+	// Label the gateway nodes of each cluster, more than one node runs the gateways active/passive
 	LabelGatewayNode(c.Origin)
 	LabelGatewayNode(c.Target)
 	logger.Info("Labeled gateway nodes")
 
 	// Deploy broker
-	logger.Info("Deploying broker")
-	DeployBroker(*c.Origin.ClusterOptions)
-	logger.Info("Deployed broker")
+	if brokerDeployed(broker) {
+		logger.Info(fmt.Sprintf("Reusing the broker of %s cluster", broker.Name))
+	} else {
+		logger.Info("Deploying broker")
+		DeployBroker(*broker.ClusterOptions)
+		logger.Info("Deployed broker")
+	}
 
 	psk := GenerateRandomString(64)
-	secretInterface, err := c.Origin.FetchResource(kube.Secret, constants.SubmarinerBrokerClientToken, constants.SubmarinerBrokerNamespace)
+	secretInterface, err := broker.FetchResource(kube.Secret, constants.SubmarinerBrokerClientToken, constants.SubmarinerBrokerNamespace)
 	if err != nil {
 		logger.Debug(fmt.Sprintf("Error fetching secret: %v", err))
 		panic(err)
@@ -40,25 +68,31 @@ func Install(c kube.Clusters) {
 	ca := base64.StdEncoding.EncodeToString(secret.Data["ca.crt"])
 
 	originJoinOptions := SubmarinerJoinOptions{
-		Psk:         psk,
-		BrokerURL:   cidrs.brokerURL,
-		Token:       token,
-		CA:          ca,
-		ClusterId:   "origin",
-		PodCIDR:     cidrs.podCIDROrigin,
-		ServiceCIDR: cidrs.serviceCIDROrigin,
-		GlobalCIDR:  "242.0.0.0/16",
+		Psk:             psk,
+		BrokerURL:       cidrs.brokerURL,
+		Token:           token,
+		CA:              ca,
+		ClusterId:       c.Origin.Name,
+		PodCIDR:         cidrs.podCIDROrigin,
+		ServiceCIDR:     cidrs.serviceCIDROrigin,
+		GlobalCIDR:      cidrs.globalCIDROrigin,
+		CableDriver:     Profile.CableDriver,
+		NATEnabled:      Profile.NATTraversal,
+		ImageRepository: Profile.ImageRepository,
 	}
 
 	targetJoinOptions := SubmarinerJoinOptions{
-		Psk:         psk,
-		BrokerURL:   cidrs.brokerURL,
-		Token:       token,
-		CA:          ca,
-		ClusterId:   "target",
-		PodCIDR:     cidrs.podCIDRTarget,
-		ServiceCIDR: cidrs.serviceCIDRTarget,
-		GlobalCIDR:  "242.1.0.0/16",
+		Psk:             psk,
+		BrokerURL:       cidrs.brokerURL,
+		Token:           token,
+		CA:              ca,
+		ClusterId:       c.Target.Name,
+		PodCIDR:         cidrs.podCIDRTarget,
+		ServiceCIDR:     cidrs.serviceCIDRTarget,
+		GlobalCIDR:      cidrs.globalCIDRTarget,
+		CableDriver:     Profile.CableDriver,
+		NATEnabled:      Profile.NATTraversal,
+		ImageRepository: Profile.ImageRepository,
 	}
 
 	// Deploy operator
@@ -71,10 +105,9 @@ func Install(c kube.Clusters) {
 }
 
 func LabelGatewayNode(c kube.Cluster) {
-	node := c.FetchMasterNode()
-	labels := map[string]string{
+	nodes, err := c.LabelGatewayNodes(gatewaySelection(), map[string]string{
 		"submariner.io/gateway": "true",
-	}
-
-	c.AddNodeLabels(&node.Items[0], labels)
+	})
+	exit.OnErrorWithMessage(err, "Failed to select gateway nodes")
+	logger.Debug(fmt.Sprintf("Labeled %d gateway nodes in %s cluster", len(nodes), c.Name))
 }
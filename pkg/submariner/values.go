@@ -1,15 +1,14 @@
 package submariner
 
 import (
-	"bufio"
 	"clustershift/internal/constants"
 	"clustershift/internal/exit"
 	"clustershift/internal/kube"
 	"clustershift/internal/logger"
+	"clustershift/internal/prompt"
 	"crypto/rand"
 	"encoding/base64"
 	"fmt"
-	"os"
 )
 
 func GenerateJoinArgs(s SubmarinerJoinOptions) (string, error) {
@@ -22,20 +21,26 @@ broker:
   token: "%s"
   namespace: "%s"
   ca: "%s"
-  globalnet: true
+  globalnet: %t
 
 submariner:
   serviceDiscovery: true
-  cableDriver: libreswan
+  cableDriver: %s
   clusterId: "%s"
   clusterCidr: "%s"
   serviceCidr: "%s"
   globalCidr: "%s"
-  natEnabled: true
+  natEnabled: %t
+  images:
+    repository: "%s"
+
+operator:
+  image:
+    repository: "%s/submariner-operator"
 
 serviceAccounts:
   globalnet:
-    create: true
+    create: %t
   lighthouseAgent:
     create: true
   lighthouseCoreDns:
@@ -46,71 +51,111 @@ serviceAccounts:
 		s.Token,
 		constants.SubmarinerBrokerNamespace,
 		s.CA,
+		s.GlobalCIDR != "",
+		s.CableDriver,
 		s.ClusterId,
 		s.PodCIDR,
 		s.ServiceCIDR,
 		s.GlobalCIDR,
+		s.NATEnabled,
+		s.ImageRepository,
+		s.ImageRepository,
+		s.GlobalCIDR != "",
 	)
 
 	return valuesTemplate, nil
 }
 
-func BuildCIDRs(c kube.Clusters) *CIDRs {
-	podCIDROrigin := promptForInput("Enter Pod CIDR for origin cluster: ")
-	podCIDRTarget := promptForInput("Enter Pod CIDR for target cluster: ")
-	serviceCIDROrigin := promptForInput("Enter Service CIDR for origin cluster (blank for automatic detection): ")
-	serviceCIDRTarget := promptForInput("Enter Service CIDR for target cluster (blank for automatic detection): ")
-	brokerURL := promptForInput("Enter broker URL (blank for automatic detection): ")
-
-	serviceCIDROrigin = fetchOrPrompt(serviceCIDROrigin, func() (string, error) { return c.Origin.FetchServiceCIDRs() }, "origin", "Service CIDR")
-	serviceCIDRTarget = fetchOrPrompt(serviceCIDRTarget, func() (string, error) { return c.Target.FetchServiceCIDRs() }, "target", "Service CIDR")
-	brokerURL = fetchOrPrompt(brokerURL, func() (string, error) { return c.Origin.FetchKubernetesAPIEndpoint() }, "", "Kubernetes API endpoint")
-
-	if podCIDROrigin == "" || podCIDRTarget == "" {
-		logger.Debug("Pod CIDRs are required for both clusters. Please provide them.")
-		podCIDROrigin = promptForInput("Enter Pod CIDR for origin cluster: ")
-		podCIDRTarget = promptForInput("Enter Pod CIDR for target cluster: ")
+// Overrides replace the discovered CIDRs, set from the migration options
+var Overrides prompt.NetworkOverrides
+
+// globalCIDRCandidates are the global CIDRs handed out to the clusters when globalnet is enabled
+var globalCIDRCandidates = []string{"242.0.0.0/16", "242.1.0.0/16", "242.2.0.0/16", "242.3.0.0/16", "243.0.0.0/16", "243.1.0.0/16"}
+
+func BuildCIDRs(c kube.Clusters, broker kube.Cluster) *CIDRs {
+	cidrs := &CIDRs{
+		podCIDROrigin:     discoverOrOverride(Overrides.OriginPodCIDR, c.Origin.DiscoverPodCIDR, "origin", "pod"),
+		podCIDRTarget:     discoverOrOverride(Overrides.TargetPodCIDR, c.Target.DiscoverPodCIDR, "target", "pod"),
+		serviceCIDROrigin: discoverOrOverride(Overrides.OriginServiceCIDR, c.Origin.DiscoverServiceCIDR, "origin", "service"),
+		serviceCIDRTarget: discoverOrOverride(Overrides.TargetServiceCIDR, c.Target.DiscoverServiceCIDR, "target", "service"),
+		brokerURL:         Overrides.BrokerURL,
+	}
+	if cidrs.brokerURL == "" {
+		brokerURL, err := broker.FetchKubernetesAPIEndpoint()
+		exit.OnErrorWithMessage(err, "Failed to determine the broker URL, set it with --broker-url")
+		cidrs.brokerURL = brokerURL
 	}
 
-	if podCIDROrigin == "" {
-		exit.OnErrorWithMessage(fmt.Errorf("pod CIDR cannot be empty"), "Pod CIDR for origin cluster cannot be empty")
+	overlaps := cidrs.overlaps()
+	for _, overlap := range overlaps {
+		logger.Info(fmt.Sprintf("CIDRs overlap: %s", overlap))
+	}
+	switch Profile.Globalnet {
+	case prompt.GlobalnetEnabled:
+		cidrs.globalnet = true
+	case prompt.GlobalnetDisabled:
+		if len(overlaps) > 0 {
+			exit.OnErrorWithMessage(fmt.Errorf("%d overlapping CIDRs", len(overlaps)), "Globalnet is disabled although the CIDRs overlap, enable it with --globalnet")
+		}
+	default:
+		cidrs.globalnet = len(overlaps) > 0
 	}
-	if podCIDRTarget == "" {
-		exit.OnErrorWithMessage(fmt.Errorf("pod CIDR cannot be empty"), "Pod CIDR for target cluster cannot be empty")
+	if cidrs.globalnet {
+		cidrs.globalCIDROrigin, cidrs.globalCIDRTarget = cidrs.selectGlobalCIDRs()
 	}
 
-	logger.Debug(fmt.Sprintf("Pod CIDR Origin: %s\n", podCIDROrigin))
-	logger.Debug(fmt.Sprintf("Pod CIDR Target: %s\n", podCIDRTarget))
-	logger.Debug(fmt.Sprintf("Service CIDR Origin: %s\n", serviceCIDROrigin))
-	logger.Debug(fmt.Sprintf("Service CIDR Target: %s\n", serviceCIDRTarget))
-	logger.Debug(fmt.Sprintf("Broker URL: %s\n", brokerURL))
-
-	return &CIDRs{
-		podCIDROrigin:     podCIDROrigin,
-		podCIDRTarget:     podCIDRTarget,
-		serviceCIDROrigin: serviceCIDROrigin,
-		serviceCIDRTarget: serviceCIDRTarget,
-		brokerURL:         brokerURL,
+	logger.Debug(fmt.Sprintf("Pod CIDR Origin: %s", cidrs.podCIDROrigin))
+	logger.Debug(fmt.Sprintf("Pod CIDR Target: %s", cidrs.podCIDRTarget))
+	logger.Debug(fmt.Sprintf("Service CIDR Origin: %s", cidrs.serviceCIDROrigin))
+	logger.Debug(fmt.Sprintf("Service CIDR Target: %s", cidrs.serviceCIDRTarget))
+	logger.Debug(fmt.Sprintf("Broker URL: %s", cidrs.brokerURL))
+	logger.Info(fmt.Sprintf("Globalnet enabled: %t", cidrs.globalnet))
+
+	return cidrs
+}
+
+func discoverOrOverride(override string, discover func() (kube.DiscoveredCIDR, error), clusterType, kind string) string {
+	if override != "" {
+		logger.Debug(fmt.Sprintf("Using %s CIDR %s of %s cluster from the migration options", kind, override, clusterType))
+		return override
 	}
+	discovered, err := discover()
+	exit.OnErrorWithMessage(err, fmt.Sprintf("Failed to discover the %s CIDR of the %s cluster, set it with --%s-%s-cidr", kind, clusterType, clusterType, kind))
+	return discovered.CIDR
 }
 
-func fetchOrPrompt(value string, fetchFunc func() (string, error), clusterType, description string) string {
-	if value == "" {
-		fetchedValue, err := fetchFunc()
-		if err != nil || fetchedValue == "" {
-			value = promptForInput(fmt.Sprintf("Could not fetch %s for %s cluster. Please enter it manually:", description, clusterType))
-		} else {
-			value = fetchedValue
+// overlaps returns the overlapping CIDRs of origin and target
+func (c *CIDRs) overlaps() []string {
+	var overlaps []string
+	for _, origin := range []string{c.podCIDROrigin, c.serviceCIDROrigin} {
+		for _, target := range []string{c.podCIDRTarget, c.serviceCIDRTarget} {
+			if kube.CIDRsOverlap(origin, target) {
+				overlaps = append(overlaps, fmt.Sprintf("origin %s and target %s", origin, target))
+			}
 		}
 	}
-	return value
+	return overlaps
 }
 
-func promptForInput(prompt string) string {
-	reader := bufio.NewReader(os.Stdin)
-	fmt.Print(prompt)
-	input, _ := reader.ReadString('\n')
-	return input[:len(input)-1] // Remove the newline character
+// selectGlobalCIDRs picks two global CIDRs that do not overlap with any cluster CIDR
+func (c *CIDRs) selectGlobalCIDRs() (string, string) {
+	var free []string
+	for _, candidate := range globalCIDRCandidates {
+		overlapping := false
+		for _, cidr := range []string{c.podCIDROrigin, c.podCIDRTarget, c.serviceCIDROrigin, c.serviceCIDRTarget} {
+			if kube.CIDRsOverlap(candidate, cidr) {
+				overlapping = true
+				break
+			}
+		}
+		if !overlapping {
+			free = append(free, candidate)
+		}
+	}
+	if len(free) < 2 {
+		exit.OnErrorWithMessage(fmt.Errorf("all global CIDR candidates overlap with the cluster CIDRs"), "Failed to select global CIDRs")
+	}
+	return free[0], free[1]
 }
 
 func GenerateRandomString(length int) string {